@@ -0,0 +1,91 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client calls the authorization API of a tenant with the access token of a client that has the authz scope
+type Client struct {
+	// BaseURL is the issuer of the tenant, e.g. https://acme.example.com
+	BaseURL     string
+	AccessToken string
+	HTTPClient  *http.Client
+}
+
+func NewClient(baseURL string, accessToken string) *Client {
+	return &Client{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		AccessToken: accessToken,
+		HTTPClient:  http.DefaultClient,
+	}
+}
+
+// Error is returned for responses other than 200 OK
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("authz: %d %s", e.StatusCode, e.Message)
+}
+
+// Check reports whether the subject has the relation to the object
+func (c *Client) Check(ctx context.Context, request CheckRequest) (*CheckResponse, error) {
+	var response CheckResponse
+	return &response, c.post(ctx, "/authz/check", request, &response)
+}
+
+// Expand returns the userset tree of the relation of the object
+func (c *Client) Expand(ctx context.Context, request ExpandRequest) (*ExpandResponse, error) {
+	var response ExpandResponse
+	return &response, c.post(ctx, "/authz/expand", request, &response)
+}
+
+// ListObjects returns the IDs of the objects of the namespace to which the subject has the relation
+func (c *Client) ListObjects(ctx context.Context, request ListObjectsRequest) (*ListObjectsResponse, error) {
+	var response ListObjectsResponse
+	return &response, c.post(ctx, "/authz/list-objects", request, &response)
+}
+
+// Write adds and deletes tuples. Pass the returned zookie to later checks that must see the write.
+func (c *Client) Write(ctx context.Context, request WriteRequest) (*WriteResponse, error) {
+	var response WriteResponse
+	return &response, c.post(ctx, "/authz/write", request, &response)
+}
+
+func (c *Client) post(ctx context.Context, path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", "Bearer "+c.AccessToken)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 4096))
+		return &Error{StatusCode: httpResponse.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
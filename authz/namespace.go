@@ -0,0 +1,172 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NamespaceConfig defines the relations of a namespace. A relation without userset rewrite only consists
+// of its stored tuples.
+//
+//	{"relations": {
+//	  "owner": null,
+//	  "editor": {"union": [{"this": {}}, {"computed_userset": "owner"}]},
+//	  "viewer": {"union": [{"this": {}}, {"computed_userset": "editor"},
+//	    {"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}]},
+//	  "parent": null
+//	}}
+type NamespaceConfig struct {
+	Relations map[string]*Userset `json:"relations"`
+}
+
+// Userset is a userset rewrite rule. Exactly one field is set.
+type Userset struct {
+	// This are the subjects of the stored tuples of the relation
+	This *struct{} `json:"this,omitempty"`
+	// ComputedUserset is another relation of the same object
+	ComputedUserset string `json:"computed_userset,omitempty"`
+	// TupleToUserset follows the tuples of a relation to other objects, e.g. the parent folder
+	TupleToUserset *TupleToUserset `json:"tuple_to_userset,omitempty"`
+	Union          []*Userset      `json:"union,omitempty"`
+	Intersection   []*Userset      `json:"intersection,omitempty"`
+	Exclusion      *Exclusion      `json:"exclusion,omitempty"`
+}
+
+// TupleToUserset takes the objects related by Tupleset and their relation ComputedUserset
+type TupleToUserset struct {
+	Tupleset        string `json:"tupleset"`
+	ComputedUserset string `json:"computed_userset"`
+}
+
+// Exclusion are the subjects of Base that are not in Subtract
+type Exclusion struct {
+	Base     *Userset `json:"base"`
+	Subtract *Userset `json:"subtract"`
+}
+
+// Validate checks the names and that computed usersets refer to relations of the namespace without
+// a cycle
+func (c *NamespaceConfig) Validate() error {
+	if len(c.Relations) == 0 {
+		return errors.New("namespace without relations")
+	}
+	for name, userset := range c.Relations {
+		if !NamePattern.MatchString(name) {
+			return fmt.Errorf("invalid relation %q", name)
+		}
+		if err := c.validateUserset(userset); err != nil {
+			return fmt.Errorf("relation %s: %w", name, err)
+		}
+	}
+
+	// Relations of the same object must not be computed from each other, tuple to userset rewrites
+	// lead to other objects and may form cycles, e.g. nested folders
+	visited := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch visited[name] {
+		case 1:
+			return fmt.Errorf("relation %s is computed from itself", name)
+		case 2:
+			return nil
+		}
+		visited[name] = 1
+		for _, computed := range c.Relations[name].computedUsersets(nil) {
+			if err := visit(computed); err != nil {
+				return err
+			}
+		}
+		visited[name] = 2
+		return nil
+	}
+	for name := range c.Relations {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *NamespaceConfig) validateUserset(u *Userset) error {
+	if u == nil {
+		return nil
+	}
+
+	set := 0
+	for _, isSet := range []bool{u.This != nil, u.ComputedUserset != "", u.TupleToUserset != nil, u.Union != nil, u.Intersection != nil, u.Exclusion != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("a userset needs exactly one rule")
+	}
+
+	switch {
+	case u.ComputedUserset != "":
+		if _, ok := c.Relations[u.ComputedUserset]; !ok {
+			return fmt.Errorf("unknown relation %q", u.ComputedUserset)
+		}
+	case u.TupleToUserset != nil:
+		if _, ok := c.Relations[u.TupleToUserset.Tupleset]; !ok {
+			return fmt.Errorf("unknown tupleset %q", u.TupleToUserset.Tupleset)
+		}
+		if !NamePattern.MatchString(u.TupleToUserset.ComputedUserset) {
+			return fmt.Errorf("invalid relation %q", u.TupleToUserset.ComputedUserset)
+		}
+	case u.Exclusion != nil:
+		if u.Exclusion.Base == nil || u.Exclusion.Subtract == nil {
+			return errors.New("an exclusion needs base and subtract")
+		}
+		if err := c.validateUserset(u.Exclusion.Base); err != nil {
+			return err
+		}
+		return c.validateUserset(u.Exclusion.Subtract)
+	}
+	for _, child := range append(u.Union, u.Intersection...) {
+		if child == nil {
+			return errors.New("empty userset")
+		}
+		if err := c.validateUserset(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Direct reports whether tuples can be stored for the relation, i.e. it has no rewrite or its rewrite uses this
+func (c *NamespaceConfig) Direct(relation string) bool {
+	userset, ok := c.Relations[relation]
+	return ok && (userset == nil || userset.usesThis())
+}
+
+func (u *Userset) usesThis() bool {
+	switch {
+	case u.This != nil:
+		return true
+	case u.Exclusion != nil:
+		return u.Exclusion.Base.usesThis() || u.Exclusion.Subtract.usesThis()
+	}
+	for _, child := range append(u.Union, u.Intersection...) {
+		if child.usesThis() {
+			return true
+		}
+	}
+	return false
+}
+
+// computedUsersets appends the relations of the same object the userset is computed from
+func (u *Userset) computedUsersets(relations []string) []string {
+	switch {
+	case u == nil:
+		return relations
+	case u.ComputedUserset != "":
+		return append(relations, u.ComputedUserset)
+	case u.Exclusion != nil:
+		return u.Exclusion.Subtract.computedUsersets(u.Exclusion.Base.computedUsersets(relations))
+	}
+	for _, child := range append(u.Union, u.Intersection...) {
+		relations = child.computedUsersets(relations)
+	}
+	return relations
+}
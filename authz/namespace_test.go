@@ -0,0 +1,78 @@
+package authz
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"stored relations", `{"relations": {"owner": null, "parent": null}}`, ""},
+		{"rewrites", `{"relations": {
+			"owner": null,
+			"parent": null,
+			"banned": null,
+			"editor": {"union": [{"this": {}}, {"computed_userset": "owner"}]},
+			"viewer": {"union": [{"this": {}}, {"computed_userset": "editor"},
+				{"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}]},
+			"approver": {"intersection": [{"computed_userset": "editor"}, {"this": {}}]},
+			"commenter": {"exclusion": {"base": {"computed_userset": "viewer"}, "subtract": {"computed_userset": "banned"}}}
+		}}`, ""},
+		{"no relations", `{"relations": {}}`, "namespace without relations"},
+		{"invalid name", `{"relations": {"Owner": null}}`, `invalid relation "Owner"`},
+		{"two rules", `{"relations": {"owner": null, "editor": {"this": {}, "computed_userset": "owner"}}}`, "exactly one rule"},
+		{"no rule", `{"relations": {"editor": {}}}`, "exactly one rule"},
+		{"unknown computed relation", `{"relations": {"editor": {"computed_userset": "owner"}}}`, `unknown relation "owner"`},
+		{"unknown tupleset", `{"relations": {"viewer": {"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}}}`, `unknown tupleset "parent"`},
+		{"invalid tuple to userset relation", `{"relations": {"parent": null, "viewer": {"tuple_to_userset": {"tupleset": "parent", "computed_userset": "-"}}}}`, `invalid relation "-"`},
+		{"empty union member", `{"relations": {"viewer": {"union": [null]}}}`, "empty userset"},
+		{"exclusion without subtract", `{"relations": {"viewer": {"exclusion": {"base": {"this": {}}}}}}`, "needs base and subtract"},
+		{"computed from itself", `{"relations": {"viewer": {"computed_userset": "viewer"}}}`, "computed from itself"},
+		{"computed cycle", `{"relations": {
+			"editor": {"union": [{"this": {}}, {"computed_userset": "viewer"}]},
+			"viewer": {"union": [{"this": {}}, {"computed_userset": "editor"}]}
+		}}`, "computed from itself"},
+		{"cycle through exclusion", `{"relations": {
+			"banned": {"computed_userset": "viewer"},
+			"viewer": {"exclusion": {"base": {"this": {}}, "subtract": {"computed_userset": "banned"}}}
+		}}`, "computed from itself"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config NamespaceConfig
+			if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+				t.Fatal(err)
+			}
+			err := config.Validate()
+			if test.err == "" && err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("Validate() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestNamespaceConfigDirect(t *testing.T) {
+	var config NamespaceConfig
+	err := json.Unmarshal([]byte(`{"relations": {
+		"owner": null,
+		"editor": {"union": [{"this": {}}, {"computed_userset": "owner"}]},
+		"viewer": {"computed_userset": "editor"},
+		"commenter": {"exclusion": {"base": {"computed_userset": "viewer"}, "subtract": {"this": {}}}}
+	}}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for relation, want := range map[string]bool{"owner": true, "editor": true, "viewer": false, "commenter": true, "unknown": false} {
+		if got := config.Direct(relation); got != want {
+			t.Errorf("Direct(%s) = %v, want %v", relation, got, want)
+		}
+	}
+}
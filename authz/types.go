@@ -0,0 +1,177 @@
+// Package authz contains the types of the relationship-based authorization API and a client for it.
+//
+// A relation tuple "document:readme#editor@user:42" states that user 42 is an editor of the document
+// readme. Subjects can also be usersets like "group:eng#member", i.e. all members of the group eng.
+// Namespace configs derive further relations from the stored tuples (computed usersets).
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NamePattern is the format of namespaces and relations
+var NamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// IDPattern is the format of object IDs
+var IDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:@|/+=-]{1,255}$`)
+
+// Subject is a single object, e.g. "user:42", or with a relation the userset "group:eng#member"
+type Subject struct {
+	Namespace string `json:"namespace"`
+	ObjectID  string `json:"object_id"`
+	Relation  string `json:"relation,omitempty"`
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.ObjectID
+	}
+	return s.Namespace + ":" + s.ObjectID + "#" + s.Relation
+}
+
+// Validate checks the format of the subject
+func (s Subject) Validate() error {
+	if !NamePattern.MatchString(s.Namespace) || !IDPattern.MatchString(s.ObjectID) {
+		return fmt.Errorf("invalid subject %q", s.String())
+	}
+	if s.Relation != "" && !NamePattern.MatchString(s.Relation) {
+		return fmt.Errorf("invalid subject relation %q", s.Relation)
+	}
+	return nil
+}
+
+// Tuple relates the subject to the object ObjectID in Namespace
+type Tuple struct {
+	Namespace string  `json:"namespace"`
+	ObjectID  string  `json:"object_id"`
+	Relation  string  `json:"relation"`
+	Subject   Subject `json:"subject"`
+}
+
+func (t Tuple) String() string {
+	return t.Namespace + ":" + t.ObjectID + "#" + t.Relation + "@" + t.Subject.String()
+}
+
+// Validate checks the format of the tuple
+func (t Tuple) Validate() error {
+	if !NamePattern.MatchString(t.Namespace) || !IDPattern.MatchString(t.ObjectID) || !NamePattern.MatchString(t.Relation) {
+		return fmt.Errorf("invalid tuple %q", t.String())
+	}
+	return t.Subject.Validate()
+}
+
+// ParseTuple parses the text form "namespace:object#relation@subject"
+func ParseTuple(text string) (Tuple, error) {
+	object, subject, ok := strings.Cut(text, "@")
+	if !ok {
+		return Tuple{}, errors.New("tuple without subject")
+	}
+	object, relation, ok := strings.Cut(object, "#")
+	if !ok {
+		return Tuple{}, errors.New("tuple without relation")
+	}
+	namespace, objectID, ok := strings.Cut(object, ":")
+	if !ok {
+		return Tuple{}, errors.New("tuple without namespace")
+	}
+	parsed, err := ParseSubject(subject)
+	if err != nil {
+		return Tuple{}, err
+	}
+
+	tuple := Tuple{Namespace: namespace, ObjectID: objectID, Relation: relation, Subject: parsed}
+	return tuple, tuple.Validate()
+}
+
+// ParseSubject parses "namespace:object" or "namespace:object#relation"
+func ParseSubject(text string) (Subject, error) {
+	text, relation, _ := strings.Cut(text, "#")
+	namespace, objectID, ok := strings.Cut(text, ":")
+	if !ok {
+		return Subject{}, errors.New("subject without namespace")
+	}
+	subject := Subject{Namespace: namespace, ObjectID: objectID, Relation: relation}
+	return subject, subject.Validate()
+}
+
+// Consistency selects the snapshot a request is evaluated at. Without both fields the latest snapshot is
+// used. AtLeastAsFresh evaluates at a snapshot that includes the write of the zookie, AtExactSnapshot
+// evaluates exactly at the snapshot of the zookie.
+type Consistency struct {
+	AtLeastAsFresh  string `json:"at_least_as_fresh,omitempty"`
+	AtExactSnapshot string `json:"at_exact_snapshot,omitempty"`
+}
+
+type CheckRequest struct {
+	Namespace   string      `json:"namespace"`
+	ObjectID    string      `json:"object_id"`
+	Relation    string      `json:"relation"`
+	Subject     Subject     `json:"subject"`
+	Consistency Consistency `json:"consistency"`
+}
+
+type CheckResponse struct {
+	Allowed bool `json:"allowed"`
+	// Zookie names the snapshot the check was evaluated at
+	Zookie string `json:"zookie"`
+}
+
+type ExpandRequest struct {
+	Namespace   string      `json:"namespace"`
+	ObjectID    string      `json:"object_id"`
+	Relation    string      `json:"relation"`
+	Consistency Consistency `json:"consistency"`
+}
+
+// Operations of expand tree nodes
+const (
+	OperationLeaf         = "leaf"
+	OperationUnion        = "union"
+	OperationIntersection = "intersection"
+	OperationExclusion    = "exclusion"
+)
+
+// ExpandNode is a node of the userset tree of an object relation. Leaves list the subjects stored in
+// tuples, usersets among them are not expanded further. The children of an exclusion are the base and
+// the subtracted userset.
+type ExpandNode struct {
+	Operation string        `json:"operation"`
+	Namespace string        `json:"namespace"`
+	ObjectID  string        `json:"object_id"`
+	Relation  string        `json:"relation"`
+	Subjects  []Subject     `json:"subjects,omitempty"`
+	Children  []*ExpandNode `json:"children,omitempty"`
+}
+
+type ExpandResponse struct {
+	Tree   *ExpandNode `json:"tree"`
+	Zookie string      `json:"zookie"`
+}
+
+type ListObjectsRequest struct {
+	Namespace   string      `json:"namespace"`
+	Relation    string      `json:"relation"`
+	Subject     Subject     `json:"subject"`
+	Consistency Consistency `json:"consistency"`
+}
+
+type ListObjectsResponse struct {
+	ObjectIDs []string `json:"object_ids"`
+	// Truncated is set if not all objects of the namespace were checked or more objects were found
+	Truncated bool   `json:"truncated,omitempty"`
+	Zookie    string `json:"zookie"`
+}
+
+// WriteRequest adds and deletes tuples atomically. Adding an existing or deleting a missing tuple has no effect.
+type WriteRequest struct {
+	Writes  []Tuple `json:"writes"`
+	Deletes []Tuple `json:"deletes"`
+}
+
+type WriteResponse struct {
+	// Zookie names the snapshot that contains the write
+	Zookie string `json:"zookie"`
+}
@@ -51,6 +51,40 @@ func (s *Server) Connect() *gorm.DB {
 		&models.AuthCode{},
 		&models.RefreshToken{},
 		&models.Consent{},
+		&models.PasswordPolicy{},
+		&models.PasswordHistory{},
+		&models.PasswordReset{},
+		&models.EmailVerification{},
+		&models.EmailTemplate{},
+		&models.TOTPFactor{},
+		&models.RecoveryCode{},
+		&models.MFAChallenge{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.PasswordlessLogin{},
+		&models.Settings{},
+		&models.TenantDomain{},
+		&models.SigningKey{},
+		&models.ClientSecret{},
+		&models.Role{},
+		&models.Group{},
+		&models.Permission{},
+		&models.RolePermission{},
+		&models.UserRole{},
+		&models.UserGroup{},
+		&models.GroupRole{},
+		&models.AuthzNamespace{},
+		&models.RelationTuple{},
+		&models.AuthzRevision{},
+		&models.Policy{},
+		&models.PolicyVersion{},
+		&models.AuditEvent{},
+		&models.ClaimMapper{},
+		&models.UserAttribute{},
+		&models.ScimToken{},
+		&models.OIDCConnection{},
+		&models.UserIdentity{},
+		&models.OIDCLogin{},
 	)
 
 	return db
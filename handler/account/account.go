@@ -1,19 +1,27 @@
 package account
 
 import (
+	"os"
+
 	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
 	"gorm.io/gorm"
 )
 
 type AccountHandler struct {
 	Handler    *handler.Handler
 	KeyManager *helper.KeyManager
+	Mailer     mailer.Mailer
+	// VerifyEmailURL is the page of the account UI which receives the verification token as "token" query parameter
+	VerifyEmailURL string
 }
 
 func NewAccountHandler(db *gorm.DB, keyManager *helper.KeyManager) *AccountHandler {
 	return &AccountHandler{
-		Handler:    handler.NewHandler(db),
-		KeyManager: keyManager,
+		Handler:        handler.NewHandler(db),
+		KeyManager:     keyManager,
+		Mailer:         mailer.Default(),
+		VerifyEmailURL: os.Getenv("VERIFY_EMAIL_URL"),
 	}
 }
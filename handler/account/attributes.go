@@ -0,0 +1,90 @@
+package account
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// AttributesResponse are the custom attributes of the signed in user with their definitions
+type AttributesResponse struct {
+	Attributes map[string]interface{} `json:"attributes"`
+	Schema     []models.UserAttribute `json:"schema"`
+}
+
+// attributesResponse returns the attributes that the user may read
+func (h *AccountHandler) attributesResponse(user *models.User) (*AttributesResponse, error) {
+	schema, err := h.Handler.UserAttributeSchema(user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	response := &AttributesResponse{
+		Attributes: handler.VisibleAttributes(schema, user, false),
+		Schema:     []models.UserAttribute{},
+	}
+	for _, attribute := range schema {
+		if attribute.UserAccess != handler.AttributeAccessNone {
+			response.Schema = append(response.Schema, attribute)
+		}
+	}
+	return response, nil
+}
+
+// Attributes returns the custom attributes of the signed in user
+func (h *AccountHandler) Attributes(w http.ResponseWriter, r *http.Request) {
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.attributesResponse(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// AttributesUpdate changes the attributes that the user may write, null removes a value
+func (h *AccountHandler) AttributesUpdate(w http.ResponseWriter, r *http.Request) {
+	var changes map[string]json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&changes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := h.Handler.ApplyAttributes(tx, user, changes, false); err != nil {
+			return err
+		}
+		return tx.Model(user).Update("attributes", user.Attributes).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	response, err := h.attributesResponse(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
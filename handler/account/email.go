@@ -0,0 +1,69 @@
+package account
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type EmailChangeRequest struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// EmailChange sends a verification email to the new address. The address is changed once it is confirmed.
+func (h *AccountHandler) EmailChange(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(models.Session)
+
+	var request EmailChangeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request.NewEmail = helper.NormalizeEmail(request.NewEmail)
+	if request.NewEmail == "" {
+		http.Error(w, "New email is required", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid, _, err := helper.DefaultHasher().Compare(request.CurrentPassword, user.Password)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid current password", http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) = ? AND tenant_id = ? AND id <> ?", request.NewEmail, user.TenantID, user.ID).Count(&count)
+	if count > 0 {
+		http.Error(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+
+	err = h.Handler.SendEmailVerification(h.Mailer, &user, request.NewEmail, h.VerifyEmailURL)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "A verification link has been sent to the new email address",
+	})
+}
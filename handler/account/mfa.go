@@ -0,0 +1,162 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (h *AccountHandler) sessionUser(r *http.Request) (*models.User, error) {
+	session := r.Context().Value("session").(models.Session)
+
+	var user models.User
+	err := h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// TOTPEnroll creates a new authenticator app secret for the signed in user
+func (h *AccountHandler) TOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	secret, uri, err := h.Handler.BeginTOTPEnrollment(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{Secret: secret, URI: uri})
+}
+
+// TOTPConfirm activates the authenticator app with a first code and returns the recovery codes
+func (h *AccountHandler) TOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	var request TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recoveryCodes, err := h.Handler.ConfirmTOTPEnrollment(user, request.Code)
+	if errors.Is(err, handler.ErrInvalidMFACode) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// TOTPRemove removes the authenticator app after checking a current code
+func (h *AccountHandler) TOTPRemove(w http.ResponseWriter, r *http.Request) {
+	var request TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if h.Handler.MFARequired(user, nil) && !h.Handler.HasWebAuthn(user.ID) {
+		http.Error(w, "The tenant requires a second factor", http.StatusForbidden)
+		return
+	}
+
+	valid, err := h.Handler.VerifyTOTP(user.ID, request.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.Handler.RemoveTOTP(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Authenticator app removed",
+	})
+}
+
+// RecoveryCodes replaces the recovery codes of the user after checking a current code
+func (h *AccountHandler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var request TOTPCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := h.Handler.VerifyTOTP(user.ID, request.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	recoveryCodes, err := h.Handler.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/helper"
 	"github.com/secnex/sethorize-kit/models"
 )
@@ -30,10 +31,10 @@ func (h *AccountHandler) PasswordChange(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	argon2 := helper.NewArgon2Default()
-	verifyOldPassword, err := argon2.Compare(request.CurrentPassword, user.Password)
+	hasher := helper.DefaultHasher()
+	verifyOldPassword, _, err := hasher.Compare(request.CurrentPassword, user.Password)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
 		return
 	}
 	if !verifyOldPassword {
@@ -41,15 +42,12 @@ func (h *AccountHandler) PasswordChange(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hash, err := argon2.Hash(request.NewPassword)
+	err = h.Handler.SetPassword(h.Handler.DB, &user, request.NewPassword)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handler.WritePasswordError(w, err)
 		return
 	}
 
-	user.Password = hash
-	h.Handler.DB.Save(&user)
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
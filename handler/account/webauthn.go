@@ -0,0 +1,132 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type WebAuthnRegisterRequest struct {
+	Name       string                      `json:"name"`
+	Credential handler.WebAuthnAttestation `json:"credential"`
+}
+
+type WebAuthnRemoveRequest struct {
+	ID string `json:"id"`
+}
+
+// WebAuthnRegisterBegin returns the options to register a new security key or passkey
+func (h *AccountHandler) WebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	options, err := h.Handler.BeginWebAuthnRegistration(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"publicKey": options,
+	})
+}
+
+// WebAuthnRegisterFinish verifies the new credential and stores it
+func (h *AccountHandler) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	var request WebAuthnRegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.Handler.FinishWebAuthnRegistration(user, request.Name, &request.Credential)
+	if errors.Is(err, handler.ErrWebAuthnAlreadyExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+// WebAuthnCredentials lists the security keys and passkeys of the user
+func (h *AccountHandler) WebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value("session").(models.Session)
+
+	var credentials []models.WebAuthnCredential
+	err := h.Handler.DB.Where("user_id = ?", session.UserID).Order("created_at").Find(&credentials).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// WebAuthnRemove deletes a security key or passkey. The last second factor cannot be removed if the tenant requires MFA.
+func (h *AccountHandler) WebAuthnRemove(w http.ResponseWriter, r *http.Request) {
+	var request WebAuthnRemoveRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := uuid.Parse(request.ID); err != nil {
+		http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if h.Handler.MFARequired(user, nil) && !h.Handler.HasTOTP(user.ID) {
+		var count int64
+		h.Handler.DB.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&count)
+		if count <= 1 {
+			http.Error(w, "The tenant requires a second factor", http.StatusForbidden)
+			return
+		}
+	}
+
+	err = h.Handler.RemoveWebAuthnCredential(user.ID, request.ID)
+	if errors.Is(err, handler.ErrWebAuthnCredential) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Security key removed",
+	})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// Authentication method references (RFC 8176)
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+	// AMREmail is not registered in RFC 8176 and marks a login with a link or code sent by email
+	AMREmail = "email"
+	// AMRPin is used for the user verification of a passkey, which is a PIN or biometrics on the authenticator
+	AMRPin = "pin"
+	// AMRFederated is not registered in RFC 8176 and marks a login at an upstream OpenID Connect provider
+	AMRFederated = "fed"
+)
+
+// Authentication context classes, ordered from weakest to strongest
+const (
+	ACRSingleFactor      = "urn:sethorize:acr:1fa"
+	ACRMultiFactor       = "urn:sethorize:acr:mfa"
+	ACRPhishingResistant = "urn:sethorize:acr:phr"
+)
+
+// ErrorInsufficientAuthentication is the error code of responses that require a step-up (RFC 9470)
+const ErrorInsufficientAuthentication = "insufficient_user_authentication"
+
+var acrLevels = map[string]int{
+	ACRSingleFactor:      1,
+	ACRMultiFactor:       2,
+	ACRPhishingResistant: 3,
+}
+
+// NewAuthentication records the methods of a login that happened now and derives its ACR
+func NewAuthentication(methods ...string) models.Authentication {
+	return AddAuthMethods(models.Authentication{}, methods...)
+}
+
+// AddAuthMethods adds the methods of a step-up to an existing authentication and resets its time
+func AddAuthMethods(authentication models.Authentication, methods ...string) models.Authentication {
+	amr := slices.Clone([]string(authentication.AuthMethods))
+	for _, method := range methods {
+		if !slices.Contains(amr, method) {
+			amr = append(amr, method)
+		}
+	}
+
+	factors := 0
+	for _, method := range amr {
+		if method != AMRMultiFactor {
+			factors++
+		}
+	}
+	if factors > 1 && !slices.Contains(amr, AMRMultiFactor) {
+		amr = append(amr, AMRMultiFactor)
+	}
+
+	acr := ACRSingleFactor
+	if factors > 1 {
+		acr = ACRMultiFactor
+		if slices.Contains(amr, AMRHardwareKey) {
+			acr = ACRPhishingResistant
+		}
+	}
+
+	return models.Authentication{
+		AuthMethods: pq.StringArray(amr),
+		ACR:         acr,
+		AuthTime:    time.Now(),
+	}
+}
+
+// ACRSatisfies reports whether acr is at least as strong as required. Unknown values never satisfy.
+func ACRSatisfies(acr string, required string) bool {
+	level, ok := acrLevels[acr]
+	requiredLevel, known := acrLevels[required]
+	return ok && known && level >= requiredLevel
+}
+
+// ACRSatisfiesAny reports whether acr satisfies one of the space separated acr_values
+func ACRSatisfiesAny(acr string, acrValues string) bool {
+	for _, required := range strings.Fields(acrValues) {
+		if ACRSatisfies(acr, required) {
+			return true
+		}
+	}
+	return false
+}
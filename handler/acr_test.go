@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestNewAuthentication(t *testing.T) {
+	tests := []struct {
+		methods []string
+		amr     []string
+		acr     string
+	}{
+		{[]string{AMRPassword}, []string{AMRPassword}, ACRSingleFactor},
+		{[]string{AMREmail}, []string{AMREmail}, ACRSingleFactor},
+		{[]string{AMRPassword, AMROTP}, []string{AMRPassword, AMROTP, AMRMultiFactor}, ACRMultiFactor},
+		{[]string{AMRPassword, AMRHardwareKey}, []string{AMRPassword, AMRHardwareKey, AMRMultiFactor}, ACRPhishingResistant},
+		{[]string{AMRHardwareKey, AMRPin}, []string{AMRHardwareKey, AMRPin, AMRMultiFactor}, ACRPhishingResistant},
+		// Repeated methods are one factor
+		{[]string{AMRPassword, AMRPassword}, []string{AMRPassword}, ACRSingleFactor},
+	}
+	for _, test := range tests {
+		authentication := NewAuthentication(test.methods...)
+		if !slices.Equal([]string(authentication.AuthMethods), test.amr) || authentication.ACR != test.acr {
+			t.Errorf("%v: amr = %v, acr = %s, want %v, %s", test.methods, authentication.AuthMethods, authentication.ACR, test.amr, test.acr)
+		}
+		if time.Since(authentication.AuthTime) > time.Second {
+			t.Errorf("%v: auth_time = %s", test.methods, authentication.AuthTime)
+		}
+	}
+}
+
+func TestAddAuthMethodsStepsUp(t *testing.T) {
+	login := models.Authentication{
+		AuthMethods: pq.StringArray{AMRPassword},
+		ACR:         ACRSingleFactor,
+		AuthTime:    time.Now().Add(-time.Hour),
+	}
+
+	stepUp := AddAuthMethods(login, AMROTP)
+	if !slices.Equal([]string(stepUp.AuthMethods), []string{AMRPassword, AMROTP, AMRMultiFactor}) || stepUp.ACR != ACRMultiFactor {
+		t.Errorf("step-up = %v, %s", stepUp.AuthMethods, stepUp.ACR)
+	}
+	if time.Since(stepUp.AuthTime) > time.Second {
+		t.Errorf("auth_time was not reset: %s", stepUp.AuthTime)
+	}
+	if len(login.AuthMethods) != 1 {
+		t.Errorf("the methods of the login were changed: %v", login.AuthMethods)
+	}
+
+	stepUp = AddAuthMethods(stepUp, AMRHardwareKey)
+	if !slices.Equal([]string(stepUp.AuthMethods), []string{AMRPassword, AMROTP, AMRMultiFactor, AMRHardwareKey}) || stepUp.ACR != ACRPhishingResistant {
+		t.Errorf("second step-up = %v, %s", stepUp.AuthMethods, stepUp.ACR)
+	}
+}
+
+func TestACRSatisfies(t *testing.T) {
+	tests := []struct {
+		acr      string
+		required string
+		ok       bool
+	}{
+		{ACRSingleFactor, ACRSingleFactor, true},
+		{ACRSingleFactor, ACRMultiFactor, false},
+		{ACRMultiFactor, ACRSingleFactor, true},
+		{ACRMultiFactor, ACRPhishingResistant, false},
+		{ACRPhishingResistant, ACRMultiFactor, true},
+		{"", ACRSingleFactor, false},
+		{"urn:other:acr", ACRSingleFactor, false},
+		{ACRPhishingResistant, "urn:other:acr", false},
+	}
+	for _, test := range tests {
+		if ok := ACRSatisfies(test.acr, test.required); ok != test.ok {
+			t.Errorf("ACRSatisfies(%q, %q) = %t", test.acr, test.required, ok)
+		}
+	}
+
+	if !ACRSatisfiesAny(ACRMultiFactor, "urn:other:acr "+ACRMultiFactor) {
+		t.Error("one satisfied value is not enough")
+	}
+	if ACRSatisfiesAny(ACRSingleFactor, ACRMultiFactor+" "+ACRPhishingResistant) || ACRSatisfiesAny(ACRMultiFactor, "") {
+		t.Error("acr_values satisfied by a weaker acr")
+	}
+}
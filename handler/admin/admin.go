@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	Handler *handler.Handler
+	Mailer  mailer.Mailer
+	// PasswordResetURL is the page of the account UI which receives the reset token as "token" query parameter
+	PasswordResetURL string
+}
+
+func NewAdminHandler(db *gorm.DB) *AdminHandler {
+	return &AdminHandler{
+		Handler:          handler.NewHandler(db),
+		Mailer:           mailer.Default(),
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+	}
+}
+
+// adminTenant returns the admin of the request and the tenant the request is scoped to: the tenant of the
+// admin or, for super admins, the tenant given as "tenant" query parameter
+func (h *AdminHandler) adminTenant(w http.ResponseWriter, r *http.Request) (*models.User, uuid.UUID, bool) {
+	session, ok := r.Context().Value("session").(models.Session)
+	if !ok {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	var admin models.User
+	err := h.Handler.DB.Where("id = ?", session.UserID).First(&admin).Error
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return nil, uuid.Nil, false
+	}
+
+	tenantParam := r.URL.Query().Get("tenant")
+	if tenantParam == "" {
+		return &admin, admin.TenantID, true
+	}
+	if !admin.IsSuperAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, uuid.Nil, false
+	}
+
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ?", tenantParam).First(&tenant).Error
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return nil, uuid.Nil, false
+	}
+	return &admin, tenant.ID, true
+}
+
+// pagination reads page (from 1) and per_page (default 50, at most 200) from the query
+func pagination(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 50
+	}
+	if perPage > 200 {
+		perPage = 200
+	}
+	return page, perPage
+}
+
+// likePattern returns a pattern for ILIKE that matches the term literally anywhere
+func likePattern(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(term) + "%"
+}
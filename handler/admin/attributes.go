@@ -0,0 +1,217 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// AttributeRequest defines a custom user attribute or, for updates, changes the fields that are set.
+// Name and type cannot be changed.
+type AttributeRequest struct {
+	Name        *string   `json:"name"`
+	DisplayName *string   `json:"display_name"`
+	Type        *string   `json:"type"`
+	Required    *bool     `json:"required"`
+	Unique      *bool     `json:"unique"`
+	Pattern     *string   `json:"pattern"`
+	MaxLength   *int      `json:"max_length"`
+	Min         *float64  `json:"min"`
+	Max         *float64  `json:"max"`
+	Options     *[]string `json:"options"`
+	UserAccess  *string   `json:"user_access"`
+	AdminAccess *string   `json:"admin_access"`
+}
+
+func applyAttributeRequest(attribute *models.UserAttribute, request *AttributeRequest) {
+	if request.DisplayName != nil {
+		attribute.DisplayName = *request.DisplayName
+	}
+	if request.Required != nil {
+		attribute.Required = *request.Required
+	}
+	if request.Unique != nil {
+		attribute.Unique = *request.Unique
+	}
+	if request.Pattern != nil {
+		attribute.Pattern = *request.Pattern
+	}
+	if request.MaxLength != nil {
+		attribute.MaxLength = *request.MaxLength
+	}
+	if request.Min != nil {
+		attribute.Min = request.Min
+	}
+	if request.Max != nil {
+		attribute.Max = request.Max
+	}
+	if request.Options != nil {
+		attribute.Options = pq.StringArray(*request.Options)
+	}
+	if request.UserAccess != nil {
+		attribute.UserAccess = *request.UserAccess
+	}
+	if request.AdminAccess != nil {
+		attribute.AdminAccess = *request.AdminAccess
+	}
+}
+
+// writeAttributeError answers invalid attributes with 400 and other errors with 500
+func writeAttributeError(w http.ResponseWriter, err error) {
+	var attributeErr *handler.AttributeError
+	if errors.As(err, &attributeErr) {
+		handler.WriteError(w, http.StatusBadRequest, "invalid_attribute", attributeErr.Message)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// Attributes lists the custom user attributes of the tenant
+func (h *AdminHandler) Attributes(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	schema, err := h.Handler.UserAttributeSchema(tenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if schema == nil {
+		schema = []models.UserAttribute{}
+	}
+
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// AttributeCreate defines a custom user attribute. Users can read new attributes and admins write them
+// unless user_access and admin_access say otherwise.
+func (h *AdminHandler) AttributeCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request AttributeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == nil || request.Type == nil {
+		http.Error(w, "name and type are required", http.StatusBadRequest)
+		return
+	}
+
+	attribute := models.UserAttribute{
+		TenantID:    tenantID,
+		Name:        *request.Name,
+		Type:        *request.Type,
+		UserAccess:  handler.AttributeAccessRead,
+		AdminAccess: handler.AttributeAccessWrite,
+	}
+	applyAttributeRequest(&attribute, &request)
+	if err := handler.ValidateAttributeDefinition(&attribute); err != nil {
+		writeAttributeError(w, err)
+		return
+	}
+	if h.nameTaken(&models.UserAttribute{}, tenantID, attribute.Name, attribute.ID) {
+		http.Error(w, "An attribute with this name exists", http.StatusConflict)
+		return
+	}
+
+	err = h.Handler.DB.Create(&attribute).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, attribute)
+}
+
+// AttributeUpdate changes the constraints or access of an attribute. Stored values are not revalidated,
+// but an attribute can only be made unique if the stored values are.
+func (h *AdminHandler) AttributeUpdate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var attribute models.UserAttribute
+	if !h.loadTenantRecord(w, r, tenantID, "attribute", &attribute) {
+		return
+	}
+
+	var request AttributeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name != nil && *request.Name != attribute.Name || request.Type != nil && *request.Type != attribute.Type {
+		http.Error(w, "Name and type of an attribute cannot be changed", http.StatusBadRequest)
+		return
+	}
+
+	wasUnique := attribute.Unique
+	applyAttributeRequest(&attribute, &request)
+	if err := handler.ValidateAttributeDefinition(&attribute); err != nil {
+		writeAttributeError(w, err)
+		return
+	}
+
+	if attribute.Unique && !wasUnique {
+		var duplicates int64
+		err := h.Handler.DB.Raw(`SELECT COUNT(*) FROM (SELECT attributes ->> ? FROM users
+			WHERE tenant_id = ? AND attributes ->> ? IS NOT NULL GROUP BY 1 HAVING COUNT(*) > 1) AS duplicates`,
+			attribute.Name, tenantID, attribute.Name).Scan(&duplicates).Error
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if duplicates > 0 {
+			http.Error(w, "Users share values of the attribute", http.StatusConflict)
+			return
+		}
+	}
+
+	err = h.Handler.DB.Save(&attribute).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, attribute)
+}
+
+// AttributeDelete removes an attribute and its values from all users of the tenant
+func (h *AdminHandler) AttributeDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var attribute models.UserAttribute
+	if !h.loadTenantRecord(w, r, tenantID, "attribute", &attribute) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&models.User{}).Where("tenant_id = ?", tenantID).
+			Update("attributes", gorm.Expr("attributes - ?::text", attribute.Name)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&attribute).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
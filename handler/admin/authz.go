@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/authz"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// AuthzNamespaces lists the namespace configs of the tenant
+func (h *AdminHandler) AuthzNamespaces(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	namespaces := []models.AuthzNamespace{}
+	err := h.Handler.DB.Where("tenant_id = ?", tenantID).Order("name").Find(&namespaces).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, namespaces)
+}
+
+// AuthzNamespaceSave creates or replaces the config of a namespace; the body is an authz.NamespaceConfig
+func (h *AdminHandler) AuthzNamespaceSave(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var config authz.NamespaceConfig
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	namespace, err := h.Handler.SaveAuthzNamespace(tenantID, mux.Vars(r)["namespace"], &config)
+	writeAuthzResult(w, namespace, err)
+}
+
+// AuthzNamespaceDelete deletes a namespace without tuples
+func (h *AdminHandler) AuthzNamespaceDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.DeleteAuthzNamespace(tenantID, mux.Vars(r)["namespace"])
+	writeAuthzResult(w, map[string]string{"message": "Namespace deleted"}, err)
+}
+
+func writeAuthzResult(w http.ResponseWriter, value interface{}, err error) {
+	var authzErr *handler.AuthzError
+	if errors.As(err, &authzErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, value)
+}
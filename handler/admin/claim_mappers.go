@@ -0,0 +1,263 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// ClaimMapperRequest creates a claim mapper or, for updates, changes the fields that are set
+type ClaimMapperRequest struct {
+	Claim  *string          `json:"claim"`
+	Scope  *string          `json:"scope"`
+	Type   *string          `json:"type"`
+	Value  *json.RawMessage `json:"value"`
+	Source *string          `json:"source"`
+}
+
+// ClaimPreviewRequest maps the claims of a token of the user with the scope. Mappers replace the stored
+// mappers of the client, e.g. to try a draft.
+type ClaimPreviewRequest struct {
+	UserID  *uuid.UUID           `json:"user_id"`
+	Scope   string               `json:"scope"`
+	Mappers []models.ClaimMapper `json:"mappers"`
+}
+
+// ClaimPreviewResponse are the mapped claims with the result of every mapper
+type ClaimPreviewResponse struct {
+	Claims  map[string]interface{}      `json:"claims"`
+	Results []handler.ClaimMapperResult `json:"results"`
+}
+
+// writeClaimMapperError answers invalid mappers with 400 and other errors with 500
+func writeClaimMapperError(w http.ResponseWriter, err error) {
+	var mapperErr *handler.ClaimMapperError
+	if errors.As(err, &mapperErr) {
+		handler.WriteError(w, http.StatusBadRequest, "invalid_claim_mapper", mapperErr.Message)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// applyClaimMapperRequest sets the fields of the request on the mapper
+func applyClaimMapperRequest(mapper *models.ClaimMapper, request *ClaimMapperRequest) {
+	if request.Claim != nil {
+		mapper.Claim = *request.Claim
+	}
+	if request.Scope != nil {
+		mapper.Scope = *request.Scope
+	}
+	if request.Type != nil {
+		mapper.Type = *request.Type
+	}
+	if request.Value != nil {
+		mapper.Value = *request.Value
+	}
+	if request.Source != nil {
+		mapper.Source = *request.Source
+	}
+}
+
+// claimMapperTaken reports whether another mapper of the client maps the claim for the scope
+func (h *AdminHandler) claimMapperTaken(mapper *models.ClaimMapper) bool {
+	var count int64
+	h.Handler.DB.Model(&models.ClaimMapper{}).Where("client_id = ? AND claim = ? AND scope = ? AND id <> ?", mapper.ClientID, mapper.Claim, mapper.Scope, mapper.ID).Count(&count)
+	return count > 0
+}
+
+// ClaimMappers lists the claim mappers of the client
+func (h *AdminHandler) ClaimMappers(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	mappers, err := h.Handler.ClientClaimMappers(client.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if mappers == nil {
+		mappers = []models.ClaimMapper{}
+	}
+
+	writeJSON(w, http.StatusOK, mappers)
+}
+
+// ClaimMapperCreate adds a claim mapper to the client. Once a client has mappers, its tokens no longer
+// carry the default user claim.
+func (h *AdminHandler) ClaimMapperCreate(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var request ClaimMapperRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mapper := models.ClaimMapper{TenantID: client.TenantID, ClientID: client.ID}
+	applyClaimMapperRequest(&mapper, &request)
+	if err := handler.ValidateClaimMapper(&mapper); err != nil {
+		writeClaimMapperError(w, err)
+		return
+	}
+	if h.claimMapperTaken(&mapper) {
+		http.Error(w, "The claim is mapped for this scope already", http.StatusConflict)
+		return
+	}
+
+	err = h.Handler.DB.Create(&mapper).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, mapper)
+}
+
+// ClaimMapperUpdate changes a claim mapper of the client
+func (h *AdminHandler) ClaimMapperUpdate(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+	var mapper models.ClaimMapper
+	if !h.loadTenantRecord(w, r, client.TenantID, "mapper", &mapper) || !h.ownClaimMapper(w, client, &mapper) {
+		return
+	}
+
+	var request ClaimMapperRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyClaimMapperRequest(&mapper, &request)
+	if err := handler.ValidateClaimMapper(&mapper); err != nil {
+		writeClaimMapperError(w, err)
+		return
+	}
+	if h.claimMapperTaken(&mapper) {
+		http.Error(w, "The claim is mapped for this scope already", http.StatusConflict)
+		return
+	}
+
+	err = h.Handler.DB.Save(&mapper).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mapper)
+}
+
+// ClaimMapperDelete removes a claim mapper of the client
+func (h *AdminHandler) ClaimMapperDelete(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+	var mapper models.ClaimMapper
+	if !h.loadTenantRecord(w, r, client.TenantID, "mapper", &mapper) || !h.ownClaimMapper(w, client, &mapper) {
+		return
+	}
+
+	err := h.Handler.DB.Delete(&mapper).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownClaimMapper answers with 404 if the mapper belongs to another client
+func (h *AdminHandler) ownClaimMapper(w http.ResponseWriter, client *models.Client, mapper *models.ClaimMapper) bool {
+	if mapper.ClientID != client.ID {
+		http.Error(w, "Mapper not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ClaimMapperPreview returns the claims that the mappers of the client produce for a token of the user,
+// together with the roles, groups and permissions claims. Without a user the claims of a client
+// credentials token are previewed.
+func (h *AdminHandler) ClaimMapperPreview(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var request ClaimPreviewRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var tenant models.Tenant
+	if err := h.Handler.DB.Where("id = ?", client.TenantID).First(&tenant).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	input := handler.ClaimInput{Tenant: &tenant, Client: client, Scopes: strings.Fields(request.Scope)}
+	if request.UserID != nil {
+		var user models.User
+		if h.Handler.DB.Where("id = ? AND tenant_id = ?", *request.UserID, client.TenantID).First(&user).Error != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		authorization, err := h.Handler.UserAuthorization(user.ID, &client.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		input.User = &user
+		input.Authorization = &authorization
+	}
+
+	mappers := request.Mappers
+	input.Preview = mappers != nil
+	if mappers == nil {
+		mappers, err = h.Handler.ClientClaimMappers(client.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	for i := range mappers {
+		if err := handler.ValidateClaimMapper(&mappers[i]); err != nil {
+			writeClaimMapperError(w, err)
+			return
+		}
+	}
+
+	response := ClaimPreviewResponse{Claims: map[string]interface{}{}, Results: []handler.ClaimMapperResult{}}
+	if len(mappers) == 0 && input.User != nil {
+		response.Claims["user"] = handler.DefaultUserClaim(input.User, &tenant)
+	} else {
+		response.Claims, response.Results, err = h.Handler.MapClaims(mappers, input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if input.Authorization != nil {
+		for name, value := range input.Authorization.Claims() {
+			response.Claims[name] = value
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,425 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// AdminClient is the view of a client in the admin API. GrantTypes is null if the client inherits the
+// allowed grants of its tenant.
+type AdminClient struct {
+	models.Client
+	GrantTypes []string `json:"grant_types"`
+}
+
+type ClientCreateRequest struct {
+	Name         string   `json:"name"`
+	Slug         string   `json:"slug"`
+	Description  string   `json:"description"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Internal     bool     `json:"internal"`
+	// SecretExpiresIn is the lifetime of the secret in seconds, 0 never expires
+	SecretExpiresIn int `json:"secret_expires_in"`
+}
+
+// ClientUpdateRequest only changes the fields that are set. An empty grant_types list is stored as is,
+// use inherit_grant_types to inherit the grants of the tenant again.
+type ClientUpdateRequest struct {
+	Name              *string   `json:"name"`
+	Description       *string   `json:"description"`
+	RedirectURIs      *[]string `json:"redirect_uris"`
+	Scopes            *[]string `json:"scopes"`
+	GrantTypes        *[]string `json:"grant_types"`
+	InheritGrantTypes bool      `json:"inherit_grant_types"`
+	IsActive          *bool     `json:"is_active"`
+}
+
+type ClientSecretRotateRequest struct {
+	// GracePeriod in seconds during which the previous secrets stay valid, default 7 days
+	GracePeriod *int `json:"grace_period"`
+	// ExpiresIn is the lifetime of the new secret in seconds, 0 never expires
+	ExpiresIn int `json:"expires_in"`
+}
+
+// ClientSecretResponse contains a new secret, which is only returned once
+type ClientSecretResponse struct {
+	Client       AdminClient         `json:"client"`
+	Secret       string              `json:"secret"`
+	ClientSecret models.ClientSecret `json:"secret_info"`
+}
+
+func validateRedirectURIs(uris []string) error {
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return errors.New("redirect URIs must be absolute URLs without fragment")
+		}
+		local := parsed.Hostname() == "localhost" || parsed.Hostname() == "127.0.0.1"
+		if parsed.Scheme == "http" && !local {
+			return errors.New("redirect URIs must use https except for localhost")
+		}
+	}
+	return nil
+}
+
+func expiryIn(seconds int) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(seconds) * time.Second)
+}
+
+func (h *AdminHandler) adminClient(client *models.Client) AdminClient {
+	view := AdminClient{Client: *client}
+	var settings models.Settings
+	if h.Handler.DB.Where("client_id = ?", client.ID).First(&settings).Error == nil && settings.AllowedGrants != nil {
+		view.GrantTypes = []string(settings.AllowedGrants)
+	}
+	return view
+}
+
+// setClientGrants stores the allowed grants in the client settings, nil inherits them from the tenant
+func (h *AdminHandler) setClientGrants(client *models.Client, grants []string) error {
+	settings, err := h.Handler.SettingsLevel(&client.TenantID, &client.ID)
+	if err != nil {
+		return err
+	}
+	settings.AllowedGrants = grants
+	return h.Handler.SaveSettingsLevel(settings)
+}
+
+// loadClient returns the client of the {client} route parameter within the tenant of the request
+func (h *AdminHandler) loadClient(w http.ResponseWriter, r *http.Request) (*models.Client, bool) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	id := mux.Vars(r)["client"]
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invalid client ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var client models.Client
+	err := h.Handler.DB.Where("id = ? AND tenant_id = ?", id, tenantID).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &client, true
+}
+
+// Clients lists the clients of the tenant, filtered by q (name or slug) and paginated with page and per_page
+func (h *AdminHandler) Clients(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.Handler.DB.Model(&models.Client{}).Where("tenant_id = ?", tenantID)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := likePattern(q)
+		query = query.Where("name ILIKE ? OR slug ILIKE ?", pattern, pattern)
+	}
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := pagination(r)
+	var clients []models.Client
+	err = query.Order("created_at, id").Offset((page - 1) * perPage).Limit(perPage).Find(&clients).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	views := []AdminClient{}
+	for i := range clients {
+		views = append(views, h.adminClient(&clients[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"clients":  views,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// Client returns a single client of the tenant
+func (h *AdminHandler) Client(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.adminClient(client))
+}
+
+// ClientCreate creates a client in the tenant. The secret is only part of this response.
+func (h *AdminHandler) ClientCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request ClientCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Name == "" || !tenantSlugPattern.MatchString(request.Slug) {
+		http.Error(w, "Name and a lowercase slug are required", http.StatusBadRequest)
+		return
+	}
+	if err := validateRedirectURIs(request.RedirectURIs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, grant := range request.GrantTypes {
+		if !slices.Contains(handler.Grants, grant) {
+			http.Error(w, "Unknown grant "+grant, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.Client{}).Where("tenant_id = ? AND (name = ? OR slug = ?)", tenantID, request.Name, request.Slug).Count(&count)
+	if count > 0 {
+		http.Error(w, "A client with this name or slug exists", http.StatusConflict)
+		return
+	}
+
+	client := models.Client{
+		Name:         request.Name,
+		Slug:         request.Slug,
+		Description:  request.Description,
+		RedirectURIs: pq.StringArray(request.RedirectURIs),
+		Scopes:       pq.StringArray(request.Scopes),
+		IsActive:     true,
+		Internal:     request.Internal,
+		TenantID:     tenantID,
+	}
+	if client.RedirectURIs == nil {
+		client.RedirectURIs = pq.StringArray{}
+	}
+
+	var secret string
+	var clientSecret *models.ClientSecret
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+		var err error
+		secret, clientSecret, err = h.Handler.CreateClientSecret(tx, client.ID, expiryIn(request.SecretExpiresIn))
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	if request.GrantTypes != nil {
+		err = h.setClientGrants(&client, request.GrantTypes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, ClientSecretResponse{Client: h.adminClient(&client), Secret: secret, ClientSecret: *clientSecret})
+}
+
+// ClientUpdate changes name, description, redirect URIs, scopes, grant types and IsActive of a client.
+// Disabling a client revokes its sessions.
+func (h *AdminHandler) ClientUpdate(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var request ClientUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != nil {
+		if *request.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		client.Name = *request.Name
+		updates["name"] = client.Name
+	}
+	if request.Description != nil {
+		client.Description = *request.Description
+		updates["description"] = client.Description
+	}
+	if request.RedirectURIs != nil {
+		if err := validateRedirectURIs(*request.RedirectURIs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		client.RedirectURIs = pq.StringArray(*request.RedirectURIs)
+		updates["redirect_uris"] = client.RedirectURIs
+	}
+	if request.Scopes != nil {
+		client.Scopes = pq.StringArray(*request.Scopes)
+		updates["scopes"] = client.Scopes
+	}
+	if request.IsActive != nil {
+		client.IsActive = *request.IsActive
+		updates["is_active"] = client.IsActive
+	}
+
+	if request.GrantTypes != nil || request.InheritGrantTypes {
+		var grants []string
+		if request.GrantTypes != nil {
+			grants = *request.GrantTypes
+		}
+		err = h.setClientGrants(client, grants)
+		var settingsErr *handler.SettingsError
+		if errors.As(err, &settingsErr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(updates) > 0 {
+		err = h.Handler.DB.Model(client).Updates(updates).Error
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if request.IsActive != nil && !*request.IsActive {
+		if err := h.Handler.RevokeClientSessions(client.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, h.adminClient(client))
+}
+
+// ClientDelete soft-deletes the client and revokes its sessions
+func (h *AdminHandler) ClientDelete(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.RevokeClientSessions(client.ID)
+	if err == nil {
+		err = h.Handler.DB.Delete(client).Error
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Client deleted",
+	})
+}
+
+// ClientSecrets lists the secrets of the client without their values
+func (h *AdminHandler) ClientSecrets(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var secrets []models.ClientSecret
+	err := h.Handler.DB.Where("client_id = ?", client.ID).Order("created_at DESC").Find(&secrets).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, secrets)
+}
+
+// ClientSecretRotate creates a new secret. The previous secrets stay valid for the grace period.
+func (h *AdminHandler) ClientSecretRotate(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var request ClientSecretRotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	gracePeriod := handler.DefaultSecretGracePeriod
+	if request.GracePeriod != nil {
+		if *request.GracePeriod < 0 {
+			http.Error(w, "Grace period must not be negative", http.StatusBadRequest)
+			return
+		}
+		gracePeriod = time.Duration(*request.GracePeriod) * time.Second
+	}
+
+	secret, clientSecret, err := h.Handler.RotateClientSecret(client, gracePeriod, expiryIn(request.ExpiresIn))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client.Secret = ""
+
+	writeJSON(w, http.StatusCreated, ClientSecretResponse{Client: h.adminClient(client), Secret: secret, ClientSecret: *clientSecret})
+}
+
+// ClientSecretRevoke lets a secret expire immediately
+func (h *AdminHandler) ClientSecretRevoke(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	result := h.Handler.DB.Model(&models.ClientSecret{}).
+		Where("id = ? AND client_id = ?", mux.Vars(r)["secret"], client.ID).
+		Update("expires_at", time.Now())
+	if result.Error != nil {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Secret revoked",
+	})
+}
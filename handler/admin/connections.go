@@ -0,0 +1,266 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// ConnectionRequest defines an upstream OIDC connection or, for updates, changes the fields that are set.
+// An empty client_secret makes the connection a public client.
+type ConnectionRequest struct {
+	Name            *string          `json:"name"`
+	DisplayName     *string          `json:"display_name"`
+	Issuer          *string          `json:"issuer"`
+	ClientID        *string          `json:"client_id"`
+	ClientSecret    *string          `json:"client_secret"`
+	Scopes          *[]string        `json:"scopes"`
+	Domains         *[]string        `json:"domains"`
+	ClaimMapping    *json.RawMessage `json:"claim_mapping"`
+	JITProvisioning *bool            `json:"jit_provisioning"`
+	LinkByEmail     *bool            `json:"link_by_email"`
+	IsActive        *bool            `json:"is_active"`
+}
+
+func applyConnectionRequest(connection *models.OIDCConnection, request *ConnectionRequest) {
+	if request.Name != nil {
+		connection.Name = *request.Name
+	}
+	if request.DisplayName != nil {
+		connection.DisplayName = *request.DisplayName
+	}
+	if request.Issuer != nil {
+		connection.Issuer = *request.Issuer
+	}
+	if request.ClientID != nil {
+		connection.ClientID = *request.ClientID
+	}
+	if request.Scopes != nil {
+		connection.Scopes = pq.StringArray(*request.Scopes)
+	}
+	if request.Domains != nil {
+		connection.Domains = pq.StringArray(*request.Domains)
+	}
+	if request.ClaimMapping != nil {
+		connection.ClaimMapping = *request.ClaimMapping
+	}
+	if request.JITProvisioning != nil {
+		connection.JITProvisioning = *request.JITProvisioning
+	}
+	if request.LinkByEmail != nil {
+		connection.LinkByEmail = *request.LinkByEmail
+	}
+	if request.IsActive != nil {
+		connection.IsActive = *request.IsActive
+	}
+}
+
+// validateConnection checks the connection and fetches the discovery document of its issuer
+func (h *AdminHandler) validateConnection(w http.ResponseWriter, connection *models.OIDCConnection) bool {
+	err := handler.ValidateOIDCConnection(connection)
+	if err == nil {
+		_, err = handler.DiscoverOIDC(connection.Issuer)
+	}
+	var federationErr *handler.FederationError
+	if errors.As(err, &federationErr) {
+		handler.WriteError(w, http.StatusBadRequest, "invalid_connection", federationErr.Message)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if h.nameTaken(&models.OIDCConnection{}, connection.TenantID, connection.Name, connection.ID) {
+		http.Error(w, "A connection with this name exists", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// Connections lists the upstream OIDC connections of the tenant
+func (h *AdminHandler) Connections(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	connections := []models.OIDCConnection{}
+	err := h.Handler.DB.Where("tenant_id = ?", tenantID).Order("name").Find(&connections).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, connections)
+}
+
+// Connection returns an upstream OIDC connection of the tenant
+func (h *AdminHandler) Connection(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var connection models.OIDCConnection
+	if !h.loadTenantRecord(w, r, tenantID, "connection", &connection) {
+		return
+	}
+	writeJSON(w, http.StatusOK, connection)
+}
+
+// ConnectionCreate adds an upstream OIDC connection after checking the discovery document of its issuer.
+// Existing users are linked by verified email and unknown users rejected unless the request says otherwise.
+func (h *AdminHandler) ConnectionCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request ConnectionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == nil || request.Issuer == nil || request.ClientID == nil {
+		http.Error(w, "name, issuer and client_id are required", http.StatusBadRequest)
+		return
+	}
+
+	connection := models.OIDCConnection{ID: uuid.New(), TenantID: tenantID, ClaimMapping: json.RawMessage("{}"), LinkByEmail: true, IsActive: true}
+	applyConnectionRequest(&connection, &request)
+	if !h.validateConnection(w, &connection) {
+		return
+	}
+	if request.ClientSecret != nil {
+		if err := handler.EncryptOIDCClientSecret(&connection, *request.ClientSecret); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&connection).Error; err != nil {
+			return err
+		}
+		// Create stores the column defaults for false
+		return tx.Model(&connection).Updates(map[string]interface{}{
+			"link_by_email": connection.LinkByEmail,
+			"is_active":     connection.IsActive,
+		}).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, connection)
+}
+
+// ConnectionUpdate changes an upstream OIDC connection
+func (h *AdminHandler) ConnectionUpdate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var connection models.OIDCConnection
+	if !h.loadTenantRecord(w, r, tenantID, "connection", &connection) {
+		return
+	}
+
+	var request ConnectionRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	applyConnectionRequest(&connection, &request)
+	if !h.validateConnection(w, &connection) {
+		return
+	}
+	if request.ClientSecret != nil {
+		if err := handler.EncryptOIDCClientSecret(&connection, *request.ClientSecret); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.Handler.DB.Save(&connection).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, connection)
+}
+
+// ConnectionDelete removes a connection with its linked identities. The users are kept.
+func (h *AdminHandler) ConnectionDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var connection models.OIDCConnection
+	if !h.loadTenantRecord(w, r, tenantID, "connection", &connection) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.UserIdentity{}, &models.OIDCLogin{}} {
+			if err := tx.Where("connection_id = ?", connection.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&connection).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Connection deleted",
+	})
+}
+
+// UserIdentities lists the upstream accounts linked to a user
+func (h *AdminHandler) UserIdentities(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, true)
+	if !ok {
+		return
+	}
+
+	identities := []models.UserIdentity{}
+	err := h.Handler.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, identities)
+}
+
+// UserIdentityDelete unlinks an upstream account from a user
+func (h *AdminHandler) UserIdentityDelete(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	result := h.Handler.DB.Where("id = ? AND user_id = ?", mux.Vars(r)["identity"], user.ID).Delete(&models.UserIdentity{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Identity unlinked",
+	})
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type DomainCreateRequest struct {
+	Hostname  string `json:"hostname"`
+	IsPrimary bool   `json:"is_primary"`
+}
+
+// Domains lists the custom hostnames of the tenant
+func (h *AdminHandler) Domains(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var domains []models.TenantDomain
+	err := h.Handler.DB.Where("tenant_id = ?", tenant.ID).Order("is_primary DESC, created_at").Find(&domains).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, domains)
+}
+
+// DomainCreate registers a custom hostname for the tenant. The DNS record of the hostname has to point
+// to this deployment. A new primary domain changes the issuer of the tenant.
+func (h *AdminHandler) DomainCreate(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request DomainCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	domain, err := h.Handler.AddTenantDomain(tenant.ID, request.Hostname, request.IsPrimary)
+	switch {
+	case errors.Is(err, handler.ErrDomainInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, handler.ErrDomainTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, domain)
+}
+
+// DomainDelete removes a custom hostname of the tenant
+func (h *AdminHandler) DomainDelete(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	result := h.Handler.DB.Where("tenant_id = ? AND hostname = ?", tenant.ID, handler.NormalizeHost(mux.Vars(r)["hostname"])).Delete(&models.TenantDomain{})
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Domain removed",
+	})
+}
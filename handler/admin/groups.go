@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// GroupRequest creates a group or, for updates, changes the fields that are set
+type GroupRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// GroupResponse is a group with the roles its members hold
+type GroupResponse struct {
+	models.Group
+	Roles []models.Role `json:"roles"`
+}
+
+// Groups lists the groups of the tenant, filtered by q
+func (h *AdminHandler) Groups(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.Handler.DB.Where("tenant_id = ?", tenantID)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		query = query.Where("name ILIKE ?", likePattern(q))
+	}
+
+	groups := []models.Group{}
+	err := query.Order("name").Find(&groups).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// Group returns a group with its roles
+func (h *AdminHandler) Group(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	response := GroupResponse{Group: group, Roles: []models.Role{}}
+	err := h.Handler.DB.Where("id IN (?)", h.Handler.DB.Model(&models.GroupRole{}).Select("role_id").Where("group_id = ?", group.ID)).
+		Order("name").Find(&response.Roles).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// GroupCreate creates a group in the tenant
+func (h *AdminHandler) GroupCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request GroupRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == nil || !handler.RBACNamePattern.MatchString(*request.Name) {
+		http.Error(w, "Invalid group name", http.StatusBadRequest)
+		return
+	}
+	if h.nameTaken(&models.Group{}, tenantID, *request.Name, uuid.Nil) {
+		http.Error(w, "A group with this name exists", http.StatusConflict)
+		return
+	}
+
+	group := models.Group{TenantID: tenantID, Name: *request.Name}
+	if request.Description != nil {
+		group.Description = *request.Description
+	}
+	err = h.Handler.DB.Create(&group).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, group)
+}
+
+// GroupUpdate renames a group or changes its description
+func (h *AdminHandler) GroupUpdate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	var request GroupRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Name != nil {
+		if !handler.RBACNamePattern.MatchString(*request.Name) {
+			http.Error(w, "Invalid group name", http.StatusBadRequest)
+			return
+		}
+		if h.nameTaken(&models.Group{}, tenantID, *request.Name, group.ID) {
+			http.Error(w, "A group with this name exists", http.StatusConflict)
+			return
+		}
+		group.Name = *request.Name
+	}
+	if request.Description != nil {
+		group.Description = *request.Description
+	}
+
+	err = h.Handler.DB.Model(&group).Updates(map[string]interface{}{"name": group.Name, "description": group.Description}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, group)
+}
+
+// GroupDelete deletes a group with its memberships and role assignments
+func (h *AdminHandler) GroupDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.UserGroup{}, &models.GroupRole{}} {
+			if err := tx.Where("group_id = ?", group.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Group deleted",
+	})
+}
+
+// GroupMembers lists the members of a group, paginated with page and per_page
+func (h *AdminHandler) GroupMembers(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	query := h.Handler.DB.Model(&models.User{}).
+		Where("id IN (?)", h.Handler.DB.Model(&models.UserGroup{}).Select("user_id").Where("group_id = ?", group.ID))
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := pagination(r)
+	var users []models.User
+	err = query.Order("email").Offset((page - 1) * perPage).Limit(perPage).Find(&users).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := UserList{Users: []AdminUser{}, Total: total, Page: page, PerPage: perPage}
+	for i := range users {
+		list.Users = append(list.Users, adminUser(&users[i]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GroupMemberAdd adds a user of the tenant to the group
+func (h *AdminHandler) GroupMemberAdd(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, user.TenantID, "group", &group) {
+		return
+	}
+
+	membership := models.UserGroup{TenantID: user.TenantID}
+	err := h.Handler.DB.FirstOrCreate(&membership, models.UserGroup{GroupID: group.ID, UserID: user.ID}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, membership)
+}
+
+// GroupMemberRemove removes a user from the group
+func (h *AdminHandler) GroupMemberRemove(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	h.removeAssignment(w, h.Handler.DB.Where("group_id = ? AND user_id = ?", group.ID, mux.Vars(r)["user"]), &models.UserGroup{})
+}
+
+// GroupRoleAdd assigns a role to all members of the group
+func (h *AdminHandler) GroupRoleAdd(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	var role models.Role
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) || !h.loadTenantRecord(w, r, tenantID, "role", &role) {
+		return
+	}
+
+	assignment := models.GroupRole{TenantID: tenantID}
+	err := h.Handler.DB.FirstOrCreate(&assignment, models.GroupRole{GroupID: group.ID, RoleID: role.ID}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, assignment)
+}
+
+// GroupRoleRemove removes a role from the group
+func (h *AdminHandler) GroupRoleRemove(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var group models.Group
+	if !h.loadTenantRecord(w, r, tenantID, "group", &group) {
+		return
+	}
+
+	h.removeAssignment(w, h.Handler.DB.Where("group_id = ? AND role_id = ?", group.ID, mux.Vars(r)["role"]), &models.GroupRole{})
+}
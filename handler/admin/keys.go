@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// SigningKeys lists the signing keys of the tenant without their private key
+func (h *AdminHandler) SigningKeys(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var keys []models.SigningKey
+	err := h.Handler.DB.Where("tenant_id = ?", tenant.ID).Order("created_at DESC").Find(&keys).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// SigningKeyRotate creates a new signing key for the tenant. Tokens signed with the previous key
+// stay valid until they expire.
+func (h *AdminHandler) SigningKeyRotate(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := h.Handler.RotateSigningKey(tenant.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// SigningKeysPurge removes expired keys of all tenants
+func (h *AdminHandler) SigningKeysPurge(w http.ResponseWriter, r *http.Request) {
+	result := h.Handler.DB.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Delete(&models.SigningKey{})
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{
+		"purged": result.RowsAffected,
+	})
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+type PermissionCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Permissions lists the permissions that a client defines
+func (h *AdminHandler) Permissions(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	permissions := []models.Permission{}
+	err := h.Handler.DB.Where("client_id = ?", client.ID).Order("name").Find(&permissions).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, permissions)
+}
+
+// PermissionCreate defines a permission of the client
+func (h *AdminHandler) PermissionCreate(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+
+	var request PermissionCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !handler.RBACNamePattern.MatchString(request.Name) {
+		http.Error(w, "Invalid permission name", http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	h.Handler.DB.Model(&models.Permission{}).Where("client_id = ? AND name = ?", client.ID, request.Name).Count(&count)
+	if count > 0 {
+		http.Error(w, "The client defines this permission already", http.StatusConflict)
+		return
+	}
+
+	permission := models.Permission{
+		TenantID:    client.TenantID,
+		ClientID:    client.ID,
+		Name:        request.Name,
+		Description: request.Description,
+	}
+	err = h.Handler.DB.Create(&permission).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, permission)
+}
+
+// PermissionDelete deletes a permission of the client and takes it away from all roles
+func (h *AdminHandler) PermissionDelete(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.loadClient(w, r)
+	if !ok {
+		return
+	}
+	var permission models.Permission
+	if !h.loadTenantRecord(w, r, client.TenantID, "permission", &permission) {
+		return
+	}
+	if permission.ClientID != client.ID {
+		http.Error(w, "Permission not found", http.StatusNotFound)
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("permission_id = ?", permission.ID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&permission).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Permission deleted",
+	})
+}
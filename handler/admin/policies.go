@@ -0,0 +1,527 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PolicyRequest creates a policy or, for updates, changes the fields that are set. A change of effect,
+// condition, message or claims creates and activates a new version.
+type PolicyRequest struct {
+	Name        *string            `json:"name"`
+	Description *string            `json:"description"`
+	Point       *string            `json:"point"`
+	IsActive    *bool              `json:"is_active"`
+	Effect      *string            `json:"effect"`
+	Condition   *string            `json:"condition"`
+	Message     *string            `json:"message"`
+	Claims      *map[string]string `json:"claims"`
+}
+
+// PolicyResponse is a policy with its active version
+type PolicyResponse struct {
+	models.Policy
+	Active *models.PolicyVersion `json:"active_version"`
+}
+
+// PolicyDetails is a policy with all its versions, the newest first
+type PolicyDetails struct {
+	models.Policy
+	Versions []models.PolicyVersion `json:"versions"`
+}
+
+// PolicyTestRequest evaluates the policies of a point for a user and client without recording the decision.
+// Policy is a draft that replaces the policy with its policy_id or is evaluated in addition; alternatively
+// PolicyID and Version evaluate a stored version instead of the active one.
+type PolicyTestRequest struct {
+	Point       string                   `json:"point"`
+	UserID      *uuid.UUID               `json:"user_id"`
+	ClientID    *uuid.UUID               `json:"client_id"`
+	Scopes      []string                 `json:"scopes"`
+	AuthMethods []string                 `json:"auth_methods"`
+	ACR         string                   `json:"acr"`
+	Request     *PolicyTestHTTPRequest   `json:"request"`
+	Policy      *handler.PolicyCandidate `json:"policy"`
+	PolicyID    *uuid.UUID               `json:"policy_id"`
+	Version     *int                     `json:"version"`
+}
+
+// PolicyTestHTTPRequest describes the request seen by the policies
+type PolicyTestHTTPRequest struct {
+	IP        string `json:"ip"`
+	Host      string `json:"host"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	UserAgent string `json:"user_agent"`
+}
+
+// AuditEventList is a page of audit events
+type AuditEventList struct {
+	Events  []models.AuditEvent `json:"events"`
+	Total   int64               `json:"total"`
+	Page    int                 `json:"page"`
+	PerPage int                 `json:"per_page"`
+}
+
+// writePolicyError answers invalid policies with 400 and other errors with 500
+func writePolicyError(w http.ResponseWriter, err error) {
+	var policyErr *handler.PolicyError
+	if errors.As(err, &policyErr) {
+		handler.WriteError(w, http.StatusBadRequest, "invalid_policy", policyErr.Message)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// createPolicyVersion stores the next version of the policy and makes it the active one
+func createPolicyVersion(tx *gorm.DB, policy *models.Policy, candidate *handler.PolicyCandidate, createdBy uuid.UUID) (*models.PolicyVersion, error) {
+	var latest int
+	err := tx.Model(&models.PolicyVersion{}).Select("COALESCE(MAX(version), 0)").Where("policy_id = ?", policy.ID).Scan(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+
+	version := models.PolicyVersion{
+		PolicyID:  policy.ID,
+		TenantID:  policy.TenantID,
+		Version:   latest + 1,
+		Effect:    candidate.Effect,
+		Condition: candidate.Condition,
+		Message:   candidate.Message,
+		CreatedBy: &createdBy,
+	}
+	if len(candidate.Claims) > 0 {
+		version.Claims, err = json.Marshal(candidate.Claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+
+	policy.Version = version.Version
+	return &version, tx.Model(policy).Update("version", version.Version).Error
+}
+
+// activeVersion loads the active version of the policy
+func (h *AdminHandler) activeVersion(policy *models.Policy) (*models.PolicyVersion, error) {
+	var version models.PolicyVersion
+	err := h.Handler.DB.Where("policy_id = ? AND version = ?", policy.ID, policy.Version).First(&version).Error
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// Policies lists the policies of the tenant with their active versions, filtered by point
+func (h *AdminHandler) Policies(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.Handler.DB.Where("tenant_id = ?", tenantID)
+	if point := r.URL.Query().Get("point"); point != "" {
+		query = query.Where("point = ?", point)
+	}
+
+	var policies []models.Policy
+	err := query.Order("point, name").Find(&policies).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := []PolicyResponse{}
+	for _, policy := range policies {
+		version, err := h.activeVersion(&policy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response = append(response, PolicyResponse{Policy: policy, Active: version})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Policy returns a policy with all its versions
+func (h *AdminHandler) Policy(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var policy models.Policy
+	if !h.loadTenantRecord(w, r, tenantID, "policy", &policy) {
+		return
+	}
+
+	response := PolicyDetails{Policy: policy, Versions: []models.PolicyVersion{}}
+	err := h.Handler.DB.Where("policy_id = ?", policy.ID).Order("version DESC").Find(&response.Versions).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// PolicyCreate creates a policy with its first version
+func (h *AdminHandler) PolicyCreate(w http.ResponseWriter, r *http.Request) {
+	admin, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request PolicyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == nil || !handler.RBACNamePattern.MatchString(*request.Name) {
+		http.Error(w, "Invalid policy name", http.StatusBadRequest)
+		return
+	}
+	if request.Point == nil || request.Effect == nil || request.Condition == nil {
+		http.Error(w, "point, effect and condition are required", http.StatusBadRequest)
+		return
+	}
+	if h.nameTaken(&models.Policy{}, tenantID, *request.Name, uuid.Nil) {
+		http.Error(w, "A policy with this name exists", http.StatusConflict)
+		return
+	}
+
+	candidate := &handler.PolicyCandidate{Effect: *request.Effect, Condition: *request.Condition}
+	if request.Message != nil {
+		candidate.Message = *request.Message
+	}
+	if request.Claims != nil {
+		candidate.Claims = *request.Claims
+	}
+	if err := handler.ValidatePolicy(*request.Point, candidate); err != nil {
+		writePolicyError(w, err)
+		return
+	}
+
+	policy := models.Policy{TenantID: tenantID, Name: *request.Name, Point: *request.Point, IsActive: true}
+	if request.Description != nil {
+		policy.Description = *request.Description
+	}
+	var version *models.PolicyVersion
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&policy).Error; err != nil {
+			return err
+		}
+		// gorm creates false as the column default true
+		if request.IsActive != nil && !*request.IsActive {
+			policy.IsActive = false
+			if err := tx.Model(&policy).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		version, err = createPolicyVersion(tx, &policy, candidate, admin.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, PolicyResponse{Policy: policy, Active: version})
+}
+
+// PolicyUpdate changes the description or state of a policy and creates a new version if its content changes
+func (h *AdminHandler) PolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	admin, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var policy models.Policy
+	if !h.loadTenantRecord(w, r, tenantID, "policy", &policy) {
+		return
+	}
+
+	var request PolicyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Point != nil && *request.Point != policy.Point {
+		http.Error(w, "The point of a policy cannot be changed", http.StatusBadRequest)
+		return
+	}
+	if request.Name != nil {
+		if !handler.RBACNamePattern.MatchString(*request.Name) {
+			http.Error(w, "Invalid policy name", http.StatusBadRequest)
+			return
+		}
+		if h.nameTaken(&models.Policy{}, tenantID, *request.Name, policy.ID) {
+			http.Error(w, "A policy with this name exists", http.StatusConflict)
+			return
+		}
+	}
+
+	current, err := h.activeVersion(&policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	candidate, err := handler.PolicyCandidateOf(current, policy.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changed := false
+	if request.Effect != nil {
+		candidate.Effect, changed = *request.Effect, true
+	}
+	if request.Condition != nil {
+		candidate.Condition, changed = *request.Condition, true
+	}
+	if request.Message != nil {
+		candidate.Message, changed = *request.Message, true
+	}
+	if request.Claims != nil {
+		candidate.Claims, changed = *request.Claims, true
+	}
+	if changed {
+		if err := handler.ValidatePolicy(policy.Point, candidate); err != nil {
+			writePolicyError(w, err)
+			return
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != nil {
+		updates["name"] = *request.Name
+	}
+	if request.Description != nil {
+		updates["description"] = *request.Description
+	}
+	if request.IsActive != nil {
+		updates["is_active"] = *request.IsActive
+	}
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		// The row lock serializes concurrent versions of the policy
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", policy.ID).First(&policy).Error; err != nil {
+			return err
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&policy).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if changed {
+			current, err = createPolicyVersion(tx, &policy, candidate, admin.ID)
+		}
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PolicyResponse{Policy: policy, Active: current})
+}
+
+// PolicyActivate makes a stored version the active version of the policy, e.g. to roll back
+func (h *AdminHandler) PolicyActivate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var policy models.Policy
+	if !h.loadTenantRecord(w, r, tenantID, "policy", &policy) {
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	var version models.PolicyVersion
+	err = h.Handler.DB.Where("policy_id = ? AND version = ?", policy.ID, number).First(&version).Error
+	if err != nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	err = h.Handler.DB.Model(&policy).Update("version", version.Version).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PolicyResponse{Policy: policy, Active: &version})
+}
+
+// PolicyDelete deletes a policy with all its versions
+func (h *AdminHandler) PolicyDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var policy models.Policy
+	if !h.loadTenantRecord(w, r, tenantID, "policy", &policy) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&policy).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PolicyTest evaluates the policies of a point as a dry run and returns the decision with the result of
+// every policy
+func (h *AdminHandler) PolicyTest(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request PolicyTestRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input := handler.PolicyInput{
+		Point:       request.Point,
+		TenantID:    tenantID,
+		Scopes:      request.Scopes,
+		AuthMethods: request.AuthMethods,
+		ACR:         request.ACR,
+		DryRun:      true,
+	}
+	if request.UserID != nil {
+		var user models.User
+		if h.Handler.DB.Where("id = ? AND tenant_id = ?", *request.UserID, tenantID).First(&user).Error != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		input.User = &user
+	}
+	if request.ClientID != nil {
+		var client models.Client
+		if h.Handler.DB.Where("id = ?", *request.ClientID).First(&client).Error != nil || !handler.ClientAllowsTenant(&client, tenantID) {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		input.Client = &client
+	}
+	if request.Request != nil {
+		input.Request = &http.Request{
+			Method:     request.Request.Method,
+			Host:       request.Request.Host,
+			RemoteAddr: request.Request.IP,
+			URL:        &url.URL{Path: request.Request.Path},
+			Header:     http.Header{"User-Agent": {request.Request.UserAgent}},
+		}
+	}
+
+	switch {
+	case request.Policy != nil:
+		if err := handler.ValidatePolicy(request.Point, request.Policy); err != nil {
+			writePolicyError(w, err)
+			return
+		}
+		input.Candidate = request.Policy
+	case request.PolicyID != nil && request.Version != nil:
+		var policy models.Policy
+		err := h.Handler.DB.Where("id = ? AND tenant_id = ?", *request.PolicyID, tenantID).First(&policy).Error
+		if err != nil {
+			http.Error(w, "Policy not found", http.StatusNotFound)
+			return
+		}
+		if policy.Point != request.Point {
+			http.Error(w, "The policy is evaluated at "+policy.Point, http.StatusBadRequest)
+			return
+		}
+		var version models.PolicyVersion
+		err = h.Handler.DB.Where("policy_id = ? AND version = ?", policy.ID, *request.Version).First(&version).Error
+		if err != nil {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		input.Candidate, err = handler.PolicyCandidateOf(&version, policy.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case !slices.Contains(handler.PolicyPoints, request.Point):
+		http.Error(w, "Unknown point", http.StatusBadRequest)
+		return
+	}
+
+	decision, err := h.Handler.EvaluatePolicies(input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// AuditEvents lists the audit log of the tenant, newest first, filtered by type, user and client
+func (h *AdminHandler) AuditEvents(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.Handler.DB.Model(&models.AuditEvent{}).Where("tenant_id = ?", tenantID)
+	if eventType := strings.TrimSpace(r.URL.Query().Get("type")); eventType != "" {
+		query = query.Where("type = ?", eventType)
+	}
+	for _, filter := range []string{"user_id", "client_id"} {
+		if value := r.URL.Query().Get(filter); value != "" {
+			if _, err := uuid.Parse(value); err != nil {
+				http.Error(w, "Invalid "+filter, http.StatusBadRequest)
+				return
+			}
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := pagination(r)
+	list := AuditEventList{Events: []models.AuditEvent{}, Total: total, Page: page, PerPage: perPage}
+	err = query.Order("created_at DESC, id").Offset((page - 1) * perPage).Limit(perPage).Find(&list.Events).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,309 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// RoleRequest creates a role or, for updates, changes the fields that are set
+type RoleRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// RoleResponse is a role with the permissions it grants
+type RoleResponse struct {
+	models.Role
+	Permissions []models.Permission `json:"permissions"`
+}
+
+// loadTenantRecord loads the record with the ID of the route parameter within the tenant into dest
+func (h *AdminHandler) loadTenantRecord(w http.ResponseWriter, r *http.Request, tenantID uuid.UUID, param string, dest interface{}) bool {
+	label := strings.ToUpper(param[:1]) + param[1:]
+	id := mux.Vars(r)[param]
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invalid "+param+" ID", http.StatusBadRequest)
+		return false
+	}
+
+	err := h.Handler.DB.Where("id = ? AND tenant_id = ?", id, tenantID).First(dest).Error
+	if err != nil {
+		http.Error(w, label+" not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// nameTaken reports whether another record of the model in the tenant has the name
+func (h *AdminHandler) nameTaken(model interface{}, tenantID uuid.UUID, name string, exceptID uuid.UUID) bool {
+	var count int64
+	h.Handler.DB.Model(model).Where("tenant_id = ? AND name = ? AND id <> ?", tenantID, name, exceptID).Count(&count)
+	return count > 0
+}
+
+// Roles lists the roles of the tenant, filtered by q
+func (h *AdminHandler) Roles(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.Handler.DB.Where("tenant_id = ?", tenantID)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		query = query.Where("name ILIKE ?", likePattern(q))
+	}
+
+	roles := []models.Role{}
+	err := query.Order("name").Find(&roles).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roles)
+}
+
+// Role returns a role with its permissions
+func (h *AdminHandler) Role(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var role models.Role
+	if !h.loadTenantRecord(w, r, tenantID, "role", &role) {
+		return
+	}
+
+	response := RoleResponse{Role: role, Permissions: []models.Permission{}}
+	err := h.Handler.DB.Where("id IN (?)", h.Handler.DB.Model(&models.RolePermission{}).Select("permission_id").Where("role_id = ?", role.ID)).
+		Order("client_id, name").Find(&response.Permissions).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RoleCreate creates a role in the tenant
+func (h *AdminHandler) RoleCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request RoleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == nil || !handler.RBACNamePattern.MatchString(*request.Name) {
+		http.Error(w, "Invalid role name", http.StatusBadRequest)
+		return
+	}
+	if h.nameTaken(&models.Role{}, tenantID, *request.Name, uuid.Nil) {
+		http.Error(w, "A role with this name exists", http.StatusConflict)
+		return
+	}
+
+	role := models.Role{TenantID: tenantID, Name: *request.Name}
+	if request.Description != nil {
+		role.Description = *request.Description
+	}
+	err = h.Handler.DB.Create(&role).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, role)
+}
+
+// RoleUpdate renames a role or changes its description
+func (h *AdminHandler) RoleUpdate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var role models.Role
+	if !h.loadTenantRecord(w, r, tenantID, "role", &role) {
+		return
+	}
+
+	var request RoleRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Name != nil {
+		if !handler.RBACNamePattern.MatchString(*request.Name) {
+			http.Error(w, "Invalid role name", http.StatusBadRequest)
+			return
+		}
+		if h.nameTaken(&models.Role{}, tenantID, *request.Name, role.ID) {
+			http.Error(w, "A role with this name exists", http.StatusConflict)
+			return
+		}
+		role.Name = *request.Name
+	}
+	if request.Description != nil {
+		role.Description = *request.Description
+	}
+
+	err = h.Handler.DB.Model(&role).Updates(map[string]interface{}{"name": role.Name, "description": role.Description}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, role)
+}
+
+// RoleDelete deletes a role and all of its assignments
+func (h *AdminHandler) RoleDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var role models.Role
+	if !h.loadTenantRecord(w, r, tenantID, "role", &role) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.RolePermission{}, &models.UserRole{}, &models.GroupRole{}} {
+			if err := tx.Where("role_id = ?", role.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Role deleted",
+	})
+}
+
+// RolePermissionAdd grants a permission of a client of the tenant to the role
+func (h *AdminHandler) RolePermissionAdd(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var role models.Role
+	var permission models.Permission
+	if !h.loadTenantRecord(w, r, tenantID, "role", &role) || !h.loadTenantRecord(w, r, tenantID, "permission", &permission) {
+		return
+	}
+
+	assignment := models.RolePermission{TenantID: tenantID}
+	err := h.Handler.DB.FirstOrCreate(&assignment, models.RolePermission{RoleID: role.ID, PermissionID: permission.ID}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, assignment)
+}
+
+// RolePermissionRemove takes a permission away from the role
+func (h *AdminHandler) RolePermissionRemove(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var role models.Role
+	if !h.loadTenantRecord(w, r, tenantID, "role", &role) {
+		return
+	}
+
+	h.removeAssignment(w, h.Handler.DB.Where("role_id = ? AND permission_id = ?", role.ID, mux.Vars(r)["permission"]), &models.RolePermission{})
+}
+
+// removeAssignment deletes the assignment matched by the query
+func (h *AdminHandler) removeAssignment(w http.ResponseWriter, query *gorm.DB, model interface{}) {
+	result := query.Delete(model)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "Assignment removed",
+	})
+}
+
+// UserRoleAdd assigns a role directly to a user
+func (h *AdminHandler) UserRoleAdd(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+	var role models.Role
+	if !h.loadTenantRecord(w, r, user.TenantID, "role", &role) {
+		return
+	}
+
+	assignment := models.UserRole{TenantID: user.TenantID}
+	err := h.Handler.DB.FirstOrCreate(&assignment, models.UserRole{UserID: user.ID, RoleID: role.ID}).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, assignment)
+}
+
+// UserRoleRemove removes a role that was assigned directly to a user
+func (h *AdminHandler) UserRoleRemove(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	h.removeAssignment(w, h.Handler.DB.Where("user_id = ? AND role_id = ?", user.ID, mux.Vars(r)["role"]), &models.UserRole{})
+}
+
+// UserAuthorization returns the groups, roles and, with client_id, the permissions a token of the user carries
+func (h *AdminHandler) UserAuthorization(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	var clientID *uuid.UUID
+	if param := r.URL.Query().Get("client_id"); param != "" {
+		id, err := uuid.Parse(param)
+		if err != nil {
+			http.Error(w, "Invalid client ID", http.StatusBadRequest)
+			return
+		}
+		clientID = &id
+	}
+
+	authorization, err := h.Handler.UserAuthorization(user.ID, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, authorization)
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type ScimTokenCreateRequest struct {
+	Name string `json:"name"`
+	// ExpiresIn is the lifetime of the token in seconds, 0 never expires
+	ExpiresIn int `json:"expires_in"`
+}
+
+// ScimTokenResponse contains a new SCIM token, which is only returned once
+type ScimTokenResponse struct {
+	Token     string           `json:"token"`
+	ScimToken models.ScimToken `json:"token_info"`
+}
+
+// ScimTokens lists the SCIM tokens of the tenant without their values
+func (h *AdminHandler) ScimTokens(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	tokens := []models.ScimToken{}
+	err := h.Handler.DB.Where("tenant_id = ?", tenantID).Order("created_at DESC").Find(&tokens).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// ScimTokenCreate creates a token with which a provisioning client manages the users and groups of the
+// tenant through the SCIM API
+func (h *AdminHandler) ScimTokenCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request ScimTokenCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || len(request.Name) > 100 {
+		http.Error(w, "A name of up to 100 characters is required", http.StatusBadRequest)
+		return
+	}
+
+	token, scimToken, err := h.Handler.CreateScimToken(tenantID, request.Name, expiryIn(request.ExpiresIn))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ScimTokenResponse{Token: token, ScimToken: *scimToken})
+}
+
+// ScimTokenDelete revokes a SCIM token
+func (h *AdminHandler) ScimTokenDelete(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var token models.ScimToken
+	if !h.loadTenantRecord(w, r, tenantID, "token", &token) {
+		return
+	}
+
+	err := h.Handler.DB.Delete(&token).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "SCIM token deleted",
+	})
+}
@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// SettingsRequest replaces the settings of a level. Fields that are null or missing are inherited.
+type SettingsRequest struct {
+	AccessTokenLifetime  *int     `json:"access_token_lifetime"`
+	RefreshTokenLifetime *int     `json:"refresh_token_lifetime"`
+	ConsentLifetime      *int     `json:"consent_lifetime"`
+	Issuer               *string  `json:"issuer"`
+	AllowedGrants        []string `json:"allowed_grants"`
+	MFAPolicy            *string  `json:"mfa_policy"`
+	BrandName            *string  `json:"brand_name"`
+	BrandLogoURL         *string  `json:"brand_logo_url"`
+	BrandPrimaryColor    *string  `json:"brand_primary_color"`
+}
+
+type SettingsResponse struct {
+	Settings  *models.Settings          `json:"settings"`
+	Effective handler.EffectiveSettings `json:"effective"`
+}
+
+type PasswordPolicyRequest struct {
+	MinLength          int  `json:"min_length"`
+	MaxLength          int  `json:"max_length"`
+	RequireUppercase   bool `json:"require_uppercase"`
+	RequireLowercase   bool `json:"require_lowercase"`
+	RequireDigit       bool `json:"require_digit"`
+	RequireSymbol      bool `json:"require_symbol"`
+	RejectPersonalInfo bool `json:"reject_personal_info"`
+	CheckBreached      bool `json:"check_breached"`
+	HistorySize        int  `json:"history_size"`
+}
+
+// settingsScope returns the tenant and client of the settings level addressed by the route
+func (h *AdminHandler) settingsScope(w http.ResponseWriter, r *http.Request) (*uuid.UUID, *uuid.UUID, bool) {
+	if _, ok := mux.Vars(r)["id"]; !ok {
+		return nil, nil, true
+	}
+
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	clientID, ok := mux.Vars(r)["client"]
+	if !ok {
+		return &tenant.ID, nil, true
+	}
+
+	var client models.Client
+	err := h.Handler.DB.Where("id = ? AND tenant_id = ?", clientID, tenant.ID).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return &tenant.ID, &client.ID, true
+}
+
+// Settings returns the stored settings of the global, tenant or client level together with the
+// effective settings. The effective settings of the global level are those of a tenant without own settings.
+func (h *AdminHandler) Settings(w http.ResponseWriter, r *http.Request) {
+	tenantID, clientID, ok := h.settingsScope(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := h.Handler.SettingsLevel(tenantID, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SettingsResponse{Settings: settings, Effective: h.effectiveSettings(tenantID, clientID)})
+}
+
+// SettingsUpdate replaces the settings of the global, tenant or client level. Changes apply immediately.
+func (h *AdminHandler) SettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	tenantID, clientID, ok := h.settingsScope(w, r)
+	if !ok {
+		return
+	}
+
+	var request SettingsRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.Handler.SettingsLevel(tenantID, clientID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	settings.AccessTokenLifetime = request.AccessTokenLifetime
+	settings.RefreshTokenLifetime = request.RefreshTokenLifetime
+	settings.ConsentLifetime = request.ConsentLifetime
+	settings.Issuer = request.Issuer
+	settings.AllowedGrants = request.AllowedGrants
+	settings.MFAPolicy = request.MFAPolicy
+	settings.BrandName = request.BrandName
+	settings.BrandLogoURL = request.BrandLogoURL
+	settings.BrandPrimaryColor = request.BrandPrimaryColor
+
+	err = h.Handler.SaveSettingsLevel(settings)
+	var settingsErr *handler.SettingsError
+	if errors.As(err, &settingsErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SettingsResponse{Settings: settings, Effective: h.effectiveSettings(tenantID, clientID)})
+}
+
+func (h *AdminHandler) effectiveSettings(tenantID *uuid.UUID, clientID *uuid.UUID) handler.EffectiveSettings {
+	if tenantID == nil {
+		return h.Handler.Settings(uuid.Nil, nil)
+	}
+	return h.Handler.Settings(*tenantID, clientID)
+}
+
+// PasswordPolicy returns the password rules of the tenant
+func (h *AdminHandler) PasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Handler.PasswordPolicy(tenant.ID))
+}
+
+// PasswordPolicyUpdate replaces the password rules of the tenant
+func (h *AdminHandler) PasswordPolicyUpdate(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request PasswordPolicyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.MinLength < 1 || request.MaxLength < request.MinLength || request.HistorySize < 0 {
+		http.Error(w, "Invalid password lengths or history size", http.StatusBadRequest)
+		return
+	}
+
+	policy := h.Handler.PasswordPolicy(tenant.ID)
+	updates := map[string]interface{}{
+		"min_length":           request.MinLength,
+		"max_length":           request.MaxLength,
+		"require_uppercase":    request.RequireUppercase,
+		"require_lowercase":    request.RequireLowercase,
+		"require_digit":        request.RequireDigit,
+		"require_symbol":       request.RequireSymbol,
+		"reject_personal_info": request.RejectPersonalInfo,
+		"check_breached":       request.CheckBreached,
+		"history_size":         request.HistorySize,
+	}
+	if policy.ID == uuid.Nil {
+		err = h.Handler.DB.Create(&policy).Error
+	}
+	if err == nil {
+		err = h.Handler.DB.Model(&policy).Updates(updates).Error
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Handler.PasswordPolicy(tenant.ID))
+}
@@ -0,0 +1,303 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/initializer"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var mfaPolicies = []string{models.MFAPolicyOptional, models.MFAPolicyAdmins, models.MFAPolicyAll}
+
+type TenantCreateRequest struct {
+	Name                 string `json:"name"`
+	Slug                 string `json:"slug"`
+	MFAPolicy            string `json:"mfa_policy"`
+	AllowUnverifiedLogin bool   `json:"allow_unverified_login"`
+	RetentionDays        int    `json:"retention_days"`
+	AdminEmail           string `json:"admin_email"`
+	AdminFirstName       string `json:"admin_first_name"`
+	AdminLastName        string `json:"admin_last_name"`
+	AdminPassword        string `json:"admin_password"`
+}
+
+// TenantUpdateRequest only changes the fields that are set
+type TenantUpdateRequest struct {
+	Name                 *string `json:"name"`
+	Slug                 *string `json:"slug"`
+	MFAPolicy            *string `json:"mfa_policy"`
+	AllowUnverifiedLogin *bool   `json:"allow_unverified_login"`
+	RetentionDays        *int    `json:"retention_days"`
+}
+
+func (h *AdminHandler) loadTenant(w http.ResponseWriter, r *http.Request) (*models.Tenant, bool) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invalid tenant ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var tenant models.Tenant
+	err := h.Handler.DB.Where("id = ?", id).First(&tenant).Error
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &tenant, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func validateTenant(tenant *models.Tenant) error {
+	if tenant.Name == "" {
+		return errors.New("name is required")
+	}
+	if tenant.Slug != "" && !tenantSlugPattern.MatchString(tenant.Slug) {
+		return errors.New("slug must be a lowercase DNS label")
+	}
+	if tenant.MFAPolicy != "" && !slices.Contains(mfaPolicies, tenant.MFAPolicy) {
+		return errors.New("unknown MFA policy")
+	}
+	if tenant.RetentionDays < 0 {
+		return errors.New("retention days must not be negative")
+	}
+	return nil
+}
+
+// Tenants lists all tenants. Deleted tenants are included with ?deleted=true.
+func (h *AdminHandler) Tenants(w http.ResponseWriter, r *http.Request) {
+	query := h.Handler.DB.Order("created_at")
+	if r.URL.Query().Get("deleted") == "true" {
+		query = query.Unscoped()
+	}
+
+	var tenants []models.Tenant
+	err := query.Find(&tenants).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tenants)
+}
+
+// Tenant returns a single tenant
+func (h *AdminHandler) Tenant(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+// TenantCreate provisions a tenant with its default clients and first admin.
+// The client secrets and a generated admin password are only part of this response.
+func (h *AdminHandler) TenantCreate(w http.ResponseWriter, r *http.Request) {
+	var request TenantCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant := models.Tenant{
+		Name:                 request.Name,
+		Slug:                 request.Slug,
+		IsActive:             true,
+		MFAPolicy:            request.MFAPolicy,
+		AllowUnverifiedLogin: request.AllowUnverifiedLogin,
+		RetentionDays:        request.RetentionDays,
+	}
+	if tenant.RetentionDays == 0 {
+		tenant.RetentionDays = models.DefaultRetentionDays
+	}
+	if err := validateTenant(&tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.AdminEmail == "" {
+		http.Error(w, "Admin email is required", http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.Tenant{}).Where("name = ? OR (slug = ? AND slug <> '')", tenant.Name, tenant.Slug).Count(&count)
+	if count > 0 {
+		http.Error(w, "A tenant with this name or slug already exists", http.StatusConflict)
+		return
+	}
+
+	provisioning, err := initializer.ProvisionTenant(h.Handler.DB, tenant, initializer.TenantAdmin{
+		Email:     request.AdminEmail,
+		FirstName: request.AdminFirstName,
+		LastName:  request.AdminLastName,
+		Password:  request.AdminPassword,
+	})
+	var policyErr *helper.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		handler.WritePasswordError(w, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, provisioning)
+}
+
+// TenantUpdate changes the settings of a tenant
+func (h *AdminHandler) TenantUpdate(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request TenantUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Name != nil {
+		tenant.Name = *request.Name
+	}
+	if request.Slug != nil {
+		tenant.Slug = *request.Slug
+	}
+	if request.MFAPolicy != nil {
+		tenant.MFAPolicy = *request.MFAPolicy
+	}
+	if request.AllowUnverifiedLogin != nil {
+		tenant.AllowUnverifiedLogin = *request.AllowUnverifiedLogin
+	}
+	if request.RetentionDays != nil {
+		tenant.RetentionDays = *request.RetentionDays
+	}
+	if err := validateTenant(tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.Tenant{}).Where("id <> ? AND (name = ? OR (slug = ? AND slug <> ''))", tenant.ID, tenant.Name, tenant.Slug).Count(&count)
+	if count > 0 {
+		http.Error(w, "A tenant with this name or slug already exists", http.StatusConflict)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"name":                   tenant.Name,
+		"mfa_policy":             tenant.MFAPolicy,
+		"allow_unverified_login": tenant.AllowUnverifiedLogin,
+		"retention_days":         tenant.RetentionDays,
+		"slug":                   nil,
+	}
+	if tenant.Slug != "" {
+		updates["slug"] = tenant.Slug
+	}
+	err = h.Handler.DB.Model(tenant).Updates(updates).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+// TenantSuspend blocks all logins of the tenant and revokes its sessions
+func (h *AdminHandler) TenantSuspend(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.SuspendTenant(tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+// TenantActivate lifts the suspension of a tenant
+func (h *AdminHandler) TenantActivate(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.ActivateTenant(tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+// TenantDelete soft-deletes a tenant. Its data is purged after the retention period.
+func (h *AdminHandler) TenantDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := r.Context().Value("session").(models.Session)
+	if !ok {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	err := h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	tenant, ok := h.loadTenant(w, r)
+	if !ok {
+		return
+	}
+	if user.TenantID == tenant.ID {
+		http.Error(w, "The own tenant cannot be deleted", http.StatusConflict)
+		return
+	}
+
+	err = h.Handler.DeleteTenant(tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message":     "Tenant deleted",
+		"purge_after": time.Now().AddDate(0, 0, tenant.RetentionDays),
+	})
+}
+
+// TenantPurge permanently removes all deleted tenants whose retention period has passed
+func (h *AdminHandler) TenantPurge(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.Handler.PurgeDeletedTenants(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"purged": purged,
+	})
+}
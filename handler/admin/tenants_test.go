@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockAdminHandler returns an admin handler on the Postgres dialect whose statements go to a sqlmock
+func newMockAdminHandler(t *testing.T) (*AdminHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AdminHandler{Handler: handler.NewHandler(gormDB)}, mock
+}
+
+func TestTenantDeleteRejections(t *testing.T) {
+	userID, tenantID := uuid.New(), uuid.New()
+	tests := []struct {
+		name    string
+		session interface{}
+		expect  func(mock sqlmock.Sqlmock)
+		status  int
+	}{
+		{
+			name:   "without session",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:    "session of an unknown user",
+			session: models.Session{UserID: userID},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:    "own tenant",
+			session: models.Session{UserID: userID},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(userID, tenantID))
+				mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+			},
+			status: http.StatusConflict,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAdminHandler(t)
+			if test.expect != nil {
+				test.expect(mock)
+			}
+
+			request := httptest.NewRequest(http.MethodDelete, "/admin/tenants/"+tenantID.String(), nil)
+			if test.session != nil {
+				request = request.WithContext(context.WithValue(request.Context(), "session", test.session))
+			}
+			recorder := httptest.NewRecorder()
+			h.TenantDelete(recorder, mux.SetURLVars(request, map[string]string{"id": tenantID.String()}))
+
+			if recorder.Code != test.status {
+				t.Errorf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), test.status)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// AdminUser is the view of a user in the admin API, without the password hash
+type AdminUser struct {
+	ID           uuid.UUID `json:"id"`
+	Email        string    `json:"email"`
+	FirstName    string    `json:"first_name"`
+	LastName     string    `json:"last_name"`
+	DisplayName  string    `json:"display_name"`
+	Locale       string    `json:"locale"`
+	IsActive     bool      `json:"is_active"`
+	IsVerified   bool      `json:"is_verified"`
+	IsAdmin      bool      `json:"is_admin"`
+	IsSuperAdmin bool      `json:"is_super_admin"`
+	TenantID     uuid.UUID `json:"tenant_id"`
+	// ExternalID is the ID of the user in the provisioning system
+	ExternalID string `json:"external_id"`
+	// Attributes are the values of the tenant's custom attributes
+	Attributes map[string]interface{} `json:"attributes"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	DeletedAt  *time.Time             `json:"deleted_at"`
+}
+
+type UserList struct {
+	Users   []AdminUser `json:"users"`
+	Total   int64       `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+type UserCreateRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Locale    string `json:"locale"`
+	// Without a password the user gets a random one and a password reset email
+	Password   string `json:"password"`
+	IsAdmin    bool   `json:"is_admin"`
+	IsVerified bool   `json:"is_verified"`
+	// Attributes are values of custom attributes by name
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// UserUpdateRequest only changes the fields that are set
+type UserUpdateRequest struct {
+	Email      *string `json:"email"`
+	FirstName  *string `json:"first_name"`
+	LastName   *string `json:"last_name"`
+	Locale     *string `json:"locale"`
+	IsAdmin    *bool   `json:"is_admin"`
+	IsVerified *bool   `json:"is_verified"`
+	// Attributes changes the values of custom attributes, null removes a value
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+func adminUser(user *models.User) AdminUser {
+	view := AdminUser{
+		ID:           user.ID,
+		Email:        user.Email,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		DisplayName:  user.DisplayName,
+		Locale:       user.Locale,
+		IsActive:     user.IsActive,
+		IsVerified:   user.IsVerified,
+		IsAdmin:      user.IsAdmin,
+		IsSuperAdmin: user.IsSuperAdmin,
+		TenantID:     user.TenantID,
+		ExternalID:   user.ExternalID,
+		Attributes:   handler.DecodeAttributes(user),
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+	}
+	if user.DeletedAt.Valid {
+		view.DeletedAt = &user.DeletedAt.Time
+	}
+	return view
+}
+
+// loadUser returns the user of the {user} route parameter within the tenant of the request.
+// Only super admins may change super admins.
+func (h *AdminHandler) loadUser(w http.ResponseWriter, r *http.Request, unscoped bool) (*models.User, *models.User, bool) {
+	admin, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	id := mux.Vars(r)["user"]
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	query := h.Handler.DB
+	if unscoped {
+		query = query.Unscoped()
+	}
+
+	var user models.User
+	err := query.Where("id = ? AND tenant_id = ?", id, tenantID).First(&user).Error
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if user.IsSuperAdmin && !admin.IsSuperAdmin && r.Method != http.MethodGet {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return admin, &user, true
+}
+
+// emailTaken reports whether another user of the tenant, including deleted users, has the email address
+func (h *AdminHandler) emailTaken(tenantID uuid.UUID, email string, exceptID uuid.UUID) bool {
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.User{}).Where("tenant_id = ? AND LOWER(email) = ? AND id <> ?", tenantID, helper.NormalizeEmail(email), exceptID).Count(&count)
+	return count > 0
+}
+
+// Users lists the users of the tenant. It filters by q (email, name or a custom attribute value), email,
+// is_active, is_verified, is_admin and attr.<name> (the value of a custom attribute) and is paginated with
+// page and per_page. With deleted=true only deleted users are listed.
+func (h *AdminHandler) Users(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	query := h.Handler.DB.Model(&models.User{}).Where("tenant_id = ?", tenantID)
+	if params.Get("deleted") == "true" {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if q := strings.TrimSpace(params.Get("q")); q != "" {
+		pattern := likePattern(q)
+		query = query.Where("email ILIKE ? OR first_name ILIKE ? OR last_name ILIKE ? OR display_name ILIKE ? OR EXISTS (SELECT 1 FROM jsonb_each_text(attributes) WHERE value ILIKE ?)", pattern, pattern, pattern, pattern, pattern)
+	}
+	for param, values := range params {
+		name, ok := strings.CutPrefix(param, "attr.")
+		if !ok {
+			continue
+		}
+		if !handler.AttributeNamePattern.MatchString(name) {
+			http.Error(w, "Invalid attribute "+name, http.StatusBadRequest)
+			return
+		}
+		query = query.Where("attributes ->> ? = ?", name, values[0])
+	}
+	if email := params.Get("email"); email != "" {
+		query = query.Where("LOWER(email) = ?", helper.NormalizeEmail(email))
+	}
+	for _, filter := range []string{"is_active", "is_verified", "is_admin"} {
+		switch params.Get(filter) {
+		case "true":
+			query = query.Where(filter+" = ?", true)
+		case "false":
+			query = query.Where(filter+" = ?", false)
+		}
+	}
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := pagination(r)
+	var users []models.User
+	err = query.Order("created_at, id").Offset((page - 1) * perPage).Limit(perPage).Find(&users).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := UserList{Users: []AdminUser{}, Total: total, Page: page, PerPage: perPage}
+	for i := range users {
+		list.Users = append(list.Users, adminUser(&users[i]))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// User returns a single user of the tenant, deleted users included
+func (h *AdminHandler) User(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, true)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserCreate creates a user in the tenant
+func (h *AdminHandler) UserCreate(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, ok := h.adminTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request UserCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !strings.Contains(request.Email, "@") || request.FirstName == "" || request.LastName == "" {
+		http.Error(w, "Email, first name and last name are required", http.StatusBadRequest)
+		return
+	}
+	if h.emailTaken(tenantID, request.Email, uuid.Nil) {
+		http.Error(w, "A user with this email address exists, deleted users can be restored", http.StatusConflict)
+		return
+	}
+
+	user := models.User{
+		Email:      request.Email,
+		FirstName:  request.FirstName,
+		LastName:   request.LastName,
+		Locale:     request.Locale,
+		Password:   request.Password,
+		IsActive:   true,
+		IsVerified: request.IsVerified,
+		IsAdmin:    request.IsAdmin,
+		TenantID:   tenantID,
+	}
+	if user.Locale == "" {
+		user.Locale = "en"
+	}
+
+	sendReset := user.Password == ""
+	if sendReset {
+		user.Password = utils.GenerateToken(32)
+	} else if err := h.Handler.ValidatePassword(&user, user.Password); err != nil {
+		handler.WritePasswordError(w, err)
+		return
+	}
+
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := h.Handler.ApplyAttributes(tx, &user, request.Attributes, true); err != nil {
+			return err
+		}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	if sendReset {
+		go func() {
+			if err := h.Handler.SendPasswordReset(h.Mailer, &user, h.PasswordResetURL); err != nil {
+				fmt.Printf("Error sending password reset: %v\n", err)
+			}
+		}()
+	}
+
+	writeJSON(w, http.StatusCreated, adminUser(&user))
+}
+
+// UserUpdate changes profile, email, locale, custom attributes and admin flag of a user. A new email
+// address is unverified unless is_verified is set.
+func (h *AdminHandler) UserUpdate(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	var request UserUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Email != nil {
+		*request.Email = helper.NormalizeEmail(*request.Email)
+	}
+	if request.Email != nil && *request.Email != user.Email {
+		if !strings.Contains(*request.Email, "@") {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		if h.emailTaken(user.TenantID, *request.Email, user.ID) {
+			http.Error(w, "A user with this email address exists", http.StatusConflict)
+			return
+		}
+		user.Email = *request.Email
+		user.IsVerified = false
+		updates["email"] = user.Email
+		updates["is_verified"] = false
+	}
+	if request.FirstName != nil {
+		user.FirstName = *request.FirstName
+	}
+	if request.LastName != nil {
+		user.LastName = *request.LastName
+	}
+	if request.FirstName != nil || request.LastName != nil {
+		if user.FirstName == "" || user.LastName == "" {
+			http.Error(w, "First name and last name are required", http.StatusBadRequest)
+			return
+		}
+		user.DisplayName = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		updates["first_name"] = user.FirstName
+		updates["last_name"] = user.LastName
+		updates["display_name"] = user.DisplayName
+	}
+	if request.Locale != nil {
+		user.Locale = *request.Locale
+		updates["locale"] = user.Locale
+	}
+	if request.IsAdmin != nil {
+		if user.ID == admin.ID && !*request.IsAdmin {
+			http.Error(w, "You cannot remove your own admin rights", http.StatusBadRequest)
+			return
+		}
+		user.IsAdmin = *request.IsAdmin
+		updates["is_admin"] = user.IsAdmin
+	}
+	if request.IsVerified != nil {
+		user.IsVerified = *request.IsVerified
+		updates["is_verified"] = user.IsVerified
+	}
+
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if len(request.Attributes) > 0 {
+			if err := h.Handler.ApplyAttributes(tx, user, request.Attributes, true); err != nil {
+				return err
+			}
+			updates["attributes"] = user.Attributes
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(user).Updates(updates).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserActivate lets a deactivated user log in again
+func (h *AdminHandler) UserActivate(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.DB.Model(user).Update("is_active", true).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserDeactivate blocks all logins of the user and revokes its sessions
+func (h *AdminHandler) UserDeactivate(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "You cannot deactivate yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := h.Handler.DB.Model(user).Update("is_active", false).Error
+	if err == nil {
+		err = h.Handler.RevokeUserSessions(user.ID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserVerify marks the email address of the user as verified
+func (h *AdminHandler) UserVerify(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+
+	err := h.Handler.DB.Model(user).Update("is_verified", true).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserDelete soft-deletes the user and revokes its sessions. The user can be restored.
+func (h *AdminHandler) UserDelete(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "You cannot delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := h.Handler.RevokeUserSessions(user.ID)
+	if err == nil {
+		err = h.Handler.DB.Delete(user).Error
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "User deleted",
+	})
+}
+
+// UserRestore restores a soft-deleted user
+func (h *AdminHandler) UserRestore(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, true)
+	if !ok {
+		return
+	}
+	if !user.DeletedAt.Valid {
+		http.Error(w, "User is not deleted", http.StatusConflict)
+		return
+	}
+
+	err := h.Handler.DB.Unscoped().Model(user).Update("deleted_at", nil).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+
+	writeJSON(w, http.StatusOK, adminUser(user))
+}
+
+// UserPasswordReset sends the user a password reset email
+func (h *AdminHandler) UserPasswordReset(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.loadUser(w, r, false)
+	if !ok {
+		return
+	}
+	if !user.IsActive {
+		http.Error(w, "User is deactivated", http.StatusConflict)
+		return
+	}
+
+	err := h.Handler.SendPasswordReset(h.Mailer, user, h.PasswordResetURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "Password reset email sent",
+	})
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// withSession returns the request with the session of the admin
+func withSession(request *http.Request, adminID uuid.UUID) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), "session", models.Session{UserID: adminID}))
+}
+
+func TestUsersMatchesEmailsCaseInsensitively(t *testing.T) {
+	h, mock := newMockAdminHandler(t)
+	adminID, tenantID, userID := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(adminID, tenantID))
+	// The search term is matched literally and the email filter ignores case
+	conditions := `WHERE tenant_id = \$1 AND \(email ILIKE \$2 OR first_name ILIKE \$3 OR last_name ILIKE \$4 OR display_name ILIKE \$5 OR EXISTS \(.*\$6\)\) AND LOWER\(email\) = \$7 AND is_active = \$8 AND "users"."deleted_at" IS NULL`
+	args := []driver.Value{tenantID, `%50\%\_off%`, `%50\%\_off%`, `%50\%\_off%`, `%50\%\_off%`, `%50\%\_off%`, "alice@example.com", true}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" ` + conditions).WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "users" ` + conditions + ` ORDER BY created_at, id LIMIT \$9 OFFSET \$10`).WithArgs(append(args, 10, 10)...).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email"}).AddRow(userID, tenantID, "alice@example.com"))
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/users?q=50%25_off&email=%20Alice@Example.COM&is_active=true&page=2&per_page=10", nil)
+	recorder := httptest.NewRecorder()
+	h.Users(recorder, withSession(request, adminID))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", recorder.Code, recorder.Body.String())
+	}
+	var list UserList
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 1 || list.Page != 2 || list.PerPage != 10 || len(list.Users) != 1 || list.Users[0].Email != "alice@example.com" {
+		t.Errorf("list = %+v", list)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserEmailTakenIgnoresCase(t *testing.T) {
+	adminID, tenantID, userID := uuid.New(), uuid.New(), uuid.New()
+	tests := []struct {
+		name     string
+		handler  func(h *AdminHandler) http.HandlerFunc
+		method   string
+		body     string
+		exceptID uuid.UUID
+	}{
+		{"create", func(h *AdminHandler) http.HandlerFunc { return h.UserCreate }, http.MethodPost,
+			`{"email": "Bob@Example.COM", "first_name": "Bob", "last_name": "Smith"}`, uuid.Nil},
+		{"update", func(h *AdminHandler) http.HandlerFunc { return h.UserUpdate }, http.MethodPatch,
+			`{"email": " BOB@example.com"}`, userID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAdminHandler(t)
+			mock.ExpectQuery(`FROM "users" WHERE id = \$1`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(adminID, tenantID))
+			if test.exceptID != uuid.Nil {
+				mock.ExpectQuery(`FROM "users" WHERE \(id = \$1 AND tenant_id = \$2\)`).WithArgs(userID.String(), tenantID, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email"}).AddRow(userID, tenantID, "alice@example.com"))
+			}
+			// Deleted users are included, they can be restored
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE tenant_id = \$1 AND LOWER\(email\) = \$2 AND id <> \$3$`).
+				WithArgs(tenantID, "bob@example.com", test.exceptID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+			request := httptest.NewRequest(test.method, "/admin/users/"+userID.String(), strings.NewReader(test.body))
+			request = mux.SetURLVars(withSession(request, adminID), map[string]string{"user": userID.String()})
+			recorder := httptest.NewRecorder()
+			test.handler(h)(recorder, request)
+
+			if recorder.Code != http.StatusConflict {
+				t.Errorf("status = %d (%s), want 409", recorder.Code, recorder.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// Types of user attributes
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeInteger = "integer"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+	AttributeTypeDate    = "date"
+	AttributeTypeEnum    = "enum"
+)
+
+var AttributeTypes = []string{AttributeTypeString, AttributeTypeInteger, AttributeTypeNumber, AttributeTypeBoolean, AttributeTypeDate, AttributeTypeEnum}
+
+// Access of users and admins to an attribute
+const (
+	AttributeAccessNone  = "none"
+	AttributeAccessRead  = "read"
+	AttributeAccessWrite = "write"
+)
+
+// AttributeNamePattern matches the names of user attributes
+var AttributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// MaxAttributeLength is the maximum length of string attributes without max_length
+const MaxAttributeLength = 1024
+
+// AttributeError is returned for invalid attribute definitions and values
+type AttributeError struct {
+	Message string
+}
+
+func (e *AttributeError) Error() string {
+	return e.Message
+}
+
+// ValidateAttributeDefinition checks the name, type, constraints and access of an attribute
+func ValidateAttributeDefinition(attribute *models.UserAttribute) error {
+	if !AttributeNamePattern.MatchString(attribute.Name) {
+		return &AttributeError{Message: "invalid attribute name " + attribute.Name}
+	}
+	if !slices.Contains(AttributeTypes, attribute.Type) {
+		return &AttributeError{Message: "unknown type " + attribute.Type}
+	}
+	if attribute.Pattern != "" {
+		if attribute.Type != AttributeTypeString {
+			return &AttributeError{Message: "only string attributes have a pattern"}
+		}
+		if _, err := regexp.Compile(attribute.Pattern); err != nil {
+			return &AttributeError{Message: "invalid pattern: " + err.Error()}
+		}
+	}
+	if attribute.MaxLength < 0 || attribute.MaxLength > MaxAttributeLength {
+		return &AttributeError{Message: fmt.Sprintf("max_length must be between 0 and %d", MaxAttributeLength)}
+	}
+	if (attribute.Min != nil || attribute.Max != nil) && attribute.Type != AttributeTypeInteger && attribute.Type != AttributeTypeNumber {
+		return &AttributeError{Message: "only integer and number attributes have min and max"}
+	}
+	if attribute.Min != nil && attribute.Max != nil && *attribute.Min > *attribute.Max {
+		return &AttributeError{Message: "min is greater than max"}
+	}
+	if (attribute.Type == AttributeTypeEnum) != (len(attribute.Options) > 0) {
+		return &AttributeError{Message: "enum attributes and only they need options"}
+	}
+	if attribute.Unique && attribute.Type == AttributeTypeBoolean {
+		return &AttributeError{Message: "boolean attributes cannot be unique"}
+	}
+	if !slices.Contains([]string{AttributeAccessNone, AttributeAccessRead, AttributeAccessWrite}, attribute.UserAccess) {
+		return &AttributeError{Message: "user_access must be none, read or write"}
+	}
+	if !slices.Contains([]string{AttributeAccessRead, AttributeAccessWrite}, attribute.AdminAccess) {
+		return &AttributeError{Message: "admin_access must be read or write"}
+	}
+	return nil
+}
+
+// UserAttributeSchema returns the attribute definitions of the tenant ordered by name
+func (h *Handler) UserAttributeSchema(tenantID uuid.UUID) ([]models.UserAttribute, error) {
+	var schema []models.UserAttribute
+	err := h.DB.Where("tenant_id = ?", tenantID).Order("name").Find(&schema).Error
+	return schema, err
+}
+
+// DecodeAttributes returns the stored attribute values of the user
+func DecodeAttributes(user *models.User) map[string]interface{} {
+	values := map[string]interface{}{}
+	if len(user.Attributes) > 0 {
+		json.Unmarshal(user.Attributes, &values)
+	}
+	return values
+}
+
+// VisibleAttributes returns the values of the attributes that users or admins may read
+func VisibleAttributes(schema []models.UserAttribute, user *models.User, admin bool) map[string]interface{} {
+	values := DecodeAttributes(user)
+	visible := map[string]interface{}{}
+	for _, attribute := range schema {
+		value, ok := values[attribute.Name]
+		if !ok || (!admin && attribute.UserAccess == AttributeAccessNone) {
+			continue
+		}
+		visible[attribute.Name] = value
+	}
+	return visible
+}
+
+// normalizeAttribute checks a value against the attribute and returns it in its stored form
+func normalizeAttribute(attribute *models.UserAttribute, raw json.RawMessage) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, &AttributeError{Message: attribute.Name + ": invalid JSON"}
+	}
+	invalid := func(message string) error {
+		return &AttributeError{Message: attribute.Name + ": " + message}
+	}
+
+	switch attribute.Type {
+	case AttributeTypeString, AttributeTypeDate, AttributeTypeEnum:
+		text, ok := value.(string)
+		if !ok {
+			return nil, invalid("must be a string")
+		}
+		maxLength := attribute.MaxLength
+		if maxLength == 0 {
+			maxLength = MaxAttributeLength
+		}
+		if utf8.RuneCountInString(text) > maxLength {
+			return nil, invalid(fmt.Sprintf("must not be longer than %d characters", maxLength))
+		}
+		if attribute.Type == AttributeTypeDate {
+			if _, err := time.Parse(time.DateOnly, text); err != nil {
+				return nil, invalid("must be a date like 2006-01-02")
+			}
+		}
+		if attribute.Type == AttributeTypeEnum && !slices.Contains(attribute.Options, text) {
+			return nil, invalid("must be one of " + strings.Join(attribute.Options, ", "))
+		}
+		if attribute.Pattern != "" && !regexp.MustCompile(attribute.Pattern).MatchString(text) {
+			return nil, invalid("does not match the pattern")
+		}
+		return text, nil
+	case AttributeTypeInteger, AttributeTypeNumber:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, invalid("must be a number")
+		}
+		float, err := number.Float64()
+		if err != nil || math.IsInf(float, 0) {
+			return nil, invalid("must be a number")
+		}
+		if attribute.Min != nil && float < *attribute.Min || attribute.Max != nil && float > *attribute.Max {
+			return nil, invalid("is out of range")
+		}
+		if attribute.Type == AttributeTypeInteger {
+			integer, err := number.Int64()
+			if err != nil {
+				return nil, invalid("must be an integer")
+			}
+			return integer, nil
+		}
+		return float, nil
+	case AttributeTypeBoolean:
+		boolean, ok := value.(bool)
+		if !ok {
+			return nil, invalid("must be true or false")
+		}
+		return boolean, nil
+	}
+	return nil, invalid("unknown type")
+}
+
+// ApplyAttributes validates the changed attribute values and stores the merged values in user.Attributes.
+// A null value removes the attribute. Users may only change attributes with user_access write, admins
+// those with admin_access write. Unique values are checked against the stored users, so the caller saves
+// user.Attributes in the transaction tx.
+func (h *Handler) ApplyAttributes(tx *gorm.DB, user *models.User, changes map[string]json.RawMessage, admin bool) error {
+	schema, err := h.UserAttributeSchema(user.TenantID)
+	if err != nil {
+		return err
+	}
+	definitions := map[string]*models.UserAttribute{}
+	for i := range schema {
+		definitions[schema[i].Name] = &schema[i]
+	}
+
+	values := DecodeAttributes(user)
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		attribute, ok := definitions[name]
+		if !ok {
+			return &AttributeError{Message: "unknown attribute " + name}
+		}
+		access := attribute.UserAccess
+		if admin {
+			access = attribute.AdminAccess
+		}
+		if access != AttributeAccessWrite {
+			return &AttributeError{Message: name + ": not writable"}
+		}
+
+		raw := changes[name]
+		if len(raw) == 0 || string(raw) == "null" {
+			delete(values, name)
+			continue
+		}
+		value, err := normalizeAttribute(attribute, raw)
+		if err != nil {
+			return err
+		}
+		if attribute.Unique {
+			// The lock serializes writes of the attribute until the transaction ends
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", user.TenantID.String()+":"+name).Error; err != nil {
+				return err
+			}
+			var count int64
+			err := tx.Unscoped().Model(&models.User{}).
+				Where("tenant_id = ? AND id <> ? AND attributes ->> ? = ?", user.TenantID, user.ID, name, fmt.Sprint(value)).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return &AttributeError{Message: name + ": the value is taken"}
+			}
+		}
+		values[name] = value
+	}
+
+	// Required attributes are checked for new users and when they are removed
+	for _, attribute := range schema {
+		_, changed := changes[attribute.Name]
+		if _, ok := values[attribute.Name]; attribute.Required && !ok && (changed || user.ID == uuid.Nil) {
+			return &AttributeError{Message: attribute.Name + ": is required"}
+		}
+	}
+
+	user.Attributes, err = json.Marshal(values)
+	return err
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestValidateAttributeDefinition(t *testing.T) {
+	high, low := 10.0, 1.0
+	valid := func(change func(attribute *models.UserAttribute)) *models.UserAttribute {
+		attribute := &models.UserAttribute{Name: "employee_id", Type: AttributeTypeString, UserAccess: AttributeAccessRead, AdminAccess: AttributeAccessWrite}
+		change(attribute)
+		return attribute
+	}
+	tests := []struct {
+		name      string
+		attribute *models.UserAttribute
+		valid     bool
+	}{
+		{"valid", valid(func(a *models.UserAttribute) { a.Pattern = `^E\d+$`; a.Unique = true }), true},
+		{"enum with options", valid(func(a *models.UserAttribute) { a.Type = AttributeTypeEnum; a.Options = []string{"a", "b"} }), true},
+		{"upper case name", valid(func(a *models.UserAttribute) { a.Name = "EmployeeID" }), false},
+		{"name with a digit first", valid(func(a *models.UserAttribute) { a.Name = "1st" }), false},
+		{"unknown type", valid(func(a *models.UserAttribute) { a.Type = "json" }), false},
+		{"invalid pattern", valid(func(a *models.UserAttribute) { a.Pattern = "(" }), false},
+		{"pattern of a number", valid(func(a *models.UserAttribute) { a.Type = AttributeTypeNumber; a.Pattern = `\d` }), false},
+		{"max_length too large", valid(func(a *models.UserAttribute) { a.MaxLength = MaxAttributeLength + 1 }), false},
+		{"min of a string", valid(func(a *models.UserAttribute) { a.Min = &low }), false},
+		{"min greater than max", valid(func(a *models.UserAttribute) { a.Type = AttributeTypeInteger; a.Min, a.Max = &high, &low }), false},
+		{"enum without options", valid(func(a *models.UserAttribute) { a.Type = AttributeTypeEnum }), false},
+		{"options of a string", valid(func(a *models.UserAttribute) { a.Options = []string{"a"} }), false},
+		{"unique boolean", valid(func(a *models.UserAttribute) { a.Type = AttributeTypeBoolean; a.Unique = true }), false},
+		{"unknown user access", valid(func(a *models.UserAttribute) { a.UserAccess = "admin" }), false},
+		{"admins without access", valid(func(a *models.UserAttribute) { a.AdminAccess = AttributeAccessNone }), false},
+	}
+	for _, test := range tests {
+		err := ValidateAttributeDefinition(test.attribute)
+		var attributeError *AttributeError
+		if test.valid && err != nil || !test.valid && !errors.As(err, &attributeError) {
+			t.Errorf("%s: error = %v", test.name, err)
+		}
+	}
+}
+
+func TestApplyAttributes(t *testing.T) {
+	tenantID, userID := uuid.New(), uuid.New()
+	schema := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "type", "required", "unique", "pattern", "max_length", "min", "max", "options", "user_access", "admin_access"}).
+			AddRow("birthday", AttributeTypeDate, false, false, "", 0, nil, nil, nil, AttributeAccessWrite, AttributeAccessWrite).
+			AddRow("cost_center", AttributeTypeInteger, true, false, "", 0, 1, 9999, nil, AttributeAccessRead, AttributeAccessWrite).
+			AddRow("employee_id", AttributeTypeString, false, true, `^E\d+$`, 8, nil, nil, nil, AttributeAccessNone, AttributeAccessWrite).
+			AddRow("level", AttributeTypeEnum, false, false, "", 0, nil, nil, "{junior,senior}", AttributeAccessRead, AttributeAccessRead)
+	}
+	tests := []struct {
+		name    string
+		changes string
+		admin   bool
+		// taken is the count of other users with the unique value, -1 if it is not checked
+		taken int
+		want  string
+	}{
+		{"user changes a writable attribute", `{"birthday": "1990-02-01"}`, false, -1, `{"birthday":"1990-02-01","cost_center":42}`},
+		{"admin sets a unique value", `{"employee_id": "E123", "cost_center": 7}`, true, 0, `{"birthday":"1980-01-01","cost_center":7,"employee_id":"E123"}`},
+		{"user changes a read only attribute", `{"cost_center": 7}`, false, -1, ""},
+		{"user changes an admin attribute", `{"employee_id": "E123"}`, false, -1, ""},
+		{"admin changes a read only attribute", `{"level": "senior"}`, true, -1, ""},
+		{"unknown attribute", `{"nickname": "al"}`, true, -1, ""},
+		{"taken unique value", `{"employee_id": "E123"}`, true, 1, ""},
+		{"value not matching the pattern", `{"employee_id": "X123"}`, true, -1, ""},
+		{"value too long", `{"employee_id": "E12345678"}`, true, -1, ""},
+		{"invalid date", `{"birthday": "01.02.1990"}`, false, -1, ""},
+		{"number out of range", `{"cost_center": 10000}`, true, -1, ""},
+		{"fraction for an integer", `{"cost_center": 1.5}`, true, -1, ""},
+		{"removed required attribute", `{"cost_center": null}`, true, -1, ""},
+		{"removed attribute", `{"birthday": null}`, false, -1, `{"cost_center":42}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			user := &models.User{ID: userID, TenantID: tenantID, Attributes: json.RawMessage(`{"birthday":"1980-01-01","cost_center":42}`)}
+
+			mock.ExpectQuery(`SELECT \* FROM "user_attributes" WHERE tenant_id = \$1 ORDER BY name`).WithArgs(tenantID).WillReturnRows(schema())
+			if test.taken >= 0 {
+				mock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).WithArgs(tenantID.String() + ":employee_id").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				// Deleted users keep their values
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE tenant_id = \$1 AND id <> \$2 AND attributes ->> \$3 = \$4$`).
+					WithArgs(tenantID, userID, "employee_id", "E123").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.taken))
+			}
+
+			var changes map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(test.changes), &changes); err != nil {
+				t.Fatal(err)
+			}
+			err = h.ApplyAttributes(h.DB, user, changes, test.admin)
+			if test.want == "" {
+				var attributeError *AttributeError
+				if !errors.As(err, &attributeError) {
+					t.Errorf("error = %v, want an AttributeError", err)
+				}
+			} else if err != nil {
+				t.Errorf("error = %v", err)
+			} else if string(user.Attributes) != test.want {
+				t.Errorf("attributes = %s, want %s", user.Attributes, test.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestApplyAttributesRequiresValuesOfNewUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+	mock.ExpectQuery(`FROM "user_attributes"`).WillReturnRows(sqlmock.NewRows([]string{"name", "type", "required", "user_access", "admin_access"}).
+		AddRow("cost_center", AttributeTypeInteger, true, AttributeAccessRead, AttributeAccessWrite))
+
+	err = h.ApplyAttributes(h.DB, &models.User{TenantID: uuid.New()}, map[string]json.RawMessage{}, true)
+	var attributeError *AttributeError
+	if !errors.As(err, &attributeError) || attributeError.Message != "cost_center: is required" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestVisibleAttributes(t *testing.T) {
+	schema := []models.UserAttribute{
+		{Name: "employee_id", UserAccess: AttributeAccessNone},
+		{Name: "level", UserAccess: AttributeAccessRead},
+	}
+	user := &models.User{Attributes: json.RawMessage(`{"employee_id":"E1","level":"senior","removed":"x"}`)}
+	if visible := VisibleAttributes(schema, user, false); len(visible) != 1 || visible["level"] != "senior" {
+		t.Errorf("visible to the user = %v", visible)
+	}
+	if visible := VisibleAttributes(schema, user, true); len(visible) != 2 || visible["employee_id"] != "E1" {
+		t.Errorf("visible to admins = %v", visible)
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// Types of audit events
+const (
+	AuditPolicyDecision = "policy.decision"
+)
+
+// Audit records an event of the tenant. Failures are logged and do not fail the request.
+func (h *Handler) Audit(tenantID uuid.UUID, eventType string, userID *uuid.UUID, clientID *uuid.UUID, details interface{}) {
+	encoded, err := json.Marshal(details)
+	if err == nil {
+		err = h.DB.Create(&models.AuditEvent{
+			TenantID: tenantID,
+			Type:     eventType,
+			UserID:   userID,
+			ClientID: clientID,
+			Details:  encoded,
+		}).Error
+	}
+	if err != nil {
+		fmt.Printf("Error recording audit event %s: %v\n", eventType, err)
+	}
+}
@@ -1,19 +1,30 @@
 package auth
 
 import (
+	"os"
+
 	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
 	Handler    *handler.Handler
 	KeyManager *helper.KeyManager
+	Mailer     mailer.Mailer
+	// PasswordResetURL is the page of the account UI which receives the reset token as "token" query parameter
+	PasswordResetURL string
+	// VerifyEmailURL is the page of the account UI which receives the verification token as "token" query parameter
+	VerifyEmailURL string
 }
 
 func NewAuthHandler(db *gorm.DB, keyManager *helper.KeyManager) *AuthHandler {
 	return &AuthHandler{
-		Handler:    handler.NewHandler(db),
-		KeyManager: keyManager,
+		Handler:          handler.NewHandler(db),
+		KeyManager:       keyManager,
+		Mailer:           mailer.Default(),
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+		VerifyEmailURL:   os.Getenv("VERIFY_EMAIL_URL"),
 	}
 }
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testPepper is the pepper of the secret hasher in the tests
+var testPepper = []byte("test pepper")
+
+// newMockAuthHandler returns an auth handler on the Postgres dialect whose statements go to a sqlmock
+func newMockAuthHandler(t *testing.T) (*AuthHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	helper.SetDefaultSecretHasher(helper.NewSecretHasher(testPepper))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AuthHandler{Handler: handler.NewHandler(gormDB)}, mock
+}
+
+// hashSecret returns the stored form of a token
+func hashSecret(t *testing.T, secret string) string {
+	t.Helper()
+	hash, err := helper.NewSecretHasher(testPepper).Hash(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
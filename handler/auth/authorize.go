@@ -3,13 +3,16 @@ package auth
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/models"
 	"github.com/secnex/sethorize-kit/utils"
 )
@@ -20,6 +23,10 @@ type AuthorizeRequest struct {
 	ResponseType string `json:"response_type"`
 	Scope        string `json:"scope"`
 	State        string `json:"state"`
+	// ACRValues are space separated ACRs of which the session has to satisfy one
+	ACRValues string `json:"acr_values"`
+	// MaxAge is the maximum age of the authentication in seconds
+	MaxAge *int `json:"max_age"`
 }
 
 type AuthorizeResponse struct {
@@ -68,6 +75,51 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Users can only authorize clients of their tenant unless the client is cross-tenant
+	var user models.User
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", session.UserID, true).First(&user).Error
+	if err != nil || !handler.ClientAllowsTenant(&client, user.TenantID) {
+		http.Error(w, handler.ErrTenantMismatch.Error(), http.StatusForbidden)
+		return
+	}
+
+	if request.MaxAge != nil && time.Since(session.AuthTime) > time.Duration(*request.MaxAge)*time.Second {
+		handler.WriteError(w, http.StatusUnauthorized, "login_required", "The authentication is older than max_age")
+		return
+	}
+
+	if request.ACRValues != "" && !handler.ACRSatisfiesAny(session.ACR, request.ACRValues) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, acr_values=%q", handler.ErrorInsufficientAuthentication, request.ACRValues))
+		handler.WriteError(w, http.StatusUnauthorized, handler.ErrorInsufficientAuthentication, "A stronger authentication is required, use the step-up flow")
+		return
+	}
+
+	settings := h.Handler.Settings(user.TenantID, &client.ID)
+	if !settings.GrantAllowed(handler.GrantAuthorizationCode) {
+		handler.WriteError(w, http.StatusForbidden, "unauthorized_client", "The grant "+handler.GrantAuthorizationCode+" is not allowed for the client")
+		return
+	}
+
+	_, err = h.Handler.AuthorizePolicies(handler.PolicyInput{
+		Point:       handler.PolicyPointAuthorize,
+		TenantID:    user.TenantID,
+		User:        &user,
+		Client:      &client,
+		Request:     r,
+		Scopes:      strings.Fields(request.Scope),
+		AuthMethods: session.AuthMethods,
+		ACR:         session.ACR,
+	})
+	var denied *handler.PolicyDeniedError
+	if errors.As(err, &denied) {
+		handler.WriteError(w, http.StatusForbidden, "access_denied", denied.Reason)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Check if consent already exists for this client and user and delete it
 	var consent models.Consent
 	_ = h.Handler.DB.Where("user_id = ? AND client_id = ?", session.UserID, client.ID).First(&consent).Error
@@ -85,6 +137,7 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	authCode := models.AuthCode{
 		ClientID:    client.ID,
 		UserID:      session.UserID,
+		SessionID:   &session.ID,
 		Code:        authCodeToken,
 		RedirectURI: request.RedirectURI,
 		Scopes:      pq.StringArray(strings.Split(request.Scope, " ")),
@@ -101,6 +154,7 @@ func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		ClientID:   client.ID,
 		AuthCodeID: authCode.ID,
 		Scopes:     pq.StringArray(strings.Split(request.Scope, " ")),
+		ExpiresAt:  time.Now().Add(settings.ConsentLifetime),
 	}
 
 	var createdConsent models.Consent
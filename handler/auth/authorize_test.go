@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestAuthorizeRequiresACRAndMaxAge(t *testing.T) {
+	maxAge := 300
+	tests := []struct {
+		name    string
+		request AuthorizeRequest
+		error   string
+	}{
+		{"acr_values not satisfied", AuthorizeRequest{ACRValues: handler.ACRMultiFactor + " " + handler.ACRPhishingResistant}, handler.ErrorInsufficientAuthentication},
+		{"authentication older than max_age", AuthorizeRequest{ACRValues: handler.ACRSingleFactor, MaxAge: &maxAge}, "login_required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			clientID, userID, tenantID := uuid.New(), uuid.New(), uuid.New()
+			mock.ExpectQuery(`FROM "clients"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "redirect_uris"}).AddRow(clientID, tenantID, "{https://app.example.com/callback}"))
+			mock.ExpectQuery(`FROM "users"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(userID, tenantID, true))
+
+			session := models.Session{ID: uuid.New(), UserID: userID, Authentication: handler.NewAuthentication(handler.AMRPassword)}
+			session.AuthTime = time.Now().Add(-time.Hour)
+			test.request.ClientID = clientID.String()
+			test.request.RedirectURI = "https://app.example.com/callback"
+			test.request.ResponseType = "code"
+			body, _ := json.Marshal(test.request)
+			request := httptest.NewRequest(http.MethodPost, "/auth/authorize", bytes.NewReader(body))
+			request = request.WithContext(context.WithValue(request.Context(), "session", session))
+			recorder := httptest.NewRecorder()
+			h.Authorize(recorder, request)
+
+			// No code is issued
+			if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), test.error) {
+				t.Errorf("status = %d (%s), want 401 %s", recorder.Code, recorder.Body.String(), test.error)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
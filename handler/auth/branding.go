@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+)
+
+// Branding returns the branding of the login page for the client and the tenant resolved like Login
+func (h *AuthHandler) Branding(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findClient(r.URL.Query().Get("client_id"))
+	if !ok || !client.IsActive {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	tenant, err := h.Handler.ResolveTenant(client, r.URL.Query().Get("tenant"), r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.Handler.Settings(tenant.ID, &client.ID).Branding)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// requireGrant answers with unauthorized_client if the settings of the tenant and client do not allow the grant
+func (h *AuthHandler) requireGrant(w http.ResponseWriter, tenantID uuid.UUID, clientID uuid.UUID, grant string) bool {
+	if h.Handler.Settings(tenantID, &clientID).GrantAllowed(grant) {
+		return true
+	}
+	handler.WriteError(w, http.StatusForbidden, "unauthorized_client", "The grant "+grant+" is not allowed for the client")
+	return false
+}
+
+// signUserToken signs the access token of a user session including how the user authenticated.
+// The roles, groups and permissions of the user are resolved, the claim mappers of the client applied
+// and the token policies evaluated at issuance.
+// It returns the token and its expiry as unix time.
+func (h *AuthHandler) signUserToken(session *models.Session, user *models.User, tenant *models.Tenant, scope string) (string, int64, error) {
+	settings := h.Handler.Settings(tenant.ID, &session.ClientID)
+	authorization, err := h.Handler.UserAuthorization(user.ID, &session.ClientID)
+	if err != nil {
+		return "", 0, err
+	}
+	var client models.Client
+	if err := h.Handler.DB.Where("id = ?", session.ClientID).First(&client).Error; err != nil {
+		return "", 0, err
+	}
+	decision, err := h.Handler.AuthorizePolicies(handler.PolicyInput{
+		Point:       handler.PolicyPointToken,
+		TenantID:    tenant.ID,
+		User:        user,
+		Client:      &client,
+		Scopes:      strings.Fields(scope),
+		AuthMethods: session.AuthMethods,
+		ACR:         session.ACR,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	exp := time.Now().Add(settings.AccessTokenLifetime).Unix()
+
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"aud": session.ClientID.String(),
+		"iss": settings.Issuer,
+		"iat": time.Now().Unix(),
+		"exp": exp,
+		"sid": session.ID.String(),
+	}
+	mapped, err := h.mappedClaims(handler.ClaimInput{
+		Tenant:        tenant,
+		Client:        &client,
+		User:          user,
+		Scopes:        strings.Fields(scope),
+		AuthMethods:   session.AuthMethods,
+		ACR:           session.ACR,
+		Authorization: &authorization,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	for name, value := range mapped {
+		claims[name] = value
+	}
+	for name, value := range authorization.Claims() {
+		claims[name] = value
+	}
+	for name, value := range decision.Claims {
+		claims[name] = value
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if !session.AuthTime.IsZero() {
+		claims["amr"] = []string(session.AuthMethods)
+		claims["acr"] = session.ACR
+		claims["auth_time"] = session.AuthTime.Unix()
+	}
+
+	token, err := h.signToken(tenant.ID, claims)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, exp, nil
+}
+
+// signToken signs the claims with the active key of the tenant and names the key in the kid header
+func (h *AuthHandler) signToken(tenantID uuid.UUID, claims jwt.MapClaims) (string, error) {
+	key, privateKey, err := h.Handler.ActiveSigningKey(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(privateKey)
+}
+
+// mappedClaims applies the claim mappers of the client. Clients without mappers get the default user claim.
+func (h *AuthHandler) mappedClaims(input handler.ClaimInput) (map[string]interface{}, error) {
+	mappers, err := h.Handler.ClientClaimMappers(input.Client.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappers) == 0 {
+		if input.User == nil {
+			return nil, nil
+		}
+		return map[string]interface{}{"user": handler.DefaultUserClaim(input.User, input.Tenant)}, nil
+	}
+
+	claims, results, err := h.Handler.MapClaims(mappers, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("Error mapping claim %s of client %s: %s\n", result.Claim, input.Client.ID, result.Error)
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// DiscoveryDocument is the OpenID Provider Metadata of a tenant
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ACRValuesSupported                []string `json:"acr_values_supported"`
+}
+
+// discoveryTenant returns the tenant of the {tenant} path parameter (ID or slug) or of the request host
+func (h *AuthHandler) discoveryTenant(r *http.Request) (*models.Tenant, error) {
+	tenantParam, ok := mux.Vars(r)["tenant"]
+	if !ok {
+		return h.Handler.HostTenant(r.Host)
+	}
+
+	var tenant models.Tenant
+	query := h.Handler.DB.Where("slug = ?", tenantParam)
+	if _, err := uuid.Parse(tenantParam); err == nil {
+		query = h.Handler.DB.Where("id = ?", tenantParam)
+	}
+	if query.First(&tenant).Error != nil {
+		return nil, handler.ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+// issuerBase returns the URL the endpoints of the issuer are served on
+func issuerBase(issuer string, r *http.Request) string {
+	if handler.IssuerHost(issuer) == "" {
+		return "https://" + r.Host
+	}
+	return strings.TrimSuffix(issuer, "/")
+}
+
+// Discovery serves /.well-known/openid-configuration for the tenant of the host, or for tenants
+// without own host below /tenants/{tenant}
+func (h *AuthHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	tenant, err := h.discoveryTenant(r)
+	if err != nil || !tenant.IsActive {
+		http.Error(w, handler.ErrTenantNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	settings := h.Handler.Settings(tenant.ID, nil)
+	base := issuerBase(settings.Issuer, r)
+	origin := base
+	if parsed, err := url.Parse(base); err == nil && parsed.Host != "" {
+		origin = parsed.Scheme + "://" + parsed.Host
+	}
+
+	grants := []string{}
+	for _, grant := range []string{handler.GrantAuthorizationCode, handler.GrantRefreshToken, handler.GrantClientCredentials} {
+		if slices.Contains(settings.AllowedGrants, grant) {
+			grants = append(grants, grant)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DiscoveryDocument{
+		Issuer:                            settings.Issuer,
+		AuthorizationEndpoint:             origin + "/auth/authorize",
+		TokenEndpoint:                     origin + "/auth/token",
+		EndSessionEndpoint:                origin + "/auth/logout",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               grants,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_post"},
+		ACRValuesSupported:                []string{handler.ACRSingleFactor, handler.ACRMultiFactor, handler.ACRPhishingResistant},
+	})
+}
+
+// JWKS serves the public signing keys of the tenant of the host or the {tenant} path parameter,
+// including retired keys until their tokens have expired
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	tenant, err := h.discoveryTenant(r)
+	if err != nil || !tenant.IsActive {
+		http.Error(w, handler.ErrTenantNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Make sure the tenant has a key before its first token is signed
+	if _, _, err := h.Handler.ActiveSigningKey(tenant.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	set, err := h.Handler.TenantJWKS(tenant.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(set)
+}
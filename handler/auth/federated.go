@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// FederatedCookie binds a federated login to the browser that started it
+const FederatedCookie = "sethorize_federated"
+
+// FederatedConnection is a connection as offered on the login page
+type FederatedConnection struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name"`
+}
+
+type FederatedStartRequest struct {
+	ClientID string `json:"client_id"`
+	Tenant   string `json:"tenant"`
+	// Connection is the ID or name of the connection, without it the connection is chosen by the domain of Email
+	Connection string `json:"connection"`
+	Email      string `json:"email"`
+	// RedirectURI is the page of the client that receives code and state, one of the client's redirect URIs
+	RedirectURI string `json:"redirect_uri"`
+}
+
+type FederatedStartResponse struct {
+	AuthorizationURL string              `json:"authorization_url"`
+	Connection       FederatedConnection `json:"connection"`
+	// FederatedToken is needed to complete the login, browsers send it as cookie instead
+	FederatedToken string `json:"federated_token"`
+	ExpiresIn      int    `json:"expires_in"`
+}
+
+type FederatedCallbackRequest struct {
+	FederatedToken string `json:"federated_token"`
+	// State, Code and Error are the parameters the provider redirected to the redirect URI with
+	State            string `json:"state"`
+	Code             string `json:"code"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// federatedClientTenant resolves client and tenant of a federated login request and checks the grant
+func (h *AuthHandler) federatedClientTenant(w http.ResponseWriter, r *http.Request, clientID string, tenantParam string) (*models.Client, *models.Tenant, bool) {
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	client, ok := h.findClient(clientID)
+	if !ok || !client.IsActive {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	tenant, err := h.Handler.ResolveTenant(client, tenantParam, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return nil, nil, false
+	}
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantFederated) {
+		return nil, nil, false
+	}
+	return client, tenant, true
+}
+
+// FederatedConnections lists the active connections of the tenant for a login page. With email only the
+// connection the email domain is routed to is returned.
+func (h *AuthHandler) FederatedConnections(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	_, tenant, ok := h.federatedClientTenant(w, r, params.Get("client_id"), params.Get("tenant"))
+	if !ok {
+		return
+	}
+
+	var connections []models.OIDCConnection
+	if email := params.Get("email"); email != "" {
+		if connection, err := h.Handler.ConnectionForEmail(tenant.ID, email); err == nil {
+			connections = append(connections, *connection)
+		}
+	} else {
+		err := h.Handler.DB.Where("tenant_id = ? AND is_active = ?", tenant.ID, true).Order("name").Find(&connections).Error
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := []FederatedConnection{}
+	for _, connection := range connections {
+		response = append(response, FederatedConnection{ID: connection.ID, Name: connection.Name, DisplayName: connection.DisplayName})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// FederatedStart starts a login at an upstream provider with the code flow and PKCE. The client sends the
+// browser to the returned authorization URL and passes code and state from its redirect URI to
+// FederatedCallback.
+func (h *AuthHandler) FederatedStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request FederatedStartRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, tenant, ok := h.federatedClientTenant(w, r, request.ClientID, request.Tenant)
+	if !ok {
+		return
+	}
+	if !slices.Contains(client.RedirectURIs, request.RedirectURI) {
+		http.Error(w, "Invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	var connection *models.OIDCConnection
+	if request.Connection != "" {
+		var found models.OIDCConnection
+		query := h.Handler.DB.Where("tenant_id = ? AND is_active = ?", tenant.ID, true)
+		if _, err := uuid.Parse(request.Connection); err == nil {
+			query = query.Where("id = ?", request.Connection)
+		} else {
+			query = query.Where("name = ?", request.Connection)
+		}
+		if query.First(&found).Error == nil {
+			connection = &found
+		}
+	} else if request.Email != "" {
+		connection, _ = h.Handler.ConnectionForEmail(tenant.ID, request.Email)
+	}
+	if connection == nil {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+
+	browserToken := utils.GenerateToken(32)
+	authorizationURL, login, err := h.Handler.FederatedAuthorizationURL(connection, client.ID, request.RedirectURI, request.Email, browserToken)
+	var federationErr *handler.FederationError
+	if errors.As(err, &federationErr) {
+		http.Error(w, federationErr.Message, http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	bearer := utils.EncodeBearerToken(login.ID.String(), browserToken)
+	expiresIn := int(time.Until(login.ExpiresAt).Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     FederatedCookie,
+		Value:    bearer,
+		Path:     "/auth/federated",
+		MaxAge:   expiresIn,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FederatedStartResponse{
+		AuthorizationURL: authorizationURL,
+		Connection:       FederatedConnection{ID: connection.ID, Name: connection.Name, DisplayName: connection.DisplayName},
+		FederatedToken:   bearer,
+		ExpiresIn:        expiresIn,
+	})
+}
+
+// FederatedCallback completes a federated login in the browser that started it: it redeems the code,
+// validates the ID token, finds, links or provisions the user and creates a session like Login,
+// including a second factor challenge if required.
+func (h *AuthHandler) FederatedCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request FederatedCallbackRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cookie, err := r.Cookie(FederatedCookie); err == nil && request.FederatedToken == "" {
+		request.FederatedToken = cookie.Value
+	}
+
+	invalid := "Invalid or expired sign-in"
+
+	loginID, browserToken, ok := utils.DecodeBearerToken(request.FederatedToken)
+	if !ok || loginID != request.State {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+	if _, err := uuid.Parse(loginID); err != nil {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	var login models.OIDCLogin
+	err = h.Handler.DB.Where("id = ? AND used_at IS NULL AND expires_at > ?", loginID, time.Now()).First(&login).Error
+	if err != nil {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := h.Handler.VerifySecret(&login, "browser_token", browserToken, login.BrowserToken)
+	if err != nil {
+		http.Error(w, invalid, handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !valid {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	result := h.Handler.DB.Model(&models.OIDCLogin{}).Where("id = ? AND used_at IS NULL", login.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: FederatedCookie, Path: "/auth/federated", MaxAge: -1, HttpOnly: true, Secure: true})
+
+	if request.Error != "" {
+		http.Error(w, "Sign-in at the provider failed: "+request.Error+" "+request.ErrorDescription, http.StatusUnauthorized)
+		return
+	}
+	if request.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	var connection models.OIDCConnection
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", login.ConnectionID, true).First(&connection).Error
+	if err != nil {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", login.ClientID, true).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", connection.TenantID, true).First(&tenant).Error
+	if err != nil {
+		http.Error(w, handler.ErrTenantInactive.Error(), http.StatusForbidden)
+		return
+	}
+
+	claims, err := h.Handler.ExchangeOIDCCode(&connection, &login, request.Code)
+	var federationErr *handler.FederationError
+	if errors.As(err, &federationErr) {
+		http.Error(w, federationErr.Message, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	user, err := h.Handler.FederatedUser(&connection, claims)
+	switch {
+	case errors.Is(err, handler.ErrFederatedUserNotFound), errors.Is(err, handler.ErrFederatedUserInactive):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, handler.ErrFederatedUserExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	scope, ok := loginScope(user, &client, &tenant)
+	if !ok {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	methods := h.mfaMethods(user.ID)
+	if len(methods) > 0 || h.Handler.MFARequired(user, &client.ID) {
+		h.writeMFAChallenge(w, models.MFAChallenge{UserID: user.ID, ClientID: client.ID, AuthMethods: []string{handler.AMRFederated}}, methods)
+		return
+	}
+
+	response, err := h.issueLogin(r, user, &client, &tenant, scope, handler.NewAuthentication(handler.AMRFederated))
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,11 +2,13 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/helper"
 	"github.com/secnex/sethorize-kit/models"
 )
@@ -15,6 +17,8 @@ type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	ClientID string `json:"client_id"`
+	// Tenant is the ID or slug of the tenant, by default the tenant of the host or the client
+	Tenant string `json:"tenant"`
 }
 
 type LoginResponse struct {
@@ -42,30 +46,33 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var client models.Client
-	// Check if clientId is a valid uuid (then search by id) or slug (then search by slug)
-	if _, err := uuid.Parse(request.ClientID); err == nil {
-		err = h.Handler.DB.Where("id = ?", request.ClientID).First(&client).Error
-	} else {
-		err = h.Handler.DB.Where("slug = ?", request.ClientID).First(&client).Error
+	client, ok := h.findClient(request.ClientID)
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
 	}
 
-	if client.ID == uuid.Nil {
-		http.Error(w, "Client not found", http.StatusNotFound)
+	tenant, err := h.Handler.ResolveTenant(client, request.Tenant, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return
+	}
+
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantPassword) {
 		return
 	}
 
 	var user models.User
-	err = h.Handler.DB.Where("email = ? AND is_active = ? AND is_verified = ?", request.Username, true, true).First(&user).Error
+	err = h.Handler.DB.Where("LOWER(email) = ? AND tenant_id = ? AND is_active = ?", helper.NormalizeEmail(request.Username), tenant.ID, true).First(&user).Error
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	argon2 := helper.NewArgon2Default()
-	valid, err := argon2.Compare(request.Password, user.Password)
+	hasher := helper.DefaultHasher()
+	valid, needsRehash, err := hasher.Compare(request.Password, user.Password)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -74,58 +81,110 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tenant models.Tenant
-	h.Handler.DB.Where("id = ?", user.TenantID).First(&tenant)
+	// Upgrade hashes with outdated parameters or from legacy systems
+	if needsRehash {
+		hash, err := hasher.Hash(request.Password)
+		if err == nil {
+			err = h.Handler.DB.Model(&user).Update("password", hash).Error
+		}
+		if err != nil {
+			fmt.Printf("Error rehashing password of user %s: %v\n", user.ID, err)
+		}
+	}
+
+	// Unverified users only get the restricted scope and only if the tenant allows it
+	scope, ok := loginScope(&user, client, tenant)
+	if !ok {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	// With an enrolled or required second factor only a challenge is returned
+	methods := h.mfaMethods(user.ID)
+	if len(methods) > 0 || h.Handler.MFARequired(&user, &client.ID) {
+		h.writeMFAChallenge(w, models.MFAChallenge{UserID: user.ID, ClientID: client.ID}, methods)
+		return
+	}
+
+	response, err := h.issueLogin(r, &user, client, tenant, scope, handler.NewAuthentication(handler.AMRPassword))
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// findClient looks up a client by its ID or, if clientID is not a valid uuid, by its slug
+func (h *AuthHandler) findClient(clientID string) (*models.Client, bool) {
+	var client models.Client
+	if _, err := uuid.Parse(clientID); err == nil {
+		h.Handler.DB.Where("id = ?", clientID).First(&client)
+	} else {
+		h.Handler.DB.Where("slug = ?", clientID).First(&client)
+	}
+	return &client, client.ID != uuid.Nil
+}
+
+// loginScope returns the scope of a login token. ok is false if the user may not log in yet.
+func loginScope(user *models.User, client *models.Client, tenant *models.Tenant) (string, bool) {
+	if user.IsVerified && (user.IsAdmin || user.IsSuperAdmin) && client.Internal {
+		return "read " + handler.ScopeAdmin, true
+	}
+	if user.IsVerified {
+		return "read", true
+	}
+	if !tenant.AllowUnverifiedLogin {
+		return "", false
+	}
+	return handler.ScopeUnverified, true
+}
+
+// issueLogin evaluates the login policies, creates a session for the user and client and signs its access token
+func (h *AuthHandler) issueLogin(r *http.Request, user *models.User, client *models.Client, tenant *models.Tenant, scope string, authentication models.Authentication) (*LoginResponse, error) {
+	_, err := h.Handler.AuthorizePolicies(handler.PolicyInput{
+		Point:       handler.PolicyPointLogin,
+		TenantID:    tenant.ID,
+		User:        user,
+		Client:      client,
+		Request:     r,
+		Scopes:      strings.Fields(scope),
+		AuthMethods: authentication.AuthMethods,
+		ACR:         authentication.ACR,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	session := models.Session{
-		UserID:   user.ID,
-		ClientID: client.ID,
+		UserID:         user.ID,
+		ClientID:       client.ID,
+		Authentication: authentication,
 	}
 
 	var createdSession models.Session
 	err = h.Handler.DB.Create(&session).Scan(&createdSession).Error
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	exp := time.Now().Add(time.Minute * 60).Unix()
-
-	jwt := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"sub": user.ID,
-		"aud": client.ID.String(),
-		"iss": "sethorize-idp-api",
-		"iat": time.Now().Unix(),
-		"exp": exp,
-		"sid": createdSession.ID.String(),
-		"user": map[string]interface{}{
-			"first_name":   user.FirstName,
-			"last_name":    user.LastName,
-			"display_name": user.DisplayName,
-			"email":        user.Email,
-			"id":           user.ID,
-			"tenant_id":    user.TenantID,
-			"tenant_name":  tenant.Name,
-			"is_admin":     user.IsAdmin,
-		},
-	})
+	return h.loginResponse(&createdSession, user, tenant, scope)
+}
 
-	tokenString, err := jwt.SignedString(h.KeyManager.GetPrivateKey())
+func (h *AuthHandler) loginResponse(session *models.Session, user *models.User, tenant *models.Tenant, scope string) (*LoginResponse, error) {
+	tokenString, exp, err := h.signUserToken(session, user, tenant, scope)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	expiresInSeconds := exp - time.Now().Unix()
 
-	response := LoginResponse{
+	return &LoginResponse{
 		AccessToken: tokenString,
 		ExpiresIn:   int(expiresInSeconds),
 		TokenType:   "Bearer",
-		Scope:       "read",
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+		Scope:       scope,
+	}, nil
 }
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestLoginScope(t *testing.T) {
+	tests := []struct {
+		name            string
+		user            models.User
+		internal        bool
+		allowUnverified bool
+		scope           string
+		ok              bool
+	}{
+		{"verified user", models.User{IsVerified: true}, false, false, "read", true},
+		{"verified admin on an internal client", models.User{IsVerified: true, IsAdmin: true}, true, false, "read " + handler.ScopeAdmin, true},
+		{"verified admin on another client", models.User{IsVerified: true, IsAdmin: true}, false, false, "read", true},
+		{"unverified admin on an internal client", models.User{IsAdmin: true}, true, true, handler.ScopeUnverified, true},
+		{"unverified user", models.User{}, false, false, "", false},
+		{"unverified user of a tenant allowing it", models.User{}, false, true, handler.ScopeUnverified, true},
+	}
+	for _, test := range tests {
+		scope, ok := loginScope(&test.user, &models.Client{Internal: test.internal}, &models.Tenant{AllowUnverifiedLogin: test.allowUnverified})
+		if scope != test.scope || ok != test.ok {
+			t.Errorf("%s: scope = %q, %t, want %q, %t", test.name, scope, ok, test.scope, test.ok)
+		}
+	}
+}
+
+func TestLoginLooksUpUsersInTheResolvedTenant(t *testing.T) {
+	for _, crossTenant := range []bool{false, true} {
+		h, mock := newMockAuthHandler(t)
+		clientID, own, other := uuid.New(), uuid.New(), uuid.New()
+		mock.ExpectQuery(`FROM "clients" WHERE id = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "cross_tenant", "is_active"}).AddRow(clientID, own, crossTenant, true))
+		mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(other.String(), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(other, true))
+		if crossTenant {
+			// Settings of the tenant and client
+			mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(other, true))
+			mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			for range 3 {
+				mock.ExpectQuery(`FROM "settings"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			}
+			// The user is only looked up in the resolved tenant
+			mock.ExpectQuery(`FROM "users" WHERE \(LOWER\(email\) = \$1 AND tenant_id = \$2 AND is_active = \$3\)`).
+				WithArgs("alice@example.com", other, true, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}
+
+		body, _ := json.Marshal(LoginRequest{Username: "Alice@Example.com", Password: "password", ClientID: clientID.String(), Tenant: other.String()})
+		recorder := httptest.NewRecorder()
+		h.Login(recorder, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body)))
+
+		status := map[bool]int{false: http.StatusForbidden, true: http.StatusNotFound}[crossTenant]
+		if recorder.Code != status {
+			t.Errorf("cross-tenant %t: status = %d (%s), want %d", crossTenant, recorder.Code, recorder.Body.String(), status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
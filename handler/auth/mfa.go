@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// MFAMaxAttempts is the number of codes that can be tried on a challenge before it is invalidated
+const MFAMaxAttempts = 5
+
+type MFAChallengeResponse struct {
+	MFARequired        bool     `json:"mfa_required"`
+	MFAToken           string   `json:"mfa_token"`
+	Methods            []string `json:"methods"`
+	EnrollmentRequired bool     `json:"enrollment_required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string                     `json:"mfa_token"`
+	Code         string                     `json:"code"`
+	RecoveryCode string                     `json:"recovery_code"`
+	WebAuthn     *handler.WebAuthnAssertion `json:"webauthn"`
+}
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MFAEnrollConfirmResponse struct {
+	LoginResponse
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaMethods lists the second factors the user has enrolled
+func (h *AuthHandler) mfaMethods(userID uuid.UUID) []string {
+	methods := []string{}
+	if h.Handler.HasTOTP(userID) {
+		methods = append(methods, "totp", "recovery_code")
+	}
+	if h.Handler.HasWebAuthn(userID) {
+		methods = append(methods, "webauthn")
+	}
+	return methods
+}
+
+// writeMFAChallenge stores the challenge for a login or a step-up of the session and answers with it.
+// Without enrolled methods the user has to enroll first.
+func (h *AuthHandler) writeMFAChallenge(w http.ResponseWriter, challenge models.MFAChallenge, methods []string) {
+	tokenValue := utils.GenerateToken(32)
+	challenge.Token = tokenValue
+
+	err := h.Handler.DB.Create(&challenge).Error
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired:        true,
+		MFAToken:           utils.EncodeBearerToken(challenge.ID.String(), tokenValue),
+		Methods:            methods,
+		EnrollmentRequired: len(methods) == 0,
+	})
+}
+
+// loadMFAChallenge returns the open challenge for the token together with its user, client and tenant
+func (h *AuthHandler) loadMFAChallenge(token string) (*models.MFAChallenge, *models.User, *models.Client, *models.Tenant, error) {
+	invalid := errors.New("invalid or expired MFA token")
+
+	challengeID, tokenValue, ok := utils.DecodeBearerToken(token)
+	if !ok {
+		return nil, nil, nil, nil, invalid
+	}
+	if _, err := uuid.Parse(challengeID); err != nil {
+		return nil, nil, nil, nil, invalid
+	}
+
+	var challenge models.MFAChallenge
+	err := h.Handler.DB.Where("id = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", challengeID, time.Now(), MFAMaxAttempts).First(&challenge).Error
+	if err != nil {
+		return nil, nil, nil, nil, invalid
+	}
+
+	valid, err := h.Handler.VerifySecret(&challenge, "token", tokenValue, challenge.Token)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if !valid {
+		return nil, nil, nil, nil, invalid
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", challenge.UserID, true).First(&user).Error
+	if err != nil {
+		return nil, nil, nil, nil, invalid
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", challenge.ClientID, true).First(&client).Error
+	if err != nil {
+		return nil, nil, nil, nil, invalid
+	}
+
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", user.TenantID, true).First(&tenant).Error
+	if err != nil {
+		return nil, nil, nil, nil, handler.ErrTenantInactive
+	}
+
+	return &challenge, &user, &client, &tenant, nil
+}
+
+// canEnrollMFA answers with 403 unless the challenge is the one of a login and the user has no second
+// factor yet. Otherwise the first factor alone would be enough to enroll an authenticator app and complete
+// a challenge meant for the user's own second factor or for a step-up.
+func (h *AuthHandler) canEnrollMFA(w http.ResponseWriter, challenge *models.MFAChallenge, user *models.User) bool {
+	if challenge.SessionID != nil || len(h.mfaMethods(user.ID)) > 0 {
+		http.Error(w, "MFA enrollment is only possible during a login without enrolled factors", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// countMFAAttempt counts an attempt on the challenge before the code is checked and answers with 401
+// once MFAMaxAttempts attempts were made
+func (h *AuthHandler) countMFAAttempt(w http.ResponseWriter, challenge *models.MFAChallenge) bool {
+	ok, err := h.Handler.CountAttempt(&models.MFAChallenge{}, challenge.ID, MFAMaxAttempts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "invalid or expired MFA token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// completeMFAChallenge marks the challenge as used and creates the session, or for a step-up adds the
+// methods to the existing session. It fails if the challenge was used concurrently.
+func (h *AuthHandler) completeMFAChallenge(r *http.Request, challenge *models.MFAChallenge, user *models.User, client *models.Client, tenant *models.Tenant, methods ...string) (*LoginResponse, error) {
+	result := h.Handler.DB.Model(&models.MFAChallenge{}).Where("id = ? AND used_at IS NULL", challenge.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, errors.New("invalid or expired MFA token")
+	}
+
+	scope, ok := loginScope(user, client, tenant)
+	if !ok {
+		return nil, errors.New("email address not verified")
+	}
+
+	if challenge.SessionID == nil {
+		firstFactor := []string(challenge.AuthMethods)
+		if len(firstFactor) == 0 {
+			firstFactor = []string{handler.AMRPassword}
+		}
+		return h.issueLogin(r, user, client, tenant, scope, handler.NewAuthentication(append(firstFactor, methods...)...))
+	}
+
+	var session models.Session
+	err := h.Handler.DB.Where("id = ? AND user_id = ? AND revoked_at IS NULL", *challenge.SessionID, user.ID).First(&session).Error
+	if err != nil {
+		return nil, errors.New("invalid session")
+	}
+
+	session.Authentication = handler.AddAuthMethods(session.Authentication, methods...)
+	err = h.Handler.DB.Model(&session).Updates(map[string]interface{}{
+		"auth_methods": session.AuthMethods,
+		"acr":          session.ACR,
+		"auth_time":    session.AuthTime,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return h.loginResponse(&session, user, tenant, scope)
+}
+
+// MFAVerify redeems an MFA challenge with a TOTP code or a recovery code
+func (h *AuthHandler) MFAVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request MFAVerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, user, client, tenant, err := h.loadMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+
+	if request.Code == "" && request.RecoveryCode == "" && request.WebAuthn == nil {
+		http.Error(w, "Code, recovery code or WebAuthn assertion is required", http.StatusBadRequest)
+		return
+	}
+	if !h.countMFAAttempt(w, challenge) {
+		return
+	}
+
+	var valid bool
+	var method string
+	switch {
+	case request.Code != "":
+		method = handler.AMROTP
+		valid, err = h.Handler.VerifyTOTP(user.ID, request.Code)
+	case request.RecoveryCode != "":
+		method = handler.AMROTP
+		valid, err = h.Handler.UseRecoveryCode(user.ID, request.RecoveryCode)
+	default:
+		method = handler.AMRHardwareKey
+		valid, err = h.verifyMFAWebAuthn(challenge, request.WebAuthn)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	response, err := h.completeMFAChallenge(r, challenge, user, client, tenant, method)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// MFAEnroll starts the authenticator app enrollment of a user whose tenant requires MFA during login
+func (h *AuthHandler) MFAEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request MFAEnrollRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, user, _, _, err := h.loadMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !h.canEnrollMFA(w, challenge, user) {
+		return
+	}
+
+	secret, uri, err := h.Handler.BeginTOTPEnrollment(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MFAEnrollResponse{Secret: secret, URI: uri})
+}
+
+// MFAEnrollConfirm confirms the enrollment with a first code and completes the login
+func (h *AuthHandler) MFAEnrollConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request MFAVerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, user, client, tenant, err := h.loadMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !h.canEnrollMFA(w, challenge, user) {
+		return
+	}
+
+	if !h.countMFAAttempt(w, challenge) {
+		return
+	}
+
+	recoveryCodes, err := h.Handler.ConfirmTOTPEnrollment(user, request.Code)
+	if errors.Is(err, handler.ErrInvalidMFACode) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.completeMFAChallenge(r, challenge, user, client, tenant, handler.AMROTP)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MFAEnrollConfirmResponse{LoginResponse: *response, RecoveryCodes: recoveryCodes})
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+func TestMFAEnrollRequiresLoginWithoutFactors(t *testing.T) {
+	stepUp := uuid.New()
+	tests := []struct {
+		name      string
+		sessionID *uuid.UUID
+		webAuthn  int
+	}{
+		{"user with WebAuthn only", nil, 1},
+		{"step-up of a session", &stepUp, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			challengeID, userID, clientID, tenantID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+			expectChallenge := func() {
+				mock.ExpectQuery(`FROM "mfa_challenges" WHERE id = \$1 AND used_at IS NULL`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "client_id", "session_id", "token", "expires_at"}).
+						AddRow(challengeID, userID, clientID, test.sessionID, hashSecret(t, "secret"), time.Now().Add(time.Minute)))
+				mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(userID, tenantID, true))
+				mock.ExpectQuery(`FROM "clients"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(clientID, tenantID, true))
+				mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(tenantID, true))
+				if test.sessionID == nil {
+					mock.ExpectQuery(`FROM "totp_factors"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+					mock.ExpectQuery(`FROM "webauthn_credentials"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.webAuthn))
+				}
+			}
+
+			// Neither an enrollment is started nor a code counted or confirmed
+			for _, endpoint := range []http.HandlerFunc{h.MFAEnroll, h.MFAEnrollConfirm} {
+				expectChallenge()
+				body, _ := json.Marshal(map[string]string{"mfa_token": utils.EncodeBearerToken(challengeID.String(), "secret"), "code": "123456"})
+				recorder := httptest.NewRecorder()
+				endpoint(recorder, httptest.NewRequest(http.MethodPost, "/auth/mfa/enroll", bytes.NewReader(body)))
+				if recorder.Code != http.StatusForbidden {
+					t.Fatalf("status = %d (%s), want 403", recorder.Code, recorder.Body.String())
+				}
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMFAVerifyCountsAttemptsBeforeCheckingTheCode(t *testing.T) {
+	for _, counted := range []bool{true, false} {
+		h, mock := newMockAuthHandler(t)
+		challengeID, userID, clientID, tenantID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+		mock.ExpectQuery(`FROM "mfa_challenges" WHERE id = \$1 AND used_at IS NULL AND expires_at > \$2 AND attempts < \$3`).
+			WithArgs(challengeID.String(), sqlmock.AnyArg(), MFAMaxAttempts, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "client_id", "token", "attempts", "expires_at"}).
+				AddRow(challengeID, userID, clientID, hashSecret(t, "secret"), MFAMaxAttempts-1, time.Now().Add(time.Minute)))
+		mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(userID, tenantID, true))
+		mock.ExpectQuery(`FROM "clients"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(clientID, tenantID, true))
+		mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(tenantID, true))
+		// A concurrent request may have made the last attempt since the challenge was loaded
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "mfa_challenges" SET "attempts"=attempts \+ 1 WHERE id = \$1 AND attempts < \$2`).
+			WithArgs(challengeID, MFAMaxAttempts).WillReturnResult(sqlmock.NewResult(0, map[bool]int64{true: 1}[counted]))
+		mock.ExpectCommit()
+		if counted {
+			mock.ExpectQuery(`FROM "totp_factors"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}
+
+		body, _ := json.Marshal(MFAVerifyRequest{MFAToken: utils.EncodeBearerToken(challengeID.String(), "secret"), Code: "123456"})
+		recorder := httptest.NewRecorder()
+		h.MFAVerify(recorder, httptest.NewRequest(http.MethodPost, "/auth/mfa/verify", bytes.NewReader(body)))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d (%s), want 401", recorder.Code, recorder.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+var errResetTokenUsed = errors.New("password reset token was already used")
+
+type PasswordResetRequest struct {
+	Email    string `json:"email"`
+	ClientID string `json:"client_id"`
+	Tenant   string `json:"tenant"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type PasswordResetResponse struct {
+	Message string `json:"message"`
+}
+
+// RequestPasswordReset sends a reset link to the user. The response is the same whether
+// the email is registered or not; the work happens in the background to keep timings equal.
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PasswordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Email == "" || request.ClientID == "" {
+		http.Error(w, "Email and client ID are required", http.StatusBadRequest)
+		return
+	}
+
+	go h.sendPasswordReset(request, r.Host)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PasswordResetResponse{
+		Message: "If the email address is registered, a reset link has been sent",
+	})
+}
+
+func (h *AuthHandler) sendPasswordReset(request PasswordResetRequest, host string) {
+	var client models.Client
+	err := h.Handler.DB.Where("id = ? AND is_active = ?", request.ClientID, true).First(&client).Error
+	if err != nil {
+		return
+	}
+
+	tenant, err := h.Handler.ResolveTenant(&client, request.Tenant, host)
+	if err != nil {
+		return
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("LOWER(email) = ? AND tenant_id = ? AND is_active = ?", helper.NormalizeEmail(request.Email), tenant.ID, true).First(&user).Error
+	if err != nil {
+		return
+	}
+
+	err = h.Handler.SendPasswordReset(h.Mailer, &user, h.PasswordResetURL)
+	if err != nil {
+		fmt.Printf("Error sending password reset: %v\n", err)
+	}
+}
+
+// ConfirmPasswordReset sets the new password and revokes all sessions and refresh tokens of the user
+func (h *AuthHandler) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PasswordResetConfirmRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resetID, tokenValue, ok := utils.DecodeBearerToken(request.Token)
+	if !ok {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if _, err := uuid.Parse(resetID); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	var reset models.PasswordReset
+	err = h.Handler.DB.Where("id = ? AND used_at IS NULL AND expires_at > ?", resetID, time.Now()).First(&reset).Error
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := h.Handler.VerifySecret(&reset, "token", tokenValue, reset.Token)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", reset.UserID, true).First(&user).Error
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Single use: the token is claimed before the password is set. If the password is rejected the claim
+	// is rolled back, so the user can try again with another password.
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errResetTokenUsed
+		}
+		return h.Handler.SetPassword(tx, &user, request.Password)
+	})
+	if errors.Is(err, errResetTokenUsed) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		handler.WritePasswordError(w, err)
+		return
+	}
+
+	err = h.Handler.RevokeUserSessions(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PasswordResetResponse{Message: "Password has been reset"})
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// recordingMailer keeps the messages instead of sending them
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(message mailer.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestConfirmPasswordResetIsSingleUse(t *testing.T) {
+	params := &helper.Argon2Params{Memory: 1024, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	helper.SetDefaultHasher(helper.NewHasher(helper.NewArgon2(params), helper.HasherOptions{}))
+	t.Cleanup(func() { helper.SetDefaultHasher(nil) })
+
+	tests := []struct {
+		name   string
+		secret string
+		claims int64
+		status int
+	}{
+		{"wrong token", "guess", 0, http.StatusBadRequest},
+		{"used concurrently", "secret", 0, http.StatusBadRequest},
+		{"claimed", "secret", 1, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			resetID, userID, tenantID := uuid.New(), uuid.New(), uuid.New()
+			mock.ExpectQuery(`FROM "password_resets" WHERE id = \$1 AND used_at IS NULL AND expires_at > \$2`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "token", "expires_at"}).
+					AddRow(resetID, userID, hashSecret(t, "secret"), time.Now().Add(time.Minute)))
+			if test.secret == "secret" {
+				mock.ExpectQuery(`FROM "users"`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email", "password", "is_active"}).
+						AddRow(userID, tenantID, "alice@example.com", "old hash", true))
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "password_resets" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+					WithArgs(sqlmock.AnyArg(), resetID).WillReturnResult(sqlmock.NewResult(0, test.claims))
+			}
+			if test.claims == 0 && test.secret == "secret" {
+				mock.ExpectRollback()
+			}
+			if test.claims == 1 {
+				policy := sqlmock.NewRows([]string{"tenant_id", "min_length", "max_length", "check_breached", "history_size"}).
+					AddRow(tenantID, 8, 128, false, 0)
+				mock.ExpectQuery(`FROM "password_policies"`).WillReturnRows(policy)
+				mock.ExpectQuery(`FROM "password_policies"`).WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "history_size"}).AddRow(tenantID, 0))
+				mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`INSERT INTO "password_histories"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+				mock.ExpectExec(`UPDATE "users" SET "password"=\$1`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				// All sessions and refresh tokens of the user are revoked
+				for _, table := range []string{"sessions", "refresh_tokens"} {
+					mock.ExpectBegin()
+					mock.ExpectExec(`UPDATE "` + table + `" SET "revoked_at"=\$1.* WHERE \(?user_id = \$\d+ AND revoked_at IS NULL`).
+						WillReturnResult(sqlmock.NewResult(0, 2))
+					mock.ExpectCommit()
+				}
+			}
+
+			body, _ := json.Marshal(PasswordResetConfirmRequest{
+				Token:    utils.EncodeBearerToken(resetID.String(), test.secret),
+				Password: "a new password",
+			})
+			recorder := httptest.NewRecorder()
+			h.ConfirmPasswordReset(recorder, httptest.NewRequest(http.MethodPost, "/auth/password/reset/confirm", bytes.NewReader(body)))
+			if recorder.Code != test.status {
+				t.Errorf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), test.status)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSendPasswordResetToUnknownEmail(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+	sent := &recordingMailer{}
+	h.Mailer = sent
+	clientID, tenantID := uuid.New(), uuid.New()
+	mock.ExpectQuery(`FROM "clients"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "is_active"}).AddRow(clientID, tenantID, true))
+	mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(tenantID, true))
+	mock.ExpectQuery(`FROM "users" WHERE \(LOWER\(email\) = \$1 AND tenant_id = \$2`).
+		WithArgs("nobody@example.com", tenantID, true, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	h.sendPasswordReset(PasswordResetRequest{Email: " Nobody@Example.com", ClientID: clientID.String()}, "")
+	if len(sent.messages) != 0 {
+		t.Errorf("sent %d messages", len(sent.messages))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
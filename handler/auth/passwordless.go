@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+const (
+	// PasswordlessMaxAttempts is the number of codes that can be tried on a passwordless login before it is invalidated
+	PasswordlessMaxAttempts = 5
+	// PasswordlessHourlyLimit is the number of emails a user receives per hour
+	PasswordlessHourlyLimit = 5
+	// PasswordlessCookie binds a passwordless login to the browser that requested it
+	PasswordlessCookie = "sethorize_passwordless"
+	// PasswordlessCodeDigits is the length of the emailed code
+	PasswordlessCodeDigits = 6
+)
+
+type PasswordlessStartRequest struct {
+	ClientID string `json:"client_id"`
+	Email    string `json:"email"`
+	Method   string `json:"method"`
+	Tenant   string `json:"tenant"`
+}
+
+type PasswordlessStartResponse struct {
+	Message           string `json:"message"`
+	PasswordlessToken string `json:"passwordless_token"`
+	Method            string `json:"method"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type PasswordlessVerifyRequest struct {
+	// PasswordlessToken is returned by PasswordlessStart, browsers send it as cookie instead
+	PasswordlessToken string `json:"passwordless_token"`
+	// Token is the token of the emailed link, Code the emailed code
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// PasswordlessStart emails a login link or code if the client allows it. The response is the same whether
+// the email is registered or not. It carries the browser token that is needed to redeem the login.
+func (h *AuthHandler) PasswordlessStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PasswordlessStartRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Email == "" || request.ClientID == "" {
+		http.Error(w, "Email and client ID are required", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := h.findClient(request.ClientID)
+	if !ok || !client.IsActive {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case request.Method == "" && client.PasswordlessCode:
+		request.Method = models.PasswordlessMethodCode
+	case request.Method == "" && client.PasswordlessLink:
+		request.Method = models.PasswordlessMethodLink
+	}
+	allowed := (request.Method == models.PasswordlessMethodCode && client.PasswordlessCode) ||
+		(request.Method == models.PasswordlessMethodLink && client.PasswordlessLink && client.PasswordlessURL != "")
+	if !allowed {
+		http.Error(w, "Passwordless login is not enabled for the client", http.StatusForbidden)
+		return
+	}
+
+	tenant, err := h.Handler.ResolveTenant(client, request.Tenant, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return
+	}
+
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantPasswordless) {
+		return
+	}
+
+	// The ID and browser token are created up front so that the response does not depend on the user
+	loginID := uuid.New()
+	browserToken := utils.GenerateToken(32)
+	go h.sendPasswordlessLogin(loginID, browserToken, client, tenant, request)
+
+	bearer := utils.EncodeBearerToken(loginID.String(), browserToken)
+	http.SetCookie(w, &http.Cookie{
+		Name:     PasswordlessCookie,
+		Value:    bearer,
+		Path:     "/auth/passwordless",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PasswordlessStartResponse{
+		Message:           "If the email address is registered, a sign-in email has been sent",
+		PasswordlessToken: bearer,
+		Method:            request.Method,
+		ExpiresIn:         int((10 * time.Minute).Seconds()),
+	})
+}
+
+func (h *AuthHandler) sendPasswordlessLogin(loginID uuid.UUID, browserToken string, client *models.Client, tenant *models.Tenant, request PasswordlessStartRequest) {
+	var user models.User
+	err := h.Handler.DB.Where("LOWER(email) = ? AND tenant_id = ? AND is_active = ?", helper.NormalizeEmail(request.Email), tenant.ID, true).First(&user).Error
+	if err != nil {
+		return
+	}
+
+	var count int64
+	h.Handler.DB.Model(&models.PasswordlessLogin{}).Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-time.Hour)).Count(&count)
+	if count >= PasswordlessHourlyLimit {
+		return
+	}
+
+	// Only the newest login of the user for the client is valid
+	h.Handler.DB.Model(&models.PasswordlessLogin{}).Where("user_id = ? AND client_id = ? AND used_at IS NULL", user.ID, client.ID).Update("used_at", time.Now())
+
+	secret := utils.GenerateToken(32)
+	if request.Method == models.PasswordlessMethodCode {
+		secret = utils.GenerateCode(PasswordlessCodeDigits)
+	}
+
+	login := models.PasswordlessLogin{
+		ID:           loginID,
+		UserID:       user.ID,
+		ClientID:     client.ID,
+		Method:       request.Method,
+		Secret:       secret,
+		BrowserToken: browserToken,
+	}
+	err = h.Handler.DB.Create(&login).Error
+	if err != nil {
+		fmt.Printf("Error creating passwordless login: %v\n", err)
+		return
+	}
+
+	if request.Method == models.PasswordlessMethodCode {
+		err = h.Handler.SendTemplate(h.Mailer, &user, user.Email, mailer.TemplateLoginCode, handler.MailData{Code: secret})
+	} else {
+		link := fmt.Sprintf("%s?token=%s", client.PasswordlessURL, url.QueryEscape(secret))
+		err = h.Handler.SendTemplate(h.Mailer, &user, user.Email, mailer.TemplateLoginLink, handler.MailData{Link: link})
+	}
+	if err != nil {
+		fmt.Printf("Error sending passwordless login: %v\n", err)
+	}
+}
+
+// PasswordlessVerify redeems the emailed link token or code in the browser that started the login
+// and creates a session like Login, including a second factor challenge if required.
+func (h *AuthHandler) PasswordlessVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PasswordlessVerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cookie, err := r.Cookie(PasswordlessCookie); err == nil && request.PasswordlessToken == "" {
+		request.PasswordlessToken = cookie.Value
+	}
+
+	invalid := "Invalid or expired sign-in"
+
+	loginID, browserToken, ok := utils.DecodeBearerToken(request.PasswordlessToken)
+	if !ok {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+	if _, err := uuid.Parse(loginID); err != nil {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	var login models.PasswordlessLogin
+	err = h.Handler.DB.Where("id = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", loginID, time.Now(), PasswordlessMaxAttempts).First(&login).Error
+	if err != nil {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := h.Handler.VerifySecret(&login, "browser_token", browserToken, login.BrowserToken)
+	if err != nil {
+		http.Error(w, invalid, handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !valid {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	// The attempt is counted before the code is compared, so parallel guesses cannot exceed the limit
+	ok, err = h.Handler.CountAttempt(&models.PasswordlessLogin{}, login.ID, PasswordlessMaxAttempts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	secret := request.Code
+	if login.Method == models.PasswordlessMethodLink {
+		secret = request.Token
+	}
+	valid, err = h.Handler.VerifySecret(&login, "secret", secret, login.Secret)
+	if err != nil {
+		http.Error(w, invalid, handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !valid {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	result := h.Handler.DB.Model(&models.PasswordlessLogin{}).Where("id = ? AND used_at IS NULL", login.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		http.Error(w, invalid, http.StatusUnauthorized)
+		return
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", login.ClientID, true).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", login.UserID, true).First(&user).Error
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", user.TenantID, true).First(&tenant).Error
+	if err != nil {
+		http.Error(w, handler.ErrTenantInactive.Error(), http.StatusForbidden)
+		return
+	}
+
+	scope, ok := loginScope(&user, &client, &tenant)
+	if !ok {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: PasswordlessCookie, Path: "/auth/passwordless", MaxAge: -1, HttpOnly: true, Secure: true})
+
+	methods := h.mfaMethods(user.ID)
+	if len(methods) > 0 || h.Handler.MFARequired(&user, &client.ID) {
+		h.writeMFAChallenge(w, models.MFAChallenge{UserID: user.ID, ClientID: client.ID, AuthMethods: []string{handler.AMREmail}}, methods)
+		return
+	}
+
+	response, err := h.issueLogin(r, &user, &client, &tenant, scope, handler.NewAuthentication(handler.AMREmail))
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
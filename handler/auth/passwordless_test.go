@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// TestPasswordlessVerify checks that a login is only redeemed by the browser that started it, with the
+// emailed code, within the attempt limit and only once
+func TestPasswordlessVerify(t *testing.T) {
+	tests := []struct {
+		name         string
+		browserToken string
+		cookie       bool
+		code         string
+		counted      bool
+		claimed      bool
+		status       int
+	}{
+		{"other browser", "other browser", false, "123456", false, false, http.StatusUnauthorized},
+		{"attempts used up concurrently", "browser", false, "123456", false, false, http.StatusUnauthorized},
+		{"wrong code", "browser", false, "654321", true, false, http.StatusUnauthorized},
+		{"used concurrently", "browser", false, "123456", true, false, http.StatusUnauthorized},
+		// The redeemed login goes on, here it stops at a deactivated client
+		{"browser token from the cookie", "browser", true, "123456", true, true, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, mock := newMockAuthHandler(t)
+			loginID, userID, clientID := uuid.New(), uuid.New(), uuid.New()
+			mock.ExpectQuery(`FROM "passwordless_logins" WHERE id = \$1 AND used_at IS NULL AND expires_at > \$2 AND attempts < \$3`).
+				WithArgs(loginID.String(), sqlmock.AnyArg(), PasswordlessMaxAttempts, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "client_id", "method", "secret", "browser_token", "attempts", "expires_at"}).
+					AddRow(loginID, userID, clientID, models.PasswordlessMethodCode, hashSecret(t, "123456"), hashSecret(t, "browser"), 1, time.Now().Add(time.Minute)))
+			if test.browserToken == "browser" {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "passwordless_logins" SET "attempts"=attempts \+ 1 WHERE id = \$1 AND attempts < \$2`).
+					WithArgs(loginID, PasswordlessMaxAttempts).WillReturnResult(sqlmock.NewResult(0, map[bool]int64{true: 1}[test.counted]))
+				mock.ExpectCommit()
+			}
+			if test.counted && test.code == "123456" {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "passwordless_logins" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+					WithArgs(sqlmock.AnyArg(), loginID).WillReturnResult(sqlmock.NewResult(0, map[bool]int64{true: 1}[test.claimed]))
+				mock.ExpectCommit()
+			}
+			if test.claimed {
+				mock.ExpectQuery(`FROM "clients"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			}
+
+			bearer := utils.EncodeBearerToken(loginID.String(), test.browserToken)
+			body := PasswordlessVerifyRequest{Code: test.code}
+			if !test.cookie {
+				body.PasswordlessToken = bearer
+			}
+			encoded, _ := json.Marshal(body)
+			request := httptest.NewRequest(http.MethodPost, "/auth/passwordless/verify", bytes.NewReader(encoded))
+			if test.cookie {
+				request.AddCookie(&http.Cookie{Name: PasswordlessCookie, Value: bearer})
+			}
+			recorder := httptest.NewRecorder()
+			h.PasswordlessVerify(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), test.status)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPasswordlessVerifyWithoutBrowserToken(t *testing.T) {
+	h, mock := newMockAuthHandler(t)
+	recorder := httptest.NewRecorder()
+	h.PasswordlessVerify(recorder, httptest.NewRequest(http.MethodPost, "/auth/passwordless/verify", bytes.NewBufferString(`{"code": "123456"}`)))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", recorder.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,8 +2,11 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/mailer"
 	"github.com/secnex/sethorize-kit/models"
 )
 
@@ -12,13 +15,17 @@ type RegisterRequest struct {
 	LastName  string `json:"last_name"`
 	Email     string `json:"email"`
 	Password  string `json:"password"`
+	ClientID  string `json:"client_id"`
+	Locale    string `json:"locale"`
+	Tenant    string `json:"tenant"`
 }
 
 type RegisterResponse struct {
-	ID        string `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
+	ID         string `json:"id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email"`
+	IsVerified bool   `json:"is_verified"`
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -29,16 +36,61 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.ClientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", request.ClientID, true).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	// Users are registered in the resolved tenant, by default the tenant of the client
+	tenant, err := h.Handler.ResolveTenant(&client, request.Tenant, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return
+	}
+
 	user := models.User{
 		FirstName: request.FirstName,
 		LastName:  request.LastName,
 		Email:     request.Email,
 		Password:  request.Password,
+		TenantID:  tenant.ID,
+		Locale:    request.Locale,
+	}
+	if user.Locale == "" {
+		user.Locale = mailer.DefaultLocale
+	}
+
+	err = h.Handler.ValidatePassword(&user, request.Password)
+	if err != nil {
+		handler.WritePasswordError(w, err)
+		return
 	}
 
-	h.Handler.DB.Create(&user)
+	err = h.Handler.DB.Create(&user).Error
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	err = h.Handler.SendEmailVerification(h.Mailer, &user, user.Email, h.VerifyEmailURL)
+	if err != nil {
+		fmt.Printf("Error sending email verification: %v\n", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(RegisterResponse{
+		ID:         user.ID.String(),
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Email:      user.Email,
+		IsVerified: user.IsVerified,
+	})
 }
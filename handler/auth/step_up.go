@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// StepUp re-prompts the signed in user for a second factor while keeping the session.
+// It returns an MFA challenge which is redeemed at MFAVerify; the new token has the same sid
+// and the amr, acr and auth_time of the stronger authentication.
+func (h *AuthHandler) StepUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := r.Context().Value("session").(models.Session)
+
+	var user models.User
+	err := h.Handler.DB.Where("id = ? AND is_active = ?", session.UserID, true).First(&user).Error
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", session.ClientID, true).First(&client).Error
+	if err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	methods := h.mfaMethods(user.ID)
+	if len(methods) == 0 {
+		handler.WriteError(w, http.StatusBadRequest, "mfa_not_enrolled", "No second factor is enrolled for the account")
+		return
+	}
+
+	h.writeMFAChallenge(w, models.MFAChallenge{UserID: user.ID, ClientID: client.ID, SessionID: &session.ID}, methods)
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt"
-	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/models"
 	"github.com/secnex/sethorize-kit/utils"
 )
@@ -66,10 +66,9 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 		return
 	}
 
-	argon2 := helper.NewArgon2Default()
-	authCodeValid, err := argon2.Compare(authCodeToken, authCode.Code)
+	authCodeValid, err := h.Handler.VerifySecret(&authCode, "code", authCodeToken, authCode.Code)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
@@ -83,9 +82,9 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 		return
 	}
 
-	authCode.UsedAt = time.Now()
-	err = h.Handler.DB.Save(&authCode).Error
-	if err != nil {
+	// Claims the code, so that concurrent requests with the same code cannot both get tokens
+	result := h.Handler.DB.Model(&models.AuthCode{}).Where("id = ? AND used_at IS NULL", authCode.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -98,7 +97,7 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 	}
 
 	var tenant models.Tenant
-	err = h.Handler.DB.Where("id = ?", user.TenantID).First(&tenant).Error
+	err = h.Handler.DB.Where("id = ? AND is_active = ? AND deleted_at IS NULL", user.TenantID, true).First(&tenant).Error
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -111,9 +110,9 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 		return
 	}
 
-	clientSecretValid, err := argon2.Compare(request.ClientSecret, client.Secret)
+	clientSecretValid, err := h.Handler.VerifyClientSecret(&client, request.ClientSecret)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
@@ -122,11 +121,23 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 		return
 	}
 
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantAuthorizationCode) {
+		return
+	}
+
 	session := models.Session{
 		UserID:   authCode.UserID,
 		ClientID: authCode.ClientID,
 	}
 
+	// The new session keeps how the user authenticated for the authorization
+	if authCode.SessionID != nil {
+		var authorizingSession models.Session
+		if h.Handler.DB.Where("id = ?", *authCode.SessionID).First(&authorizingSession).Error == nil {
+			session.Authentication = authorizingSession.Authentication
+		}
+	}
+
 	var createdSession models.Session
 	err = h.Handler.DB.Create(&session).Scan(&createdSession).Error
 	if err != nil {
@@ -137,44 +148,25 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 	refreshTokenValue := utils.GenerateToken(32)
 
 	refreshToken := models.RefreshToken{
-		UserID:   session.UserID,
-		ClientID: session.ClientID,
-		Token:    refreshTokenValue,
+		UserID:    session.UserID,
+		ClientID:  session.ClientID,
+		SessionID: &createdSession.ID,
+		Token:     refreshTokenValue,
+		ExpiresAt: time.Now().Add(h.Handler.Settings(tenant.ID, &client.ID).RefreshTokenLifetime),
 	}
 
 	var createdRefreshToken models.RefreshToken
 	err = h.Handler.DB.Create(&refreshToken).Scan(&createdRefreshToken).Error
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
-	bearerRefreshToken := base64.StdEncoding.EncodeToString([]byte(createdRefreshToken.ID.String() + ":" + createdRefreshToken.Token))
-
-	exp := time.Now().Add(time.Minute * 60).Unix()
-
-	jwt := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"sub": session.UserID,
-		"aud": session.ClientID.String(),
-		"iss": "sethorize-idp-api",
-		"iat": time.Now().Unix(),
-		"exp": exp,
-		"sid": createdSession.ID.String(),
-		"user": map[string]interface{}{
-			"first_name":   user.FirstName,
-			"last_name":    user.LastName,
-			"display_name": user.DisplayName,
-			"email":        user.Email,
-			"id":           user.ID,
-			"tenant_id":    user.TenantID,
-			"tenant_name":  tenant.Name,
-			"is_admin":     user.IsAdmin,
-		},
-	})
+	bearerRefreshToken := base64.StdEncoding.EncodeToString([]byte(createdRefreshToken.ID.String() + ":" + refreshTokenValue))
 
-	tokenString, err := jwt.SignedString(h.KeyManager.GetPrivateKey())
+	tokenString, exp, err := h.signUserToken(&createdSession, &user, &tenant, "")
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
@@ -183,12 +175,11 @@ func (h *AuthHandler) AuthorizationCodeFlow(w http.ResponseWriter, request Token
 	json.NewEncoder(w).Encode(TokenResponse{
 		AccessToken:  tokenString,
 		RefreshToken: bearerRefreshToken,
-		ExpiresIn:    3600,
+		ExpiresIn:    int(exp - time.Now().Unix()),
 	})
 }
 
 func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenRequest) {
-	argon2 := helper.NewArgon2Default()
 	bearerToken, err := base64.StdEncoding.DecodeString(*request.RefreshToken)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -211,9 +202,20 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 		return
 	}
 
-	refreshToken.UsedAt = time.Now()
-	err = h.Handler.DB.Save(&refreshToken).Error
+	refreshTokenValid, err := h.Handler.VerifySecret(&refreshToken, "token", refreshTokenValue, refreshToken.Token)
 	if err != nil {
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+
+	if !refreshTokenValid {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Claims the refresh token, so that concurrent requests with the same token cannot both rotate it
+	result := h.Handler.DB.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", refreshToken.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -239,9 +241,9 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 		return
 	}
 
-	clientSecretValid, err := argon2.Compare(request.ClientSecret, client.Secret)
+	clientSecretValid, err := h.Handler.VerifyClientSecret(&client, request.ClientSecret)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
@@ -250,14 +252,7 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 		return
 	}
 
-	refreshTokenValid, err := argon2.Compare(refreshTokenValue, refreshToken.Token)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	if !refreshTokenValid {
-		w.WriteHeader(http.StatusUnauthorized)
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantRefreshToken) {
 		return
 	}
 
@@ -266,6 +261,13 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 		ClientID: refreshToken.ClientID,
 	}
 
+	if refreshToken.SessionID != nil {
+		var previousSession models.Session
+		if h.Handler.DB.Where("id = ?", *refreshToken.SessionID).First(&previousSession).Error == nil {
+			session.Authentication = previousSession.Authentication
+		}
+	}
+
 	var createdSession models.Session
 	err = h.Handler.DB.Create(&session).Scan(&createdSession).Error
 	if err != nil {
@@ -276,44 +278,25 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 	newRefreshTokenValue := utils.GenerateToken(32)
 
 	newRefreshToken := models.RefreshToken{
-		UserID:   session.UserID,
-		ClientID: session.ClientID,
-		Token:    newRefreshTokenValue,
+		UserID:    session.UserID,
+		ClientID:  session.ClientID,
+		SessionID: &createdSession.ID,
+		Token:     newRefreshTokenValue,
+		ExpiresAt: time.Now().Add(h.Handler.Settings(tenant.ID, &client.ID).RefreshTokenLifetime),
 	}
 
 	var createdRefreshToken models.RefreshToken
 	err = h.Handler.DB.Create(&newRefreshToken).Scan(&createdRefreshToken).Error
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
-	bearerRefreshToken := base64.StdEncoding.EncodeToString([]byte(createdRefreshToken.ID.String() + ":" + createdRefreshToken.Token))
-
-	exp := time.Now().Add(time.Minute * 60).Unix()
-
-	jwt := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": session.UserID,
-		"aud": session.ClientID.String(),
-		"iss": "sethorize-idp-api",
-		"iat": time.Now().Unix(),
-		"exp": exp,
-		"sid": createdSession.ID.String(),
-		"user": map[string]interface{}{
-			"first_name":   user.FirstName,
-			"last_name":    user.LastName,
-			"display_name": user.DisplayName,
-			"email":        user.Email,
-			"id":           user.ID,
-			"tenant_id":    user.TenantID,
-			"tenant_name":  tenant.Name,
-			"is_admin":     user.IsAdmin,
-		},
-	})
+	bearerRefreshToken := base64.StdEncoding.EncodeToString([]byte(createdRefreshToken.ID.String() + ":" + newRefreshTokenValue))
 
-	tokenString, err := jwt.SignedString(h.KeyManager.GetPrivateKey())
+	tokenString, exp, err := h.signUserToken(&createdSession, &user, &tenant, "")
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
 		return
 	}
 
@@ -331,8 +314,6 @@ func (h *AuthHandler) RefreshTokenFlow(w http.ResponseWriter, request TokenReque
 }
 
 func (h *AuthHandler) ClientCredentialsFlow(w http.ResponseWriter, request TokenRequest) {
-	argon2 := helper.NewArgon2Default()
-
 	var client models.Client
 	err := h.Handler.DB.Where("id = ? AND is_active = ? AND deleted_at IS NULL", request.ClientID, true).First(&client).Error
 	if err != nil {
@@ -340,17 +321,39 @@ func (h *AuthHandler) ClientCredentialsFlow(w http.ResponseWriter, request Token
 		return
 	}
 
-	clientSecretValid, err := argon2.Compare(request.ClientSecret, client.Secret)
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ? AND is_active = ? AND deleted_at IS NULL", client.TenantID, true).First(&tenant).Error
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	clientSecretValid, err := h.Handler.VerifyClientSecret(&client, request.ClientSecret)
+	if err != nil {
+		w.WriteHeader(handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+
 	if !clientSecretValid {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantClientCredentials) {
+		return
+	}
+
+	decision, err := h.Handler.AuthorizePolicies(handler.PolicyInput{
+		Point:    handler.PolicyPointToken,
+		TenantID: tenant.ID,
+		Client:   &client,
+		Scopes:   client.Scopes,
+	})
+	if err != nil {
+		w.WriteHeader(handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
 	session := models.Session{
 		ClientID: client.ID,
 	}
@@ -362,20 +365,32 @@ func (h *AuthHandler) ClientCredentialsFlow(w http.ResponseWriter, request Token
 		return
 	}
 
-	exp := time.Now().Add(time.Minute * 60).Unix()
+	settings := h.Handler.Settings(tenant.ID, &client.ID)
+	exp := time.Now().Add(settings.AccessTokenLifetime).Unix()
 
-	jwt := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"sub":   session.ClientID,
 		"aud":   session.ClientID.String(),
-		"iss":   "sethorize-idp-api",
+		"iss":   settings.Issuer,
 		"iat":   time.Now().Unix(),
 		"exp":   exp,
 		"sid":   createdSession.ID.String(),
 		"type":  "client_credentials",
 		"scope": client.Scopes,
-	})
+	}
+	mapped, err := h.mappedClaims(handler.ClaimInput{Tenant: &tenant, Client: &client, Scopes: client.Scopes})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for name, value := range mapped {
+		claims[name] = value
+	}
+	for name, value := range decision.Claims {
+		claims[name] = value
+	}
 
-	tokenString, err := jwt.SignedString(h.KeyManager.GetPrivateKey())
+	tokenString, err := h.signToken(tenant.ID, claims)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -423,11 +438,11 @@ func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch request.GrantType {
-	case "authorization_code":
+	case handler.GrantAuthorizationCode:
 		h.AuthorizationCodeFlow(w, request)
-	case "refresh_token":
+	case handler.GrantRefreshToken:
 		h.RefreshTokenFlow(w, request)
-	case "client_credentials":
+	case handler.GrantClientCredentials:
 		h.ClientCredentialsFlow(w, request)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// TestTokenFlowsClaimOnce checks that a code or refresh token is claimed with a conditional update and that a
+// request which loses the claim to a concurrent one gets no tokens
+func TestTokenFlowsClaimOnce(t *testing.T) {
+	flows := []struct {
+		name  string
+		table string
+		claim string
+		args  []driver.Value
+		flow  func(h *AuthHandler, w http.ResponseWriter, bearer string)
+	}{
+		{
+			name:  "authorization code",
+			table: "auth_codes",
+			claim: `UPDATE "auth_codes" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL$`,
+			args:  []driver.Value{sqlmock.AnyArg()},
+			flow: func(h *AuthHandler, w http.ResponseWriter, bearer string) {
+				h.AuthorizationCodeFlow(w, TokenRequest{GrantType: "authorization_code", Code: &bearer})
+			},
+		},
+		{
+			name:  "refresh token",
+			table: "refresh_tokens",
+			claim: `UPDATE "refresh_tokens" SET "used_at"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND used_at IS NULL AND revoked_at IS NULL\) AND "refresh_tokens"."deleted_at" IS NULL$`,
+			args:  []driver.Value{sqlmock.AnyArg(), sqlmock.AnyArg()},
+			flow: func(h *AuthHandler, w http.ResponseWriter, bearer string) {
+				h.RefreshTokenFlow(w, TokenRequest{GrantType: "refresh_token", RefreshToken: &bearer})
+			},
+		},
+	}
+	for _, flow := range flows {
+		for _, claimed := range []bool{true, false} {
+			name := flow.name + " claimed"
+			if !claimed {
+				name = flow.name + " used concurrently"
+			}
+			t.Run(name, func(t *testing.T) {
+				h, mock := newMockAuthHandler(t)
+				id, userID := uuid.New(), uuid.New()
+				hash := hashSecret(t, "secret")
+				mock.ExpectQuery(`FROM "` + flow.table + `" WHERE \(?id = \$1 AND .*used_at IS NULL`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "client_id", "code", "token", "expires_at"}).
+						AddRow(id, userID, uuid.New(), hash, hash, time.Now().Add(time.Minute)))
+				mock.ExpectBegin()
+				mock.ExpectExec(flow.claim).WithArgs(append(flow.args, id)...).WillReturnResult(sqlmock.NewResult(0, map[bool]int64{true: 1}[claimed]))
+				mock.ExpectCommit()
+				// Only the request that claimed the code goes on, here it stops at a deactivated user
+				if claimed {
+					mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				}
+
+				recorder := httptest.NewRecorder()
+				flow.flow(h, recorder, utils.EncodeBearerToken(id.String(), "secret"))
+				if recorder.Code != http.StatusUnauthorized {
+					t.Errorf("status = %d, want 401", recorder.Code)
+				}
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
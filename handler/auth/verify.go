@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type VerifyEmailResponse struct {
+	Message string `json:"message"`
+	Email   string `json:"email"`
+}
+
+type ResendVerificationRequest struct {
+	Email    string `json:"email"`
+	ClientID string `json:"client_id"`
+	Tenant   string `json:"tenant"`
+}
+
+// VerifyEmail confirms the email address of a user with the token from the verification email
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request VerifyEmailRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	verificationID, tokenValue, ok := utils.DecodeBearerToken(request.Token)
+	if !ok {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if _, err := uuid.Parse(verificationID); err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	var verification models.EmailVerification
+	err = h.Handler.DB.Where("id = ? AND used_at IS NULL AND expires_at > ?", verificationID, time.Now()).First(&verification).Error
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := h.Handler.VerifySecret(&verification, "token", tokenValue, verification.Token)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Handler.ConfirmEmailVerification(&verification)
+	if errors.Is(err, handler.ErrVerificationUsed) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(VerifyEmailResponse{Message: "Email address verified", Email: user.Email})
+}
+
+// ResendVerification sends a new verification email to an unverified user.
+// Like the password reset it does not reveal whether the email is registered.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request ResendVerificationRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Email == "" || request.ClientID == "" {
+		http.Error(w, "Email and client ID are required", http.StatusBadRequest)
+		return
+	}
+
+	host := r.Host
+	go func() {
+		var client models.Client
+		err := h.Handler.DB.Where("id = ? AND is_active = ?", request.ClientID, true).First(&client).Error
+		if err != nil {
+			return
+		}
+
+		tenant, err := h.Handler.ResolveTenant(&client, request.Tenant, host)
+		if err != nil {
+			return
+		}
+
+		var user models.User
+		err = h.Handler.DB.Where("LOWER(email) = ? AND tenant_id = ? AND is_active = ? AND is_verified = ?", helper.NormalizeEmail(request.Email), tenant.ID, true, false).First(&user).Error
+		if err != nil {
+			return
+		}
+
+		err = h.Handler.SendEmailVerification(h.Mailer, &user, user.Email, h.VerifyEmailURL)
+		if err != nil {
+			fmt.Printf("Error sending email verification: %v\n", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PasswordResetResponse{
+		Message: "If the email address is registered and not verified yet, a verification link has been sent",
+	})
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+type WebAuthnLoginBeginRequest struct {
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Tenant   string `json:"tenant"`
+}
+
+type WebAuthnLoginFinishRequest struct {
+	Credential handler.WebAuthnAssertion `json:"credential"`
+}
+
+type WebAuthnOptionsResponse struct {
+	PublicKey *handler.WebAuthnRequestOptions `json:"publicKey"`
+}
+
+// WebAuthnLoginBegin starts a passwordless login with a passkey. With a username only its credentials are allowed,
+// otherwise the browser offers its discoverable credentials.
+func (h *AuthHandler) WebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request WebAuthnLoginBeginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, ok := h.findClient(request.ClientID)
+	if !ok {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	tenant, err := h.Handler.ResolveTenant(client, request.Tenant, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusBadRequest))
+		return
+	}
+
+	if !h.requireGrant(w, tenant.ID, client.ID, handler.GrantWebAuthn) {
+		return
+	}
+
+	var user models.User
+	if request.Username != "" {
+		// Unknown users get options without credentials so that accounts cannot be enumerated
+		h.Handler.DB.Where("LOWER(email) = ? AND tenant_id = ? AND is_active = ?", helper.NormalizeEmail(request.Username), tenant.ID, true).First(&user)
+	}
+	userID := &user.ID
+	if request.Username == "" {
+		userID = nil
+	}
+
+	options, err := h.Handler.BeginWebAuthnAssertion(userID, &client.ID, nil, "required")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WebAuthnOptionsResponse{PublicKey: options})
+}
+
+// WebAuthnLoginFinish verifies the passkey assertion and creates a session like Login.
+// The passkey requires user verification and therefore replaces both password and second factor.
+func (h *AuthHandler) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request WebAuthnLoginFinishRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credential, challenge, err := h.Handler.FinishWebAuthnAssertion(&request.Credential, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if challenge.ClientID == nil || challenge.MFAChallengeID != nil {
+		http.Error(w, handler.ErrWebAuthnChallenge.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var client models.Client
+	err = h.Handler.DB.Where("id = ?", *challenge.ClientID).First(&client).Error
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var user models.User
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", credential.UserID, true).First(&user).Error
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !handler.ClientAllowsTenant(&client, user.TenantID) {
+		http.Error(w, handler.ErrTenantMismatch.Error(), http.StatusForbidden)
+		return
+	}
+
+	var tenant models.Tenant
+	err = h.Handler.DB.Where("id = ? AND is_active = ?", user.TenantID, true).First(&tenant).Error
+	if err != nil {
+		http.Error(w, handler.ErrTenantInactive.Error(), http.StatusForbidden)
+		return
+	}
+
+	scope, ok := loginScope(&user, &client, &tenant)
+	if !ok {
+		http.Error(w, "Email address not verified", http.StatusForbidden)
+		return
+	}
+
+	response, err := h.issueLogin(r, &user, &client, &tenant, scope, handler.NewAuthentication(handler.AMRHardwareKey, handler.AMRPin))
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// MFAWebAuthnBegin returns the options to answer an MFA challenge with a security key
+func (h *AuthHandler) MFAWebAuthnBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request MFAEnrollRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge, user, client, _, err := h.loadMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, err.Error(), handler.StatusFor(err, http.StatusUnauthorized))
+		return
+	}
+	if !h.Handler.HasWebAuthn(user.ID) {
+		http.Error(w, "No security key registered", http.StatusBadRequest)
+		return
+	}
+
+	options, err := h.Handler.BeginWebAuthnAssertion(&user.ID, &client.ID, &challenge.ID, "discouraged")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WebAuthnOptionsResponse{PublicKey: options})
+}
+
+// verifyMFAWebAuthn checks a security key assertion that was started for the MFA challenge.
+// Failed verifications are reported as invalid so that they count as attempts.
+func (h *AuthHandler) verifyMFAWebAuthn(challenge *models.MFAChallenge, assertion *handler.WebAuthnAssertion) (bool, error) {
+	_, webAuthnChallenge, err := h.Handler.FinishWebAuthnAssertion(assertion, false)
+	if errors.Is(err, handler.ErrWebAuthnChallenge) || errors.Is(err, handler.ErrWebAuthnCredential) ||
+		errors.Is(err, handler.ErrWebAuthnVerification) || errors.Is(err, handler.ErrWebAuthnSignCount) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return webAuthnChallenge.MFAChallengeID != nil && *webAuthnChallenge.MFAChallengeID == challenge.ID, nil
+}
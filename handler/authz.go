@@ -0,0 +1,594 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/authz"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// ScopeAuthz grants clients access to the authorization API
+const ScopeAuthz = "authz"
+
+// Limits of the authorization API
+const (
+	// MaxAuthzDepth is the maximum nesting of usersets a check or expand follows
+	MaxAuthzDepth = 25
+	// MaxAuthzWrite is the maximum number of tuples in one write
+	MaxAuthzWrite = 100
+	// MaxListObjects is the maximum number of objects ListObjects returns
+	MaxListObjects = 1000
+	// MaxListObjectsScan is the maximum number of objects ListObjects checks
+	MaxListObjectsScan = 10000
+)
+
+// AuthzError is returned for invalid requests to the authorization API
+type AuthzError struct {
+	Message string
+}
+
+func (e *AuthzError) Error() string {
+	return e.Message
+}
+
+// Zookies name a revision of a tenant's tuples. They are opaque to clients.
+func encodeZookie(tenantID uuid.UUID, revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(tenantID.String() + ":" + strconv.FormatInt(revision, 10)))
+}
+
+func decodeZookie(tenantID uuid.UUID, zookie string) (int64, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(zookie)
+	if err != nil {
+		return 0, &AuthzError{Message: "invalid zookie"}
+	}
+	tenant, revision, ok := strings.Cut(string(decoded), ":")
+	if !ok || tenant != tenantID.String() {
+		return 0, &AuthzError{Message: "invalid zookie"}
+	}
+	parsed, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil || parsed < 0 {
+		return 0, &AuthzError{Message: "invalid zookie"}
+	}
+	return parsed, nil
+}
+
+// AuthzRevision returns the latest revision of the tenant's tuples
+func (h *Handler) AuthzRevision(tenantID uuid.UUID) (int64, error) {
+	var state models.AuthzRevision
+	err := h.DB.Where("tenant_id = ?", tenantID).First(&state).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	return state.Revision, err
+}
+
+// authzSnapshot resolves the consistency of a request to the revision it is evaluated at
+func (h *Handler) authzSnapshot(tenantID uuid.UUID, consistency authz.Consistency) (int64, error) {
+	latest, err := h.AuthzRevision(tenantID)
+	if err != nil {
+		return 0, err
+	}
+
+	zookie := consistency.AtExactSnapshot
+	if zookie == "" {
+		zookie = consistency.AtLeastAsFresh
+	}
+	if zookie == "" {
+		return latest, nil
+	}
+
+	revision, err := decodeZookie(tenantID, zookie)
+	if err != nil {
+		return 0, err
+	}
+	if revision > latest {
+		return 0, &AuthzError{Message: "zookie is newer than the stored tuples"}
+	}
+	if consistency.AtExactSnapshot != "" {
+		return revision, nil
+	}
+	return latest, nil
+}
+
+// AuthzNamespaceConfig returns the config of the tenant's namespace
+func (h *Handler) AuthzNamespaceConfig(tenantID uuid.UUID, name string) (*authz.NamespaceConfig, error) {
+	var namespace models.AuthzNamespace
+	err := h.DB.Where("tenant_id = ? AND name = ?", tenantID, name).First(&namespace).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, &AuthzError{Message: "unknown namespace " + name}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config authz.NamespaceConfig
+	err = json.Unmarshal(namespace.Config, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SaveAuthzNamespace validates and stores the config of a namespace. Configs apply to all snapshots.
+func (h *Handler) SaveAuthzNamespace(tenantID uuid.UUID, name string, config *authz.NamespaceConfig) (*models.AuthzNamespace, error) {
+	if !authz.NamePattern.MatchString(name) {
+		return nil, &AuthzError{Message: "invalid namespace name"}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, &AuthzError{Message: err.Error()}
+	}
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespace models.AuthzNamespace
+	err = h.DB.Where("tenant_id = ? AND name = ?", tenantID, name).First(&namespace).Error
+	if err == gorm.ErrRecordNotFound {
+		namespace = models.AuthzNamespace{TenantID: tenantID, Name: name, Config: encoded}
+		return &namespace, h.DB.Create(&namespace).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	namespace.Config = encoded
+	return &namespace, h.DB.Model(&namespace).Update("config", encoded).Error
+}
+
+// DeleteAuthzNamespace deletes a namespace that has no tuples left
+func (h *Handler) DeleteAuthzNamespace(tenantID uuid.UUID, name string) error {
+	var count int64
+	h.DB.Model(&models.RelationTuple{}).Where("tenant_id = ? AND namespace = ? AND deleted_revision IS NULL", tenantID, name).Count(&count)
+	if count > 0 {
+		return &AuthzError{Message: "the namespace still has tuples"}
+	}
+
+	result := h.DB.Where("tenant_id = ? AND name = ?", tenantID, name).Delete(&models.AuthzNamespace{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &AuthzError{Message: "unknown namespace " + name}
+	}
+	return nil
+}
+
+// AuthzWrite adds and deletes the tuples in one revision and returns the zookie of that revision
+func (h *Handler) AuthzWrite(tenantID uuid.UUID, request authz.WriteRequest) (string, error) {
+	if len(request.Writes)+len(request.Deletes) == 0 {
+		return "", &AuthzError{Message: "nothing to write"}
+	}
+	if len(request.Writes)+len(request.Deletes) > MaxAuthzWrite {
+		return "", &AuthzError{Message: fmt.Sprintf("at most %d tuples can be written at once", MaxAuthzWrite)}
+	}
+
+	graph := h.relationGraph(tenantID, 0)
+	for _, tuple := range append(request.Writes, request.Deletes...) {
+		if err := graph.validateTuple(tuple); err != nil {
+			return "", err
+		}
+	}
+
+	var revision int64
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// The row lock on the counter serializes the writes of the tenant, so that a revision is only
+		// visible once all tuples of the previous revisions are committed
+		err := tx.Exec("INSERT INTO authz_revisions (tenant_id, revision, updated_at) VALUES (?, 0, now()) ON CONFLICT DO NOTHING", tenantID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Raw("UPDATE authz_revisions SET revision = revision + 1, updated_at = now() WHERE tenant_id = ? RETURNING revision", tenantID).Scan(&revision).Error
+		if err != nil {
+			return err
+		}
+
+		for _, tuple := range request.Deletes {
+			err := liveTuple(tx.Model(&models.RelationTuple{}), tenantID, tuple).Update("deleted_revision", revision).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, tuple := range request.Writes {
+			var count int64
+			if err := liveTuple(tx.Model(&models.RelationTuple{}), tenantID, tuple).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			err := tx.Create(&models.RelationTuple{
+				TenantID:         tenantID,
+				Namespace:        tuple.Namespace,
+				ObjectID:         tuple.ObjectID,
+				Relation:         tuple.Relation,
+				SubjectNamespace: tuple.Subject.Namespace,
+				SubjectObjectID:  tuple.Subject.ObjectID,
+				SubjectRelation:  tuple.Subject.Relation,
+				CreatedRevision:  revision,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return encodeZookie(tenantID, revision), nil
+}
+
+func liveTuple(query *gorm.DB, tenantID uuid.UUID, tuple authz.Tuple) *gorm.DB {
+	return query.Where("tenant_id = ? AND namespace = ? AND object_id = ? AND relation = ? AND subject_namespace = ? AND subject_object_id = ? AND subject_relation = ? AND deleted_revision IS NULL",
+		tenantID, tuple.Namespace, tuple.ObjectID, tuple.Relation, tuple.Subject.Namespace, tuple.Subject.ObjectID, tuple.Subject.Relation)
+}
+
+// AuthzCheck reports whether the subject has the relation to the object
+func (h *Handler) AuthzCheck(tenantID uuid.UUID, request authz.CheckRequest) (*authz.CheckResponse, error) {
+	if err := request.Subject.Validate(); err != nil {
+		return nil, &AuthzError{Message: err.Error()}
+	}
+	revision, err := h.authzSnapshot(tenantID, request.Consistency)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := h.relationGraph(tenantID, revision).check(request.Namespace, request.ObjectID, request.Relation, request.Subject, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &authz.CheckResponse{Allowed: allowed, Zookie: encodeZookie(tenantID, revision)}, nil
+}
+
+// AuthzExpand returns the userset tree of the relation of the object
+func (h *Handler) AuthzExpand(tenantID uuid.UUID, request authz.ExpandRequest) (*authz.ExpandResponse, error) {
+	revision, err := h.authzSnapshot(tenantID, request.Consistency)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := h.relationGraph(tenantID, revision).expand(request.Namespace, request.ObjectID, request.Relation, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &authz.ExpandResponse{Tree: tree, Zookie: encodeZookie(tenantID, revision)}, nil
+}
+
+// AuthzListObjects returns the objects of the namespace to which the subject has the relation. The first
+// MaxListObjectsScan objects with tuples in the namespace are checked, at most MaxListObjects objects are
+// returned. The results of the checks are shared, so usersets common to many objects are evaluated once.
+func (h *Handler) AuthzListObjects(tenantID uuid.UUID, request authz.ListObjectsRequest) (*authz.ListObjectsResponse, error) {
+	if err := request.Subject.Validate(); err != nil {
+		return nil, &AuthzError{Message: err.Error()}
+	}
+	revision, err := h.authzSnapshot(tenantID, request.Consistency)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := h.relationGraph(tenantID, revision)
+	if _, err := graph.userset(request.Namespace, request.Relation); err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	err = graph.visible(h.DB.Model(&models.RelationTuple{})).Where("namespace = ?", request.Namespace).
+		Distinct("object_id").Order("object_id").Limit(MaxListObjectsScan+1).Pluck("object_id", &candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	response := &authz.ListObjectsResponse{ObjectIDs: []string{}, Zookie: encodeZookie(tenantID, revision)}
+	if len(candidates) > MaxListObjectsScan {
+		candidates = candidates[:MaxListObjectsScan]
+		response.Truncated = true
+	}
+	for i, objectID := range candidates {
+		allowed, err := graph.check(request.Namespace, objectID, request.Relation, request.Subject, 0)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			response.ObjectIDs = append(response.ObjectIDs, objectID)
+			if len(response.ObjectIDs) == MaxListObjects {
+				response.Truncated = response.Truncated || i < len(candidates)-1
+				break
+			}
+		}
+	}
+	return response, nil
+}
+
+// relationGraph evaluates the tuples of a tenant at one revision with the current namespace configs. A graph
+// serves one request and remembers the results of its checks.
+type relationGraph struct {
+	db         *gorm.DB
+	handler    *Handler
+	tenantID   uuid.UUID
+	revision   int64
+	namespaces map[string]*authz.NamespaceConfig
+	checks     map[checkKey]bool
+	// visiting are the checks in progress. Reaching one of them again follows a cycle of usersets, e.g. two
+	// groups that are members of each other, which adds no further subjects.
+	visiting map[checkKey]bool
+	// cycles counts the cycles cut so far. Results that depend on a cut cycle are not remembered, as they
+	// can miss subjects for checks outside of the cycle.
+	cycles int
+}
+
+// checkKey names a check of a subject against the relation of an object
+type checkKey struct {
+	namespace string
+	objectID  string
+	relation  string
+	subject   authz.Subject
+}
+
+func (h *Handler) relationGraph(tenantID uuid.UUID, revision int64) *relationGraph {
+	return &relationGraph{db: h.DB, handler: h, tenantID: tenantID, revision: revision, namespaces: map[string]*authz.NamespaceConfig{}, checks: map[checkKey]bool{}, visiting: map[checkKey]bool{}}
+}
+
+func (g *relationGraph) config(namespace string) (*authz.NamespaceConfig, error) {
+	if config, ok := g.namespaces[namespace]; ok {
+		return config, nil
+	}
+	config, err := g.handler.AuthzNamespaceConfig(g.tenantID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	g.namespaces[namespace] = config
+	return config, nil
+}
+
+func (g *relationGraph) userset(namespace string, relation string) (*authz.Userset, error) {
+	config, err := g.config(namespace)
+	if err != nil {
+		return nil, err
+	}
+	userset, ok := config.Relations[relation]
+	if !ok {
+		return nil, &AuthzError{Message: "unknown relation " + namespace + "#" + relation}
+	}
+	return userset, nil
+}
+
+// hasRelation reports whether the namespace exists and defines the relation
+func (g *relationGraph) hasRelation(namespace string, relation string) bool {
+	_, err := g.userset(namespace, relation)
+	return err == nil
+}
+
+// validateTuple checks that the relation of the tuple is stored and that a userset subject exists
+func (g *relationGraph) validateTuple(tuple authz.Tuple) error {
+	if err := tuple.Validate(); err != nil {
+		return &AuthzError{Message: err.Error()}
+	}
+	config, err := g.config(tuple.Namespace)
+	if err != nil {
+		return err
+	}
+	if !config.Direct(tuple.Relation) {
+		return &AuthzError{Message: "relation " + tuple.Namespace + "#" + tuple.Relation + " is not stored"}
+	}
+	if tuple.Subject.Relation != "" {
+		if _, err := g.userset(tuple.Subject.Namespace, tuple.Subject.Relation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visible limits the query to the tuples of the tenant that exist at the revision
+func (g *relationGraph) visible(query *gorm.DB) *gorm.DB {
+	return query.Where("tenant_id = ? AND created_revision <= ? AND (deleted_revision IS NULL OR deleted_revision > ?)", g.tenantID, g.revision, g.revision)
+}
+
+func (g *relationGraph) tuples(namespace string, objectID string, relation string) ([]models.RelationTuple, error) {
+	var tuples []models.RelationTuple
+	err := g.visible(g.db).Where("namespace = ? AND object_id = ? AND relation = ?", namespace, objectID, relation).
+		Order("subject_namespace, subject_object_id, subject_relation").Find(&tuples).Error
+	return tuples, err
+}
+
+func tupleSubject(tuple *models.RelationTuple) authz.Subject {
+	return authz.Subject{Namespace: tuple.SubjectNamespace, ObjectID: tuple.SubjectObjectID, Relation: tuple.SubjectRelation}
+}
+
+var errAuthzDepth = &AuthzError{Message: "the usersets are nested too deeply"}
+
+func (g *relationGraph) check(namespace string, objectID string, relation string, subject authz.Subject, depth int) (bool, error) {
+	if depth > MaxAuthzDepth {
+		return false, errAuthzDepth
+	}
+	if subject.Namespace == namespace && subject.ObjectID == objectID && subject.Relation == relation {
+		return true, nil
+	}
+	key := checkKey{namespace: namespace, objectID: objectID, relation: relation, subject: subject}
+	if allowed, ok := g.checks[key]; ok {
+		return allowed, nil
+	}
+	if g.visiting[key] {
+		g.cycles++
+		return false, nil
+	}
+
+	userset, err := g.userset(namespace, relation)
+	if err != nil {
+		return false, err
+	}
+	g.visiting[key] = true
+	cycles := g.cycles
+	allowed, err := g.checkUserset(userset, namespace, objectID, relation, subject, depth)
+	delete(g.visiting, key)
+	if err != nil {
+		return false, err
+	}
+	if g.cycles == cycles {
+		g.checks[key] = allowed
+	}
+	return allowed, nil
+}
+
+func (g *relationGraph) checkUserset(userset *authz.Userset, namespace string, objectID string, relation string, subject authz.Subject, depth int) (bool, error) {
+	switch {
+	case userset == nil || userset.This != nil:
+		tuples, err := g.tuples(namespace, objectID, relation)
+		if err != nil {
+			return false, err
+		}
+		for i := range tuples {
+			if tupleSubject(&tuples[i]) == subject {
+				return true, nil
+			}
+		}
+		for i := range tuples {
+			if tuples[i].SubjectRelation == "" {
+				continue
+			}
+			allowed, err := g.check(tuples[i].SubjectNamespace, tuples[i].SubjectObjectID, tuples[i].SubjectRelation, subject, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+		return false, nil
+
+	case userset.ComputedUserset != "":
+		return g.check(namespace, objectID, userset.ComputedUserset, subject, depth+1)
+
+	case userset.TupleToUserset != nil:
+		tuples, err := g.tuples(namespace, objectID, userset.TupleToUserset.Tupleset)
+		if err != nil {
+			return false, err
+		}
+		for i := range tuples {
+			// Objects whose namespace does not define the relation are skipped
+			if !g.hasRelation(tuples[i].SubjectNamespace, userset.TupleToUserset.ComputedUserset) {
+				continue
+			}
+			allowed, err := g.check(tuples[i].SubjectNamespace, tuples[i].SubjectObjectID, userset.TupleToUserset.ComputedUserset, subject, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+		return false, nil
+
+	case userset.Union != nil:
+		for _, child := range userset.Union {
+			allowed, err := g.checkUserset(child, namespace, objectID, relation, subject, depth+1)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+		return false, nil
+
+	case userset.Intersection != nil:
+		for _, child := range userset.Intersection {
+			allowed, err := g.checkUserset(child, namespace, objectID, relation, subject, depth+1)
+			if err != nil || !allowed {
+				return false, err
+			}
+		}
+		return len(userset.Intersection) > 0, nil
+
+	case userset.Exclusion != nil:
+		allowed, err := g.checkUserset(userset.Exclusion.Base, namespace, objectID, relation, subject, depth+1)
+		if err != nil || !allowed {
+			return false, err
+		}
+		excluded, err := g.checkUserset(userset.Exclusion.Subtract, namespace, objectID, relation, subject, depth+1)
+		return !excluded, err
+	}
+	return false, errors.New("empty userset")
+}
+
+func (g *relationGraph) expand(namespace string, objectID string, relation string, depth int) (*authz.ExpandNode, error) {
+	if depth > MaxAuthzDepth {
+		return nil, errAuthzDepth
+	}
+	// A relation that is already being expanded further up the tree adds no subjects, e.g. a folder that
+	// is its own ancestor
+	key := checkKey{namespace: namespace, objectID: objectID, relation: relation}
+	if g.visiting[key] {
+		return &authz.ExpandNode{Operation: authz.OperationUnion, Namespace: namespace, ObjectID: objectID, Relation: relation}, nil
+	}
+
+	userset, err := g.userset(namespace, relation)
+	if err != nil {
+		return nil, err
+	}
+	g.visiting[key] = true
+	defer delete(g.visiting, key)
+	return g.expandUserset(userset, namespace, objectID, relation, depth)
+}
+
+func (g *relationGraph) expandUserset(userset *authz.Userset, namespace string, objectID string, relation string, depth int) (*authz.ExpandNode, error) {
+	node := &authz.ExpandNode{Namespace: namespace, ObjectID: objectID, Relation: relation}
+
+	switch {
+	case userset == nil || userset.This != nil:
+		tuples, err := g.tuples(namespace, objectID, relation)
+		if err != nil {
+			return nil, err
+		}
+		node.Operation = authz.OperationLeaf
+		node.Subjects = []authz.Subject{}
+		for i := range tuples {
+			node.Subjects = append(node.Subjects, tupleSubject(&tuples[i]))
+		}
+		return node, nil
+
+	case userset.ComputedUserset != "":
+		return g.expand(namespace, objectID, userset.ComputedUserset, depth+1)
+
+	case userset.TupleToUserset != nil:
+		tuples, err := g.tuples(namespace, objectID, userset.TupleToUserset.Tupleset)
+		if err != nil {
+			return nil, err
+		}
+		node.Operation = authz.OperationUnion
+		for i := range tuples {
+			if !g.hasRelation(tuples[i].SubjectNamespace, userset.TupleToUserset.ComputedUserset) {
+				continue
+			}
+			child, err := g.expand(tuples[i].SubjectNamespace, tuples[i].SubjectObjectID, userset.TupleToUserset.ComputedUserset, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+
+	case userset.Exclusion != nil:
+		node.Operation = authz.OperationExclusion
+		for _, child := range []*authz.Userset{userset.Exclusion.Base, userset.Exclusion.Subtract} {
+			expanded, err := g.expandUserset(child, namespace, objectID, relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, expanded)
+		}
+		return node, nil
+	}
+
+	children := userset.Union
+	node.Operation = authz.OperationUnion
+	if userset.Intersection != nil {
+		children = userset.Intersection
+		node.Operation = authz.OperationIntersection
+	}
+	for _, child := range children {
+		expanded, err := g.expandUserset(child, namespace, objectID, relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, expanded)
+	}
+	return node, nil
+}
@@ -0,0 +1,436 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/authz"
+)
+
+// testNamespaces are the namespace configs of the relation graph tests
+var testNamespaces = map[string]string{
+	"document": `{"relations": {
+		"owner": null,
+		"parent": null,
+		"banned": null,
+		"editor": {"union": [{"this": {}}, {"computed_userset": "owner"}]},
+		"viewer": {"union": [{"this": {}}, {"computed_userset": "editor"},
+			{"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}]},
+		"approver": {"intersection": [{"computed_userset": "editor"}, {"this": {}}]},
+		"commenter": {"exclusion": {"base": {"computed_userset": "editor"}, "subtract": {"computed_userset": "banned"}}}
+	}}`,
+	"folder": `{"relations": {
+		"parent": null,
+		"viewer": {"union": [{"this": {}}, {"tuple_to_userset": {"tupleset": "parent", "computed_userset": "viewer"}}]}
+	}}`,
+	"group": `{"relations": {"member": null}}`,
+}
+
+const testRevision = 7
+
+// newMockRelationGraph returns a graph of the test namespaces at testRevision whose tuple queries go to the
+// mock. The queries may come in any order.
+func newMockRelationGraph(t *testing.T) (*relationGraph, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.MatchExpectationsInOrder(false)
+
+	graph := newMockHandler(t, db).relationGraph(uuid.New(), testRevision)
+	for name, config := range testNamespaces {
+		graph.namespaces[name] = &authz.NamespaceConfig{}
+		if err := json.Unmarshal([]byte(config), graph.namespaces[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return graph, mock
+}
+
+// expectTuples answers the query for the tuples of the object relation, e.g. "document:readme#owner", with
+// the subjects
+func expectTuples(t *testing.T, mock sqlmock.Sqlmock, graph *relationGraph, relation string, subjects ...string) {
+	t.Helper()
+	object, err := authz.ParseSubject(relation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := sqlmock.NewRows([]string{"tenant_id", "namespace", "object_id", "relation", "subject_namespace", "subject_object_id", "subject_relation", "created_revision"})
+	for _, text := range subjects {
+		subject, err := authz.ParseSubject(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.AddRow(graph.tenantID, object.Namespace, object.ObjectID, object.Relation, subject.Namespace, subject.ObjectID, subject.Relation, 1)
+	}
+	mock.ExpectQuery(`FROM "relation_tuples" WHERE .*namespace = \$4 AND object_id = \$5 AND relation = \$6`).
+		WithArgs(graph.tenantID, testRevision, testRevision, object.Namespace, object.ObjectID, object.Relation).
+		WillReturnRows(rows)
+}
+
+func TestRelationGraphCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   string
+		subject string
+		// tuples are the subjects of all object relations the check reads
+		tuples map[string][]string
+		want   bool
+	}{
+		{
+			name: "direct", check: "document:readme#owner", subject: "user:1",
+			tuples: map[string][]string{"document:readme#owner": {"user:2", "user:1"}},
+			want:   true,
+		},
+		{
+			name: "direct other subject", check: "document:readme#owner", subject: "user:1",
+			tuples: map[string][]string{"document:readme#owner": {"user:2"}},
+		},
+		{
+			name: "direct through group", check: "document:readme#owner", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#owner": {"group:eng#member"},
+				"group:eng#member":      {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "direct userset subject", check: "document:readme#owner", subject: "group:eng#member",
+			tuples: map[string][]string{"document:readme#owner": {"group:eng#member"}},
+			want:   true,
+		},
+		{
+			name: "subject is the object relation", check: "group:eng#member", subject: "group:eng#member",
+			want: true,
+		},
+		{
+			name: "computed", check: "document:readme#editor", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor": {},
+				"document:readme#owner":  {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "tuple to userset", check: "document:readme#viewer", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#viewer": {},
+				"document:readme#editor": {},
+				"document:readme#owner":  {},
+				"document:readme#parent": {"folder:docs"},
+				"folder:docs#viewer":     {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "tuple to userset over nested folders", check: "folder:docs#viewer", subject: "user:1",
+			tuples: map[string][]string{
+				"folder:docs#viewer": {},
+				"folder:docs#parent": {"folder:root"},
+				"folder:root#viewer": {"group:all#member"},
+				"group:all#member":   {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "tuple to userset skips namespaces without the relation", check: "document:readme#viewer", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#viewer": {},
+				"document:readme#editor": {},
+				"document:readme#owner":  {},
+				"document:readme#parent": {"group:eng"},
+			},
+		},
+		{
+			name: "union", check: "document:readme#viewer", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#viewer": {},
+				"document:readme#editor": {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "intersection", check: "document:readme#approver", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor":   {"user:1"},
+				"document:readme#approver": {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "intersection missing first", check: "document:readme#approver", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor": {},
+				"document:readme#owner":  {},
+			},
+		},
+		{
+			name: "intersection missing second", check: "document:readme#approver", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor":   {"user:1"},
+				"document:readme#approver": {"user:2"},
+			},
+		},
+		{
+			name: "exclusion", check: "document:readme#commenter", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor": {"user:1"},
+				"document:readme#banned": {"user:2"},
+			},
+			want: true,
+		},
+		{
+			name: "exclusion subtracted", check: "document:readme#commenter", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor": {"user:1"},
+				"document:readme#banned": {"group:trolls#member"},
+				"group:trolls#member":    {"user:1"},
+			},
+		},
+		{
+			name: "exclusion not in base", check: "document:readme#commenter", subject: "user:1",
+			tuples: map[string][]string{
+				"document:readme#editor": {},
+				"document:readme#owner":  {},
+			},
+		},
+		{
+			name: "group cycle", check: "group:a#member", subject: "user:1",
+			tuples: map[string][]string{
+				"group:a#member": {"group:b#member"},
+				"group:b#member": {"group:a#member"},
+			},
+		},
+		{
+			name: "group cycle with a way out", check: "group:a#member", subject: "user:1",
+			tuples: map[string][]string{
+				"group:a#member": {"group:b#member"},
+				"group:b#member": {"group:a#member", "group:c#member"},
+				"group:c#member": {"user:1"},
+			},
+			want: true,
+		},
+		{
+			name: "folder cycle", check: "folder:a#viewer", subject: "user:1",
+			tuples: map[string][]string{
+				"folder:a#viewer": {},
+				"folder:a#parent": {"folder:b"},
+				"folder:b#viewer": {},
+				"folder:b#parent": {"folder:a"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph, mock := newMockRelationGraph(t)
+			for relation, subjects := range test.tuples {
+				expectTuples(t, mock, graph, relation, subjects...)
+			}
+			object, err := authz.ParseSubject(test.check)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := authz.ParseSubject(test.subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allowed, err := graph.check(object.Namespace, object.ObjectID, object.Relation, subject, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != test.want {
+				t.Errorf("check = %v, want %v", allowed, test.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRelationGraphCheckForgetsResultsOfCutCycles(t *testing.T) {
+	graph, mock := newMockRelationGraph(t)
+	// b is a member of a only through a, which is cut while a is checked
+	expectTuples(t, mock, graph, "group:a#member", "group:b#member", "group:c#member")
+	expectTuples(t, mock, graph, "group:b#member", "group:a#member")
+	expectTuples(t, mock, graph, "group:c#member", "user:1")
+	expectTuples(t, mock, graph, "group:b#member", "group:a#member")
+	expectTuples(t, mock, graph, "group:a#member", "group:b#member", "group:c#member")
+
+	subject := authz.Subject{Namespace: "user", ObjectID: "1"}
+	for _, group := range []string{"a", "b"} {
+		allowed, err := graph.check("group", group, "member", subject, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Errorf("user:1 is no member of group:%s", group)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelationGraphCheckDepth(t *testing.T) {
+	graph, mock := newMockRelationGraph(t)
+	for i := 0; i <= MaxAuthzDepth; i++ {
+		expectTuples(t, mock, graph, fmt.Sprintf("group:g%d#member", i), fmt.Sprintf("group:g%d#member", i+1))
+	}
+
+	_, err := graph.check("group", "g0", "member", authz.Subject{Namespace: "user", ObjectID: "1"}, 0)
+	if err != errAuthzDepth {
+		t.Fatalf("check = %v, want %v", err, errAuthzDepth)
+	}
+}
+
+func TestRelationGraphExpand(t *testing.T) {
+	graph, mock := newMockRelationGraph(t)
+	expectTuples(t, mock, graph, "folder:a#viewer", "user:1")
+	expectTuples(t, mock, graph, "folder:a#parent", "folder:b")
+	expectTuples(t, mock, graph, "folder:b#viewer", "user:2")
+	expectTuples(t, mock, graph, "folder:b#parent", "folder:a")
+
+	tree, err := graph.expand("folder", "a", "viewer", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cycle back to folder:a ends in an empty union
+	want := `{"operation":"union","namespace":"folder","object_id":"a","relation":"viewer","children":[` +
+		`{"operation":"leaf","namespace":"folder","object_id":"a","relation":"viewer","subjects":[{"namespace":"user","object_id":"1"}]},` +
+		`{"operation":"union","namespace":"folder","object_id":"a","relation":"viewer","children":[` +
+		`{"operation":"union","namespace":"folder","object_id":"b","relation":"viewer","children":[` +
+		`{"operation":"leaf","namespace":"folder","object_id":"b","relation":"viewer","subjects":[{"namespace":"user","object_id":"2"}]},` +
+		`{"operation":"union","namespace":"folder","object_id":"b","relation":"viewer","children":[` +
+		`{"operation":"union","namespace":"folder","object_id":"a","relation":"viewer"}]}]}]}]}`
+	if string(encoded) != want {
+		t.Errorf("expand = %s\nwant %s", encoded, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZookie(t *testing.T) {
+	tenantID := uuid.New()
+	revision, err := decodeZookie(tenantID, encodeZookie(tenantID, 42))
+	if err != nil || revision != 42 {
+		t.Fatalf("decodeZookie = %d, %v", revision, err)
+	}
+
+	for _, zookie := range []string{
+		encodeZookie(uuid.New(), 42),
+		"not base64!",
+		"bm8gY29sb24",
+		encodeZookie(tenantID, -1),
+		strings.TrimSuffix(encodeZookie(tenantID, 42), "Mg") + "eA",
+	} {
+		if _, err := decodeZookie(tenantID, zookie); err == nil {
+			t.Errorf("decodeZookie(%q) succeeded", zookie)
+		}
+	}
+}
+
+func TestAuthzSnapshot(t *testing.T) {
+	tenantID := uuid.New()
+	tests := []struct {
+		name        string
+		consistency authz.Consistency
+		want        int64
+		err         string
+	}{
+		{name: "latest", want: 5},
+		{name: "at least as fresh", consistency: authz.Consistency{AtLeastAsFresh: encodeZookie(tenantID, 3)}, want: 5},
+		{name: "at least as fresh as the latest", consistency: authz.Consistency{AtLeastAsFresh: encodeZookie(tenantID, 5)}, want: 5},
+		{name: "exact snapshot", consistency: authz.Consistency{AtExactSnapshot: encodeZookie(tenantID, 3)}, want: 3},
+		{name: "exact snapshot wins", consistency: authz.Consistency{AtLeastAsFresh: encodeZookie(tenantID, 4), AtExactSnapshot: encodeZookie(tenantID, 3)}, want: 3},
+		{name: "newer than stored", consistency: authz.Consistency{AtLeastAsFresh: encodeZookie(tenantID, 6)}, err: "newer than the stored tuples"},
+		{name: "other tenant", consistency: authz.Consistency{AtExactSnapshot: encodeZookie(uuid.New(), 3)}, err: "invalid zookie"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mock.ExpectQuery(`FROM "authz_revisions"`).WithArgs(tenantID, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "revision"}).AddRow(tenantID, 5))
+
+			revision, err := newMockHandler(t, db).authzSnapshot(tenantID, test.consistency)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("authzSnapshot = %d, %v, want %q", revision, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revision != test.want {
+				t.Errorf("authzSnapshot = %d, want %d", revision, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthzListObjectsScanCap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+	tenantID := uuid.New()
+
+	mock.ExpectQuery(`FROM "authz_revisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "revision"}).AddRow(tenantID, testRevision))
+	mock.ExpectQuery(`FROM "authz_namespaces"`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "config"}).AddRow("document", []byte(testNamespaces["document"])))
+	candidates := sqlmock.NewRows([]string{"object_id"})
+	for i := 0; i <= MaxListObjectsScan; i++ {
+		candidates.AddRow(fmt.Sprintf("doc%05d", i))
+	}
+	mock.ExpectQuery(`SELECT DISTINCT "object_id" FROM "relation_tuples"`).
+		WithArgs(tenantID, testRevision, testRevision, "document", MaxListObjectsScan+1).
+		WillReturnRows(candidates)
+	// Only the first MaxListObjectsScan objects are checked, the subject owns every 50th of them
+	for i := 0; i < MaxListObjectsScan; i++ {
+		rows := sqlmock.NewRows([]string{"subject_namespace", "subject_object_id", "subject_relation"})
+		if i%50 == 0 {
+			rows.AddRow("user", "1", "")
+		}
+		mock.ExpectQuery(`FROM "relation_tuples"`).WillReturnRows(rows)
+	}
+
+	response, err := h.AuthzListObjects(tenantID, authz.ListObjectsRequest{Namespace: "document", Relation: "owner", Subject: authz.Subject{Namespace: "user", ObjectID: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Truncated {
+		t.Error("response is not truncated")
+	}
+	if len(response.ObjectIDs) != MaxListObjectsScan/50 {
+		t.Fatalf("got %d objects, want %d", len(response.ObjectIDs), MaxListObjectsScan/50)
+	}
+	if last := response.ObjectIDs[len(response.ObjectIDs)-1]; response.ObjectIDs[0] != "doc00000" || last != "doc09950" {
+		t.Errorf("objects %s ... %s", response.ObjectIDs[0], last)
+	}
+	if response.Zookie != encodeZookie(tenantID, testRevision) {
+		t.Errorf("zookie %s", response.Zookie)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
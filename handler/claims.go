@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// Types of claim mappers
+const (
+	ClaimMapperStatic        = "static"
+	ClaimMapperUserAttribute = "user_attribute"
+	ClaimMapperRoles         = "roles"
+	ClaimMapperGroups        = "groups"
+	ClaimMapperPermissions   = "permissions"
+	ClaimMapperExpression    = "expression"
+)
+
+var ClaimMapperTypes = []string{ClaimMapperStatic, ClaimMapperUserAttribute, ClaimMapperRoles, ClaimMapperGroups, ClaimMapperPermissions, ClaimMapperExpression}
+
+// ClaimPathPattern matches claim names, nested with dots
+var ClaimPathPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_:-]{0,63}(\.[A-Za-z_][A-Za-z0-9_:-]{0,63}){0,3}$`)
+
+// UserAttributeNames are the user attributes available to claim mappers, custom attributes are named
+// "attributes.<name>"
+var UserAttributeNames = []string{"id", "email", "first_name", "last_name", "display_name", "locale", "is_verified", "is_admin", "tenant_id", "tenant_name", "tenant_slug"}
+
+// mappedReservedClaims are set by the server and cannot be mapped
+var mappedReservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true, "sid": true,
+	"scope": true, "amr": true, "acr": true, "auth_time": true, "type": true,
+	ClaimRoles: true, ClaimGroups: true, ClaimPermissions: true, ClaimOverage: true,
+}
+
+// ClaimMapperError is returned for invalid claim mappers
+type ClaimMapperError struct {
+	Message string
+}
+
+func (e *ClaimMapperError) Error() string {
+	return e.Message
+}
+
+// ClaimInput is the context in which claims are mapped. User is nil for client credentials tokens.
+type ClaimInput struct {
+	Tenant        *models.Tenant
+	Client        *models.Client
+	User          *models.User
+	Scopes        []string
+	AuthMethods   []string
+	ACR           string
+	Authorization *Authorization
+	// Preview evaluations may use unsaved mappers whose expressions are not cached
+	Preview bool
+}
+
+// ClaimMapperResult is the outcome of one claim mapper
+type ClaimMapperResult struct {
+	MapperID uuid.UUID `json:"mapper_id"`
+	Claim    string    `json:"claim"`
+	Applied  bool      `json:"applied"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// UserAttributes returns the attributes of the user by name
+func UserAttributes(user *models.User, tenant *models.Tenant) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           user.ID,
+		"email":        user.Email,
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"display_name": user.DisplayName,
+		"locale":       user.Locale,
+		"is_verified":  user.IsVerified,
+		"is_admin":     user.IsAdmin,
+		"tenant_id":    user.TenantID,
+		"tenant_name":  tenant.Name,
+		"tenant_slug":  tenant.Slug,
+	}
+}
+
+// DefaultUserClaim is the user claim of clients without claim mappers
+func DefaultUserClaim(user *models.User, tenant *models.Tenant) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"display_name": user.DisplayName,
+		"email":        user.Email,
+		"id":           user.ID,
+		"tenant_id":    user.TenantID,
+		"tenant_name":  tenant.Name,
+		"is_admin":     user.IsAdmin,
+	}
+}
+
+// ValidateClaimMapper checks the claim name, type and source of a mapper
+func ValidateClaimMapper(mapper *models.ClaimMapper) error {
+	if !ClaimPathPattern.MatchString(mapper.Claim) {
+		return &ClaimMapperError{Message: "invalid claim name " + mapper.Claim}
+	}
+	if top, _, _ := strings.Cut(mapper.Claim, "."); mappedReservedClaims[top] {
+		return &ClaimMapperError{Message: "claim " + top + " is reserved"}
+	}
+	if strings.ContainsAny(mapper.Scope, " \t\n") {
+		return &ClaimMapperError{Message: "scope must be a single scope"}
+	}
+
+	switch mapper.Type {
+	case ClaimMapperStatic:
+		if len(mapper.Value) == 0 || !json.Valid(mapper.Value) {
+			return &ClaimMapperError{Message: "static mappers need a JSON value"}
+		}
+	case ClaimMapperUserAttribute:
+		custom, isCustom := strings.CutPrefix(mapper.Source, "attributes.")
+		if isCustom && !AttributeNamePattern.MatchString(custom) || !isCustom && !slices.Contains(UserAttributeNames, mapper.Source) {
+			return &ClaimMapperError{Message: "unknown user attribute " + mapper.Source}
+		}
+	case ClaimMapperRoles, ClaimMapperGroups, ClaimMapperPermissions:
+	case ClaimMapperExpression:
+		if _, err := compilePolicy(mapper.Source, false); err != nil {
+			return &ClaimMapperError{Message: "expression: " + err.Error()}
+		}
+	default:
+		return &ClaimMapperError{Message: "unknown type " + mapper.Type}
+	}
+	return nil
+}
+
+// ClientClaimMappers returns the claim mappers of the client ordered by claim name
+func (h *Handler) ClientClaimMappers(clientID uuid.UUID) ([]models.ClaimMapper, error) {
+	var mappers []models.ClaimMapper
+	err := h.DB.Where("client_id = ?", clientID).Order("claim, scope").Find(&mappers).Error
+	return mappers, err
+}
+
+// MapClaims evaluates the mappers whose scope the token carries. Mappers that need a user are skipped
+// without one, and mappers that fail are left out and reported in the results.
+func (h *Handler) MapClaims(mappers []models.ClaimMapper, input ClaimInput) (map[string]interface{}, []ClaimMapperResult, error) {
+	claims := map[string]interface{}{}
+	results := []ClaimMapperResult{}
+
+	var variables map[string]interface{}
+	for _, mapper := range mappers {
+		result := ClaimMapperResult{MapperID: mapper.ID, Claim: mapper.Claim}
+		if mapper.Scope != "" && !slices.Contains(input.Scopes, mapper.Scope) {
+			results = append(results, result)
+			continue
+		}
+
+		var value interface{}
+		var err error
+		switch mapper.Type {
+		case ClaimMapperStatic:
+			err = json.Unmarshal(mapper.Value, &value)
+		case ClaimMapperUserAttribute:
+			if input.User == nil {
+				results = append(results, result)
+				continue
+			}
+			if name, ok := strings.CutPrefix(mapper.Source, "attributes."); ok {
+				// Users without a value of the custom attribute do not get the claim
+				if value, ok = DecodeAttributes(input.User)[name]; !ok {
+					results = append(results, result)
+					continue
+				}
+			} else {
+				value = UserAttributes(input.User, input.Tenant)[mapper.Source]
+			}
+		case ClaimMapperRoles, ClaimMapperGroups, ClaimMapperPermissions:
+			if input.User == nil {
+				results = append(results, result)
+				continue
+			}
+			if input.Authorization == nil {
+				authorization, err := h.UserAuthorization(input.User.ID, &input.Client.ID)
+				if err != nil {
+					return nil, nil, err
+				}
+				input.Authorization = &authorization
+			}
+			value = map[string][]string{
+				ClaimMapperRoles:       input.Authorization.Roles,
+				ClaimMapperGroups:      input.Authorization.Groups,
+				ClaimMapperPermissions: input.Authorization.Permissions,
+			}[mapper.Type]
+		case ClaimMapperExpression:
+			if variables == nil {
+				variables, err = h.policyVariables(&PolicyInput{
+					Point:       PolicyPointToken,
+					TenantID:    input.Tenant.ID,
+					User:        input.User,
+					Client:      input.Client,
+					Scopes:      input.Scopes,
+					AuthMethods: input.AuthMethods,
+					ACR:         input.ACR,
+				})
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			value, err = evaluateExpression(mapper.Source, variables, !input.Preview)
+		default:
+			err = fmt.Errorf("unknown type %s", mapper.Type)
+		}
+		if err == nil {
+			err = setClaim(claims, mapper.Claim, value)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Applied = true
+		}
+		results = append(results, result)
+	}
+	return claims, results, nil
+}
+
+// setClaim sets the claim at the dotted path, creating the enclosing objects
+func setClaim(claims map[string]interface{}, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	current := claims
+	for _, part := range parts[:len(parts)-1] {
+		next, exists := current[part]
+		if !exists {
+			nested := map[string]interface{}{}
+			current[part] = nested
+			current = nested
+			continue
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("claim %s is no object", part)
+		}
+		current = nested
+	}
+
+	last := parts[len(parts)-1]
+	if _, exists := current[last]; exists {
+		return fmt.Errorf("claim %s is mapped twice", path)
+	}
+	current[last] = value
+	return nil
+}
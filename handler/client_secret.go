@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// DefaultSecretGracePeriod is how long the previous secrets of a client stay valid after a rotation
+const DefaultSecretGracePeriod = time.Hour * 24 * 7
+
+// VerifyClientSecret checks the secret against the unexpired secrets of the client and, for clients
+// created before ClientSecret existed, against Client.Secret
+func (h *Handler) VerifyClientSecret(client *models.Client, secret string) (bool, error) {
+	if secret == "" {
+		return false, nil
+	}
+
+	var secrets []models.ClientSecret
+	err := h.DB.Where("client_id = ? AND (expires_at IS NULL OR expires_at > ?)", client.ID, time.Now()).Find(&secrets).Error
+	if err != nil {
+		return false, err
+	}
+	for i := range secrets {
+		valid, err := h.VerifySecret(&secrets[i], "secret", secret, secrets[i].Secret)
+		if err != nil {
+			return false, err
+		}
+		if valid {
+			h.DB.Model(&secrets[i]).Update("last_used_at", time.Now())
+			return true, nil
+		}
+	}
+
+	if client.Secret == "" {
+		return false, nil
+	}
+	return h.VerifySecret(client, "secret", secret, client.Secret)
+}
+
+// CreateClientSecret adds a new secret to the client and returns it in plain text. A zero expiresAt never expires.
+func (h *Handler) CreateClientSecret(tx *gorm.DB, clientID uuid.UUID, expiresAt time.Time) (string, *models.ClientSecret, error) {
+	secret := utils.GenerateToken(32)
+	clientSecret := models.ClientSecret{ClientID: clientID, Secret: secret, ExpiresAt: expiresAt}
+	err := tx.Create(&clientSecret).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, &clientSecret, nil
+}
+
+// RotateClientSecret creates a new secret and lets all other secrets of the client expire after the grace
+// period. Secrets that expire earlier keep their expiry. A legacy Client.Secret is moved into a ClientSecret.
+func (h *Handler) RotateClientSecret(client *models.Client, gracePeriod time.Duration, expiresAt time.Time) (string, *models.ClientSecret, error) {
+	var secret string
+	var clientSecret *models.ClientSecret
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		graceEnd := time.Now().Add(gracePeriod)
+
+		if client.Secret != "" {
+			// The hash is moved as is, the hook would hash it again
+			err := tx.Session(&gorm.Session{SkipHooks: true}).Create(&models.ClientSecret{
+				ClientID:  client.ID,
+				Secret:    client.Secret,
+				ExpiresAt: graceEnd,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(client).Update("secret", "").Error; err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(&models.ClientSecret{}).
+			Where("client_id = ? AND (expires_at IS NULL OR expires_at > ?)", client.ID, graceEnd).
+			Update("expires_at", graceEnd).Error
+		if err != nil {
+			return err
+		}
+
+		secret, clientSecret, err = h.CreateClientSecret(tx, client.ID, expiresAt)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, clientSecret, nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// timeAround matches time arguments within a second of the expected time
+type timeAround struct {
+	time.Time
+}
+
+func (a timeAround) Match(value driver.Value) bool {
+	t, ok := value.(time.Time)
+	return ok && t.Sub(a.Time).Abs() < time.Second
+}
+
+func TestVerifyClientSecretDuringRotation(t *testing.T) {
+	secretHasher := helper.NewSecretHasher([]byte("test pepper"))
+	helper.SetDefaultSecretHasher(secretHasher)
+	hash := func(secret string) string {
+		hash, err := secretHasher.Hash(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		legacy string
+		valid  bool
+	}{
+		{"previous secret within the grace period", "old", "", true},
+		{"new secret", "new", "", true},
+		{"unknown secret", "guess", "", false},
+		{"legacy secret of the client", "legacy", hash("legacy"), true},
+		{"empty secret", "", hash(""), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			client := &models.Client{ID: uuid.New(), Secret: test.legacy}
+			oldID, newID := uuid.New(), uuid.New()
+
+			if test.secret != "" {
+				// Expired secrets are not loaded
+				mock.ExpectQuery(`FROM "client_secrets" WHERE client_id = \$1 AND \(expires_at IS NULL OR expires_at > \$2\)`).
+					WithArgs(client.ID, timeAround{time.Now()}).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "client_id", "secret", "expires_at"}).
+						AddRow(oldID, client.ID, hash("old"), time.Now().Add(time.Hour)).
+						AddRow(newID, client.ID, hash("new"), nil))
+			}
+			if test.valid && test.legacy == "" {
+				id := map[string]uuid.UUID{"old": oldID, "new": newID}[test.secret]
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "client_secrets" SET "last_used_at"=\$1,"updated_at"=\$2 WHERE "id" = \$3`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			valid, err := h.VerifyClientSecret(client, test.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != test.valid {
+				t.Errorf("valid = %t, want %t", valid, test.valid)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRotateClientSecretSetsTheGraceWindow(t *testing.T) {
+	secretHasher := helper.NewSecretHasher([]byte("test pepper"))
+	helper.SetDefaultSecretHasher(secretHasher)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+	legacyHash, err := secretHasher.Hash("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &models.Client{ID: uuid.New(), Secret: legacyHash}
+	gracePeriod := 2 * time.Hour
+	graceEnd := timeAround{time.Now().Add(gracePeriod)}
+
+	mock.ExpectBegin()
+	// The legacy secret is moved with its hash and expires at the end of the grace period
+	mock.ExpectQuery(`INSERT INTO "client_secrets"`).WithArgs(client.ID, legacyHash, "", sqlmock.AnyArg(), sqlmock.AnyArg(), graceEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectExec(`UPDATE "clients" SET "secret"=\$1`).WithArgs("", sqlmock.AnyArg(), client.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Secrets that would stay valid longer are shortened to the grace period
+	mock.ExpectExec(`UPDATE "client_secrets" SET "expires_at"=\$1,"updated_at"=\$2 WHERE client_id = \$3 AND \(expires_at IS NULL OR expires_at > \$4\)`).
+		WithArgs(graceEnd, sqlmock.AnyArg(), client.ID, graceEnd).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`INSERT INTO "client_secrets"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	secret, clientSecret, err := h.RotateClientSecret(client, gracePeriod, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, _, err := secretHasher.Compare(secret, clientSecret.Secret); err != nil || !valid {
+		t.Errorf("the new secret does not match its stored hash: %v", err)
+	}
+	if clientSecret.Hint != secret[len(secret)-4:] || !clientSecret.ExpiresAt.IsZero() {
+		t.Errorf("new secret = %+v", clientSecret)
+	}
+	if client.Secret != "" {
+		t.Error("the legacy secret is kept on the client")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
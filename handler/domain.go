@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+var (
+	ErrDomainInvalid = errors.New("invalid hostname")
+	ErrDomainTaken   = errors.New("hostname is already in use")
+)
+
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// NormalizeHost returns the lowercase hostname of a Host header without port
+func NormalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// HostTenant returns the tenant served on host: the owner of a registered TenantDomain or the tenant
+// whose slug is the subdomain of APPLICATION_DOMAIN. The initializer registers APPLICATION_DOMAIN
+// itself as domain of the default tenant.
+func (h *Handler) HostTenant(host string) (*models.Tenant, error) {
+	hostname := NormalizeHost(host)
+	if hostname == "" {
+		return nil, ErrTenantNotFound
+	}
+
+	var tenant models.Tenant
+	var domain models.TenantDomain
+	err := h.DB.Where("hostname = ?", hostname).First(&domain).Error
+	if err == nil {
+		err = h.DB.Where("id = ?", domain.TenantID).First(&tenant).Error
+	} else if slug := TenantSlugFromHost(hostname); slug != "" {
+		err = h.DB.Where("slug = ?", slug).First(&tenant).Error
+	}
+	if err != nil {
+		return nil, ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+// IsSharedHost reports whether host is APPLICATION_DOMAIN, which is shared by all tenants that have
+// neither a custom domain nor a slug
+func IsSharedHost(host string) bool {
+	domain := os.Getenv("APPLICATION_DOMAIN")
+	return domain != "" && NormalizeHost(host) == strings.ToLower(domain)
+}
+
+// KnownHost reports whether host belongs to this deployment, i.e. it is APPLICATION_DOMAIN or routed to a tenant
+func (h *Handler) KnownHost(host string) bool {
+	if IsSharedHost(host) {
+		return true
+	}
+	_, err := h.HostTenant(host)
+	return err == nil
+}
+
+// TenantIssuer derives the issuer of the tenant from its primary domain, its slug subdomain or,
+// for tenants without both, a path below APPLICATION_DOMAIN. The issuer setting overrides it.
+func (h *Handler) TenantIssuer(tenant *models.Tenant) string {
+	var domain models.TenantDomain
+	err := h.DB.Where("tenant_id = ?", tenant.ID).Order("is_primary DESC, created_at").First(&domain).Error
+	if err == nil {
+		return "https://" + domain.Hostname
+	}
+
+	appDomain := strings.ToLower(os.Getenv("APPLICATION_DOMAIN"))
+	switch {
+	case appDomain == "":
+		return DefaultIssuer
+	case tenant.Slug != "":
+		return "https://" + tenant.Slug + "." + appDomain
+	}
+	return "https://" + appDomain + "/tenants/" + tenant.ID.String()
+}
+
+// IssuerHost returns the hostname of an issuer URL or "" if the issuer is not a URL
+func IssuerHost(issuer string) string {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	return NormalizeHost(parsed.Host)
+}
+
+// AddTenantDomain registers hostname for the tenant. The first domain of a tenant becomes its primary domain.
+func (h *Handler) AddTenantDomain(tenantID uuid.UUID, hostname string, primary bool) (*models.TenantDomain, error) {
+	hostname = NormalizeHost(hostname)
+	if !hostnamePattern.MatchString(hostname) || len(hostname) > 253 {
+		return nil, ErrDomainInvalid
+	}
+	if TenantSlugFromHost(hostname) != "" {
+		// Subdomains of APPLICATION_DOMAIN are reserved for tenant slugs
+		return nil, ErrDomainInvalid
+	}
+
+	var count int64
+	h.DB.Model(&models.TenantDomain{}).Where("hostname = ?", hostname).Count(&count)
+	if count > 0 {
+		return nil, ErrDomainTaken
+	}
+
+	var existing int64
+	h.DB.Model(&models.TenantDomain{}).Where("tenant_id = ?", tenantID).Count(&existing)
+
+	domain := models.TenantDomain{TenantID: tenantID, Hostname: hostname, IsPrimary: primary || existing == 0}
+	err := h.DB.Create(&domain).Error
+	if err != nil {
+		return nil, err
+	}
+	if domain.IsPrimary {
+		err = h.DB.Model(&models.TenantDomain{}).Where("tenant_id = ? AND id <> ?", tenantID, domain.ID).Update("is_primary", false).Error
+	}
+	return &domain, err
+}
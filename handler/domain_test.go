@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := map[string]string{
+		"Login.Acme.Example":      "login.acme.example",
+		"login.acme.example:8443": "login.acme.example",
+		"login.acme.example.":     "login.acme.example",
+		"[::1]:8080":              "::1",
+		"":                        "",
+	}
+	for host, want := range tests {
+		if got := NormalizeHost(host); got != want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestTenantIssuer(t *testing.T) {
+	tenantID := uuid.New()
+	tests := []struct {
+		name      string
+		appDomain string
+		slug      string
+		domain    string
+		issuer    string
+	}{
+		{"custom domain", "secnex.io", "acme", "login.acme.example", "https://login.acme.example"},
+		{"slug subdomain", "secnex.io", "acme", "", "https://acme.secnex.io"},
+		{"path below the application domain", "SecNex.io", "", "", "https://secnex.io/tenants/" + tenantID.String()},
+		{"without application domain", "", "acme", "", DefaultIssuer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("APPLICATION_DOMAIN", test.appDomain)
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+
+			domains := sqlmock.NewRows([]string{"id", "tenant_id", "hostname"})
+			if test.domain != "" {
+				domains.AddRow(uuid.New(), tenantID, test.domain)
+			}
+			mock.ExpectQuery(`FROM "tenant_domains" WHERE tenant_id = \$1 ORDER BY is_primary DESC, created_at`).WillReturnRows(domains)
+
+			if issuer := h.TenantIssuer(&models.Tenant{ID: tenantID, Slug: test.slug}); issuer != test.issuer {
+				t.Errorf("issuer = %s, want %s", issuer, test.issuer)
+			}
+		})
+	}
+}
+
+func TestHostTenant(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "secnex.io")
+	tenantID := uuid.New()
+	tests := []struct {
+		host   string
+		expect func(mock sqlmock.Sqlmock)
+		found  bool
+	}{
+		{"Login.Acme.Example:443", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`FROM "tenant_domains" WHERE hostname = \$1`).WithArgs("login.acme.example", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "hostname"}).AddRow(uuid.New(), tenantID, "login.acme.example"))
+			mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(tenantID, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+		}, true},
+		{"acme.secnex.io", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`FROM "tenants" WHERE slug = \$1`).WithArgs("acme", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+		}, true},
+		// Only direct subdomains are slugs
+		{"login.acme.secnex.io", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, false},
+		{"unknown.example", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, false},
+		{"", func(mock sqlmock.Sqlmock) {}, false},
+	}
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newMockHandler(t, db)
+		test.expect(mock)
+
+		tenant, err := h.HostTenant(test.host)
+		if test.found && (err != nil || tenant.ID != tenantID) {
+			t.Errorf("HostTenant(%q) = %v, %v", test.host, tenant, err)
+		}
+		if !test.found && !errors.Is(err, ErrTenantNotFound) {
+			t.Errorf("HostTenant(%q) = %v, want ErrTenantNotFound", test.host, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", test.host, err)
+		}
+		db.Close()
+	}
+}
+
+func TestAddTenantDomainRejections(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "secnex.io")
+	tests := []struct {
+		hostname string
+		taken    bool
+		err      error
+	}{
+		{"localhost", false, ErrDomainInvalid},
+		{"login_acme.example", false, ErrDomainInvalid},
+		{"https://login.acme.example", false, ErrDomainInvalid},
+		// Subdomains of APPLICATION_DOMAIN are the slugs of the tenants
+		{"other.secnex.io", false, ErrDomainInvalid},
+		{"Login.Acme.Example", true, ErrDomainTaken},
+	}
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newMockHandler(t, db)
+		if test.taken {
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "tenant_domains" WHERE hostname = \$1`).WithArgs("login.acme.example").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		}
+
+		if _, err := h.AddTenantDomain(uuid.New(), test.hostname, false); !errors.Is(err, test.err) {
+			t.Errorf("AddTenantDomain(%q) = %v, want %v", test.hostname, err, test.err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", test.hostname, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+type ErrorResponse struct {
+	Error      string                     `json:"error"`
+	Message    string                     `json:"message,omitempty"`
+	Violations []helper.PasswordViolation `json:"violations,omitempty"`
+}
+
+// StatusFor maps well known errors to a HTTP status code and returns fallback for all others
+func StatusFor(err error, fallback int) int {
+	if errors.Is(err, helper.ErrHasherBusy) || errors.Is(err, helper.ErrBreachedPasswordsUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrVerificationThrottled) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, ErrTenantNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrTenantMismatch) || errors.Is(err, ErrTenantInactive) {
+		return http.StatusForbidden
+	}
+	var attributeErr *AttributeError
+	if errors.As(err, &attributeErr) {
+		return http.StatusBadRequest
+	}
+	var denied *PolicyDeniedError
+	if errors.As(err, &denied) {
+		return http.StatusForbidden
+	}
+	return fallback
+}
+
+// WriteError writes a JSON error response
+func WriteError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Message: message})
+}
+
+// WritePasswordError writes password policy violations as a structured 400 response
+// and any other error with the status returned by StatusFor
+func WritePasswordError(w http.ResponseWriter, err error) {
+	var policyErr *helper.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:      "password_policy",
+			Message:    "Password does not meet the password policy",
+			Violations: policyErr.Violations,
+		})
+		return
+	}
+	http.Error(w, err.Error(), StatusFor(err, http.StatusInternalServerError))
+}
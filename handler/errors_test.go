@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+func TestStatusFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{helper.ErrHasherBusy, http.StatusServiceUnavailable},
+		{fmt.Errorf("hashing the password: %w", helper.ErrHasherBusy), http.StatusServiceUnavailable},
+		{helper.ErrBreachedPasswordsUnavailable, http.StatusServiceUnavailable},
+		{ErrVerificationThrottled, http.StatusTooManyRequests},
+		{ErrTenantNotFound, http.StatusNotFound},
+		{ErrTenantMismatch, http.StatusForbidden},
+		{ErrTenantInactive, http.StatusForbidden},
+		{&AttributeError{Message: "invalid"}, http.StatusBadRequest},
+		{&PolicyDeniedError{Reason: "denied"}, http.StatusForbidden},
+		{errors.New("database is down"), http.StatusTeapot},
+	}
+	for _, test := range tests {
+		if got := StatusFor(test.err, http.StatusTeapot); got != test.want {
+			t.Errorf("StatusFor(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWritePasswordErrorWhenHasherIsBusy(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	WritePasswordError(recorder, helper.ErrHasherBusy)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", recorder.Code)
+	}
+}
@@ -0,0 +1,594 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// OIDCHTTPClient sends the requests to upstream providers
+var OIDCHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Lifetimes of cached discovery documents and keys of upstream providers
+const (
+	OIDCDiscoveryTTL = time.Hour
+	// OIDCKeysRefreshInterval limits how often the keys are fetched again for an unknown kid
+	OIDCKeysRefreshInterval = time.Minute
+)
+
+// DefaultOIDCScopes are requested from connections without scopes
+var DefaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// DefaultOIDCClaimMapping maps user fields to the standard claims, the mapping of a connection overrides it
+var DefaultOIDCClaimMapping = map[string]string{
+	"email":      "email",
+	"first_name": "given_name",
+	"last_name":  "family_name",
+	"locale":     "locale",
+}
+
+// oidcSigningMethods are the accepted algorithms of ID tokens
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384"}
+
+var (
+	ErrFederatedUserNotFound = errors.New("no user is linked to the upstream account")
+	ErrFederatedUserExists   = errors.New("a user with this email address exists and cannot be linked")
+	ErrFederatedUserInactive = errors.New("user is deactivated")
+)
+
+// FederationError is returned for failed requests to upstream providers and invalid ID tokens
+type FederationError struct {
+	Message string
+}
+
+func (e *FederationError) Error() string {
+	return e.Message
+}
+
+// OIDCProvider is the discovery document of an upstream provider
+type OIDCProvider struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+type cachedProvider struct {
+	provider  *OIDCProvider
+	fetchedAt time.Time
+}
+
+type cachedKeys struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var (
+	oidcProviders sync.Map
+	oidcKeys      sync.Map
+	// oidcKeysMu serializes refreshes of the keys
+	oidcKeysMu sync.Mutex
+)
+
+// oidcURL checks that an URL of a provider uses https, or http on localhost for local providers
+func oidcURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid URL %s", value)
+	}
+	local := parsed.Hostname() == "localhost" || parsed.Hostname() == "127.0.0.1"
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && local) {
+		return fmt.Errorf("%s must use https", value)
+	}
+	return nil
+}
+
+// getJSON fetches a JSON document from a provider
+func getJSON(endpoint string, value interface{}) error {
+	response, err := OIDCHTTPClient.Get(endpoint)
+	if err != nil {
+		return &FederationError{Message: "request to provider failed: " + err.Error()}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &FederationError{Message: fmt.Sprintf("%s returned status %d", endpoint, response.StatusCode)}
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(value); err != nil {
+		return &FederationError{Message: "invalid response of " + endpoint}
+	}
+	return nil
+}
+
+// DiscoverOIDC returns the discovery document of the issuer, cached for OIDCDiscoveryTTL
+func DiscoverOIDC(issuer string) (*OIDCProvider, error) {
+	if cached, ok := oidcProviders.Load(issuer); ok && time.Since(cached.(cachedProvider).fetchedAt) < OIDCDiscoveryTTL {
+		return cached.(cachedProvider).provider, nil
+	}
+
+	var provider OIDCProvider
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, err
+	}
+	if provider.Issuer != issuer {
+		return nil, &FederationError{Message: "discovery document is for issuer " + provider.Issuer}
+	}
+	for _, endpoint := range []string{provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.JWKSURI} {
+		if err := oidcURL(endpoint); err != nil {
+			return nil, &FederationError{Message: "discovery document: " + err.Error()}
+		}
+	}
+	if len(provider.CodeChallengeMethodsSupported) > 0 && !slices.Contains(provider.CodeChallengeMethodsSupported, "S256") {
+		return nil, &FederationError{Message: "provider does not support PKCE with S256"}
+	}
+
+	oidcProviders.Store(issuer, cachedProvider{provider: &provider, fetchedAt: time.Now()})
+	return &provider, nil
+}
+
+// oidcKey returns the signing key with the kid. Unknown kids fetch the keys again, e.g. after a rotation.
+func oidcKey(jwksURI string, kid string) (crypto.PublicKey, error) {
+	lookup := func() (crypto.PublicKey, bool, time.Time) {
+		cached, ok := oidcKeys.Load(jwksURI)
+		if !ok {
+			return nil, false, time.Time{}
+		}
+		keys := cached.(cachedKeys)
+		key, found := keys.keys[kid]
+		return key, found, keys.fetchedAt
+	}
+	if key, found, fetchedAt := lookup(); found && time.Since(fetchedAt) < OIDCDiscoveryTTL {
+		return key, nil
+	}
+
+	oidcKeysMu.Lock()
+	defer oidcKeysMu.Unlock()
+	key, found, fetchedAt := lookup()
+	if found && time.Since(fetchedAt) < OIDCDiscoveryTTL {
+		return key, nil
+	}
+	if time.Since(fetchedAt) < OIDCKeysRefreshInterval {
+		return nil, &FederationError{Message: "unknown signing key " + kid}
+	}
+
+	var set helper.JWKSet
+	if err := getJSON(jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if publicKey, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = publicKey
+		}
+	}
+	oidcKeys.Store(jwksURI, cachedKeys{keys: keys, fetchedAt: time.Now()})
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, &FederationError{Message: "unknown signing key " + kid}
+}
+
+// ValidateOIDCConnection checks the name, issuer, scopes, domains and claim mapping of a connection
+func ValidateOIDCConnection(connection *models.OIDCConnection) error {
+	if !RBACNamePattern.MatchString(connection.Name) {
+		return &FederationError{Message: "invalid name " + connection.Name}
+	}
+	if err := oidcURL(connection.Issuer); err != nil {
+		return &FederationError{Message: "issuer: " + err.Error()}
+	}
+	if connection.ClientID == "" {
+		return &FederationError{Message: "client_id is required"}
+	}
+	if len(connection.Scopes) > 0 && !slices.Contains(connection.Scopes, "openid") {
+		return &FederationError{Message: "scopes must include openid"}
+	}
+	for i, domain := range connection.Domains {
+		connection.Domains[i] = strings.ToLower(domain)
+		if !hostnamePattern.MatchString(connection.Domains[i]) {
+			return &FederationError{Message: "invalid domain " + domain}
+		}
+	}
+
+	if len(connection.ClaimMapping) == 0 {
+		connection.ClaimMapping = json.RawMessage(`{}`)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(connection.ClaimMapping, &mapping); err != nil {
+		return &FederationError{Message: "claim_mapping must map fields to claim names"}
+	}
+	for field, claim := range mapping {
+		name, custom := strings.CutPrefix(field, "attributes.")
+		if custom && !AttributeNamePattern.MatchString(name) || !custom && DefaultOIDCClaimMapping[field] == "" {
+			return &FederationError{Message: "unknown field " + field}
+		}
+		if !ClaimPathPattern.MatchString(claim) {
+			return &FederationError{Message: "invalid claim name " + claim}
+		}
+	}
+	return nil
+}
+
+// EncryptOIDCClientSecret encrypts the upstream client secret of the connection, whose ID has to be set
+func EncryptOIDCClientSecret(connection *models.OIDCConnection, secret string) error {
+	if secret == "" {
+		connection.ClientSecret = ""
+		return nil
+	}
+	masterKey, err := helper.DefaultMasterKey()
+	if err != nil {
+		return err
+	}
+	connection.ClientSecret, err = masterKey.Encrypt([]byte(secret), connection.ID.String())
+	return err
+}
+
+// ConnectionForEmail returns the active connection of the tenant that the domain of the email is routed to
+func (h *Handler) ConnectionForEmail(tenantID uuid.UUID, email string) (*models.OIDCConnection, error) {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var connection models.OIDCConnection
+	err := h.DB.Where("tenant_id = ? AND is_active = ? AND ? = ANY(domains)", tenantID, true, strings.ToLower(domain)).
+		Order("name").First(&connection).Error
+	if err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// randomURLToken returns length random bytes in URL-safe base64, e.g. as PKCE verifier (RFC 7636)
+func randomURLToken(length int) string {
+	token := make([]byte, length)
+	rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// FederatedAuthorizationURL starts a login at the provider of the connection: it stores the pending login
+// with nonce and PKCE verifier and returns the authorization URL, whose state is the ID of the login.
+// The browser token binds the login to the browser that started it.
+func (h *Handler) FederatedAuthorizationURL(connection *models.OIDCConnection, clientID uuid.UUID, redirectURI string, loginHint string, browserToken string) (string, *models.OIDCLogin, error) {
+	provider, err := DiscoverOIDC(connection.Issuer)
+	if err != nil {
+		return "", nil, err
+	}
+
+	login := models.OIDCLogin{
+		ConnectionID: connection.ID,
+		ClientID:     clientID,
+		BrowserToken: browserToken,
+		Nonce:        randomURLToken(24),
+		CodeVerifier: randomURLToken(32),
+		RedirectURI:  redirectURI,
+	}
+	if err := h.DB.Create(&login).Error; err != nil {
+		return "", nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+	scopes := []string(connection.Scopes)
+	if len(scopes) == 0 {
+		scopes = DefaultOIDCScopes
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {connection.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {login.ID.String()},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if loginHint != "" {
+		query.Set("login_hint", loginHint)
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + query.Encode(), &login, nil
+}
+
+// ExchangeOIDCCode redeems the code of a login at the token endpoint and returns the claims of the
+// validated ID token
+func (h *Handler) ExchangeOIDCCode(connection *models.OIDCConnection, login *models.OIDCLogin, code string) (jwt.MapClaims, error) {
+	provider, err := DiscoverOIDC(connection.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.RedirectURI},
+		"code_verifier": {login.CodeVerifier},
+		"client_id":     {connection.ClientID},
+	}
+	request, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if connection.ClientSecret != "" {
+		masterKey, err := helper.DefaultMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := masterKey.Decrypt(connection.ClientSecret, connection.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		request.SetBasicAuth(url.QueryEscape(connection.ClientID), url.QueryEscape(string(secret)))
+	}
+
+	response, err := OIDCHTTPClient.Do(request)
+	if err != nil {
+		return nil, &FederationError{Message: "request to provider failed: " + err.Error()}
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, &FederationError{Message: "invalid token response"}
+	}
+	if response.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, &FederationError{Message: strings.TrimSpace("token request failed: " + tokens.Error + " " + tokens.ErrorDescription)}
+	}
+	if tokens.IDToken == "" {
+		return nil, &FederationError{Message: "token response has no ID token"}
+	}
+
+	return VerifyIDToken(connection, provider, tokens.IDToken, login.Nonce)
+}
+
+// VerifyIDToken validates signature, issuer, audience, lifetime and nonce of an ID token
+func VerifyIDToken(connection *models.OIDCConnection, provider *OIDCProvider, idToken string, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if !slices.Contains(oidcSigningMethods, token.Method.Alg()) {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return oidcKey(provider.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, &FederationError{Message: "invalid ID token: " + err.Error()}
+	}
+
+	if !claims.VerifyIssuer(connection.Issuer, true) {
+		return nil, &FederationError{Message: "ID token has the wrong issuer"}
+	}
+	if !claims.VerifyAudience(connection.ClientID, true) {
+		return nil, &FederationError{Message: "ID token has the wrong audience"}
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != connection.ClientID {
+		return nil, &FederationError{Message: "ID token has the wrong authorized party"}
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, &FederationError{Message: "ID token has no expiry"}
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, &FederationError{Message: "ID token has the wrong nonce"}
+	}
+	if subject, _ := claims["sub"].(string); subject == "" || len(subject) > 255 {
+		return nil, &FederationError{Message: "ID token has no valid subject"}
+	}
+	return claims, nil
+}
+
+// claimValue returns the claim at the dotted path
+func claimValue(claims jwt.MapClaims, path string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// federatedProfile is the user data mapped from the claims of an ID token
+type federatedProfile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Fields        map[string]string
+	Attributes    map[string]json.RawMessage
+}
+
+// mapFederatedClaims maps the claims of an ID token with the claim mapping of the connection
+func mapFederatedClaims(connection *models.OIDCConnection, claims jwt.MapClaims) federatedProfile {
+	mapping := map[string]string{}
+	for field, claim := range DefaultOIDCClaimMapping {
+		mapping[field] = claim
+	}
+	json.Unmarshal(connection.ClaimMapping, &mapping)
+
+	profile := federatedProfile{Fields: map[string]string{}, Attributes: map[string]json.RawMessage{}}
+	profile.Subject, _ = claims["sub"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		profile.EmailVerified = verified
+	case string:
+		profile.EmailVerified = verified == "true"
+	}
+
+	for field, claim := range mapping {
+		value, ok := claimValue(claims, claim)
+		if !ok || value == nil {
+			continue
+		}
+		if name, custom := strings.CutPrefix(field, "attributes."); custom {
+			profile.Attributes[name], _ = json.Marshal(value)
+			continue
+		}
+		if text, ok := value.(string); ok {
+			profile.Fields[field] = strings.TrimSpace(text)
+		}
+	}
+	profile.Email = helper.NormalizeEmail(profile.Fields["email"])
+	return profile
+}
+
+// emailDomainPattern matches email addresses with a domain
+var emailDomainPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// FederatedUser returns the user of an upstream login: the user linked to the subject, otherwise the user
+// with the email address if the connection links by email and the address is verified upstream and locally,
+// otherwise a new user if the connection provisions users just in time. Names and mapped attributes are
+// updated on every login.
+func (h *Handler) FederatedUser(connection *models.OIDCConnection, claims jwt.MapClaims) (*models.User, error) {
+	profile := mapFederatedClaims(connection, claims)
+
+	var user models.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Where("connection_id = ? AND subject = ?", connection.ID, profile.Subject).First(&identity).Error
+		switch {
+		case err == nil:
+			if err := tx.Where("id = ? AND tenant_id = ?", identity.UserID, connection.TenantID).First(&user).Error; err != nil {
+				return ErrFederatedUserNotFound
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if !emailDomainPattern.MatchString(profile.Email) {
+				return ErrFederatedUserNotFound
+			}
+			if err := h.linkFederatedUser(tx, connection, &profile, &user); err != nil {
+				return err
+			}
+			identity = models.UserIdentity{TenantID: connection.TenantID, UserID: user.ID, ConnectionID: connection.ID, Subject: profile.Subject}
+		default:
+			return err
+		}
+
+		identity.Email = profile.Email
+		identity.LastLoginAt = time.Now()
+		if err := tx.Save(&identity).Error; err != nil {
+			return err
+		}
+		return h.syncFederatedProfile(tx, &user, &profile)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrFederatedUserInactive
+	}
+	return &user, nil
+}
+
+// linkFederatedUser finds the user with the email address of the profile or provisions it
+func (h *Handler) linkFederatedUser(tx *gorm.DB, connection *models.OIDCConnection, profile *federatedProfile, user *models.User) error {
+	// Deleted users are included, their email address cannot be taken by a new user
+	err := tx.Unscoped().Where("tenant_id = ? AND LOWER(email) = ?", connection.TenantID, profile.Email).First(user).Error
+	if err == nil {
+		// Unverified local accounts may have been registered by someone else to take over the upstream login
+		if !connection.LinkByEmail || !profile.EmailVerified || !user.IsVerified || user.DeletedAt.Valid {
+			return ErrFederatedUserExists
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if !connection.JITProvisioning {
+		return ErrFederatedUserNotFound
+	}
+
+	*user = models.User{
+		Email:      profile.Email,
+		FirstName:  profile.Fields["first_name"],
+		LastName:   profile.Fields["last_name"],
+		Locale:     profile.Fields["locale"],
+		Password:   utils.GenerateToken(32),
+		IsActive:   true,
+		IsVerified: profile.EmailVerified,
+		TenantID:   connection.TenantID,
+	}
+	if user.Locale == "" {
+		user.Locale = "en"
+	}
+	if err := h.ApplyAttributes(tx, user, profile.Attributes, true); err != nil {
+		return err
+	}
+	return tx.Create(user).Error
+}
+
+// syncFederatedProfile updates names, locale and mapped attributes of the user from the profile
+func (h *Handler) syncFederatedProfile(tx *gorm.DB, user *models.User, profile *federatedProfile) error {
+	updates := map[string]interface{}{}
+	firstName, lastName := user.FirstName, user.LastName
+	if value, ok := profile.Fields["first_name"]; ok && value != "" {
+		firstName = value
+	}
+	if value, ok := profile.Fields["last_name"]; ok && value != "" {
+		lastName = value
+	}
+	if firstName != user.FirstName || lastName != user.LastName {
+		user.FirstName, user.LastName = firstName, lastName
+		user.DisplayName = fmt.Sprintf("%s %s", firstName, lastName)
+		updates["first_name"] = user.FirstName
+		updates["last_name"] = user.LastName
+		updates["display_name"] = user.DisplayName
+	}
+	if value := profile.Fields["locale"]; value != "" && value != user.Locale {
+		user.Locale = value
+		updates["locale"] = value
+	}
+
+	changes := map[string]json.RawMessage{}
+	stored := DecodeAttributes(user)
+	for name, raw := range profile.Attributes {
+		if current, ok := stored[name]; ok {
+			if encoded, _ := json.Marshal(current); string(encoded) == string(raw) {
+				continue
+			}
+		}
+		changes[name] = raw
+	}
+	if len(changes) > 0 {
+		if err := h.ApplyAttributes(tx, user, changes, true); err != nil {
+			return err
+		}
+		updates["attributes"] = user.Attributes
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	return tx.Model(user).Updates(updates).Error
+}
@@ -0,0 +1,393 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// mockProvider is an upstream OpenID provider that issues ID tokens for codes with PKCE
+type mockProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+// mockGrant is a code issued by the provider and the claims of its ID token
+type mockGrant struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T, clientID string) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, clientID: clientID, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCProvider{
+			Issuer:                        p.server.URL,
+			AuthorizationEndpoint:         p.server.URL + "/authorize",
+			TokenEndpoint:                 p.server.URL + "/token",
+			JWKSURI:                       p.server.URL + "/jwks",
+			CodeChallengeMethodsSupported: []string{"plain", "S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(helper.JWKSet{Keys: []helper.JWK{helper.RSAPublicJWK(&key.PublicKey, "mock")}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize plays the user's login at the provider and returns the code of the authorization request
+func (p *mockProvider) authorize(t *testing.T, authorizationURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != p.clientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authorizationURL)
+	}
+
+	token := p.idToken(claims, query.Get("nonce"))
+	code := uuid.NewString()
+	p.mu.Lock()
+	p.codes[code] = mockGrant{challenge: query.Get("code_challenge"), redirectURI: query.Get("redirect_uri"), claims: token}
+	p.mu.Unlock()
+	return code
+}
+
+// idToken returns the standard claims of an ID token for the client, overridden by claims
+func (p *mockProvider) idToken(claims jwt.MapClaims, nonce string) jwt.MapClaims {
+	now := time.Now()
+	token := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   p.clientID,
+		"sub":   "upstream-alice",
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(token, name)
+			continue
+		}
+		token[name] = value
+	}
+	return token
+}
+
+func (p *mockProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("invalid_request")
+		return
+	}
+
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || r.PostForm.Get("client_id") != p.clientID || r.PostForm.Get("redirect_uri") != grant.redirectURI:
+		fail("invalid_grant")
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge:
+		fail("invalid_grant")
+	default:
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "mock"
+		signed, _ := token.SignedString(p.key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": signed})
+	}
+}
+
+func (p *mockProvider) connection() *models.OIDCConnection {
+	return &models.OIDCConnection{
+		ID:           uuid.New(),
+		TenantID:     uuid.New(),
+		Name:         "mock",
+		Issuer:       p.server.URL,
+		ClientID:     p.clientID,
+		ClaimMapping: json.RawMessage(`{}`),
+		LinkByEmail:  true,
+		IsActive:     true,
+	}
+}
+
+func TestFederatedLoginWithMockProvider(t *testing.T) {
+	helper.SetDefaultSecretHasher(helper.NewSecretHasher([]byte("test pepper")))
+	provider := newMockProvider(t, "sethorize")
+	connection := provider.connection()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "oidc_logins"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	redirectURI := "https://app.example.com/callback"
+	authorizationURL, login, err := h.FederatedAuthorizationURL(connection, uuid.New(), redirectURI, "alice@example.com", "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _ := url.Parse(authorizationURL)
+	query := parsed.Query()
+	if !strings.HasPrefix(authorizationURL, provider.server.URL+"/authorize?") {
+		t.Fatalf("authorization URL %s is not at the discovered endpoint", authorizationURL)
+	}
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		t.Fatal("code challenge is not the S256 hash of the verifier")
+	}
+	if query.Get("state") != login.ID.String() || query.Get("nonce") != login.Nonce || query.Get("login_hint") != "alice@example.com" {
+		t.Fatalf("unexpected authorization request %s", authorizationURL)
+	}
+	if query.Get("scope") != "openid email profile" {
+		t.Fatalf("scope = %q", query.Get("scope"))
+	}
+
+	code := provider.authorize(t, authorizationURL, jwt.MapClaims{"email": "Alice@Example.com", "email_verified": true})
+	stolen := *login
+	stolen.CodeVerifier = "a verifier of another login"
+	if _, err := h.ExchangeOIDCCode(connection, &stolen, code); err == nil {
+		t.Fatal("code was redeemed without its PKCE verifier")
+	}
+
+	code = provider.authorize(t, authorizationURL, jwt.MapClaims{"email": "Alice@Example.com", "email_verified": true})
+	claims, err := h.ExchangeOIDCCode(connection, login, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "upstream-alice" || claims["email"] != "Alice@Example.com" {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+	if _, err := h.ExchangeOIDCCode(connection, login, code); err == nil {
+		t.Fatal("code was redeemed twice")
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	provider := newMockProvider(t, "sethorize")
+	connection := provider.connection()
+	discovered, err := DiscoverOIDC(provider.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := provider.sign(t, provider.idToken(nil, "nonce"))
+	if _, err := VerifyIDToken(connection, discovered, valid, "nonce"); err != nil {
+		t.Fatalf("valid ID token rejected: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signWith := func(method jwt.SigningMethod, key interface{}, kid string) string {
+		token := jwt.NewWithClaims(method, provider.idToken(nil, "nonce"))
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong issuer", provider.sign(t, provider.idToken(jwt.MapClaims{"iss": "https://evil.example.com"}, "nonce"))},
+		{"wrong audience", provider.sign(t, provider.idToken(jwt.MapClaims{"aud": "another-client"}, "nonce"))},
+		{"wrong authorized party", provider.sign(t, provider.idToken(jwt.MapClaims{"aud": []string{"sethorize", "other"}, "azp": "other"}, "nonce"))},
+		{"expired", provider.sign(t, provider.idToken(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, "nonce"))},
+		{"no expiry", provider.sign(t, provider.idToken(jwt.MapClaims{"exp": nil}, "nonce"))},
+		{"wrong nonce", provider.sign(t, provider.idToken(nil, "another nonce"))},
+		{"no subject", provider.sign(t, provider.idToken(jwt.MapClaims{"sub": ""}, "nonce"))},
+		{"unknown key", signWith(jwt.SigningMethodRS256, otherKey, "other")},
+		{"wrong key", signWith(jwt.SigningMethodRS256, otherKey, "mock")},
+		{"symmetric algorithm", signWith(jwt.SigningMethodHS256, []byte("sethorize"), "mock")},
+		{"malformed", "not.a.token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := VerifyIDToken(connection, discovered, test.token, "nonce")
+			var federationError *FederationError
+			if !errors.As(err, &federationError) {
+				t.Fatalf("VerifyIDToken = %v, want FederationError", err)
+			}
+		})
+	}
+}
+
+func TestDiscoverOIDCRejectsInvalidDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		document func(issuer string) OIDCProvider
+	}{
+		{"other issuer", func(issuer string) OIDCProvider {
+			return OIDCProvider{Issuer: "https://evil.example.com", AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: issuer + "/token", JWKSURI: issuer + "/jwks"}
+		}},
+		{"insecure endpoint", func(issuer string) OIDCProvider {
+			return OIDCProvider{Issuer: issuer, AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: "http://evil.example.com/token", JWKSURI: issuer + "/jwks"}
+		}},
+		{"no S256", func(issuer string) OIDCProvider {
+			return OIDCProvider{Issuer: issuer, AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: issuer + "/token", JWKSURI: issuer + "/jwks", CodeChallengeMethodsSupported: []string{"plain"}}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(test.document(server.URL))
+			}))
+			defer server.Close()
+
+			_, err := DiscoverOIDC(server.URL)
+			var federationError *FederationError
+			if !errors.As(err, &federationError) {
+				t.Fatalf("DiscoverOIDC = %v, want FederationError", err)
+			}
+		})
+	}
+}
+
+// useTestHasher replaces the password hasher with a fast one for users created by the tests
+func useTestHasher(t *testing.T) {
+	params := &helper.Argon2Params{Memory: 1024, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	helper.SetDefaultHasher(helper.NewHasher(helper.NewArgon2(params), helper.HasherOptions{}))
+	t.Cleanup(func() { helper.SetDefaultHasher(nil) })
+}
+
+func TestFederatedUserProvisionsUnknownUsers(t *testing.T) {
+	useTestHasher(t)
+	provider := newMockProvider(t, "sethorize")
+	connection := provider.connection()
+	connection.JITProvisioning = true
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "user_identities" WHERE connection_id = $1 AND subject = $2`)).
+		WithArgs(connection.ID, "upstream-alice", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE tenant_id = $1 AND LOWER(email) = $2`)).
+		WithArgs(connection.TenantID, "alice@example.com", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "user_attributes" WHERE tenant_id = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	userID := uuid.New()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "user_identities"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	mock.ExpectCommit()
+
+	claims := provider.idToken(jwt.MapClaims{"email": "Alice@Example.com", "email_verified": true, "given_name": "Alice", "family_name": "Smith"}, "nonce")
+	user, err := h.FederatedUser(connection, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID || user.Email != "alice@example.com" || !user.IsVerified || user.DisplayName != "Alice Smith" || user.TenantID != connection.TenantID {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestFederatedUserLinksOnlyVerifiedUsers(t *testing.T) {
+	provider := newMockProvider(t, "sethorize")
+	connection := provider.connection()
+	claims := provider.idToken(jwt.MapClaims{"email": "alice@example.com", "email_verified": true, "given_name": "Alice", "family_name": "Smith"}, "nonce")
+
+	for _, verified := range []bool{true, false} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newMockHandler(t, db)
+
+		userID := uuid.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "user_identities"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE tenant_id = $1 AND LOWER(email) = $2`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "display_name", "locale", "is_active", "is_verified", "tenant_id"}).
+				AddRow(userID, "alice@example.com", "Alice", "Smith", "Alice Smith", "en", true, verified, connection.TenantID))
+		if verified {
+			mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "user_identities"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+
+		user, err := h.FederatedUser(connection, claims)
+		switch {
+		case verified && (err != nil || user.ID != userID):
+			t.Fatalf("verified user was not linked: %v", err)
+		case !verified && !errors.Is(err, ErrFederatedUserExists):
+			t.Fatalf("unverified user: FederatedUser = %v, want ErrFederatedUserExists", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+}
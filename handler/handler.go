@@ -2,6 +2,12 @@ package handler
 
 import "gorm.io/gorm"
 
+// ScopeUnverified is the only scope of tokens issued to users with an unverified email address
+const ScopeUnverified = "unverified"
+
+// ScopeAdmin is added for admins and super admins logging in with an internal client and grants access to the admin API
+const ScopeAdmin = "admin"
+
 type Handler struct {
 	DB *gorm.DB
 }
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"database/sql"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockHandler returns a handler on the Postgres dialect whose statements go to db, e.g. a sqlmock
+func newMockHandler(t *testing.T, db *sql.DB) *Handler {
+	t.Helper()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(gormDB)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// MailData is passed to every mail template. Extra holds template specific values.
+type MailData struct {
+	Name       string
+	Email      string
+	TenantName string
+	Link       string
+	Code       string
+	Event      string
+	Extra      map[string]interface{}
+}
+
+// SendTemplate renders the named template in the locale of the user and sends it to the given address.
+// Templates of the user's tenant take precedence over the built-in templates.
+func (h *Handler) SendTemplate(m mailer.Mailer, user *models.User, to string, name string, data MailData) error {
+	data.Name = user.DisplayName
+	data.Email = user.Email
+	data.TenantName = h.Settings(user.TenantID, nil).Branding.Name
+
+	template, ok := h.mailTemplate(user, name)
+	if !ok {
+		return fmt.Errorf("mail template %s not found", name)
+	}
+
+	message, err := template.Render(to, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(message)
+}
+
+func (h *Handler) mailTemplate(user *models.User, name string) (mailer.Template, bool) {
+	for _, locale := range mailer.LocaleCandidates(user.Locale) {
+		var override models.EmailTemplate
+		err := h.DB.Where("tenant_id = ? AND name = ? AND locale = ?", user.TenantID, name, locale).First(&override).Error
+		if err == nil {
+			return mailer.Template{Subject: override.Subject, Text: override.Text, HTML: override.HTML}, true
+		}
+	}
+	return mailer.BuiltinTemplate(name, user.Locale)
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+// Metrics writes the internal metrics in the Prometheus text exposition format
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b strings.Builder
+	writeHasherMetrics(&b, helper.DefaultHasher().Metrics())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
+
+func writeHasherMetrics(b *strings.Builder, m helper.HasherMetrics) {
+	fmt.Fprintln(b, "# HELP sethorize_hasher_max_concurrent Maximum number of concurrent hash operations.")
+	fmt.Fprintln(b, "# TYPE sethorize_hasher_max_concurrent gauge")
+	fmt.Fprintf(b, "sethorize_hasher_max_concurrent %d\n", m.MaxConcurrent)
+
+	fmt.Fprintln(b, "# HELP sethorize_hasher_queue_depth Number of hash operations waiting for a slot.")
+	fmt.Fprintln(b, "# TYPE sethorize_hasher_queue_depth gauge")
+	fmt.Fprintf(b, "sethorize_hasher_queue_depth %d\n", m.QueueDepth)
+
+	fmt.Fprintln(b, "# HELP sethorize_hasher_in_flight Number of hash operations currently running.")
+	fmt.Fprintln(b, "# TYPE sethorize_hasher_in_flight gauge")
+	fmt.Fprintf(b, "sethorize_hasher_in_flight %d\n", m.InFlight)
+
+	fmt.Fprintln(b, "# HELP sethorize_hasher_rejected_total Hash operations rejected after the queue timeout.")
+	fmt.Fprintln(b, "# TYPE sethorize_hasher_rejected_total counter")
+	fmt.Fprintf(b, "sethorize_hasher_rejected_total %d\n", m.Rejected)
+
+	fmt.Fprintln(b, "# HELP sethorize_hasher_latency_seconds Duration of hash operations.")
+	fmt.Fprintln(b, "# TYPE sethorize_hasher_latency_seconds histogram")
+	for i, bound := range m.LatencyBounds {
+		fmt.Fprintf(b, "sethorize_hasher_latency_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'f', -1, 64), m.LatencyBuckets[i])
+	}
+	count := m.LatencyBuckets[len(m.LatencyBounds)]
+	fmt.Fprintf(b, "sethorize_hasher_latency_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(b, "sethorize_hasher_latency_seconds_sum %g\n", m.LatencySum)
+	fmt.Fprintf(b, "sethorize_hasher_latency_seconds_count %d\n", count)
+}
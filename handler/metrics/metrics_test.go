@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+func TestWriteHasherMetrics(t *testing.T) {
+	var b strings.Builder
+	writeHasherMetrics(&b, helper.HasherMetrics{
+		MaxConcurrent:  4,
+		QueueDepth:     2,
+		InFlight:       3,
+		Completed:      7,
+		Rejected:       5,
+		LatencySum:     1.5,
+		LatencyBounds:  []float64{0.01, 0.5},
+		LatencyBuckets: []uint64{1, 6, 7},
+	})
+
+	for _, line := range []string{
+		"sethorize_hasher_max_concurrent 4\n",
+		"sethorize_hasher_queue_depth 2\n",
+		"sethorize_hasher_in_flight 3\n",
+		"# TYPE sethorize_hasher_rejected_total counter\nsethorize_hasher_rejected_total 5\n",
+		"sethorize_hasher_latency_seconds_bucket{le=\"0.01\"} 1\n",
+		"sethorize_hasher_latency_seconds_bucket{le=\"0.5\"} 6\n",
+		"sethorize_hasher_latency_seconds_bucket{le=\"+Inf\"} 7\n",
+		"sethorize_hasher_latency_seconds_sum 1.5\n",
+		"sethorize_hasher_latency_seconds_count 7\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("metrics without %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestMetricsOfTheDefaultHasher(t *testing.T) {
+	hasher := helper.NewHasher(nil, helper.HasherOptions{MaxConcurrent: 3})
+	helper.SetDefaultHasher(hasher)
+	t.Cleanup(func() { helper.SetDefaultHasher(nil) })
+
+	recorder := httptest.NewRecorder()
+	Metrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "sethorize_hasher_max_concurrent 3\n") {
+		t.Errorf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	Metrics(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", recorder.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated on enrollment
+const RecoveryCodeCount = 10
+
+var (
+	ErrTOTPAlreadyEnrolled = errors.New("authenticator app is already enrolled")
+	ErrTOTPNotEnrolled     = errors.New("no authenticator app enrollment in progress")
+	ErrInvalidMFACode      = errors.New("invalid code")
+)
+
+// MFARequired reports whether the MFA policy of the user's tenant, or of the client if given,
+// requires a second factor from the user
+func (h *Handler) MFARequired(user *models.User, clientID *uuid.UUID) bool {
+	switch h.Settings(user.TenantID, clientID).MFAPolicy {
+	case models.MFAPolicyAll:
+		return true
+	case models.MFAPolicyAdmins:
+		return user.IsAdmin
+	}
+	return false
+}
+
+// HasTOTP reports whether the user has a confirmed authenticator app
+func (h *Handler) HasTOTP(userID uuid.UUID) bool {
+	var count int64
+	h.DB.Model(&models.TOTPFactor{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).Count(&count)
+	return count > 0
+}
+
+// BeginTOTPEnrollment creates a new unconfirmed secret for the user and returns it with its otpauth URI
+func (h *Handler) BeginTOTPEnrollment(user *models.User) (string, string, error) {
+	if h.HasTOTP(user.ID) {
+		return "", "", ErrTOTPAlreadyEnrolled
+	}
+
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	// Replace a previous, unconfirmed enrollment
+	err = h.DB.Unscoped().Where("user_id = ?", user.ID).Delete(&models.TOTPFactor{}).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	factor := models.TOTPFactor{ID: uuid.New(), UserID: user.ID}
+	factor.Secret, err = encryptTOTPSecret(factor.ID, secret)
+	if err != nil {
+		return "", "", err
+	}
+	err = h.DB.Create(&factor).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	branding := h.Settings(user.TenantID, nil).Branding
+	return secret, helper.TOTPURI(branding.Name, user.Email, secret), nil
+}
+
+// ConfirmTOTPEnrollment activates the pending secret with a first code and returns new recovery codes
+func (h *Handler) ConfirmTOTPEnrollment(user *models.User, code string) ([]string, error) {
+	var factor models.TOTPFactor
+	err := h.DB.Where("user_id = ? AND confirmed_at IS NULL", user.ID).First(&factor).Error
+	if err != nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	secret, err := h.totpSecret(&factor)
+	if err != nil {
+		return nil, err
+	}
+	valid, counter, err := helper.ValidateTOTP(secret, code, time.Now(), factor.LastCounter)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidMFACode
+	}
+
+	err = h.DB.Model(&factor).Updates(map[string]interface{}{
+		"confirmed_at": time.Now(),
+		"last_counter": counter,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return h.RegenerateRecoveryCodes(user.ID)
+}
+
+// encryptTOTPSecret encrypts the secret of a factor with the master key and the factor ID as associated data
+func encryptTOTPSecret(factorID uuid.UUID, secret string) (string, error) {
+	masterKey, err := helper.DefaultMasterKey()
+	if err != nil {
+		return "", err
+	}
+	return masterKey.Encrypt([]byte(secret), factorID.String())
+}
+
+// totpSecret decrypts the secret of the factor. Secrets stored in plain text before they were
+// encrypted are encrypted on their first use.
+func (h *Handler) totpSecret(factor *models.TOTPFactor) (string, error) {
+	if !helper.IsEncrypted(factor.Secret) {
+		encrypted, err := encryptTOTPSecret(factor.ID, factor.Secret)
+		if err == nil {
+			err = h.DB.Model(factor).Update("secret", encrypted).Error
+		}
+		if err != nil {
+			fmt.Printf("Error encrypting TOTP secret: %v\n", err)
+		}
+		return factor.Secret, nil
+	}
+
+	masterKey, err := helper.DefaultMasterKey()
+	if err != nil {
+		return "", err
+	}
+	secret, err := masterKey.Decrypt(factor.Secret, factor.ID.String())
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user. The plain codes are only returned here.
+func (h *Handler) RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes, err := helper.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		err = h.DB.Create(&models.RecoveryCode{UserID: userID, Code: code}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// VerifyTOTP checks a code of the confirmed authenticator app. Every code can be used only once.
+func (h *Handler) VerifyTOTP(userID uuid.UUID, code string) (bool, error) {
+	var factor models.TOTPFactor
+	err := h.DB.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&factor).Error
+	if err != nil {
+		return false, nil
+	}
+
+	secret, err := h.totpSecret(&factor)
+	if err != nil {
+		return false, err
+	}
+	valid, counter, err := helper.ValidateTOTP(secret, code, time.Now(), factor.LastCounter)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	// Conditional update so that concurrent requests cannot use the same code twice
+	result := h.DB.Model(&models.TOTPFactor{}).Where("id = ? AND last_counter < ?", factor.ID, counter).Update("last_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UseRecoveryCode redeems one of the unused recovery codes of the user
+func (h *Handler) UseRecoveryCode(userID uuid.UUID, code string) (bool, error) {
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return false, err
+	}
+	hash, err := secretHasher.Hash(helper.NormalizeRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	result := h.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RemoveTOTP deletes the authenticator app and the recovery codes of the user
+func (h *Handler) RemoveTOTP(userID uuid.UUID) error {
+	err := h.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.TOTPFactor{}).Error
+	if err != nil {
+		return err
+	}
+	return h.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
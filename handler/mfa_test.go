@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+func TestMFARequired(t *testing.T) {
+	all, optional := models.MFAPolicyAll, models.MFAPolicyOptional
+	tests := []struct {
+		name         string
+		tenantPolicy string
+		clientPolicy *string
+		admin        bool
+		required     bool
+	}{
+		{"default", "", nil, true, false},
+		{"all users", models.MFAPolicyAll, nil, false, true},
+		{"admins, user", models.MFAPolicyAdmins, nil, false, false},
+		{"admins, admin", models.MFAPolicyAdmins, nil, true, true},
+		{"client requires it", models.MFAPolicyOptional, &all, false, true},
+		{"client relaxes it", models.MFAPolicyAll, &optional, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			user := &models.User{ID: uuid.New(), TenantID: uuid.New(), IsAdmin: test.admin}
+			clientID := uuid.New()
+
+			mock.ExpectQuery(`FROM "tenants"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "mfa_policy"}).AddRow(user.TenantID, "acme", test.tenantPolicy))
+			mock.ExpectQuery(`FROM "settings" WHERE tenant_id IS NULL AND client_id IS NULL`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`FROM "settings" WHERE tenant_id = \$1 AND client_id IS NULL`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			client := sqlmock.NewRows([]string{"id", "client_id", "mfa_policy"})
+			if test.clientPolicy != nil {
+				client.AddRow(uuid.New(), clientID, *test.clientPolicy)
+			}
+			mock.ExpectQuery(`FROM "settings" WHERE client_id = \$1`).WillReturnRows(client)
+
+			if required := h.MFARequired(user, &clientID); required != test.required {
+				t.Errorf("MFARequired = %t, want %t", required, test.required)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTPUsesEveryCodeOnce(t *testing.T) {
+	helper.SetDefaultMasterKey(helper.NewMasterKey(bytes.Repeat([]byte{7}, helper.MasterKeySize)))
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := helper.TOTPCounter(time.Now())
+	code, err := helper.TOTPCode(secret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		lastCounter int64
+		claims      int64
+		valid       bool
+	}{
+		{"unused code", counter - 2, 1, true},
+		{"code used concurrently", counter - 2, 0, false},
+		{"code used before", counter + 1, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			factorID, userID := uuid.New(), uuid.New()
+			encrypted, err := encryptTOTPSecret(factorID, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mock.ExpectQuery(`FROM "totp_factors" WHERE \(user_id = \$1 AND confirmed_at IS NOT NULL\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "secret", "last_counter"}).AddRow(factorID, userID, encrypted, test.lastCounter))
+			if test.lastCounter < counter {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "totp_factors" SET "last_counter"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND last_counter < \$4\)`).
+					WithArgs(counter, sqlmock.AnyArg(), factorID, counter).WillReturnResult(sqlmock.NewResult(0, test.claims))
+				mock.ExpectCommit()
+			}
+
+			valid, err := h.VerifyTOTP(userID, code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != test.valid {
+				t.Errorf("valid = %t, want %t", valid, test.valid)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	secretHasher := helper.NewSecretHasher([]byte("test pepper"))
+	helper.SetDefaultSecretHasher(secretHasher)
+	hash, err := secretHasher.Hash("ABCDE12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, claims := range []int64{1, 0} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newMockHandler(t, db)
+		userID := uuid.New()
+
+		// The code is looked up by its hash and claimed in one statement
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "recovery_codes" SET "used_at"=\$1 WHERE user_id = \$2 AND code = \$3 AND used_at IS NULL$`).
+			WithArgs(sqlmock.AnyArg(), userID, hash).WillReturnResult(sqlmock.NewResult(0, claims))
+		mock.ExpectCommit()
+
+		valid, err := h.UseRecoveryCode(userID, " abcde-12345")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != (claims == 1) {
+			t.Errorf("valid = %t after claiming %d codes", valid, claims)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	}
+}
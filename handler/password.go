@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// PasswordPolicy returns the password policy of the tenant or the default policy
+func (h *Handler) PasswordPolicy(tenantID uuid.UUID) models.PasswordPolicy {
+	var policy models.PasswordPolicy
+	err := h.DB.Where("tenant_id = ?", tenantID).First(&policy).Error
+	if err != nil {
+		return models.DefaultPasswordPolicy(tenantID)
+	}
+	return policy
+}
+
+// ValidatePassword checks the password against the tenant policy of the user and,
+// for existing users, against the current password and the password history
+func (h *Handler) ValidatePassword(user *models.User, password string) error {
+	policy := h.PasswordPolicy(user.TenantID)
+
+	err := policy.Rules().Check(password, helper.PasswordContext{
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	})
+	if err != nil {
+		return err
+	}
+
+	if user.ID == uuid.Nil || policy.HistorySize <= 0 {
+		return nil
+	}
+
+	hashes := []string{user.Password}
+	var history []models.PasswordHistory
+	h.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(policy.HistorySize).Find(&history)
+	for _, entry := range history {
+		hashes = append(hashes, entry.Password)
+	}
+
+	hasher := helper.DefaultHasher()
+	for _, hash := range hashes {
+		reused, _, err := hasher.Compare(password, hash)
+		if err != nil {
+			return err
+		}
+		if reused {
+			return &helper.PasswordPolicyError{Violations: []helper.PasswordViolation{{
+				Code:    "reused",
+				Message: "Password was used recently",
+			}}}
+		}
+	}
+
+	return nil
+}
+
+// SetPassword validates and stores a new password for an existing user and records the old hash in the
+// history. The writes run in one transaction, nested in tx if it is one.
+func (h *Handler) SetPassword(tx *gorm.DB, user *models.User, password string) error {
+	err := h.ValidatePassword(user, password)
+	if err != nil {
+		return err
+	}
+
+	hash, err := helper.DefaultHasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	policy := h.PasswordPolicy(user.TenantID)
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&models.PasswordHistory{UserID: user.ID, Password: user.Password}).Error
+		if err != nil {
+			return err
+		}
+
+		// Keep only the newest entries of the history
+		if policy.HistorySize > 0 {
+			err = tx.Where("user_id = ? AND id NOT IN (?)", user.ID,
+				tx.Model(&models.PasswordHistory{}).Select("id").Where("user_id = ?", user.ID).Order("created_at DESC").Limit(policy.HistorySize),
+			).Delete(&models.PasswordHistory{}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(user).Update("password", hash).Error
+	})
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
+
+// SendPasswordReset mails a reset link to the user. Only the newest link is valid. resetURL is the page of
+// the account UI which receives the token as "token" query parameter; without it the token itself is sent.
+func (h *Handler) SendPasswordReset(m mailer.Mailer, user *models.User, resetURL string) error {
+	h.DB.Model(&models.PasswordReset{}).Where("user_id = ? AND used_at IS NULL", user.ID).Update("used_at", time.Now())
+
+	tokenValue := utils.GenerateToken(32)
+	reset := models.PasswordReset{
+		UserID: user.ID,
+		Token:  tokenValue,
+	}
+	err := h.DB.Create(&reset).Error
+	if err != nil {
+		return err
+	}
+
+	token := utils.EncodeBearerToken(reset.ID.String(), tokenValue)
+	link := token
+	if resetURL != "" {
+		link = fmt.Sprintf("%s?token=%s", resetURL, url.QueryEscape(token))
+	}
+
+	return h.SendTemplate(m, user, user.Email, mailer.TemplatePasswordReset, MailData{Link: link})
+}
@@ -0,0 +1,422 @@
+package handler
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/policy"
+)
+
+// Points at which policies are evaluated
+const (
+	PolicyPointLogin     = "login"
+	PolicyPointAuthorize = "authorize"
+	PolicyPointToken     = "token"
+	PolicyPointAPI       = "api"
+)
+
+var PolicyPoints = []string{PolicyPointLogin, PolicyPointAuthorize, PolicyPointToken, PolicyPointAPI}
+
+// Effects of a policy whose condition is true
+const (
+	PolicyEffectDeny   = "deny"
+	PolicyEffectClaims = "claims"
+)
+
+// PolicyVariables are the variables available in policy expressions
+var PolicyVariables = []string{"point", "user", "client", "tenant", "request", "scopes", "auth", "now"}
+
+// PolicyInput is the context of a policy evaluation. User, Client and Request are optional.
+type PolicyInput struct {
+	Point       string
+	TenantID    uuid.UUID
+	User        *models.User
+	Client      *models.Client
+	Request     *http.Request
+	Scopes      []string
+	AuthMethods []string
+	ACR         string
+	// DryRun evaluations are not recorded in the audit log
+	DryRun bool
+	// Candidate replaces the active version of its policy or is evaluated in addition to the active policies
+	Candidate *PolicyCandidate
+}
+
+// PolicyCandidate is a policy version to evaluate
+type PolicyCandidate struct {
+	PolicyID  uuid.UUID         `json:"policy_id"`
+	Name      string            `json:"name"`
+	Version   int               `json:"version"`
+	Effect    string            `json:"effect"`
+	Condition string            `json:"condition"`
+	Message   string            `json:"message"`
+	Claims    map[string]string `json:"claims"`
+}
+
+// PolicyResult is the outcome of one policy
+type PolicyResult struct {
+	PolicyID uuid.UUID `json:"policy_id"`
+	Name     string    `json:"name"`
+	Version  int       `json:"version"`
+	Matched  bool      `json:"matched"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// PolicyDecision is the result of all policies of a point
+type PolicyDecision struct {
+	Point   string                 `json:"point"`
+	Allowed bool                   `json:"allowed"`
+	Reason  string                 `json:"reason,omitempty"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+	Results []PolicyResult         `json:"results"`
+}
+
+// PolicyDeniedError is returned when a policy denies the request
+type PolicyDeniedError struct {
+	Reason string
+}
+
+func (e *PolicyDeniedError) Error() string {
+	return e.Reason
+}
+
+// PolicyError is returned for invalid policies
+type PolicyError struct {
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// MaxCompiledPolicies is the number of compiled expressions kept in memory
+const MaxCompiledPolicies = 1000
+
+// programCache keeps the most recently used programs by their source
+type programCache struct {
+	mu       sync.Mutex
+	order    *list.List
+	programs map[string]*list.Element
+}
+
+type cachedProgram struct {
+	source  string
+	program *policy.Program
+}
+
+// compiledPolicies caches the programs of stored policies and claim mappers
+var compiledPolicies = &programCache{order: list.New(), programs: map[string]*list.Element{}}
+
+func (c *programCache) get(source string) (*policy.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.programs[source]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedProgram).program, true
+}
+
+func (c *programCache) add(source string, program *policy.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.programs[source]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.programs[source] = c.order.PushFront(&cachedProgram{source: source, program: program})
+	for c.order.Len() > MaxCompiledPolicies {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.programs, oldest.Value.(*cachedProgram).source)
+	}
+}
+
+// compilePolicy compiles an expression. Only the expressions of stored policies and mappers are cached,
+// candidates that are validated or evaluated in a dry run are compiled each time.
+func compilePolicy(source string, cache bool) (*policy.Program, error) {
+	if program, ok := compiledPolicies.get(source); ok {
+		return program, nil
+	}
+	program, err := policy.Compile(source, PolicyVariables...)
+	if err != nil {
+		return nil, err
+	}
+	if cache {
+		compiledPolicies.add(source, program)
+	}
+	return program, nil
+}
+
+// ValidatePolicy checks the effect, point and expressions of a policy version
+func ValidatePolicy(point string, candidate *PolicyCandidate) error {
+	if !slices.Contains(PolicyPoints, point) {
+		return &PolicyError{Message: "unknown point " + point}
+	}
+	switch candidate.Effect {
+	case PolicyEffectDeny:
+		if len(candidate.Claims) > 0 {
+			return &PolicyError{Message: "deny policies have no claims"}
+		}
+	case PolicyEffectClaims:
+		if point != PolicyPointToken {
+			return &PolicyError{Message: "claims policies can only be evaluated at the token point"}
+		}
+		if len(candidate.Claims) == 0 {
+			return &PolicyError{Message: "claims policies need claims"}
+		}
+	default:
+		return &PolicyError{Message: "unknown effect " + candidate.Effect}
+	}
+
+	if _, err := compilePolicy(candidate.Condition, false); err != nil {
+		return &PolicyError{Message: "condition: " + err.Error()}
+	}
+	for name, expression := range candidate.Claims {
+		if reservedClaims[name] {
+			return &PolicyError{Message: "claim " + name + " is reserved"}
+		}
+		if _, err := compilePolicy(expression, false); err != nil {
+			return &PolicyError{Message: "claim " + name + ": " + err.Error()}
+		}
+	}
+	return nil
+}
+
+// reservedClaims cannot be set by policies
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true, "sid": true,
+	"scope": true, "amr": true, "acr": true, "auth_time": true, "user": true, "type": true,
+	ClaimRoles: true, ClaimGroups: true, ClaimPermissions: true, ClaimOverage: true,
+}
+
+// activePolicies returns the active policies of the point in their current version, ordered by name
+func (h *Handler) activePolicies(tenantID uuid.UUID, point string) ([]PolicyCandidate, error) {
+	var rows []struct {
+		models.PolicyVersion
+		Name string
+	}
+	err := h.DB.Table("policies").
+		Select("policy_versions.*, policies.name").
+		Joins("JOIN policy_versions ON policy_versions.policy_id = policies.id AND policy_versions.version = policies.version").
+		Where("policies.tenant_id = ? AND policies.point = ? AND policies.is_active = ?", tenantID, point, true).
+		Order("policies.name").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]PolicyCandidate, 0, len(rows))
+	for _, row := range rows {
+		candidate, err := PolicyCandidateOf(&row.PolicyVersion, row.Name)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, *candidate)
+	}
+	return candidates, nil
+}
+
+// PolicyCandidateOf returns the stored version of the named policy as candidate
+func PolicyCandidateOf(version *models.PolicyVersion, name string) (*PolicyCandidate, error) {
+	candidate := &PolicyCandidate{
+		PolicyID:  version.PolicyID,
+		Name:      name,
+		Version:   version.Version,
+		Effect:    version.Effect,
+		Condition: version.Condition,
+		Message:   version.Message,
+	}
+	if len(version.Claims) > 0 {
+		if err := json.Unmarshal(version.Claims, &candidate.Claims); err != nil {
+			return nil, err
+		}
+	}
+	return candidate, nil
+}
+
+// policyVariables builds the variables of the expressions from the input
+func (h *Handler) policyVariables(input *PolicyInput) (map[string]interface{}, error) {
+	variables := map[string]interface{}{
+		"point":   input.Point,
+		"user":    nil,
+		"client":  nil,
+		"tenant":  nil,
+		"request": map[string]interface{}{"ip": "", "host": "", "method": "", "path": "", "user_agent": ""},
+		"scopes":  input.Scopes,
+		"auth":    map[string]interface{}{"methods": input.AuthMethods, "acr": input.ACR},
+		"now":     time.Now(),
+	}
+
+	var tenant models.Tenant
+	if h.DB.Where("id = ?", input.TenantID).First(&tenant).Error == nil {
+		variables["tenant"] = map[string]interface{}{"id": tenant.ID, "name": tenant.Name, "slug": tenant.Slug}
+	}
+
+	if input.Client != nil {
+		variables["client"] = map[string]interface{}{
+			"id":           input.Client.ID,
+			"name":         input.Client.Name,
+			"slug":         input.Client.Slug,
+			"internal":     input.Client.Internal,
+			"cross_tenant": input.Client.CrossTenant,
+			"scopes":       input.Client.Scopes,
+		}
+	}
+
+	if input.User != nil {
+		var clientID *uuid.UUID
+		if input.Client != nil {
+			clientID = &input.Client.ID
+		}
+		authorization, err := h.UserAuthorization(input.User.ID, clientID)
+		if err != nil {
+			return nil, err
+		}
+		variables["user"] = map[string]interface{}{
+			"id":           input.User.ID,
+			"email":        input.User.Email,
+			"first_name":   input.User.FirstName,
+			"last_name":    input.User.LastName,
+			"display_name": input.User.DisplayName,
+			"locale":       input.User.Locale,
+			"is_verified":  input.User.IsVerified,
+			"is_admin":     input.User.IsAdmin,
+			"tenant_id":    input.User.TenantID,
+			"roles":        authorization.Roles,
+			"groups":       authorization.Groups,
+			"permissions":  authorization.Permissions,
+			"attributes":   DecodeAttributes(input.User),
+		}
+	}
+
+	if r := input.Request; r != nil {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		variables["request"] = map[string]interface{}{
+			"ip":         ip,
+			"host":       NormalizeHost(r.Host),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"user_agent": r.UserAgent(),
+		}
+	}
+	return variables, nil
+}
+
+// EvaluatePolicies evaluates the active policies of the point in the order of their names. The first
+// matching deny policy denies; a deny policy that fails to evaluate denies as well. Claims policies add
+// their claims, claims that fail to evaluate are left out. Decisions are recorded in the audit log.
+func (h *Handler) EvaluatePolicies(input PolicyInput) (*PolicyDecision, error) {
+	candidates, err := h.activePolicies(input.TenantID, input.Point)
+	if err != nil {
+		return nil, err
+	}
+	// The candidate of the input may be unsaved, its expressions are not cached
+	candidateIndex := -1
+	if input.Candidate != nil {
+		candidateIndex = slices.IndexFunc(candidates, func(c PolicyCandidate) bool { return c.PolicyID == input.Candidate.PolicyID })
+		if candidateIndex >= 0 && input.Candidate.PolicyID != uuid.Nil {
+			candidates[candidateIndex] = *input.Candidate
+		} else {
+			candidateIndex = len(candidates)
+			candidates = append(candidates, *input.Candidate)
+		}
+	}
+
+	decision := &PolicyDecision{Point: input.Point, Allowed: true, Results: []PolicyResult{}}
+	if len(candidates) == 0 {
+		return decision, nil
+	}
+
+	variables, err := h.policyVariables(&input)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, candidate := range candidates {
+		result := PolicyResult{PolicyID: candidate.PolicyID, Name: candidate.Name, Version: candidate.Version}
+		cache := i != candidateIndex
+		matched, err := evaluateCondition(candidate.Condition, variables, cache)
+		result.Matched = matched
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		switch {
+		case candidate.Effect == PolicyEffectDeny && (matched || err != nil):
+			decision.Allowed = false
+			decision.Reason = candidate.Message
+			if decision.Reason == "" {
+				decision.Reason = "Denied by policy " + candidate.Name
+			}
+		case candidate.Effect == PolicyEffectClaims && matched:
+			for name, expression := range candidate.Claims {
+				value, err := evaluateExpression(expression, variables, cache)
+				if err != nil {
+					result.Error = fmt.Sprintf("claim %s: %v", name, err)
+					continue
+				}
+				if decision.Claims == nil {
+					decision.Claims = map[string]interface{}{}
+				}
+				decision.Claims[name] = value
+			}
+		}
+		decision.Results = append(decision.Results, result)
+		if !decision.Allowed {
+			decision.Claims = nil
+			break
+		}
+	}
+
+	if !input.DryRun {
+		var userID, clientID *uuid.UUID
+		if input.User != nil {
+			userID = &input.User.ID
+		}
+		if input.Client != nil {
+			clientID = &input.Client.ID
+		}
+		h.Audit(input.TenantID, AuditPolicyDecision, userID, clientID, decision)
+	}
+	return decision, nil
+}
+
+// AuthorizePolicies evaluates the policies of the point and returns a PolicyDeniedError if they deny
+func (h *Handler) AuthorizePolicies(input PolicyInput) (*PolicyDecision, error) {
+	decision, err := h.EvaluatePolicies(input)
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allowed {
+		return decision, &PolicyDeniedError{Reason: decision.Reason}
+	}
+	return decision, nil
+}
+
+func evaluateCondition(source string, variables map[string]interface{}, cache bool) (bool, error) {
+	program, err := compilePolicy(source, cache)
+	if err != nil {
+		return false, err
+	}
+	return program.EvalBool(variables)
+}
+
+func evaluateExpression(source string, variables map[string]interface{}, cache bool) (interface{}, error) {
+	program, err := compilePolicy(source, cache)
+	if err != nil {
+		return nil, err
+	}
+	return program.Eval(variables)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"container/list"
+	"fmt"
+	"testing"
+)
+
+func resetCompiledPolicies(t *testing.T) {
+	compiledPolicies = &programCache{order: list.New(), programs: map[string]*list.Element{}}
+	t.Cleanup(func() { compiledPolicies = &programCache{order: list.New(), programs: map[string]*list.Element{}} })
+}
+
+func TestCompilePolicyCachesOnlyStoredSources(t *testing.T) {
+	resetCompiledPolicies(t)
+
+	if _, err := compilePolicy(`point == "login"`, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := compiledPolicies.get(`point == "login"`); ok {
+		t.Fatal("uncached source was stored")
+	}
+
+	program, err := compilePolicy(`point == "token"`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, ok := compiledPolicies.get(`point == "token"`)
+	if !ok || cached != program {
+		t.Fatal("stored source was not cached")
+	}
+
+	if _, err := compilePolicy(`point ==`, true); err == nil {
+		t.Fatal("invalid source compiled")
+	}
+	if compiledPolicies.order.Len() != 1 {
+		t.Fatalf("cache has %d programs, want 1", compiledPolicies.order.Len())
+	}
+}
+
+func TestCompiledPoliciesEvictLeastRecentlyUsed(t *testing.T) {
+	resetCompiledPolicies(t)
+
+	source := func(i int) string { return fmt.Sprintf("point == \"p%d\"", i) }
+	first, err := compilePolicy(source(0), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < MaxCompiledPolicies+10; i++ {
+		if _, err := compilePolicy(source(i), true); err != nil {
+			t.Fatal(err)
+		}
+		// The first program stays in use
+		if program, _ := compilePolicy(source(0), true); program != first {
+			t.Fatalf("program %d evicted the most recently used program", i)
+		}
+	}
+
+	if got := compiledPolicies.order.Len(); got != MaxCompiledPolicies || len(compiledPolicies.programs) != MaxCompiledPolicies {
+		t.Fatalf("cache has %d programs, want %d", got, MaxCompiledPolicies)
+	}
+	if _, ok := compiledPolicies.get(source(1)); ok {
+		t.Fatal("least recently used program was not evicted")
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// Claims with the roles, groups and permissions of a user
+const (
+	ClaimRoles       = "roles"
+	ClaimGroups      = "groups"
+	ClaimPermissions = "permissions"
+	// ClaimOverage lists the claims that were left out because they exceeded the size limits.
+	// Resource servers resolve them with UserAuthorization instead.
+	ClaimOverage = "overage"
+)
+
+// Size limits of each authorization claim
+const (
+	MaxClaimEntries = 100
+	MaxClaimBytes   = 4096
+)
+
+// RBACNamePattern is the format of role, group and permission names, which end up in tokens
+var RBACNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]{0,99}$`)
+
+// Authorization are the sorted names of the roles, groups and permissions of a user
+type Authorization struct {
+	Roles       []string `json:"roles"`
+	Groups      []string `json:"groups"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserAuthorization resolves the groups of the user, the roles assigned directly or through the groups and
+// the permissions of these roles that the client defines. Without client no permissions are resolved.
+func (h *Handler) UserAuthorization(userID uuid.UUID, clientID *uuid.UUID) (Authorization, error) {
+	authorization := Authorization{Roles: []string{}, Groups: []string{}, Permissions: []string{}}
+
+	err := h.DB.Model(&models.Group{}).
+		Where("id IN (?)", h.DB.Model(&models.UserGroup{}).Select("group_id").Where("user_id = ?", userID)).
+		Order("name").Pluck("name", &authorization.Groups).Error
+	if err != nil {
+		return authorization, err
+	}
+
+	err = h.DB.Model(&models.Role{}).Where("id IN (?)", h.userRoleIDs(userID)).Order("name").Pluck("name", &authorization.Roles).Error
+	if err != nil || clientID == nil {
+		return authorization, err
+	}
+
+	err = h.DB.Model(&models.Permission{}).
+		Where("client_id = ? AND id IN (?)", *clientID,
+			h.DB.Model(&models.RolePermission{}).Select("permission_id").Where("role_id IN (?)", h.userRoleIDs(userID))).
+		Order("name").Pluck("name", &authorization.Permissions).Error
+	return authorization, err
+}
+
+// userRoleIDs is a subquery for the IDs of the roles a user holds directly or through groups
+func (h *Handler) userRoleIDs(userID uuid.UUID) *gorm.DB {
+	return h.DB.Raw(`SELECT role_id FROM user_roles WHERE user_id = ?
+		UNION SELECT group_roles.role_id FROM group_roles JOIN user_groups ON user_groups.group_id = group_roles.group_id WHERE user_groups.user_id = ?`,
+		userID, userID)
+}
+
+// Claims returns the roles, groups and permissions claims. A claim with more than MaxClaimEntries entries or
+// MaxClaimBytes bytes is left out and named in the overage claim, so tokens stay small.
+func (a Authorization) Claims() map[string]interface{} {
+	claims := map[string]interface{}{}
+	overage := []string{}
+	for _, claim := range []struct {
+		name   string
+		values []string
+	}{
+		{ClaimRoles, a.Roles},
+		{ClaimGroups, a.Groups},
+		{ClaimPermissions, a.Permissions},
+	} {
+		size := 0
+		for _, value := range claim.values {
+			size += len(value) + 3
+		}
+		if len(claim.values) > MaxClaimEntries || size > MaxClaimBytes {
+			overage = append(overage, claim.name)
+			continue
+		}
+		claims[claim.name] = slices.Clone(claim.values)
+	}
+	if len(overage) > 0 {
+		claims[ClaimOverage] = overage
+	}
+	return claims
+}
+
+// Has reports whether the claim (roles, groups or permissions) contains the value
+func (a Authorization) Has(claim string, value string) bool {
+	switch claim {
+	case ClaimRoles:
+		return slices.Contains(a.Roles, value)
+	case ClaimGroups:
+		return slices.Contains(a.Groups, value)
+	case ClaimPermissions:
+		return slices.Contains(a.Permissions, value)
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestUserAuthorization(t *testing.T) {
+	for _, withClient := range []bool{true, false} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newMockHandler(t, db)
+		userID, clientID := uuid.New(), uuid.New()
+		// Roles held directly and through groups
+		roleIDs := `SELECT role_id FROM user_roles WHERE user_id = \$\d+\s+UNION SELECT group_roles.role_id FROM group_roles JOIN user_groups ON user_groups.group_id = group_roles.group_id WHERE user_groups.user_id = \$\d+`
+
+		mock.ExpectQuery(`SELECT "name" FROM "groups" WHERE id IN \(SELECT "group_id" FROM "user_groups" WHERE user_id = \$1\) .*ORDER BY name`).
+			WithArgs(userID).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("engineering").AddRow("oncall"))
+		mock.ExpectQuery(`SELECT "name" FROM "roles" WHERE id IN \(`+roleIDs+`\) .*ORDER BY name`).
+			WithArgs(userID, userID).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("admin").AddRow("viewer"))
+		if withClient {
+			// Only the permissions the client defines
+			mock.ExpectQuery(`SELECT "name" FROM "permissions" WHERE client_id = \$1 AND id IN \(SELECT "permission_id" FROM "role_permissions" WHERE role_id IN \(`+roleIDs+`\)\) .*ORDER BY name`).
+				WithArgs(clientID, userID, userID).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("invoices:read"))
+		}
+
+		var client *uuid.UUID
+		if withClient {
+			client = &clientID
+		}
+		authorization, err := h.UserAuthorization(userID, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		permissions := []string{}
+		if withClient {
+			permissions = []string{"invoices:read"}
+		}
+		if !slices.Equal(authorization.Groups, []string{"engineering", "oncall"}) || !slices.Equal(authorization.Roles, []string{"admin", "viewer"}) ||
+			!slices.Equal(authorization.Permissions, permissions) {
+			t.Errorf("authorization with client %t = %+v", withClient, authorization)
+		}
+		if !authorization.Has(ClaimRoles, "admin") || !authorization.Has(ClaimGroups, "oncall") || authorization.Has(ClaimRoles, "oncall") ||
+			authorization.Has(ClaimPermissions, "invoices:read") != withClient || authorization.Has("scope", "admin") {
+			t.Errorf("Has with client %t is wrong for %+v", withClient, authorization)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	}
+}
+
+func TestAuthorizationClaimsOverage(t *testing.T) {
+	names := func(count int, length int) []string {
+		values := make([]string, count)
+		for i := range values {
+			values[i] = fmt.Sprintf("%0*d", length, i)
+		}
+		return values
+	}
+
+	tests := []struct {
+		name          string
+		authorization Authorization
+		overage       []string
+	}{
+		{"within the limits", Authorization{Roles: names(MaxClaimEntries, 3), Groups: []string{}, Permissions: names(1, 10)}, nil},
+		{"too many roles", Authorization{Roles: names(MaxClaimEntries+1, 3), Groups: []string{"a"}, Permissions: []string{}}, []string{ClaimRoles}},
+		// 40 names of 100 characters exceed MaxClaimBytes
+		{"too large groups and permissions", Authorization{Roles: []string{"admin"}, Groups: names(40, 100), Permissions: names(40, 100)}, []string{ClaimGroups, ClaimPermissions}},
+	}
+	for _, test := range tests {
+		claims := test.authorization.Claims()
+		overage, _ := claims[ClaimOverage].([]string)
+		if !slices.Equal(overage, test.overage) {
+			t.Errorf("%s: overage = %v, want %v", test.name, overage, test.overage)
+		}
+		for claim, values := range map[string][]string{ClaimRoles: test.authorization.Roles, ClaimGroups: test.authorization.Groups, ClaimPermissions: test.authorization.Permissions} {
+			value, included := claims[claim].([]string)
+			if included == slices.Contains(test.overage, claim) {
+				t.Errorf("%s: claim %s included %t", test.name, claim, included)
+			}
+			if included && !slices.Equal(value, values) {
+				t.Errorf("%s: claim %s = %v", test.name, claim, value)
+			}
+		}
+	}
+
+	// The claims do not share the slices of the authorization
+	authorization := Authorization{Roles: []string{"admin"}, Groups: []string{}, Permissions: []string{}}
+	authorization.Claims()[ClaimRoles].([]string)[0] = "changed"
+	if authorization.Roles[0] != "admin" {
+		t.Error("changing the claims changed the authorization")
+	}
+}
+
+func TestRBACNamePattern(t *testing.T) {
+	for _, name := range []string{"admin", "invoices:read", "team/eng-1", "a.b_c"} {
+		if !RBACNamePattern.MatchString(name) {
+			t.Errorf("%q rejected", name)
+		}
+	}
+	for _, name := range []string{"", "-admin", "has space", "quote\"", strings.Repeat("a", 101)} {
+		if RBACNamePattern.MatchString(name) {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
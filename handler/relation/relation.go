@@ -0,0 +1,105 @@
+package relation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/authz"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// RelationHandler serves the relationship-based authorization API (package authz) for the tenant of the token
+type RelationHandler struct {
+	Handler *handler.Handler
+}
+
+func NewRelationHandler(db *gorm.DB) *RelationHandler {
+	return &RelationHandler{
+		Handler: handler.NewHandler(db),
+	}
+}
+
+// tenant returns the tenant of the token: the tenant of the user or, for client tokens, of the client
+func (h *RelationHandler) tenant(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	session, ok := r.Context().Value("session").(models.Session)
+	if !ok {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+
+	if session.UserID != uuid.Nil {
+		var user models.User
+		if err := h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error; err != nil {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return uuid.Nil, false
+		}
+		return user.TenantID, true
+	}
+
+	var client models.Client
+	if err := h.Handler.DB.Where("id = ?", session.ClientID).First(&client).Error; err != nil {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+	return client.TenantID, true
+}
+
+// serve decodes the request, runs the call for the tenant and writes its response
+func serve[Request any, Response any](h *RelationHandler, w http.ResponseWriter, r *http.Request, call func(uuid.UUID, Request) (Response, error)) {
+	tenantID, ok := h.tenant(w, r)
+	if !ok {
+		return
+	}
+
+	var request Request
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := call(tenantID, request)
+	var authzErr *handler.AuthzError
+	if errors.As(err, &authzErr) {
+		handler.WriteError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Check answers whether a subject has a relation to an object
+func (h *RelationHandler) Check(w http.ResponseWriter, r *http.Request) {
+	serve(h, w, r, h.Handler.AuthzCheck)
+}
+
+// Expand returns the userset tree of a relation of an object
+func (h *RelationHandler) Expand(w http.ResponseWriter, r *http.Request) {
+	serve(h, w, r, h.Handler.AuthzExpand)
+}
+
+// ListObjects returns the objects of a namespace to which a subject has a relation
+func (h *RelationHandler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	serve(h, w, r, h.Handler.AuthzListObjects)
+}
+
+// Write adds and deletes tuples and returns the zookie of the new snapshot
+func (h *RelationHandler) Write(w http.ResponseWriter, r *http.Request) {
+	serve(h, w, r, func(tenantID uuid.UUID, request authz.WriteRequest) (*authz.WriteResponse, error) {
+		zookie, err := h.Handler.AuthzWrite(tenantID, request)
+		if err != nil {
+			return nil, err
+		}
+		return &authz.WriteResponse{Zookie: zookie}, nil
+	})
+}
@@ -0,0 +1,187 @@
+package scim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkRequest runs several operations in one request (RFC 7644, section 3.7)
+type BulkRequest struct {
+	Schemas      []string        `json:"schemas"`
+	FailOnErrors int             `json:"failOnErrors"`
+	Operations   []BulkOperation `json:"Operations"`
+}
+
+type BulkOperation struct {
+	Method string          `json:"method"`
+	BulkID string          `json:"bulkId,omitempty"`
+	Path   string          `json:"path"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	// Version is the ETag the resource must have
+	Version string `json:"version,omitempty"`
+}
+
+type BulkResponse struct {
+	Schemas    []string     `json:"schemas"`
+	Operations []BulkResult `json:"Operations"`
+}
+
+type BulkResult struct {
+	Method   string          `json:"method"`
+	BulkID   string          `json:"bulkId,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	Location string          `json:"location,omitempty"`
+	Status   string          `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// bulkReferencePrefix starts references to resources created by earlier operations, e.g. "bulkId:qwerty"
+const bulkReferencePrefix = "bulkId:"
+
+// resolveBulkReferences replaces the string values of data that are exactly a reference. Strings that only
+// contain a reference, e.g. an externalId, are kept.
+func resolveBulkReferences(data json.RawMessage, resolve func(string) string) json.RawMessage {
+	if len(data) == 0 {
+		return data
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if decoder.Decode(&value) != nil {
+		// The operation answers the syntax error
+		return data
+	}
+	resolved, err := json.Marshal(resolveBulkValue(value, resolve))
+	if err != nil {
+		return data
+	}
+	return resolved
+}
+
+func resolveBulkValue(value interface{}, resolve func(string) string) interface{} {
+	switch value := value.(type) {
+	case string:
+		if strings.HasPrefix(value, bulkReferencePrefix) {
+			return resolve(value)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = resolveBulkValue(value[i], resolve)
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = resolveBulkValue(value[key], resolve)
+		}
+	}
+	return value
+}
+
+// route returns the handler of a bulk operation and the ID of its resource
+func (h *ScimHandler) route(method string, path string) (http.HandlerFunc, string) {
+	resourceType, id, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	handlers := map[string]map[string]http.HandlerFunc{
+		"Users":  {http.MethodPost: h.UserCreate, http.MethodPut: h.UserReplace, http.MethodPatch: h.UserPatch, http.MethodDelete: h.UserDelete},
+		"Groups": {http.MethodPost: h.GroupCreate, http.MethodPut: h.GroupReplace, http.MethodPatch: h.GroupPatch, http.MethodDelete: h.GroupDelete},
+	}[resourceType]
+	if (method == http.MethodPost) != (id == "") || strings.Contains(id, "/") {
+		return nil, ""
+	}
+	return handlers[method], id
+}
+
+// Bulk runs the operations in order. Operations may reference resources created by earlier operations
+// with "bulkId:<bulkId>" as the ID of the path or as a string value of the data. After failOnErrors failed operations the remaining ones are skipped.
+func (h *ScimHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	var request BulkRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBulkPayload)).Decode(&request)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "", "The maximum payload size is "+strconv.Itoa(MaxBulkPayload)+" bytes")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if len(request.Operations) > MaxBulkOperations {
+		writeError(w, http.StatusRequestEntityTooLarge, "", "The maximum number of operations is "+strconv.Itoa(MaxBulkOperations))
+		return
+	}
+
+	base := strings.TrimSuffix(r.URL.Path, "/Bulk")
+	created := map[string]string{}
+	response := BulkResponse{Schemas: []string{SchemaBulkResponse}, Operations: []BulkResult{}}
+	failures := 0
+	for _, operation := range request.Operations {
+		if request.FailOnErrors > 0 && failures >= request.FailOnErrors {
+			break
+		}
+
+		method := strings.ToUpper(operation.Method)
+		result := BulkResult{Method: method, BulkID: operation.BulkID}
+
+		// Replaces references to created resources, unknown references fail the operation
+		unresolved := ""
+		resolve := func(reference string) string {
+			id, ok := created[strings.TrimPrefix(reference, bulkReferencePrefix)]
+			if !ok {
+				unresolved = reference
+				return reference
+			}
+			return id
+		}
+		path := operation.Path
+		if resourceType, id, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/"); ok && strings.HasPrefix(id, bulkReferencePrefix) {
+			path = "/" + resourceType + "/" + resolve(id)
+		}
+		data := resolveBulkReferences(operation.Data, resolve)
+
+		recorder := httptest.NewRecorder()
+		handle, id := h.route(method, path)
+		switch {
+		case method == http.MethodPost && operation.BulkID == "":
+			writeError(recorder, http.StatusBadRequest, "invalidValue", "POST operations need a bulkId")
+		case unresolved != "":
+			writeError(recorder, http.StatusConflict, "invalidValue", "Unresolved reference "+unresolved)
+		case handle == nil:
+			writeError(recorder, http.StatusBadRequest, "invalidPath", "Unsupported operation "+method+" "+operation.Path)
+		default:
+			internal, err := http.NewRequestWithContext(r.Context(), method, base+path, bytes.NewReader(data))
+			if err != nil {
+				writeError(recorder, http.StatusBadRequest, "invalidPath", err.Error())
+				break
+			}
+			internal.Host = r.Host
+			internal.TLS = r.TLS
+			internal.Header.Set("Content-Type", ContentType)
+			if operation.Version != "" {
+				internal.Header.Set("If-Match", operation.Version)
+			}
+			handle(recorder, mux.SetURLVars(internal, map[string]string{"id": id}))
+		}
+
+		result.Status = strconv.Itoa(recorder.Code)
+		result.Location = recorder.Header().Get("Location")
+		result.Version = recorder.Header().Get("ETag")
+		if recorder.Code >= http.StatusBadRequest {
+			failures++
+			result.Response = json.RawMessage(bytes.TrimSpace(recorder.Body.Bytes()))
+		} else if method == http.MethodPost {
+			var resource struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &resource)
+			created[operation.BulkID] = resource.ID
+		}
+		response.Operations = append(response.Operations, result)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,164 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestResolveBulkReferences(t *testing.T) {
+	created := map[string]string{"alice": "u-1", "eng": "g-1"}
+	var unresolved []string
+	resolve := func(reference string) string {
+		id, ok := created[strings.TrimPrefix(reference, bulkReferencePrefix)]
+		if !ok {
+			unresolved = append(unresolved, reference)
+			return reference
+		}
+		return id
+	}
+
+	tests := []struct {
+		data       string
+		want       string
+		unresolved []string
+	}{
+		{`{"members": [{"value": "bulkId:alice"}, {"value": "u-2"}]}`, `{"members":[{"value":"u-1"},{"value":"u-2"}]}`, nil},
+		{`{"groups": ["bulkId:eng"], "manager": {"value": "bulkId:alice"}}`, `{"groups":["g-1"],"manager":{"value":"u-1"}}`, nil},
+		// Only whole values are references
+		{`{"externalId": "imported bulkId:alice", "displayName": "bulkId:alice/2", "title": "xbulkId:eng"}`,
+			`{"displayName":"bulkId:alice/2","externalId":"imported bulkId:alice","title":"xbulkId:eng"}`, []string{"bulkId:alice/2"}},
+		{`{"bulkId:alice": 1.50, "count": 12345678901234567890}`, `{"bulkId:alice":1.50,"count":12345678901234567890}`, nil},
+		{`{"value": "bulkId:bob"}`, `{"value":"bulkId:bob"}`, []string{"bulkId:bob"}},
+		{`{"value": "bulkId:"}`, `{"value":"bulkId:"}`, []string{"bulkId:"}},
+		{`not json "bulkId:alice"`, `not json "bulkId:alice"`, nil},
+		{``, ``, nil},
+	}
+	for _, test := range tests {
+		unresolved = nil
+		got := resolveBulkReferences(json.RawMessage(test.data), resolve)
+		if string(got) != test.want {
+			t.Errorf("%s = %s, want %s", test.data, got, test.want)
+		}
+		if strings.Join(unresolved, ",") != strings.Join(test.unresolved, ",") {
+			t.Errorf("%s: unresolved %v, want %v", test.data, unresolved, test.unresolved)
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ScimHandler{Handler: handler.NewHandler(gormDB)}
+	tenantID := uuid.New()
+	groupID := uuid.New()
+
+	// POST /Groups creates the group, its externalId only contains a reference and is kept
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "groups"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "groups"`).
+		WithArgs(tenantID, "eng", "", "legacy bulkId:eng", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(groupID))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`DELETE FROM "user_groups"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM "user_groups" JOIN users`).WillReturnRows(sqlmock.NewRows([]string{"group_id", "user_id", "display_name"}))
+	// DELETE /Groups/bulkId:eng deletes the created group
+	mock.ExpectQuery(`SELECT \* FROM "groups" WHERE id = \$1 AND tenant_id = \$2`).WithArgs(groupID.String(), tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "name", "updated_at"}).AddRow(groupID, tenantID, "eng", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "user_groups"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "group_roles"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "groups"`).WithArgs(groupID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := `{
+		"schemas": ["` + SchemaBulkRequest + `"],
+		"failOnErrors": 2,
+		"Operations": [
+			{"method": "POST", "bulkId": "eng", "path": "/Groups", "data": {"displayName": "eng", "externalId": "legacy bulkId:eng"}},
+			{"method": "delete", "path": "/Groups/bulkId:eng"},
+			{"method": "POST", "path": "/Groups", "data": {"displayName": "ops"}},
+			{"method": "PATCH", "path": "/Groups/bulkId:ops", "data": {"Operations": []}},
+			{"method": "DELETE", "path": "/Groups/` + uuid.NewString() + `"}
+		]
+	}`
+	request := httptest.NewRequest(http.MethodPost, "https://acme.example.com/scim/v2/Bulk", strings.NewReader(body))
+	request = request.WithContext(context.WithValue(request.Context(), tenantKey, tenantID))
+	recorder := httptest.NewRecorder()
+	h.Bulk(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+	var response BulkResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	// The last operation is skipped after failOnErrors failures
+	want := []struct {
+		method string
+		bulkID string
+		status string
+	}{
+		{http.MethodPost, "eng", "201"},
+		{http.MethodDelete, "", "204"},
+		{http.MethodPost, "", "400"},
+		{http.MethodPatch, "", "409"},
+	}
+	if len(response.Operations) != len(want) {
+		t.Fatalf("got %d results: %s", len(response.Operations), recorder.Body)
+	}
+	for i, result := range response.Operations {
+		if result.Method != want[i].method || result.BulkID != want[i].bulkID || result.Status != want[i].status {
+			t.Errorf("result %d = %s %s %s, want %+v", i, result.Method, result.BulkID, result.Status, want[i])
+		}
+	}
+	if location := response.Operations[0].Location; location != "https://acme.example.com/scim/v2/Groups/"+groupID.String() {
+		t.Errorf("location %s", location)
+	}
+	if !strings.Contains(string(response.Operations[3].Response), "Unresolved reference bulkId:ops") {
+		t.Errorf("response %s", response.Operations[3].Response)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBulkLimits(t *testing.T) {
+	h := &ScimHandler{}
+	operations := strings.Repeat(`{"method": "DELETE", "path": "/Groups/x"},`, MaxBulkOperations+1)
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"Operations": [` + strings.TrimSuffix(operations, ",") + `]}`, http.StatusRequestEntityTooLarge},
+		{`{"Operations": [{"data": "` + strings.Repeat("x", MaxBulkPayload) + `"}]}`, http.StatusRequestEntityTooLarge},
+		{`{"Operations": `, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		h.Bulk(recorder, httptest.NewRequest(http.MethodPost, "/scim/v2/Bulk", strings.NewReader(test.body)))
+		if recorder.Code != test.status {
+			t.Errorf("status %d, want %d: %.200s", recorder.Code, test.status, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of filterable attributes
+const (
+	kindString = iota
+	kindBool
+	kindTime
+	// kindMember compares the user IDs of the members of a group
+	kindMember
+)
+
+// column maps a SCIM attribute to its SQL expression
+type column struct {
+	expr      string
+	kind      int
+	caseExact bool
+}
+
+// FilterError is returned for filters that cannot be parsed or name unknown attributes
+type FilterError struct {
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return e.Message
+}
+
+var userColumns = map[string]column{
+	"id":                {expr: "users.id::text", caseExact: true},
+	"externalid":        {expr: "users.external_id", caseExact: true},
+	"username":          {expr: "users.email"},
+	"emails":            {expr: "users.email"},
+	"emails.value":      {expr: "users.email"},
+	"name.givenname":    {expr: "users.first_name"},
+	"name.familyname":   {expr: "users.last_name"},
+	"name.formatted":    {expr: "users.display_name"},
+	"displayname":       {expr: "users.display_name"},
+	"locale":            {expr: "users.locale"},
+	"active":            {expr: "users.is_active", kind: kindBool},
+	"meta.created":      {expr: "users.created_at", kind: kindTime},
+	"meta.lastmodified": {expr: "users.updated_at", kind: kindTime},
+	"groups.value":      {expr: "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.user_id = users.id AND user_groups.group_id::text %s)", kind: kindMember},
+	"groups":            {expr: "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.user_id = users.id AND user_groups.group_id::text %s)", kind: kindMember},
+}
+
+var groupColumns = map[string]column{
+	"id":                {expr: "groups.id::text", caseExact: true},
+	"externalid":        {expr: "groups.external_id", caseExact: true},
+	"displayname":       {expr: "groups.name"},
+	"meta.created":      {expr: "groups.created_at", kind: kindTime},
+	"meta.lastmodified": {expr: "groups.updated_at", kind: kindTime},
+	"members":           {expr: "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.group_id = groups.id AND user_groups.user_id::text %s)", kind: kindMember},
+	"members.value":     {expr: "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.group_id = groups.id AND user_groups.user_id::text %s)", kind: kindMember},
+}
+
+// filterToken is a word, a quoted string or a parenthesis of a filter
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(filter) {
+				return nil, &FilterError{Message: "unterminated string"}
+			}
+			var text string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &text); err != nil {
+				return nil, &FilterError{Message: "invalid string " + filter[i:end+1]}
+			}
+			tokens = append(tokens, filterToken{text: text, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t\n()\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser compiles a SCIM filter (RFC 7644, section 3.4.2.2) to an SQL condition
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	schema  string
+	columns map[string]column
+	args    []interface{}
+}
+
+// compileFilter returns the SQL condition and arguments of a filter on the attributes of the schema
+func compileFilter(filter string, schema string, columns map[string]column) (string, []interface{}, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	parser := &filterParser{tokens: tokens, schema: schema, columns: columns}
+	condition, err := parser.or()
+	if err != nil {
+		return "", nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return "", nil, &FilterError{Message: "unexpected " + parser.tokens[parser.pos].text}
+	}
+	return condition, parser.args, nil
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token if it is the unquoted keyword
+func (p *filterParser) keyword(keyword string) bool {
+	token, ok := p.peek()
+	if ok && !token.quoted && strings.EqualFold(token.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) or() (string, error) {
+	left, err := p.and()
+	if err != nil {
+		return "", err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (string, error) {
+	left, err := p.not()
+	if err != nil {
+		return "", err
+	}
+	for p.keyword("and") {
+		right, err := p.not()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) not() (string, error) {
+	if p.keyword("not") {
+		if !p.keyword("(") {
+			return "", &FilterError{Message: "not needs a parenthesized filter"}
+		}
+		condition, err := p.group()
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + condition, nil
+	}
+	if p.keyword("(") {
+		return p.group()
+	}
+	return p.comparison()
+}
+
+// group parses a filter after an opening parenthesis
+func (p *filterParser) group() (string, error) {
+	condition, err := p.or()
+	if err != nil {
+		return "", err
+	}
+	if !p.keyword(")") {
+		return "", &FilterError{Message: "missing )"}
+	}
+	return "(" + condition + ")", nil
+}
+
+// attribute resolves an attribute path, optionally prefixed with the schema URN
+func (p *filterParser) attribute(path string) (column, error) {
+	name := strings.ToLower(path)
+	if prefix := strings.ToLower(p.schema) + ":"; strings.HasPrefix(name, prefix) {
+		name = name[len(prefix):]
+	}
+	column, ok := p.columns[name]
+	if !ok {
+		return column, &FilterError{Message: "unknown attribute " + path}
+	}
+	return column, nil
+}
+
+func (p *filterParser) comparison() (string, error) {
+	token, ok := p.peek()
+	if !ok || token.quoted {
+		return "", &FilterError{Message: "expected an attribute"}
+	}
+	p.pos++
+	column, err := p.attribute(token.text)
+	if err != nil {
+		return "", err
+	}
+
+	operator, ok := p.peek()
+	if !ok || operator.quoted {
+		return "", &FilterError{Message: "expected an operator after " + token.text}
+	}
+	p.pos++
+	op := strings.ToLower(operator.text)
+
+	if op == "pr" {
+		switch column.kind {
+		case kindString:
+			return column.expr + " <> ''", nil
+		case kindMember:
+			return fmt.Sprintf(column.expr, "IS NOT NULL"), nil
+		}
+		return column.expr + " IS NOT NULL", nil
+	}
+
+	value, ok := p.peek()
+	if !ok {
+		return "", &FilterError{Message: "expected a value after " + operator.text}
+	}
+	p.pos++
+	return p.compare(column, op, value)
+}
+
+var comparisonOperators = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+func (p *filterParser) compare(column column, op string, value filterToken) (string, error) {
+	switch column.kind {
+	case kindBool:
+		if value.quoted || (value.text != "true" && value.text != "false") || (op != "eq" && op != "ne") {
+			return "", &FilterError{Message: "boolean attributes only support eq, ne and pr with true or false"}
+		}
+		p.args = append(p.args, value.text == "true")
+		return column.expr + " " + comparisonOperators[op] + " ?", nil
+	case kindTime:
+		sqlOp, ok := comparisonOperators[op]
+		if !ok {
+			return "", &FilterError{Message: "date attributes do not support " + op}
+		}
+		at, err := time.Parse(time.RFC3339, value.text)
+		if !value.quoted || err != nil {
+			return "", &FilterError{Message: "invalid date " + value.text}
+		}
+		p.args = append(p.args, at)
+		return column.expr + " " + sqlOp + " ?", nil
+	}
+
+	if !value.quoted {
+		return "", &FilterError{Message: "expected a string instead of " + value.text}
+	}
+	// Member IDs are compared inside the subquery of the column, e.g. members.value eq "id" holds if
+	// any member has the ID
+	expr := column.expr
+	if column.kind == kindMember {
+		expr = ""
+	}
+	arg := value.text
+	if !column.caseExact {
+		if column.kind != kindMember {
+			expr = "LOWER(" + expr + ")"
+		}
+		arg = strings.ToLower(arg)
+	}
+
+	var condition string
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	switch op {
+	case "co":
+		condition, arg = "LIKE ?", "%"+replacer.Replace(arg)+"%"
+	case "sw":
+		condition, arg = "LIKE ?", replacer.Replace(arg)+"%"
+	case "ew":
+		condition, arg = "LIKE ?", "%"+replacer.Replace(arg)
+	default:
+		sqlOp, ok := comparisonOperators[op]
+		if !ok {
+			return "", &FilterError{Message: "unknown operator " + op}
+		}
+		condition = sqlOp + " ?"
+	}
+	p.args = append(p.args, arg)
+
+	if column.kind == kindMember {
+		return fmt.Sprintf(column.expr, condition), nil
+	}
+	return expr + " " + condition, nil
+}
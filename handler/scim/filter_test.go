@@ -0,0 +1,109 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileFilter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		filter    string
+		condition string
+		args      []interface{}
+	}{
+		{`userName eq "Alice@Example.com"`, "LOWER(users.email) = ?", []interface{}{"alice@example.com"}},
+		{`USERNAME EQ "alice"`, "LOWER(users.email) = ?", []interface{}{"alice"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName ne "alice"`, "LOWER(users.email) <> ?", []interface{}{"alice"}},
+		{`id eq "0A1B"`, "users.id::text = ?", []interface{}{"0A1B"}},
+		{`externalId eq "Ext-1"`, "users.external_id = ?", []interface{}{"Ext-1"}},
+		{`emails.value ew "@example.com"`, "LOWER(users.email) LIKE ?", []interface{}{"%@example.com"}},
+		{`name.familyName co "Doe"`, "LOWER(users.last_name) LIKE ?", []interface{}{"%doe%"}},
+		{`displayName sw "a_b%c\\"`, "LOWER(users.display_name) LIKE ?", []interface{}{`a\_b\%c\\%`}},
+		{`userName gt "m"`, "LOWER(users.email) > ?", []interface{}{"m"}},
+		{`name.givenName eq "with \"quotes\" and (parens)"`, "LOWER(users.first_name) = ?", []interface{}{`with "quotes" and (parens)`}},
+		{`active eq true`, "users.is_active = ?", []interface{}{true}},
+		{`active ne false`, "users.is_active <> ?", []interface{}{false}},
+		{`meta.created ge "2024-01-02T03:04:05Z"`, "users.created_at >= ?", []interface{}{created}},
+		{`meta.lastModified lt "2024-01-02T04:04:05+01:00"`, "users.updated_at < ?", []interface{}{created.In(time.FixedZone("", 3600))}},
+		{`displayName pr`, "users.display_name <> ''", nil},
+		{`active pr`, "users.is_active IS NOT NULL", nil},
+		{`groups pr`, "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.user_id = users.id AND user_groups.group_id::text IS NOT NULL)", nil},
+		{`groups.value eq "0A1B"`, "EXISTS (SELECT 1 FROM user_groups WHERE user_groups.user_id = users.id AND user_groups.group_id::text = ?)", []interface{}{"0a1b"}},
+		{`userName eq "a" or userName eq "b" and active eq true`, "(LOWER(users.email) = ? OR (LOWER(users.email) = ? AND users.is_active = ?))", []interface{}{"a", "b", true}},
+		{`(userName eq "a" or userName eq "b") and active eq true`, "(((LOWER(users.email) = ? OR LOWER(users.email) = ?)) AND users.is_active = ?)", []interface{}{"a", "b", true}},
+		{`not (active eq true) and displayName pr`, "(NOT (users.is_active = ?) AND users.display_name <> '')", []interface{}{true}},
+		{`userName eq "a" AND userName eq "b" OR userName eq "c"`, "((LOWER(users.email) = ? AND LOWER(users.email) = ?) OR LOWER(users.email) = ?)", []interface{}{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		condition, args, err := compileFilter(test.filter, SchemaUser, userColumns)
+		if err != nil {
+			t.Errorf("%s: %v", test.filter, err)
+			continue
+		}
+		if condition != test.condition {
+			t.Errorf("%s = %s, want %s", test.filter, condition, test.condition)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s args = %#v, want %#v", test.filter, args, test.args)
+		}
+	}
+}
+
+func TestCompileGroupFilter(t *testing.T) {
+	condition, args, err := compileFilter(`displayName eq "Eng" and members eq "0A1B"`, SchemaGroup, groupColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(LOWER(groups.name) = ? AND EXISTS (SELECT 1 FROM user_groups WHERE user_groups.group_id = groups.id AND user_groups.user_id::text = ?))"
+	if condition != want || !reflect.DeepEqual(args, []interface{}{"eng", "0a1b"}) {
+		t.Errorf("condition %s %v", condition, args)
+	}
+
+	// Attributes of users are unknown in group filters
+	if _, _, err := compileFilter(`userName eq "alice"`, SchemaGroup, groupColumns); err == nil {
+		t.Error("userName accepted in a group filter")
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{``, "expected an attribute"},
+		{`"userName" eq "alice"`, "expected an attribute"},
+		{`password eq "secret"`, "unknown attribute password"},
+		{`users.email eq "alice"`, "unknown attribute users.email"},
+		{`email);DROP eq "x"`, "unknown attribute email"},
+		{`urn:ietf:params:scim:schemas:core:2.0:Group:displayName eq "x"`, "unknown attribute"},
+		{`userName`, "expected an operator after userName"},
+		{`userName "eq" "alice"`, "expected an operator"},
+		{`userName eq`, "expected a value after eq"},
+		{`userName xx "alice"`, "unknown operator xx"},
+		{`userName eq alice`, "expected a string instead of alice"},
+		{`userName eq "alice`, "unterminated string"},
+		{`userName eq "alice\"`, "unterminated string"},
+		{`userName eq "\q"`, `invalid string "\q"`},
+		{`active eq "true"`, "boolean attributes"},
+		{`active eq yes`, "boolean attributes"},
+		{`active gt true`, "boolean attributes"},
+		{`meta.created gt 2024`, "invalid date 2024"},
+		{`meta.created gt "yesterday"`, "invalid date yesterday"},
+		{`meta.created co "2024"`, "date attributes do not support co"},
+		{`(userName pr`, "missing )"},
+		{`not userName pr`, "not needs a parenthesized filter"},
+		{`userName pr displayName pr`, "unexpected displayName"},
+		{`userName pr and`, "expected an attribute"},
+		{`userName pr)`, "unexpected )"},
+	}
+	for _, test := range tests {
+		_, _, err := compileFilter(test.filter, SchemaUser, userColumns)
+		filterErr, ok := err.(*FilterError)
+		if !ok || !strings.Contains(filterErr.Message, test.err) {
+			t.Errorf("%s = %v, want %q", test.filter, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package scim
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// Group is the SCIM representation of a group. The displayName is the name of the group.
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// groupMembers returns the members of the groups by group ID
+func (h *ScimHandler) groupMembers(r *http.Request, groupIDs []uuid.UUID) (map[uuid.UUID][]Reference, error) {
+	var rows []struct {
+		GroupID     uuid.UUID
+		UserID      uuid.UUID
+		DisplayName string
+	}
+	err := h.Handler.DB.Table("user_groups").
+		Select("user_groups.group_id, users.id AS user_id, users.display_name").
+		Joins("JOIN users ON users.id = user_groups.user_id AND users.deleted_at IS NULL").
+		Where("user_groups.group_id IN ?", groupIDs).
+		Order("users.display_name, users.id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[uuid.UUID][]Reference{}
+	for _, row := range rows {
+		members[row.GroupID] = append(members[row.GroupID], Reference{
+			Value:   row.UserID.String(),
+			Ref:     location(r, "/Users/"+row.UserID.String()),
+			Display: row.DisplayName,
+		})
+	}
+	return members, nil
+}
+
+// excludesMembers reports whether the request asks to leave out the members, e.g. of large groups
+func excludesMembers(r *http.Request) bool {
+	for _, attribute := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+func scimGroup(r *http.Request, group *models.Group, members []Reference) Group {
+	return Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.Name,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     location(r, "/Groups/"+group.ID.String()),
+			Version:      version(group.UpdatedAt),
+		},
+	}
+}
+
+// writeGroup answers with the SCIM representation of the group
+func (h *ScimHandler) writeGroup(w http.ResponseWriter, r *http.Request, status int, group *models.Group) {
+	var members []Reference
+	if !excludesMembers(r) {
+		groupMembers, err := h.groupMembers(r, []uuid.UUID{group.ID})
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		members = groupMembers[group.ID]
+	}
+
+	resource := scimGroup(r, group, members)
+	if status == http.StatusOK && notModified(w, r, resource.Meta.Version) {
+		return
+	}
+	writeResource(w, status, resource.Meta, resource)
+}
+
+// loadGroup returns the group of the {id} route parameter within the tenant of the SCIM token
+func (h *ScimHandler) loadGroup(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	id := mux.Vars(r)["id"]
+	var group models.Group
+	if _, err := uuid.Parse(id); err != nil || h.Handler.DB.Where("id = ? AND tenant_id = ?", id, tenant(r)).First(&group).Error != nil {
+		writeError(w, http.StatusNotFound, "", "Group not found")
+		return nil, false
+	}
+	return &group, true
+}
+
+// applyGroup checks the name of the resource and sets the fields of group
+func (h *ScimHandler) applyGroup(group *models.Group, resource *Group) error {
+	if !handler.RBACNamePattern.MatchString(resource.DisplayName) {
+		return invalidValue("displayName must be a valid group name: letters, digits and _.:/-")
+	}
+	var count int64
+	h.Handler.DB.Model(&models.Group{}).Where("tenant_id = ? AND name = ? AND id <> ?", group.TenantID, resource.DisplayName, group.ID).Count(&count)
+	if count > 0 {
+		return &resourceError{status: http.StatusConflict, scimType: "uniqueness", detail: "A group with this displayName exists"}
+	}
+
+	group.Name = resource.DisplayName
+	group.ExternalID = resource.ExternalID
+	return nil
+}
+
+// setMembers replaces the members of the group with the users of the references
+func setMembers(tx *gorm.DB, group *models.Group, members []Reference) error {
+	userIDs := []uuid.UUID{}
+	for _, member := range members {
+		userID, err := uuid.Parse(member.Value)
+		if err != nil {
+			return invalidValue("invalid member " + member.Value)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	var count int64
+	err := tx.Model(&models.User{}).Where("tenant_id = ? AND id IN ?", group.TenantID, userIDs).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if len(userIDs) > 0 && count != int64(len(uniqueIDs(userIDs))) {
+		return invalidValue("members must be users of the tenant")
+	}
+
+	if err := tx.Where("group_id = ? AND user_id NOT IN ?", group.ID, append(userIDs, uuid.Nil)).Delete(&models.UserGroup{}).Error; err != nil {
+		return err
+	}
+	for _, userID := range uniqueIDs(userIDs) {
+		membership := models.UserGroup{TenantID: group.TenantID}
+		if err := tx.FirstOrCreate(&membership, models.UserGroup{GroupID: group.ID, UserID: userID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := map[uuid.UUID]bool{}
+	unique := []uuid.UUID{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// Groups lists the groups of the tenant, filtered with filter and paginated with startIndex and count
+func (h *ScimHandler) Groups(w http.ResponseWriter, r *http.Request) {
+	query := h.Handler.DB.Model(&models.Group{}).Where("groups.tenant_id = ?", tenant(r))
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		condition, args, err := compileFilter(filter, SchemaGroup, groupColumns)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		query = query.Where(condition, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	startIndex, count := pagination(r)
+	var groups []models.Group
+	if count > 0 {
+		if err := query.Order("groups.created_at, groups.id").Offset(startIndex - 1).Limit(count).Find(&groups).Error; err != nil {
+			writeFailure(w, err)
+			return
+		}
+	}
+
+	members := map[uuid.UUID][]Reference{}
+	if !excludesMembers(r) {
+		groupIDs := make([]uuid.UUID, len(groups))
+		for i := range groups {
+			groupIDs[i] = groups[i].ID
+		}
+		var err error
+		if members, err = h.groupMembers(r, groupIDs); err != nil {
+			writeFailure(w, err)
+			return
+		}
+	}
+
+	list := ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: startIndex, Resources: []interface{}{}}
+	for i := range groups {
+		list.Resources = append(list.Resources, scimGroup(r, &groups[i], members[groups[i].ID]))
+	}
+	list.ItemsPerPage = len(list.Resources)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Group returns a group of the tenant
+func (h *ScimHandler) Group(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+	h.writeGroup(w, r, http.StatusOK, group)
+}
+
+// GroupCreate creates a group with its members
+func (h *ScimHandler) GroupCreate(w http.ResponseWriter, r *http.Request) {
+	var resource Group
+	if !decode(w, r, &resource) {
+		return
+	}
+
+	group := models.Group{TenantID: tenant(r)}
+	if err := h.applyGroup(&group, &resource); err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return setMembers(tx, &group, resource.Members)
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	h.writeGroup(w, r, http.StatusCreated, &group)
+}
+
+// saveGroup stores the resource as the new state of the group
+func (h *ScimHandler) saveGroup(w http.ResponseWriter, r *http.Request, group *models.Group, resource *Group) {
+	if err := h.applyGroup(group, resource); err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		// Updates also moves updated_at, the version of the group, when only the members change
+		err := tx.Model(group).Updates(map[string]interface{}{"name": group.Name, "external_id": group.ExternalID}).Error
+		if err != nil {
+			return err
+		}
+		return setMembers(tx, group, resource.Members)
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	h.writeGroup(w, r, http.StatusOK, group)
+}
+
+// GroupReplace replaces the name and members of a group (PUT)
+func (h *ScimHandler) GroupReplace(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok || preconditionFailed(w, r, version(group.UpdatedAt)) {
+		return
+	}
+
+	var resource Group
+	if !decode(w, r, &resource) {
+		return
+	}
+	h.saveGroup(w, r, group, &resource)
+}
+
+// GroupPatch changes a group with PATCH operations, e.g. adds or removes members
+func (h *ScimHandler) GroupPatch(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok || preconditionFailed(w, r, version(group.UpdatedAt)) {
+		return
+	}
+
+	var request PatchRequest
+	if !decode(w, r, &request) {
+		return
+	}
+
+	members, err := h.groupMembers(r, []uuid.UUID{group.ID})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	var resource Group
+	err = patchResource(scimGroup(r, group, members[group.ID]), &resource, &request, SchemaGroup, nil)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	h.saveGroup(w, r, group, &resource)
+}
+
+// GroupDelete deletes a group with its memberships and role assignments
+func (h *ScimHandler) GroupDelete(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok || preconditionFailed(w, r, version(group.UpdatedAt)) {
+		return
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.UserGroup{}, &models.GroupRole{}} {
+			if err := tx.Where("group_id = ?", group.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,323 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+// PatchRequest changes a resource with add, replace and remove operations (RFC 7644, section 3.5.2)
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// resourceError is answered with its status and SCIM error type
+type resourceError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *resourceError) Error() string {
+	return e.detail
+}
+
+func invalidValue(detail string) error {
+	return &resourceError{status: http.StatusBadRequest, scimType: "invalidValue", detail: detail}
+}
+
+// writeFailure answers an error of a resource operation
+func writeFailure(w http.ResponseWriter, err error) {
+	var resourceErr *resourceError
+	var filterErr *FilterError
+	var attributeErr *handler.AttributeError
+	var passwordErr *helper.PasswordPolicyError
+	switch {
+	case errors.As(err, &resourceErr):
+		writeError(w, resourceErr.status, resourceErr.scimType, resourceErr.detail)
+	case errors.As(err, &filterErr):
+		writeError(w, http.StatusBadRequest, "invalidFilter", filterErr.Message)
+	case errors.As(err, &attributeErr):
+		writeError(w, http.StatusBadRequest, "invalidValue", attributeErr.Message)
+	case errors.As(err, &passwordErr):
+		writeError(w, http.StatusBadRequest, "invalidValue", passwordErr.Error())
+	default:
+		writeError(w, handler.StatusFor(err, http.StatusInternalServerError), "", err.Error())
+	}
+}
+
+// patchPathPattern matches attribute paths like emails[type eq "work"].value
+var patchPathPattern = regexp.MustCompile(`^([A-Za-z][\w$-]*)(?:\[\s*([A-Za-z][\w$-]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*"|true|false)\s*\])?(?:\.([A-Za-z][\w$-]*))?$`)
+
+// patchPath is a parsed attribute path. With a filter, the operation targets the values of the
+// multi-valued attribute whose filterAttribute equals filterValue.
+type patchPath struct {
+	attribute       string
+	filterAttribute string
+	filterValue     interface{}
+	subAttribute    string
+}
+
+// parsePatchPath parses a path relative to the resource. Paths of the extensions are nested below the
+// extension URN, paths of other schemas are not supported.
+func parsePatchPath(path string, schema string, extensions []string) (*patchPath, error) {
+	if rest, ok := cutPrefixFold(path, schema+":"); ok {
+		path = rest
+	}
+	for _, extension := range extensions {
+		if strings.EqualFold(path, extension) {
+			return &patchPath{attribute: extension}, nil
+		}
+		if rest, ok := cutPrefixFold(path, extension+":"); ok {
+			return &patchPath{attribute: extension, subAttribute: rest}, nil
+		}
+	}
+
+	match := patchPathPattern.FindStringSubmatch(path)
+	if match == nil {
+		return nil, &resourceError{status: http.StatusBadRequest, scimType: "invalidPath", detail: "unsupported path " + path}
+	}
+	parsed := &patchPath{attribute: match[1], filterAttribute: match[2], subAttribute: match[4]}
+	if match[3] != "" {
+		json.Unmarshal([]byte(match[3]), &parsed.filterValue)
+	}
+	return parsed, nil
+}
+
+func cutPrefixFold(value string, prefix string) (string, bool) {
+	if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+		return value[len(prefix):], true
+	}
+	return value, false
+}
+
+// key returns the key of the attribute in the resource, attribute names are case-insensitive
+func key(resource map[string]interface{}, name string) string {
+	for existing := range resource {
+		if strings.EqualFold(existing, name) {
+			return existing
+		}
+	}
+	return name
+}
+
+// sameValue compares values of multi-valued attributes, by their "value" if they are complex
+func sameValue(a interface{}, b interface{}) bool {
+	if complex, ok := a.(map[string]interface{}); ok {
+		a = complex[key(complex, "value")]
+	}
+	if complex, ok := b.(map[string]interface{}); ok {
+		b = complex[key(complex, "value")]
+	}
+	textA, okA := a.(string)
+	textB, okB := b.(string)
+	if okA && okB {
+		return strings.EqualFold(textA, textB)
+	}
+	return a == b
+}
+
+// applyPatch applies the operations to the JSON form of a resource
+func applyPatch(resource map[string]interface{}, request *PatchRequest, schema string, extensions []string) error {
+	for _, operation := range request.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return invalidValue("unknown operation " + operation.Op)
+		}
+
+		var value interface{}
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return &resourceError{status: http.StatusBadRequest, scimType: "invalidSyntax", detail: err.Error()}
+			}
+		}
+
+		if operation.Path == "" {
+			values, ok := value.(map[string]interface{})
+			if !ok || op == "remove" {
+				return &resourceError{status: http.StatusBadRequest, scimType: "noTarget", detail: "operations without path need an object value"}
+			}
+			for name, attributeValue := range values {
+				path, err := parsePatchPath(name, schema, extensions)
+				if err != nil {
+					return err
+				}
+				// Values of an extension without path are the attributes of the extension
+				if extension, ok := attributeValue.(map[string]interface{}); ok && path.subAttribute == "" && strings.HasPrefix(path.attribute, "urn:") {
+					for sub, subValue := range extension {
+						if err := applyOperation(resource, op, &patchPath{attribute: path.attribute, subAttribute: sub}, subValue); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				if err := applyOperation(resource, op, path, attributeValue); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path, err := parsePatchPath(operation.Path, schema, extensions)
+		if err != nil {
+			return err
+		}
+		if err := applyOperation(resource, op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource map[string]interface{}, op string, path *patchPath, value interface{}) error {
+	name := key(resource, path.attribute)
+
+	if path.filterAttribute == "" {
+		if path.subAttribute != "" {
+			container, _ := resource[name].(map[string]interface{})
+			if container == nil {
+				if op == "remove" {
+					return nil
+				}
+				container = map[string]interface{}{}
+				resource[name] = container
+			}
+			return applyOperation(container, op, &patchPath{attribute: path.subAttribute}, value)
+		}
+
+		existing, exists := resource[name]
+		switch op {
+		case "remove":
+			values, isList := existing.([]interface{})
+			removed, hasValues := value.([]interface{})
+			if !isList || !hasValues {
+				delete(resource, name)
+				return nil
+			}
+			// Removes the listed values, e.g. members given as [{"value": "id"}]
+			kept := []interface{}{}
+			for _, current := range values {
+				if !containsValue(removed, current) {
+					kept = append(kept, current)
+				}
+			}
+			resource[name] = kept
+		default:
+			if current, ok := existing.(map[string]interface{}); ok && exists {
+				if changes, ok := value.(map[string]interface{}); ok {
+					for sub, subValue := range changes {
+						current[key(current, sub)] = subValue
+					}
+					return nil
+				}
+			}
+			values, isList := existing.([]interface{})
+			added, addsList := value.([]interface{})
+			if op == "add" && isList && addsList {
+				for _, item := range added {
+					if !containsValue(values, item) {
+						values = append(values, item)
+					}
+				}
+				resource[name] = values
+				return nil
+			}
+			resource[name] = value
+		}
+		return nil
+	}
+
+	values, _ := resource[name].([]interface{})
+	matched := false
+	kept := []interface{}{}
+	for _, current := range values {
+		element, ok := current.(map[string]interface{})
+		if !ok || !sameValue(element[key(element, path.filterAttribute)], path.filterValue) {
+			kept = append(kept, current)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && path.subAttribute == "":
+			continue
+		case op == "remove":
+			delete(element, key(element, path.subAttribute))
+		case path.subAttribute != "":
+			element[key(element, path.subAttribute)] = value
+		default:
+			changes, ok := value.(map[string]interface{})
+			if !ok {
+				return invalidValue("the value of " + path.attribute + " must be an object")
+			}
+			for sub, subValue := range changes {
+				element[key(element, sub)] = subValue
+			}
+		}
+		kept = append(kept, element)
+	}
+
+	// Values that do not exist yet are added, e.g. emails[type eq "work"].value
+	if !matched && op != "remove" {
+		element := map[string]interface{}{path.filterAttribute: path.filterValue}
+		if path.subAttribute != "" {
+			element[path.subAttribute] = value
+		} else if changes, ok := value.(map[string]interface{}); ok {
+			for sub, subValue := range changes {
+				element[sub] = subValue
+			}
+		}
+		kept = append(kept, element)
+	}
+	resource[name] = kept
+	return nil
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, current := range values {
+		if sameValue(current, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchResource applies a PATCH request to the JSON form of current and decodes the result into patched
+func patchResource(current interface{}, patched interface{}, request *PatchRequest, schema string, extensions []string) error {
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return err
+	}
+	if err := applyPatch(values, request, schema, extensions); err != nil {
+		return err
+	}
+
+	// Some provisioning clients send booleans as strings, e.g. "False"
+	if active, ok := values[key(values, "active")].(string); ok {
+		values[key(values, "active")] = strings.EqualFold(active, "true")
+	}
+
+	encoded, err = json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(encoded, patched); err != nil {
+		return invalidValue(err.Error())
+	}
+	return nil
+}
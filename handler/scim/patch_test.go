@@ -0,0 +1,226 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testPatchResource is the resource the PATCH tests start from
+const testPatchResource = `{
+	"userName": "alice",
+	"name": {"givenName": "Alice"},
+	"emails": [{"value": "alice@work.example", "type": "work", "primary": true}],
+	"members": [{"value": "m1"}, {"value": "M2"}]
+}`
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations string
+		// want are the attributes that differ from testPatchResource, null for removed ones
+		want string
+	}{
+		{
+			name:       "replace",
+			operations: `[{"op": "replace", "path": "userName", "value": "bob"}]`,
+			want:       `{"userName": "bob"}`,
+		},
+		{
+			name:       "attribute names are case-insensitive",
+			operations: `[{"op": "Replace", "path": "USERNAME", "value": "bob"}]`,
+			want:       `{"userName": "bob"}`,
+		},
+		{
+			name:       "schema prefix",
+			operations: `[{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:userName", "value": "bob"}]`,
+			want:       `{"userName": "bob"}`,
+		},
+		{
+			name:       "add sub-attribute",
+			operations: `[{"op": "add", "path": "name.familyName", "value": "Doe"}]`,
+			want:       `{"name": {"givenName": "Alice", "familyName": "Doe"}}`,
+		},
+		{
+			name:       "add sub-attribute of a missing attribute",
+			operations: `[{"op": "add", "path": "address.city", "value": "Berlin"}]`,
+			want:       `{"address": {"city": "Berlin"}}`,
+		},
+		{
+			name:       "replace complex attribute merges",
+			operations: `[{"op": "replace", "path": "name", "value": {"familyName": "Doe"}}]`,
+			want:       `{"name": {"givenName": "Alice", "familyName": "Doe"}}`,
+		},
+		{
+			name:       "add values",
+			operations: `[{"op": "add", "path": "members", "value": [{"value": "m2"}, {"value": "m3"}]}]`,
+			want:       `{"members": [{"value": "m1"}, {"value": "M2"}, {"value": "m3"}]}`,
+		},
+		{
+			name:       "replace values",
+			operations: `[{"op": "replace", "path": "members", "value": [{"value": "m3"}]}]`,
+			want:       `{"members": [{"value": "m3"}]}`,
+		},
+		{
+			name:       "remove attribute",
+			operations: `[{"op": "remove", "path": "name"}]`,
+			want:       `{"name": null}`,
+		},
+		{
+			name:       "remove sub-attribute",
+			operations: `[{"op": "remove", "path": "name.givenName"}]`,
+			want:       `{"name": {}}`,
+		},
+		{
+			name:       "remove sub-attribute of a missing attribute",
+			operations: `[{"op": "remove", "path": "address.city"}]`,
+			want:       `{}`,
+		},
+		{
+			name:       "remove listed values",
+			operations: `[{"op": "remove", "path": "members", "value": [{"value": "m2"}]}]`,
+			want:       `{"members": [{"value": "m1"}]}`,
+		},
+		{
+			name:       "remove with value path",
+			operations: `[{"op": "remove", "path": "members[value eq \"M1\"]"}]`,
+			want:       `{"members": [{"value": "M2"}]}`,
+		},
+		{
+			name:       "remove with value path without match",
+			operations: `[{"op": "remove", "path": "members[value eq \"m3\"]"}]`,
+			want:       `{}`,
+		},
+		{
+			name:       "remove sub-attribute with value path",
+			operations: `[{"op": "remove", "path": "emails[type eq \"work\"].primary"}]`,
+			want:       `{"emails": [{"value": "alice@work.example", "type": "work"}]}`,
+		},
+		{
+			name:       "replace with value path",
+			operations: `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "alice@new.example"}]`,
+			want:       `{"emails": [{"value": "alice@new.example", "type": "work", "primary": true}]}`,
+		},
+		{
+			name:       "replace with boolean value path",
+			operations: `[{"op": "replace", "path": "emails[primary eq true].type", "value": "home"}]`,
+			want:       `{"emails": [{"value": "alice@work.example", "type": "home", "primary": true}]}`,
+		},
+		{
+			name:       "replace object with value path",
+			operations: `[{"op": "replace", "path": "emails[type eq \"work\"]", "value": {"primary": false, "display": "Work"}}]`,
+			want:       `{"emails": [{"value": "alice@work.example", "type": "work", "primary": false, "display": "Work"}]}`,
+		},
+		{
+			name:       "add with value path without match",
+			operations: `[{"op": "add", "path": "emails[type eq \"home\"].value", "value": "alice@home.example"}]`,
+			want: `{"emails": [{"value": "alice@work.example", "type": "work", "primary": true},
+				{"type": "home", "value": "alice@home.example"}]}`,
+		},
+		{
+			name:       "replace without path",
+			operations: `[{"op": "replace", "value": {"userName": "bob", "name.familyName": "Doe"}}]`,
+			want:       `{"userName": "bob", "name": {"givenName": "Alice", "familyName": "Doe"}}`,
+		},
+		{
+			name:       "extension attribute",
+			operations: `[{"op": "add", "path": "` + SchemaAttributes + `:costCenter", "value": "42"}]`,
+			want:       `{"` + SchemaAttributes + `": {"costCenter": "42"}}`,
+		},
+		{
+			name:       "extension without path",
+			operations: `[{"op": "add", "value": {"` + SchemaAttributes + `": {"costCenter": "42", "team": "core"}}}]`,
+			want:       `{"` + SchemaAttributes + `": {"costCenter": "42", "team": "core"}}`,
+		},
+		{
+			name: "operations in order",
+			operations: `[{"op": "add", "path": "members", "value": [{"value": "m3"}]},
+				{"op": "remove", "path": "members[value eq \"m1\"]"},
+				{"op": "replace", "path": "members[value eq \"m3\"].display", "value": "Carol"}]`,
+			want: `{"members": [{"value": "M2"}, {"value": "m3", "display": "Carol"}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var request PatchRequest
+			if err := json.Unmarshal([]byte(`{"Operations": `+test.operations+`}`), &request); err != nil {
+				t.Fatal(err)
+			}
+			resource := map[string]interface{}{}
+			json.Unmarshal([]byte(testPatchResource), &resource)
+			if err := applyPatch(resource, &request, SchemaUser, []string{SchemaAttributes}); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]interface{}{}
+			json.Unmarshal([]byte(testPatchResource), &want)
+			changes := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(test.want), &changes); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range changes {
+				if value == nil {
+					delete(want, name)
+				} else {
+					want[name] = value
+				}
+			}
+
+			got, _ := json.Marshal(resource)
+			expected, _ := json.Marshal(want)
+			if string(got) != string(expected) {
+				t.Errorf("patched %s\nwant %s", got, expected)
+			}
+		})
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		operations string
+		scimType   string
+		err        string
+	}{
+		{`[{"op": "move", "path": "userName"}]`, "invalidValue", "unknown operation move"},
+		{`[{"op": "remove"}]`, "noTarget", "operations without path"},
+		{`[{"op": "replace", "value": "bob"}]`, "noTarget", "operations without path"},
+		{`[{"op": "replace", "path": "emails[type ne \"work\"].value", "value": "x"}]`, "invalidPath", "unsupported path"},
+		{`[{"op": "replace", "path": "emails[type eq work].value", "value": "x"}]`, "invalidPath", "unsupported path"},
+		{`[{"op": "replace", "path": "emails.value.type", "value": "x"}]`, "invalidPath", "unsupported path"},
+		{`[{"op": "replace", "path": "urn:example:Other:userName", "value": "x"}]`, "invalidPath", "unsupported path"},
+		{`[{"op": "replace", "value": {"emails[": "x"}}]`, "invalidPath", "unsupported path"},
+		{`[{"op": "replace", "path": "emails[type eq \"work\"]", "value": "x"}]`, "invalidValue", "must be an object"},
+	}
+	for _, test := range tests {
+		var request PatchRequest
+		if err := json.Unmarshal([]byte(`{"Operations": `+test.operations+`}`), &request); err != nil {
+			t.Fatal(err)
+		}
+		resource := map[string]interface{}{}
+		json.Unmarshal([]byte(testPatchResource), &resource)
+		err := applyPatch(resource, &request, SchemaUser, []string{SchemaAttributes})
+		resourceErr, ok := err.(*resourceError)
+		if !ok || resourceErr.scimType != test.scimType || !strings.Contains(resourceErr.detail, test.err) {
+			t.Errorf("%s = %v, want %s %q", test.operations, err, test.scimType, test.err)
+		}
+	}
+}
+
+func TestPatchResourceConvertsActive(t *testing.T) {
+	var request PatchRequest
+	json.Unmarshal([]byte(`{"Operations": [{"op": "replace", "value": {"active": "False"}}]}`), &request)
+	active := true
+	var patched User
+	if err := patchResource(User{UserName: "alice", Active: &active}, &patched, &request, SchemaUser, nil); err != nil {
+		t.Fatal(err)
+	}
+	if patched.Active == nil || *patched.Active || patched.UserName != "alice" {
+		t.Errorf("patched %+v", patched)
+	}
+
+	json.Unmarshal([]byte(`{"Operations": [{"op": "replace", "path": "userName", "value": 1}]}`), &request)
+	err := patchResource(User{UserName: "alice"}, &patched, &request, SchemaUser, nil)
+	if resourceErr, ok := err.(*resourceError); !ok || resourceErr.scimType != "invalidValue" {
+		t.Errorf("patchResource with a number as userName = %v", err)
+	}
+}
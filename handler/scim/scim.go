@@ -0,0 +1,251 @@
+// Package scim serves the SCIM 2.0 provisioning API (RFC 7643, RFC 7644) for the users and groups of a
+// tenant. Provisioning clients authenticate with a SCIM token of the tenant.
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"gorm.io/gorm"
+)
+
+// Schemas and messages of SCIM
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaBulkRequest           = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+	SchemaBulkResponse          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// Limits of list and bulk requests
+const (
+	DefaultCount      = 100
+	MaxCount          = 200
+	MaxBulkOperations = 100
+	MaxBulkPayload    = 1 << 20
+)
+
+// tenantKey is the context key of the tenant of the SCIM token
+const tenantKey = "scim_tenant"
+
+// ScimHandler serves the SCIM API for the tenant of the SCIM token
+type ScimHandler struct {
+	Handler *handler.Handler
+}
+
+func NewScimHandler(db *gorm.DB) *ScimHandler {
+	return &ScimHandler{
+		Handler: handler.NewHandler(db),
+	}
+}
+
+// Error is a SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Meta describes a resource
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// ListResponse is a page of resources
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// Authenticate lets requests with a valid SCIM token through and stores its tenant in the context
+func (h *ScimHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "", "A SCIM token is required")
+			return
+		}
+		tenantID, err := h.Handler.ScimTokenTenant(bearer)
+		if errors.Is(err, handler.ErrInvalidScimToken) {
+			writeError(w, http.StatusUnauthorized, "", err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, handler.StatusFor(err, http.StatusInternalServerError), "", err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey, tenantID)))
+	})
+}
+
+func tenant(r *http.Request) uuid.UUID {
+	tenantID, _ := r.Context().Value(tenantKey).(uuid.UUID)
+	return tenantID
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, scimType string, detail string) {
+	writeJSON(w, status, Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail})
+}
+
+// writeResource writes a resource with its version as ETag
+func writeResource(w http.ResponseWriter, status int, meta *Meta, resource interface{}) {
+	w.Header().Set("ETag", meta.Version)
+	w.Header().Set("Location", meta.Location)
+	writeJSON(w, status, resource)
+}
+
+// endpoints are the paths of the SCIM API below its prefix
+var endpoints = []string{"/Users", "/Groups", "/Bulk", "/ServiceProviderConfig", "/ResourceTypes"}
+
+// location returns the URL of a resource on the host of the request
+func location(r *http.Request, path string) string {
+	scheme := "https"
+	if r.TLS == nil && strings.HasPrefix(handler.NormalizeHost(r.Host), "localhost") {
+		scheme = "http"
+	}
+	base := r.URL.Path
+	for _, endpoint := range endpoints {
+		base, _, _ = strings.Cut(base, endpoint)
+	}
+	return fmt.Sprintf("%s://%s%s%s", scheme, r.Host, strings.TrimSuffix(base, "/"), path)
+}
+
+// version returns the weak ETag of a resource modified at the time, in the microseconds stored by the database
+func version(modified time.Time) string {
+	return fmt.Sprintf(`W/"%d"`, modified.Round(time.Microsecond).UnixMicro())
+}
+
+// preconditionFailed checks If-Match against the version of the resource and answers with 412 on a mismatch
+func preconditionFailed(w http.ResponseWriter, r *http.Request, current string) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return false
+	}
+	for _, candidate := range strings.Split(match, ",") {
+		if strings.TrimSpace(candidate) == current {
+			return false
+		}
+	}
+	writeError(w, http.StatusPreconditionFailed, "", "The resource was modified")
+	return true
+}
+
+// notModified answers with 304 if If-None-Match names the version of the resource
+func notModified(w http.ResponseWriter, r *http.Request, current string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if strings.TrimSpace(candidate) == current {
+			w.Header().Set("ETag", current)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// pagination returns the 1-based startIndex and count of a list request
+func pagination(r *http.Request) (int, int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil {
+		count = DefaultCount
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxCount {
+		count = MaxCount
+	}
+	return startIndex, count
+}
+
+// decode reads a SCIM request body
+func decode(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return false
+	}
+	return true
+}
+
+// ServiceProviderConfig describes the supported features
+func (h *ScimHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(value bool) map[string]bool { return map[string]bool{"supported": value} }
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"schemas": []string{SchemaServiceProviderConfig},
+		"patch":   supported(true),
+		"bulk": map[string]interface{}{
+			"supported":      true,
+			"maxOperations":  MaxBulkOperations,
+			"maxPayloadSize": MaxBulkPayload,
+		},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": MaxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(true),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "SCIM token",
+			"description": "A SCIM token of the tenant created in the admin API",
+			"primary":     true,
+		}},
+		"meta": map[string]string{"resourceType": "ServiceProviderConfig", "location": location(r, "/ServiceProviderConfig")},
+	})
+}
+
+// ResourceTypes lists the User and Group resource types
+func (h *ScimHandler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	resourceType := func(name string, endpoint string, schema string) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":  []string{SchemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": endpoint,
+			"schema":   schema,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": location(r, "/ResourceTypes/"+name)},
+		}
+	}
+	writeJSON(w, http.StatusOK, ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: 2,
+		StartIndex:   1,
+		ItemsPerPage: 2,
+		Resources: []interface{}{
+			resourceType("User", "/Users", SchemaUser),
+			resourceType("Group", "/Groups", SchemaGroup),
+		},
+	})
+}
@@ -0,0 +1,455 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// SchemaAttributes is the extension of the User schema with the custom attributes of the tenant
+const SchemaAttributes = "urn:sethorize:params:scim:schemas:extension:attributes:2.0:User"
+
+// User is the SCIM representation of a user. The userName is the email address of the user.
+type User struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *Name       `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []Email     `json:"emails,omitempty"`
+	Locale      string      `json:"locale,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"`
+	Groups      []Reference `json:"groups,omitempty"`
+	// Attributes are the values of the tenant's custom attributes
+	Attributes map[string]json.RawMessage `json:"urn:sethorize:params:scim:schemas:extension:attributes:2.0:User,omitempty"`
+	Meta       *Meta                      `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference is a group of a user or a member of a group
+type Reference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// userGroups returns the groups of the users by user ID
+func (h *ScimHandler) userGroups(r *http.Request, userIDs []uuid.UUID) (map[uuid.UUID][]Reference, error) {
+	var rows []struct {
+		UserID  uuid.UUID
+		GroupID uuid.UUID
+		Name    string
+	}
+	err := h.Handler.DB.Table("user_groups").
+		Select("user_groups.user_id, groups.id AS group_id, groups.name").
+		Joins("JOIN groups ON groups.id = user_groups.group_id").
+		Where("user_groups.user_id IN ?", userIDs).
+		Order("groups.name").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[uuid.UUID][]Reference{}
+	for _, row := range rows {
+		groups[row.UserID] = append(groups[row.UserID], Reference{
+			Value:   row.GroupID.String(),
+			Ref:     location(r, "/Groups/"+row.GroupID.String()),
+			Display: row.Name,
+		})
+	}
+	return groups, nil
+}
+
+func scimUser(r *http.Request, user *models.User, schema []models.UserAttribute, groups []Reference) User {
+	active := user.IsActive
+	resource := User{
+		Schemas:     []string{SchemaUser},
+		ID:          user.ID.String(),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &Name{Formatted: user.DisplayName, GivenName: user.FirstName, FamilyName: user.LastName},
+		DisplayName: user.DisplayName,
+		Emails:      []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Locale:      user.Locale,
+		Active:      &active,
+		Groups:      groups,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     location(r, "/Users/"+user.ID.String()),
+			Version:      version(user.UpdatedAt),
+		},
+	}
+
+	if attributes := handler.VisibleAttributes(schema, user, true); len(attributes) > 0 {
+		resource.Schemas = append(resource.Schemas, SchemaAttributes)
+		resource.Attributes = map[string]json.RawMessage{}
+		for name, value := range attributes {
+			resource.Attributes[name], _ = json.Marshal(value)
+		}
+	}
+	return resource
+}
+
+// writeUser answers with the SCIM representation of the user
+func (h *ScimHandler) writeUser(w http.ResponseWriter, r *http.Request, status int, user *models.User) {
+	schema, err := h.Handler.UserAttributeSchema(user.TenantID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	groups, err := h.userGroups(r, []uuid.UUID{user.ID})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	resource := scimUser(r, user, schema, groups[user.ID])
+	if status == http.StatusOK && notModified(w, r, resource.Meta.Version) {
+		return
+	}
+	writeResource(w, status, resource.Meta, resource)
+}
+
+// loadUser returns the user of the {id} route parameter within the tenant of the SCIM token
+func (h *ScimHandler) loadUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id := mux.Vars(r)["id"]
+	var user models.User
+	if _, err := uuid.Parse(id); err != nil || h.Handler.DB.Where("id = ? AND tenant_id = ?", id, tenant(r)).First(&user).Error != nil {
+		writeError(w, http.StatusNotFound, "", "User not found")
+		return nil, false
+	}
+	return &user, true
+}
+
+// writableUser rejects changes of super admins, which are not managed by provisioning clients
+func writableUser(w http.ResponseWriter, user *models.User) bool {
+	if user.IsSuperAdmin {
+		writeError(w, http.StatusForbidden, "", "Super admins cannot be changed through SCIM")
+		return false
+	}
+	return true
+}
+
+// emailTaken reports whether another user of the tenant, including deleted users, has the email address
+func (h *ScimHandler) emailTaken(tenantID uuid.UUID, email string, exceptID uuid.UUID) bool {
+	var count int64
+	h.Handler.DB.Unscoped().Model(&models.User{}).Where("tenant_id = ? AND LOWER(email) = ? AND id <> ?", tenantID, helper.NormalizeEmail(email), exceptID).Count(&count)
+	return count > 0
+}
+
+// applyUser sets the fields of user from the resource and returns the changed columns
+func (h *ScimHandler) applyUser(user *models.User, resource *User) (map[string]interface{}, error) {
+	email := resource.UserName
+	if !strings.Contains(email, "@") {
+		email = ""
+		for _, candidate := range resource.Emails {
+			if email == "" || candidate.Primary {
+				email = candidate.Value
+			}
+		}
+	}
+	if !strings.Contains(email, "@") {
+		return nil, invalidValue("userName or a primary email must be an email address")
+	}
+	email = helper.NormalizeEmail(email)
+
+	var name Name
+	if resource.Name != nil {
+		name = *resource.Name
+	}
+	if name.GivenName == "" && name.FamilyName == "" {
+		name.GivenName = resource.DisplayName
+		if name.GivenName == "" {
+			name.GivenName, _, _ = strings.Cut(email, "@")
+		}
+	}
+
+	locale := resource.Locale
+	if locale == "" {
+		locale = "en"
+	}
+	active := resource.Active == nil || *resource.Active
+
+	updates := map[string]interface{}{}
+	if email != user.Email {
+		if !strings.EqualFold(email, user.Email) && h.emailTaken(user.TenantID, email, user.ID) {
+			return nil, &resourceError{status: http.StatusConflict, scimType: "uniqueness", detail: "A user with this userName exists"}
+		}
+		updates["email"] = email
+	}
+	if name.GivenName != user.FirstName || name.FamilyName != user.LastName {
+		updates["first_name"] = name.GivenName
+		updates["last_name"] = name.FamilyName
+		updates["display_name"] = fmt.Sprintf("%s %s", name.GivenName, name.FamilyName)
+	}
+	if resource.ExternalID != user.ExternalID {
+		updates["external_id"] = resource.ExternalID
+	}
+	if locale != user.Locale {
+		updates["locale"] = locale
+	}
+	if active != user.IsActive {
+		updates["is_active"] = active
+	}
+
+	user.Email = email
+	user.FirstName = name.GivenName
+	user.LastName = name.FamilyName
+	user.DisplayName = fmt.Sprintf("%s %s", name.GivenName, name.FamilyName)
+	user.ExternalID = resource.ExternalID
+	user.Locale = locale
+	user.IsActive = active
+	return updates, nil
+}
+
+// attributeChanges returns the custom attribute values of the resource that differ from the stored ones.
+// Stored values missing in the resource are removed if admins may write them.
+func attributeChanges(schema []models.UserAttribute, user *models.User, values map[string]json.RawMessage) map[string]json.RawMessage {
+	stored := handler.DecodeAttributes(user)
+	changes := map[string]json.RawMessage{}
+	for name, raw := range values {
+		var value interface{}
+		json.Unmarshal(raw, &value)
+		if current, ok := stored[name]; !ok || !reflect.DeepEqual(current, value) {
+			changes[name] = raw
+		}
+	}
+	for _, attribute := range schema {
+		if _, ok := values[attribute.Name]; !ok && attribute.AdminAccess == handler.AttributeAccessWrite {
+			if _, exists := stored[attribute.Name]; exists {
+				changes[attribute.Name] = nil
+			}
+		}
+	}
+	return changes
+}
+
+// Users lists the users of the tenant, filtered with filter and paginated with startIndex and count
+func (h *ScimHandler) Users(w http.ResponseWriter, r *http.Request) {
+	query := h.Handler.DB.Model(&models.User{}).Where("users.tenant_id = ?", tenant(r))
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		condition, args, err := compileFilter(filter, SchemaUser, userColumns)
+		if err != nil {
+			writeFailure(w, err)
+			return
+		}
+		query = query.Where(condition, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	startIndex, count := pagination(r)
+	var users []models.User
+	if count > 0 {
+		if err := query.Order("users.created_at, users.id").Offset(startIndex - 1).Limit(count).Find(&users).Error; err != nil {
+			writeFailure(w, err)
+			return
+		}
+	}
+
+	schema, err := h.Handler.UserAttributeSchema(tenant(r))
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	userIDs := make([]uuid.UUID, len(users))
+	for i := range users {
+		userIDs[i] = users[i].ID
+	}
+	groups, err := h.userGroups(r, userIDs)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	list := ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: startIndex, Resources: []interface{}{}}
+	for i := range users {
+		list.Resources = append(list.Resources, scimUser(r, &users[i], schema, groups[users[i].ID]))
+	}
+	list.ItemsPerPage = len(list.Resources)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// User returns a user of the tenant
+func (h *ScimHandler) User(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+	h.writeUser(w, r, http.StatusOK, user)
+}
+
+// UserCreate provisions a user. Provisioned users are verified and get a random password unless the
+// request sets one.
+func (h *ScimHandler) UserCreate(w http.ResponseWriter, r *http.Request) {
+	var resource User
+	if !decode(w, r, &resource) {
+		return
+	}
+
+	user := models.User{TenantID: tenant(r), IsVerified: true}
+	if _, err := h.applyUser(&user, &resource); err != nil {
+		writeFailure(w, err)
+		return
+	}
+	if resource.Password != "" {
+		if err := h.Handler.ValidatePassword(&user, resource.Password); err != nil {
+			writeFailure(w, err)
+			return
+		}
+		user.Password = resource.Password
+	} else {
+		user.Password = utils.GenerateToken(32)
+	}
+
+	err := h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if err := h.Handler.ApplyAttributes(tx, &user, resource.Attributes, true); err != nil {
+			return err
+		}
+		active := user.IsActive
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		// Create stores the column default for false
+		if !active {
+			user.IsActive = false
+			return tx.Model(&user).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusCreated, &user)
+}
+
+// saveUser stores the resource as the new state of the user. Deactivating the user revokes its sessions.
+func (h *ScimHandler) saveUser(w http.ResponseWriter, r *http.Request, user *models.User, resource *User) {
+	schema, err := h.Handler.UserAttributeSchema(user.TenantID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	changes := attributeChanges(schema, user, resource.Attributes)
+
+	wasActive := user.IsActive
+	updates, err := h.applyUser(user, resource)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	err = h.Handler.DB.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			if err := h.Handler.ApplyAttributes(tx, user, changes, true); err != nil {
+				return err
+			}
+			updates["attributes"] = user.Attributes
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(user).Updates(updates).Error
+	})
+	if err == nil && wasActive && !user.IsActive {
+		err = h.Handler.RevokeUserSessions(user.ID)
+	}
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	h.Handler.DB.Where("id = ?", user.ID).First(user)
+	h.writeUser(w, r, http.StatusOK, user)
+}
+
+// UserReplace replaces the attributes of a user (PUT)
+func (h *ScimHandler) UserReplace(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok || !writableUser(w, user) || preconditionFailed(w, r, version(user.UpdatedAt)) {
+		return
+	}
+
+	var resource User
+	if !decode(w, r, &resource) {
+		return
+	}
+	h.saveUser(w, r, user, &resource)
+}
+
+// UserPatch changes attributes of a user with PATCH operations
+func (h *ScimHandler) UserPatch(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok || !writableUser(w, user) || preconditionFailed(w, r, version(user.UpdatedAt)) {
+		return
+	}
+
+	var request PatchRequest
+	if !decode(w, r, &request) {
+		return
+	}
+
+	schema, err := h.Handler.UserAttributeSchema(user.TenantID)
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	var resource User
+	err = patchResource(scimUser(r, user, schema, nil), &resource, &request, SchemaUser, []string{SchemaAttributes})
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+	h.saveUser(w, r, user, &resource)
+}
+
+// UserDelete deprovisions a user: the user is deactivated and its sessions are revoked. The user is kept
+// and can be activated again.
+func (h *ScimHandler) UserDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok || !writableUser(w, user) || preconditionFailed(w, r, version(user.UpdatedAt)) {
+		return
+	}
+
+	err := h.Handler.DB.Model(user).Update("is_active", false).Error
+	if err == nil {
+		err = h.Handler.RevokeUserSessions(user.ID)
+	}
+	if err != nil {
+		writeFailure(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+)
+
+// ErrInvalidScimToken is returned for unknown, expired or malformed SCIM tokens
+var ErrInvalidScimToken = errors.New("invalid SCIM token")
+
+// CreateScimToken creates a SCIM token for the tenant and returns its bearer value, which is only shown once.
+// A zero expiresAt never expires.
+func (h *Handler) CreateScimToken(tenantID uuid.UUID, name string, expiresAt time.Time) (string, *models.ScimToken, error) {
+	secret := utils.GenerateToken(32)
+	token := models.ScimToken{TenantID: tenantID, Name: name, Secret: secret, ExpiresAt: expiresAt}
+	err := h.DB.Create(&token).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return utils.EncodeBearerToken(token.ID.String(), secret), &token, nil
+}
+
+// ScimTokenTenant verifies a SCIM bearer token and returns the tenant it belongs to
+func (h *Handler) ScimTokenTenant(bearer string) (uuid.UUID, error) {
+	id, secret, ok := utils.DecodeBearerToken(bearer)
+	if _, err := uuid.Parse(id); !ok || err != nil {
+		return uuid.Nil, ErrInvalidScimToken
+	}
+
+	var token models.ScimToken
+	err := h.DB.Joins("JOIN tenants ON tenants.id = scim_tokens.tenant_id AND tenants.is_active = ? AND tenants.deleted_at IS NULL", true).
+		Where("scim_tokens.id = ? AND (scim_tokens.expires_at IS NULL OR scim_tokens.expires_at > ?)", id, time.Now()).
+		First(&token).Error
+	if err != nil {
+		return uuid.Nil, ErrInvalidScimToken
+	}
+
+	valid, err := h.VerifySecret(&token, "secret", secret, token.Secret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !valid {
+		return uuid.Nil, ErrInvalidScimToken
+	}
+
+	h.DB.Model(&token).Update("last_used_at", time.Now())
+	return token.TenantID, nil
+}
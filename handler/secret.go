@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// VerifySecret compares a machine generated secret with the hash stored in column of model.
+// Legacy Argon2 hashes are replaced by the peppered secret hash after a successful verification.
+func (h *Handler) VerifySecret(model interface{}, column string, secret string, hash string) (bool, error) {
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return false, err
+	}
+
+	valid, needsRehash, err := secretHasher.Compare(secret, hash)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if needsRehash {
+		newHash, err := secretHasher.Hash(secret)
+		if err == nil {
+			err = h.DB.Model(model).Update(column, newHash).Error
+		}
+		if err != nil {
+			fmt.Printf("Error migrating %s hash: %v\n", column, err)
+		}
+	}
+
+	return true, nil
+}
+
+// CountAttempt atomically counts an attempt on the code of model (e.g. an MFA challenge) before the code is
+// compared. It reports false once maxAttempts attempts were made, so concurrent guesses cannot exceed the limit.
+func (h *Handler) CountAttempt(model interface{}, id uuid.UUID, maxAttempts int) (bool, error) {
+	result := h.DB.Model(model).Where("id = ? AND attempts < ?", id, maxAttempts).Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// RevokeUserSessions revokes all sessions and refresh tokens of the user
+func (h *Handler) RevokeUserSessions(userID uuid.UUID) error {
+	now := time.Now()
+
+	err := h.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error
+}
+
+// RevokeClientSessions revokes all sessions and refresh tokens issued to the client
+func (h *Handler) RevokeClientSessions(clientID uuid.UUID) error {
+	now := time.Now()
+
+	err := h.DB.Model(&models.Session{}).Where("client_id = ? AND revoked_at IS NULL", clientID).Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Model(&models.RefreshToken{}).Where("client_id = ? AND revoked_at IS NULL", clientID).Update("revoked_at", now).Error
+}
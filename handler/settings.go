@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+// Grants that can be allowed per tenant or client
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	// GrantPassword is the login with email and password
+	GrantPassword     = "password"
+	GrantPasswordless = "passwordless"
+	GrantWebAuthn     = "webauthn"
+	// GrantFederated is the login at an upstream OpenID Connect provider
+	GrantFederated = "federated"
+)
+
+// Grants are all known grants, all of them are allowed by default
+var Grants = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials, GrantPassword, GrantPasswordless, GrantWebAuthn, GrantFederated}
+
+// DefaultIssuer is the issuer of tokens if no issuer is configured and APPLICATION_DOMAIN is not set
+const DefaultIssuer = "sethorize-idp-api"
+
+type Branding struct {
+	Name         string `json:"name"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+}
+
+// EffectiveSettings are the settings that apply to a tenant or client after resolving all levels
+type EffectiveSettings struct {
+	AccessTokenLifetime  time.Duration `json:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration `json:"refresh_token_lifetime"`
+	ConsentLifetime      time.Duration `json:"consent_lifetime"`
+	Issuer               string        `json:"issuer"`
+	AllowedGrants        []string      `json:"allowed_grants"`
+	MFAPolicy            string        `json:"mfa_policy"`
+	Branding             Branding      `json:"branding"`
+}
+
+// MarshalJSON writes the lifetimes in seconds like models.Settings
+func (s EffectiveSettings) MarshalJSON() ([]byte, error) {
+	type effectiveSettings EffectiveSettings
+	return json.Marshal(struct {
+		effectiveSettings
+		AccessTokenLifetime  int64 `json:"access_token_lifetime"`
+		RefreshTokenLifetime int64 `json:"refresh_token_lifetime"`
+		ConsentLifetime      int64 `json:"consent_lifetime"`
+	}{
+		effectiveSettings:    effectiveSettings(s),
+		AccessTokenLifetime:  int64(s.AccessTokenLifetime.Seconds()),
+		RefreshTokenLifetime: int64(s.RefreshTokenLifetime.Seconds()),
+		ConsentLifetime:      int64(s.ConsentLifetime.Seconds()),
+	})
+}
+
+// GrantAllowed reports whether the grant is allowed
+func (s EffectiveSettings) GrantAllowed(grant string) bool {
+	return slices.Contains(s.AllowedGrants, grant)
+}
+
+// DefaultSettings are the built-in defaults below the global settings
+func DefaultSettings() EffectiveSettings {
+	return EffectiveSettings{
+		AccessTokenLifetime:  time.Minute * 60,
+		RefreshTokenLifetime: time.Hour * 24,
+		ConsentLifetime:      time.Hour * 24 * 30,
+		Issuer:               DefaultIssuer,
+		AllowedGrants:        slices.Clone(Grants),
+		MFAPolicy:            models.MFAPolicyOptional,
+		Branding:             Branding{Name: os.Getenv("APPLICATION_NAME")},
+	}
+}
+
+// Settings resolves the settings for the tenant and, if given, the client. The order is built-in defaults,
+// global settings, tenant (including Tenant.MFAPolicy and Tenant.Name as brand name) and client. The issuer
+// defaults to TenantIssuer.
+// Settings are read from the database on every call so that changes apply without a restart.
+func (h *Handler) Settings(tenantID uuid.UUID, clientID *uuid.UUID) EffectiveSettings {
+	settings := DefaultSettings()
+
+	var tenant models.Tenant
+	tenantFound := h.DB.Where("id = ?", tenantID).First(&tenant).Error == nil
+	if tenantFound {
+		// The derived issuer is a default, a configured issuer on any level replaces it
+		settings.Issuer = h.TenantIssuer(&tenant)
+	}
+
+	var global models.Settings
+	if h.DB.Where("tenant_id IS NULL AND client_id IS NULL").First(&global).Error == nil {
+		settings.apply(&global)
+	}
+
+	if tenantFound {
+		settings.Branding.Name = tenant.Name
+		if tenant.MFAPolicy != "" {
+			settings.MFAPolicy = tenant.MFAPolicy
+		}
+	}
+
+	var tenantSettings models.Settings
+	if h.DB.Where("tenant_id = ? AND client_id IS NULL", tenantID).First(&tenantSettings).Error == nil {
+		settings.apply(&tenantSettings)
+	}
+
+	if clientID != nil {
+		var clientSettings models.Settings
+		if h.DB.Where("client_id = ?", *clientID).First(&clientSettings).Error == nil {
+			settings.apply(&clientSettings)
+		}
+	}
+
+	return settings
+}
+
+func (s *EffectiveSettings) apply(level *models.Settings) {
+	if level.AccessTokenLifetime != nil {
+		s.AccessTokenLifetime = time.Duration(*level.AccessTokenLifetime) * time.Second
+	}
+	if level.RefreshTokenLifetime != nil {
+		s.RefreshTokenLifetime = time.Duration(*level.RefreshTokenLifetime) * time.Second
+	}
+	if level.ConsentLifetime != nil {
+		s.ConsentLifetime = time.Duration(*level.ConsentLifetime) * time.Second
+	}
+	if level.Issuer != nil {
+		s.Issuer = *level.Issuer
+	}
+	if level.AllowedGrants != nil {
+		s.AllowedGrants = []string(level.AllowedGrants)
+	}
+	if level.MFAPolicy != nil {
+		s.MFAPolicy = *level.MFAPolicy
+	}
+	if level.BrandName != nil {
+		s.Branding.Name = *level.BrandName
+	}
+	if level.BrandLogoURL != nil {
+		s.Branding.LogoURL = *level.BrandLogoURL
+	}
+	if level.BrandPrimaryColor != nil {
+		s.Branding.PrimaryColor = *level.BrandPrimaryColor
+	}
+}
+
+// SettingsLevel returns the stored settings of one level. Both IDs nil is the global level.
+// A new, unsaved row is returned if the level has no settings yet.
+func (h *Handler) SettingsLevel(tenantID *uuid.UUID, clientID *uuid.UUID) (*models.Settings, error) {
+	query := h.DB.Where("tenant_id IS NULL AND client_id IS NULL")
+	switch {
+	case clientID != nil:
+		query = h.DB.Where("client_id = ?", *clientID)
+	case tenantID != nil:
+		query = h.DB.Where("tenant_id = ? AND client_id IS NULL", *tenantID)
+	}
+
+	var settings models.Settings
+	err := query.First(&settings).Error
+	if err == gorm.ErrRecordNotFound {
+		return &models.Settings{TenantID: tenantID, ClientID: clientID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveSettingsLevel stores the settings of one level; all fields are written, nil fields inherit again
+func (h *Handler) SaveSettingsLevel(settings *models.Settings) error {
+	for _, grant := range settings.AllowedGrants {
+		if !slices.Contains(Grants, grant) {
+			return &SettingsError{Message: "unknown grant " + grant}
+		}
+	}
+	if settings.MFAPolicy != nil && !slices.Contains([]string{models.MFAPolicyOptional, models.MFAPolicyAdmins, models.MFAPolicyAll}, *settings.MFAPolicy) {
+		return &SettingsError{Message: "unknown MFA policy"}
+	}
+	for _, lifetime := range []*int{settings.AccessTokenLifetime, settings.RefreshTokenLifetime, settings.ConsentLifetime} {
+		if lifetime != nil && *lifetime <= 0 {
+			return &SettingsError{Message: "lifetimes must be positive"}
+		}
+	}
+
+	if settings.ID == uuid.Nil {
+		return h.DB.Create(settings).Error
+	}
+	return h.DB.Select("*").Omit("id", "created_at").Save(settings).Error
+}
+
+// SettingsError is returned by SaveSettingsLevel for invalid settings
+type SettingsError struct {
+	Message string
+}
+
+func (e *SettingsError) Error() string {
+	return e.Message
+}
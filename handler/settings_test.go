@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// settingsColumns are the columns of the settings rows in the tests
+var settingsColumns = []string{"id", "access_token_lifetime", "refresh_token_lifetime", "issuer", "allowed_grants", "mfa_policy", "brand_name"}
+
+func TestSettingsInheritance(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "")
+	t.Setenv("APPLICATION_NAME", "Sethorize")
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+	tenantID, clientID := uuid.New(), uuid.New()
+
+	mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(tenantID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "mfa_policy"}).AddRow(tenantID, "Acme", models.MFAPolicyAdmins))
+	mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// Global: shorter access tokens, an issuer and a brand name
+	mock.ExpectQuery(`FROM "settings" WHERE tenant_id IS NULL AND client_id IS NULL`).
+		WillReturnRows(sqlmock.NewRows(settingsColumns).AddRow(uuid.New(), 900, nil, "https://id.example.com", nil, models.MFAPolicyAll, "Global"))
+	// Tenant: longer access tokens and fewer grants
+	mock.ExpectQuery(`FROM "settings" WHERE tenant_id = \$1 AND client_id IS NULL`).WithArgs(tenantID, 1).
+		WillReturnRows(sqlmock.NewRows(settingsColumns).AddRow(uuid.New(), 1800, nil, nil, "{password,refresh_token}", nil, nil))
+	// Client: shorter refresh tokens, everything else inherited
+	mock.ExpectQuery(`FROM "settings" WHERE client_id = \$1`).WithArgs(clientID, 1).
+		WillReturnRows(sqlmock.NewRows(settingsColumns).AddRow(uuid.New(), nil, 3600, nil, nil, nil, nil))
+
+	settings := h.Settings(tenantID, &clientID)
+	want := EffectiveSettings{
+		AccessTokenLifetime:  30 * time.Minute,
+		RefreshTokenLifetime: time.Hour,
+		ConsentLifetime:      30 * 24 * time.Hour,
+		Issuer:               "https://id.example.com",
+		AllowedGrants:        []string{GrantPassword, GrantRefreshToken},
+		// The MFA policy and name of the tenant override the global settings
+		MFAPolicy: models.MFAPolicyAdmins,
+		Branding:  Branding{Name: "Acme"},
+	}
+	if settings.AccessTokenLifetime != want.AccessTokenLifetime || settings.RefreshTokenLifetime != want.RefreshTokenLifetime ||
+		settings.ConsentLifetime != want.ConsentLifetime || settings.Issuer != want.Issuer ||
+		!slices.Equal(settings.AllowedGrants, want.AllowedGrants) || settings.MFAPolicy != want.MFAPolicy || settings.Branding != want.Branding {
+		t.Errorf("settings = %+v\nwant %+v", settings, want)
+	}
+	if settings.GrantAllowed(GrantClientCredentials) || !settings.GrantAllowed(GrantPassword) {
+		t.Errorf("allowed grants = %v", settings.AllowedGrants)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSettingsDefaults(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "secnex.io")
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newMockHandler(t, db)
+	tenantID := uuid.New()
+
+	mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(tenantID, "acme"))
+	mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM "settings"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM "settings"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	settings := h.Settings(tenantID, nil)
+	defaults := DefaultSettings()
+	if settings.AccessTokenLifetime != defaults.AccessTokenLifetime || settings.RefreshTokenLifetime != defaults.RefreshTokenLifetime ||
+		!slices.Equal(settings.AllowedGrants, Grants) || settings.MFAPolicy != models.MFAPolicyOptional {
+		t.Errorf("settings = %+v", settings)
+	}
+	// The issuer defaults to the one of the tenant
+	if settings.Issuer != "https://acme.secnex.io" {
+		t.Errorf("issuer = %s", settings.Issuer)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaveSettingsLevelValidates(t *testing.T) {
+	zero, unknown := 0, "sometimes"
+	tests := []models.Settings{
+		{AllowedGrants: []string{GrantPassword, "implicit"}},
+		{MFAPolicy: &unknown},
+		{ConsentLifetime: &zero},
+	}
+	h := &Handler{}
+	for _, settings := range tests {
+		var settingsErr *SettingsError
+		if err := h.SaveSettingsLevel(&settings); !errors.As(err, &settingsErr) {
+			t.Errorf("SaveSettingsLevel(%+v) = %v", settings, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrSigningKeyNotFound = errors.New("unknown signing key")
+
+// privateKeys caches decrypted private keys by kid
+var privateKeys sync.Map
+
+// legacyKeyCutover caches the cutover once it is known, see LegacyKeyCutover
+var legacyKeyCutover atomic.Pointer[time.Time]
+
+// ActiveSigningKey returns the key that signs new tokens of the tenant. The first key of a tenant is created on demand.
+func (h *Handler) ActiveSigningKey(tenantID uuid.UUID) (*models.SigningKey, *rsa.PrivateKey, error) {
+	var key models.SigningKey
+	err := activeSigningKey(h.DB, tenantID, &key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return h.createFirstSigningKey(tenantID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, err := h.decryptSigningKey(&key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &key, privateKey, nil
+}
+
+// createFirstSigningKey creates the first key of the tenant unless a concurrent request already did
+func (h *Handler) createFirstSigningKey(tenantID uuid.UUID) (*models.SigningKey, *rsa.PrivateKey, error) {
+	var key *models.SigningKey
+	var privateKey *rsa.PrivateKey
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockTenant(tx, tenantID); err != nil {
+			return err
+		}
+		var existing models.SigningKey
+		err := activeSigningKey(tx, tenantID, &existing)
+		if err == nil {
+			key = &existing
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		key, privateKey, err = h.createSigningKey(tx, tenantID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if privateKey == nil {
+		privateKey, err = h.decryptSigningKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return key, privateKey, nil
+}
+
+func activeSigningKey(tx *gorm.DB, tenantID uuid.UUID, key *models.SigningKey) error {
+	return tx.Where("tenant_id = ? AND retired_at IS NULL", tenantID).Order("created_at DESC").First(key).Error
+}
+
+// lockTenant locks the row of the tenant until the transaction ends, so that its keys are created and
+// rotated one at a time
+func lockTenant(tx *gorm.DB, tenantID uuid.UUID) error {
+	var tenant models.Tenant
+	return tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", tenantID).First(&tenant).Error
+}
+
+func (h *Handler) createSigningKey(tx *gorm.DB, tenantID uuid.UUID) (*models.SigningKey, *rsa.PrivateKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, helper.KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masterKey, err := helper.DefaultMasterKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kid := helper.JWKThumbprint(&privateKey.PublicKey)
+	encrypted, err := masterKey.Encrypt(x509.MarshalPKCS1PrivateKey(privateKey), kid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := models.SigningKey{
+		TenantID:   tenantID,
+		Kid:        kid,
+		Algorithm:  "RS256",
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)})),
+		PrivateKey: encrypted,
+	}
+	err = tx.Create(&key).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKeys.Store(kid, privateKey)
+	return &key, privateKey, nil
+}
+
+func (h *Handler) decryptSigningKey(key *models.SigningKey) (*rsa.PrivateKey, error) {
+	if cached, ok := privateKeys.Load(key.Kid); ok {
+		return cached.(*rsa.PrivateKey), nil
+	}
+
+	masterKey, err := helper.DefaultMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := masterKey.Decrypt(key.PrivateKey, key.Kid)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeys.Store(key.Kid, privateKey)
+	return privateKey, nil
+}
+
+// SigningKeyPublicKey parses the public key of the signing key
+func SigningKeyPublicKey(key *models.SigningKey) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(key.PublicKey))
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// PublishedSigningKey returns the active or not yet expired key with the kid
+func (h *Handler) PublishedSigningKey(kid string) (*models.SigningKey, *rsa.PublicKey, error) {
+	var key models.SigningKey
+	err := h.DB.Where("kid = ? AND (expires_at IS NULL OR expires_at > ?)", kid, time.Now()).First(&key).Error
+	if err != nil {
+		return nil, nil, ErrSigningKeyNotFound
+	}
+
+	publicKey, err := SigningKeyPublicKey(&key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &key, publicKey, nil
+}
+
+// TenantJWKS returns the active and not yet expired keys of the tenant
+func (h *Handler) TenantJWKS(tenantID uuid.UUID) (helper.JWKSet, error) {
+	set := helper.JWKSet{Keys: []helper.JWK{}}
+
+	var keys []models.SigningKey
+	err := h.DB.Where("tenant_id = ? AND (expires_at IS NULL OR expires_at > ?)", tenantID, time.Now()).Order("created_at DESC").Find(&keys).Error
+	if err != nil {
+		return set, err
+	}
+
+	for _, key := range keys {
+		publicKey, err := SigningKeyPublicKey(&key)
+		if err != nil {
+			return set, err
+		}
+		set.Keys = append(set.Keys, helper.RSAPublicJWK(publicKey, key.Kid))
+	}
+	return set, nil
+}
+
+// RotateSigningKey creates a new active key for the tenant and retires the previous keys. Retired keys
+// stay published for the longest configured access token lifetime.
+func (h *Handler) RotateSigningKey(tenantID uuid.UUID) (*models.SigningKey, error) {
+	lifetime := h.maxAccessTokenLifetime(tenantID)
+
+	var key *models.SigningKey
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockTenant(tx, tenantID); err != nil {
+			return err
+		}
+		var err error
+		key, _, err = h.createSigningKey(tx, tenantID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&models.SigningKey{}).
+			Where("tenant_id = ? AND id <> ? AND retired_at IS NULL", tenantID, key.ID).
+			Updates(map[string]interface{}{
+				"retired_at": now,
+				"expires_at": now.Add(lifetime),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// maxAccessTokenLifetime is the longest access token lifetime of the tenant on any settings level
+func (h *Handler) maxAccessTokenLifetime(tenantID uuid.UUID) time.Duration {
+	lifetime := DefaultSettings().AccessTokenLifetime
+
+	var seconds *int
+	h.DB.Model(&models.Settings{}).
+		Where("tenant_id = ? OR (tenant_id IS NULL AND client_id IS NULL)", tenantID).
+		Select("MAX(access_token_lifetime)").Scan(&seconds)
+	if seconds != nil && time.Duration(*seconds)*time.Second > lifetime {
+		lifetime = time.Duration(*seconds) * time.Second
+	}
+	return lifetime
+}
+
+// LegacyKeyCutover returns when tokens started to be signed with tenant keys: LEGACY_KEY_CUTOVER (RFC 3339)
+// or the creation of the first signing key. Without signing keys no token was signed with one yet and the
+// cutover is now.
+func (h *Handler) LegacyKeyCutover() time.Time {
+	if cutover := legacyKeyCutover.Load(); cutover != nil {
+		return *cutover
+	}
+
+	if cutover, err := time.Parse(time.RFC3339, os.Getenv("LEGACY_KEY_CUTOVER")); err == nil {
+		legacyKeyCutover.Store(&cutover)
+		return cutover
+	}
+
+	var first models.SigningKey
+	err := h.DB.Order("created_at").First(&first).Error
+	if err != nil {
+		return time.Now()
+	}
+	legacyKeyCutover.Store(&first.CreatedAt)
+	return first.CreatedAt
+}
+
+// AcceptsLegacyToken reports whether a token without kid, signed with the shared key of helper.KeyManager,
+// is still accepted. It has to be issued before the cutover, and the cutover must be less than the longest
+// access token lifetime ago. Afterwards all of these tokens have expired and tokens without kid are rejected.
+func (h *Handler) AcceptsLegacyToken(issuedAt time.Time) bool {
+	cutover := h.LegacyKeyCutover()
+	if !issuedAt.Before(cutover) {
+		return false
+	}
+
+	lifetime := DefaultSettings().AccessTokenLifetime
+	var seconds *int
+	h.DB.Model(&models.Settings{}).Select("MAX(access_token_lifetime)").Scan(&seconds)
+	if seconds != nil && time.Duration(*seconds)*time.Second > lifetime {
+		lifetime = time.Duration(*seconds) * time.Second
+	}
+	return time.Now().Before(cutover.Add(lifetime))
+}
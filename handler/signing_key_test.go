@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+)
+
+func TestActiveSigningKeyCreatesFirstKeyUnderTenantLock(t *testing.T) {
+	helper.SetDefaultMasterKey(helper.NewMasterKey(bytes.Repeat([]byte{7}, helper.MasterKeySize)))
+	tenantID := uuid.New()
+
+	t.Run("created concurrently", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		h := newMockHandler(t, db)
+
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kid := helper.JWKThumbprint(&privateKey.PublicKey)
+		privateKeys.Store(kid, privateKey)
+
+		mock.ExpectQuery(`FROM "signing_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM "tenants" WHERE id = \$1 .* FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+		mock.ExpectQuery(`FROM "signing_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "kid"}).AddRow(uuid.New(), tenantID, kid))
+		mock.ExpectCommit()
+
+		key, signingKey, err := h.ActiveSigningKey(tenantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Kid != kid || signingKey != privateKey {
+			t.Fatalf("ActiveSigningKey = %s, want the concurrently created key %s", key.Kid, kid)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("first key", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		h := newMockHandler(t, db)
+
+		mock.ExpectQuery(`FROM "signing_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM "tenants" .* FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+		mock.ExpectQuery(`FROM "signing_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "signing_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+		mock.ExpectCommit()
+
+		key, privateKey, err := h.ActiveSigningKey(tenantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.TenantID != tenantID || key.Kid != helper.JWKThumbprint(&privateKey.PublicKey) {
+			t.Fatalf("unexpected key %+v", key)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantMismatch = errors.New("the client is not allowed to authenticate users of the tenant")
+	ErrTenantInactive = errors.New("tenant is suspended")
+)
+
+// ResolveTenant determines the tenant users are authenticated in. In order of precedence it is taken from
+// the explicit tenant parameter (ID or slug), the host (a custom domain or a subdomain of APPLICATION_DOMAIN),
+// or the client. APPLICATION_DOMAIN itself is shared and resolves to the tenant of the client.
+// Unless the client is CrossTenant the result has to be the tenant of the client.
+func (h *Handler) ResolveTenant(client *models.Client, tenantParam string, host string) (*models.Tenant, error) {
+	var tenant models.Tenant
+	var err error
+
+	switch {
+	case tenantParam != "":
+		if _, parseErr := uuid.Parse(tenantParam); parseErr == nil {
+			err = h.DB.Where("id = ?", tenantParam).First(&tenant).Error
+		} else {
+			err = h.DB.Where("slug = ?", tenantParam).First(&tenant).Error
+		}
+	case !IsSharedHost(host):
+		hostTenant, hostErr := h.HostTenant(host)
+		if hostErr == nil {
+			tenant = *hostTenant
+			break
+		}
+		fallthrough
+	default:
+		err = h.DB.Where("id = ?", client.TenantID).First(&tenant).Error
+	}
+	if err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	if tenant.ID != client.TenantID && !client.CrossTenant {
+		return nil, ErrTenantMismatch
+	}
+	if !tenant.IsActive {
+		return nil, ErrTenantInactive
+	}
+	return &tenant, nil
+}
+
+// ClientAllowsTenant reports whether the client may authenticate users of the tenant
+func ClientAllowsTenant(client *models.Client, tenantID uuid.UUID) bool {
+	return client.CrossTenant || client.TenantID == tenantID
+}
+
+// TenantSlugFromHost returns the subdomain of host directly below APPLICATION_DOMAIN,
+// e.g. "acme" for "acme.secnex.io". Other hosts have no tenant slug.
+func TenantSlugFromHost(host string) string {
+	domain := os.Getenv("APPLICATION_DOMAIN")
+	if domain == "" || host == "" {
+		return ""
+	}
+
+	subdomain, ok := strings.CutSuffix(NormalizeHost(host), "."+strings.ToLower(domain))
+	if !ok || subdomain == "" || strings.Contains(subdomain, ".") {
+		return ""
+	}
+	return subdomain
+}
+
+// RevokeTenantSessions revokes all sessions and refresh tokens of the users and clients of the tenant
+func (h *Handler) RevokeTenantSessions(tenantID uuid.UUID) error {
+	now := time.Now()
+	users := h.DB.Unscoped().Model(&models.User{}).Select("id").Where("tenant_id = ?", tenantID)
+	clients := h.DB.Unscoped().Model(&models.Client{}).Select("id").Where("tenant_id = ?", tenantID)
+
+	err := h.DB.Model(&models.Session{}).
+		Where("revoked_at IS NULL AND (user_id IN (?) OR client_id IN (?))", users, clients).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Model(&models.RefreshToken{}).
+		Where("revoked_at IS NULL AND (user_id IN (?) OR client_id IN (?))", users, clients).
+		Update("revoked_at", now).Error
+}
+
+// SuspendTenant blocks all logins of the tenant and revokes its sessions
+func (h *Handler) SuspendTenant(tenant *models.Tenant) error {
+	err := h.DB.Model(tenant).Updates(map[string]interface{}{
+		"is_active":    false,
+		"suspended_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return h.RevokeTenantSessions(tenant.ID)
+}
+
+// ActivateTenant lifts the suspension of the tenant
+func (h *Handler) ActivateTenant(tenant *models.Tenant) error {
+	return h.DB.Model(tenant).Updates(map[string]interface{}{
+		"is_active":    true,
+		"suspended_at": nil,
+	}).Error
+}
+
+// DeleteTenant suspends the tenant and soft-deletes it together with its users and clients.
+// The data is removed by PurgeDeletedTenants once the retention period of the tenant has passed.
+func (h *Handler) DeleteTenant(tenant *models.Tenant) error {
+	err := h.SuspendTenant(tenant)
+	if err != nil {
+		return err
+	}
+
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", tenant.ID).Delete(&models.User{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", tenant.ID).Delete(&models.Client{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(tenant).Error
+	})
+}
+
+// tenantUserData are the models removed together with the users of a purged tenant
+var tenantUserData = []interface{}{
+	&models.Session{},
+	&models.RefreshToken{},
+	&models.AuthCode{},
+	&models.Consent{},
+	&models.PasswordHistory{},
+	&models.PasswordReset{},
+	&models.EmailVerification{},
+	&models.TOTPFactor{},
+	&models.RecoveryCode{},
+	&models.MFAChallenge{},
+	&models.WebAuthnCredential{},
+	&models.WebAuthnChallenge{},
+	&models.PasswordlessLogin{},
+}
+
+// tenantClientData are the models removed together with the clients of a purged tenant
+var tenantClientData = []interface{}{
+	&models.ClientSecret{},
+	&models.Session{},
+	&models.RefreshToken{},
+	&models.AuthCode{},
+	&models.Consent{},
+	&models.MFAChallenge{},
+	&models.WebAuthnChallenge{},
+	&models.PasswordlessLogin{},
+	&models.OIDCLogin{},
+}
+
+// tenantData are the models that belong directly to a purged tenant
+var tenantData = []interface{}{
+	&models.PasswordPolicy{},
+	&models.EmailTemplate{},
+	&models.Settings{},
+	&models.TenantDomain{},
+	&models.SigningKey{},
+	&models.RolePermission{},
+	&models.UserRole{},
+	&models.UserGroup{},
+	&models.GroupRole{},
+	&models.Permission{},
+	&models.Role{},
+	&models.Group{},
+	&models.AuthzNamespace{},
+	&models.RelationTuple{},
+	&models.AuthzRevision{},
+	&models.PolicyVersion{},
+	&models.Policy{},
+	&models.AuditEvent{},
+	&models.UserAttribute{},
+	&models.ScimToken{},
+	&models.UserIdentity{},
+	&models.OIDCConnection{},
+	&models.ClaimMapper{},
+}
+
+// PurgeDeletedTenants permanently removes tenants whose retention period after deletion has passed,
+// including all of their users, clients and dependent data. It returns the number of purged tenants.
+func (h *Handler) PurgeDeletedTenants(now time.Time) (int, error) {
+	var tenants []models.Tenant
+	err := h.DB.Unscoped().Where("deleted_at IS NOT NULL").Find(&tenants).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, tenant := range tenants {
+		retention := tenant.RetentionDays
+		if retention <= 0 {
+			retention = models.DefaultRetentionDays
+		}
+		if tenant.DeletedAt.Time.AddDate(0, 0, retention).After(now) {
+			continue
+		}
+
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			users := tx.Unscoped().Model(&models.User{}).Select("id").Where("tenant_id = ?", tenant.ID)
+			clients := tx.Unscoped().Model(&models.Client{}).Select("id").Where("tenant_id = ?", tenant.ID)
+
+			for _, model := range tenantUserData {
+				if err := tx.Unscoped().Where("user_id IN (?)", users).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			for _, model := range tenantClientData {
+				if err := tx.Unscoped().Where("client_id IN (?)", clients).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			for _, model := range tenantData {
+				if err := tx.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(&models.User{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(&models.Client{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&tenant).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/gorm/schema"
+)
+
+// deletePattern matches the table and the first condition column of a DELETE statement
+var deletePattern = regexp.MustCompile(`^DELETE FROM "(\w+)" WHERE "?(?:\w+"?\.)?"?(\w+)"?`)
+
+func TestPurgeDeletedTenantsDeletesByExistingColumns(t *testing.T) {
+	var statements []string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_ string, actual string) error {
+		statements = append(statements, actual)
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h := newMockHandler(t, db)
+
+	now := time.Now()
+	tenantID := uuid.New()
+	mock.ExpectQuery("tenants").WillReturnRows(sqlmock.NewRows([]string{"id", "retention_days", "deleted_at"}).
+		AddRow(tenantID, 30, now.AddDate(0, 0, -31)).
+		AddRow(uuid.New(), 30, now.AddDate(0, 0, -1)))
+	mock.ExpectBegin()
+	for range len(tenantUserData) + len(tenantClientData) + len(tenantData) + 3 {
+		mock.ExpectExec("DELETE").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	purged, err := h.PurgeDeletedTenants(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d tenants, want 1", purged)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := map[string]*schema.Schema{}
+	cache := &sync.Map{}
+	purgedModels := append(append(append([]interface{}{&models.Tenant{}, &models.User{}, &models.Client{}}, tenantUserData...), tenantClientData...), tenantData...)
+	for _, model := range purgedModels {
+		parsed, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables[parsed.Table] = parsed
+	}
+
+	deleted := map[string]bool{}
+	for _, statement := range statements[1:] {
+		match := deletePattern.FindStringSubmatch(statement)
+		if match == nil {
+			t.Fatalf("unexpected statement %s", statement)
+		}
+		table, ok := tables[match[1]]
+		if !ok {
+			t.Fatalf("statement on unknown table %s", statement)
+		}
+		if _, ok := table.FieldsByDBName[match[2]]; !ok {
+			t.Errorf("table %s has no column %s: %s", match[1], match[2], statement)
+		}
+		deleted[match[1]] = true
+	}
+	for name := range tables {
+		if !deleted[name] {
+			t.Errorf("table %s is not purged", name)
+		}
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "secnex.io")
+	own, other := uuid.New(), uuid.New()
+	tests := []struct {
+		name        string
+		param       string
+		host        string
+		crossTenant bool
+		expect      func(mock sqlmock.Sqlmock)
+		tenant      uuid.UUID
+		err         error
+	}{
+		{
+			name: "client on the shared host",
+			host: "secnex.io",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(own, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(own, true))
+			},
+			tenant: own,
+		},
+		{
+			name:  "explicit slug takes precedence over the host",
+			param: "acme",
+			host:  "other.secnex.io",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenants" WHERE slug = \$1`).WithArgs("acme", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(own, true))
+			},
+			tenant: own,
+		},
+		{
+			name: "subdomain of another tenant",
+			host: "other.secnex.io:443",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenant_domains" WHERE hostname = \$1`).WithArgs("other.secnex.io", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`FROM "tenants" WHERE slug = \$1`).WithArgs("other", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(other, true))
+			},
+			err: ErrTenantMismatch,
+		},
+		{
+			name:        "custom domain of another tenant with a cross-tenant client",
+			host:        "login.other.example",
+			crossTenant: true,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenant_domains" WHERE hostname = \$1`).WithArgs("login.other.example", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "hostname"}).AddRow(uuid.New(), other, "login.other.example"))
+				mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(other, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(other, true))
+			},
+			tenant: other,
+		},
+		{
+			name: "unknown host falls back to the client",
+			host: "unknown.example",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenant_domains"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(own, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(own, true))
+			},
+			tenant: own,
+		},
+		{
+			name:  "unknown tenant",
+			param: other.String(),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenants" WHERE id = \$1`).WithArgs(other.String(), 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			err: ErrTenantNotFound,
+		},
+		{
+			name:  "suspended tenant",
+			param: "acme",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM "tenants" WHERE slug = \$1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow(own, false))
+			},
+			err: ErrTenantInactive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			test.expect(mock)
+
+			tenant, err := h.ResolveTenant(&models.Client{TenantID: own, CrossTenant: test.crossTenant}, test.param, test.host)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err == nil && tenant.ID != test.tenant {
+				t.Errorf("tenant = %s, want %s", tenant.ID, test.tenant)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
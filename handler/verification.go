@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// VerificationResendInterval is the minimum time between two verification emails of a user
+	VerificationResendInterval = time.Minute
+	// VerificationDailyLimit is the maximum number of verification emails per user within 24 hours
+	VerificationDailyLimit = 5
+)
+
+var (
+	// ErrVerificationThrottled is returned when a verification email was requested too often
+	ErrVerificationThrottled = errors.New("too many verification emails requested")
+	// ErrVerificationUsed is returned when a verification token was already redeemed
+	ErrVerificationUsed = errors.New("verification token was already used")
+	// ErrEmailInUse is returned when another user of the tenant has the address
+	ErrEmailInUse = errors.New("email address is already in use")
+)
+
+// SendEmailVerification issues a verification token for email and sends it to that address.
+// For an email change email is the new address; the user keeps the old one until it is confirmed.
+func (h *Handler) SendEmailVerification(m mailer.Mailer, user *models.User, email string, verifyURL string) error {
+	email = helper.NormalizeEmail(email)
+	var last models.EmailVerification
+	err := h.DB.Where("user_id = ?", user.ID).Order("created_at DESC").First(&last).Error
+	if err == nil && time.Since(last.CreatedAt) < VerificationResendInterval {
+		return ErrVerificationThrottled
+	}
+
+	var count int64
+	h.DB.Model(&models.EmailVerification{}).Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-24*time.Hour)).Count(&count)
+	if count >= VerificationDailyLimit {
+		return ErrVerificationThrottled
+	}
+
+	// Only the newest verification link is valid
+	h.DB.Model(&models.EmailVerification{}).Where("user_id = ? AND used_at IS NULL", user.ID).Update("used_at", time.Now())
+
+	tokenValue := utils.GenerateToken(32)
+	verification := models.EmailVerification{
+		UserID: user.ID,
+		Email:  email,
+		Token:  tokenValue,
+	}
+	err = h.DB.Create(&verification).Error
+	if err != nil {
+		return err
+	}
+
+	token := utils.EncodeBearerToken(verification.ID.String(), tokenValue)
+	link := token
+	if verifyURL != "" {
+		link = fmt.Sprintf("%s?token=%s", verifyURL, url.QueryEscape(token))
+	}
+
+	return h.SendTemplate(m, user, email, mailer.TemplateEmailVerification, MailData{Link: link})
+}
+
+// ConfirmEmailVerification marks the user as verified and applies a pending email change. The token is
+// claimed, the address checked for uniqueness and the user updated in one transaction.
+func (h *Handler) ConfirmEmailVerification(verification *models.EmailVerification) (*models.User, error) {
+	var user models.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.EmailVerification{}).Where("id = ? AND used_at IS NULL", verification.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrVerificationUsed
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", verification.UserID).First(&user).Error
+		if err != nil {
+			return err
+		}
+
+		email := helper.NormalizeEmail(verification.Email)
+		if email != helper.NormalizeEmail(user.Email) {
+			var count int64
+			err := tx.Unscoped().Model(&models.User{}).Where("LOWER(email) = ? AND tenant_id = ? AND id <> ?", email, user.TenantID, user.ID).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrEmailInUse
+			}
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email":       email,
+			"is_verified": true,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/mailer"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// failingMailer fails the test when a message is sent
+type failingMailer struct {
+	t *testing.T
+}
+
+func (m failingMailer) Send(message mailer.Message) error {
+	m.t.Errorf("sent a message to %s", message.To)
+	return nil
+}
+
+func TestSendEmailVerificationThrottles(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastSent time.Duration
+		daily    int
+	}{
+		{"within the resend interval", 10 * time.Second, 1},
+		{"daily limit reached", 2 * VerificationResendInterval, VerificationDailyLimit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			user := &models.User{ID: uuid.New(), Email: "alice@example.com"}
+
+			mock.ExpectQuery(`FROM "email_verifications" WHERE user_id = \$1 ORDER BY created_at DESC`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "created_at"}).AddRow(uuid.New(), user.ID, time.Now().Add(-test.lastSent)))
+			if test.lastSent >= VerificationResendInterval {
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "email_verifications" WHERE user_id = \$1 AND created_at > \$2`).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.daily))
+			}
+
+			err = h.SendEmailVerification(failingMailer{t}, user, user.Email, "")
+			if !errors.Is(err, ErrVerificationThrottled) {
+				t.Errorf("err = %v, want ErrVerificationThrottled", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConfirmEmailVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		email  string
+		claims int64
+		taken  int
+		err    error
+	}{
+		{"used concurrently", "alice@example.com", 0, 0, ErrVerificationUsed},
+		{"new address of another user", "Bob@Example.com", 1, 1, ErrEmailInUse},
+		{"current address", "alice@example.com", 1, 0, nil},
+		{"new address", "Alice.Smith@Example.com", 1, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			h := newMockHandler(t, db)
+			verification := &models.EmailVerification{ID: uuid.New(), UserID: uuid.New(), Email: test.email}
+			tenantID := uuid.New()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "email_verifications" SET "used_at"=\$1 WHERE id = \$2 AND used_at IS NULL`).
+				WithArgs(sqlmock.AnyArg(), verification.ID).WillReturnResult(sqlmock.NewResult(0, test.claims))
+			if test.claims == 1 {
+				mock.ExpectQuery(`FROM "users" WHERE id = \$1 .*FOR UPDATE`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "email"}).AddRow(verification.UserID, tenantID, "alice@example.com"))
+			}
+			if test.claims == 1 && test.email != "alice@example.com" {
+				mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE LOWER\(email\) = \$1 AND tenant_id = \$2 AND id <> \$3`).
+					WithArgs(sqlmock.AnyArg(), tenantID, verification.UserID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(test.taken))
+			}
+			if test.err != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(`UPDATE "users" SET "email"=\$1,"is_verified"=\$2`).
+					WithArgs(strings.ToLower(test.email), true, sqlmock.AnyArg(), verification.UserID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			user, err := h.ConfirmEmailVerification(verification)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err == nil && (user.Email != strings.ToLower(test.email) || !user.IsVerified) {
+				t.Errorf("user = %s, verified %t", user.Email, user.IsVerified)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
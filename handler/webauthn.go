@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+)
+
+// WebAuthnTimeout is the ceremony timeout sent to the browser in milliseconds
+const WebAuthnTimeout = 300000
+
+var (
+	ErrWebAuthnChallenge     = errors.New("invalid or expired WebAuthn challenge")
+	ErrWebAuthnCredential    = errors.New("unknown WebAuthn credential")
+	ErrWebAuthnVerification  = errors.New("WebAuthn verification failed")
+	ErrWebAuthnSignCount     = helper.ErrWebAuthnSignCount
+	ErrWebAuthnAlreadyExists = errors.New("WebAuthn credential is already registered")
+)
+
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUserEntity struct {
+	ID          helper.Base64URL `json:"id"`
+	Name        string           `json:"name"`
+	DisplayName string           `json:"displayName"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type       string           `json:"type"`
+	ID         helper.Base64URL `json:"id"`
+	Transports []string         `json:"transports,omitempty"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions are the PublicKeyCredentialCreationOptions for navigator.credentials.create
+type WebAuthnCreationOptions struct {
+	Challenge              helper.Base64URL               `json:"challenge"`
+	RP                     WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUserEntity             `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                            `json:"timeout"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are the PublicKeyCredentialRequestOptions for navigator.credentials.get
+type WebAuthnRequestOptions struct {
+	Challenge        helper.Base64URL               `json:"challenge"`
+	Timeout          int                            `json:"timeout"`
+	RPID             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnAttestation is the JSON form of the PublicKeyCredential returned by navigator.credentials.create
+type WebAuthnAttestation struct {
+	ID       string           `json:"id"`
+	RawID    helper.Base64URL `json:"rawId"`
+	Type     string           `json:"type"`
+	Response struct {
+		ClientDataJSON    helper.Base64URL `json:"clientDataJSON"`
+		AttestationObject helper.Base64URL `json:"attestationObject"`
+		Transports        []string         `json:"transports"`
+	} `json:"response"`
+}
+
+// WebAuthnAssertion is the JSON form of the PublicKeyCredential returned by navigator.credentials.get
+type WebAuthnAssertion struct {
+	ID       string           `json:"id"`
+	RawID    helper.Base64URL `json:"rawId"`
+	Type     string           `json:"type"`
+	Response struct {
+		ClientDataJSON    helper.Base64URL `json:"clientDataJSON"`
+		AuthenticatorData helper.Base64URL `json:"authenticatorData"`
+		Signature         helper.Base64URL `json:"signature"`
+		UserHandle        helper.Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// HasWebAuthn reports whether the user has at least one registered security key or passkey
+func (h *Handler) HasWebAuthn(userID uuid.UUID) bool {
+	var count int64
+	h.DB.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count)
+	return count > 0
+}
+
+func (h *Handler) webAuthnDescriptors(userID uuid.UUID) ([]WebAuthnCredentialDescriptor, error) {
+	var credentials []models.WebAuthnCredential
+	err := h.DB.Where("user_id = ?", userID).Find(&credentials).Error
+	if err != nil {
+		return nil, err
+	}
+
+	descriptors := []WebAuthnCredentialDescriptor{}
+	for _, credential := range credentials {
+		id, err := helper.DecodeBase64URL(credential.CredentialID)
+		if err != nil {
+			continue
+		}
+		descriptor := WebAuthnCredentialDescriptor{Type: "public-key", ID: id}
+		if credential.Transports != "" {
+			descriptor.Transports = strings.Split(credential.Transports, ",")
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, nil
+}
+
+// BeginWebAuthnRegistration creates the options to register a new security key or passkey for the user
+func (h *Handler) BeginWebAuthnRegistration(user *models.User) (*WebAuthnCreationOptions, error) {
+	config := helper.WebAuthnConfigFromEnv()
+
+	challenge, err := helper.GenerateWebAuthnChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.DB.Create(&models.WebAuthnChallenge{
+		Type:      models.WebAuthnChallengeRegistration,
+		Challenge: challenge,
+		UserID:    &user.ID,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := h.webAuthnDescriptors(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := user.DisplayName
+	if displayName == "" {
+		displayName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	return &WebAuthnCreationOptions{
+		Challenge: challenge,
+		RP:        WebAuthnRelyingParty{ID: config.RPID, Name: config.RPName},
+		User: WebAuthnUserEntity{
+			ID:          user.ID[:],
+			Name:        user.Email,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []WebAuthnCredentialParameter{
+			{Type: "public-key", Alg: helper.COSEAlgES256},
+			{Type: "public-key", Alg: helper.COSEAlgEdDSA},
+			{Type: "public-key", Alg: helper.COSEAlgRS256},
+		},
+		Timeout:            WebAuthnTimeout,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: WebAuthnAuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishWebAuthnRegistration verifies the attestation of the browser and stores the new credential
+func (h *Handler) FinishWebAuthnRegistration(user *models.User, name string, attestation *WebAuthnAttestation) (*models.WebAuthnCredential, error) {
+	config := helper.WebAuthnConfigFromEnv()
+
+	challenge, err := h.consumeWebAuthnChallenge(attestation.Response.ClientDataJSON, models.WebAuthnChallengeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != user.ID {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	authData, err := config.VerifyRegistration(attestation.Response.ClientDataJSON, attestation.Response.AttestationObject, challenge.Challenge)
+	if err != nil {
+		return nil, errors.Join(ErrWebAuthnVerification, err)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(authData.CredentialID)
+
+	var count int64
+	h.DB.Unscoped().Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credentialID).Count(&count)
+	if count > 0 {
+		return nil, ErrWebAuthnAlreadyExists
+	}
+
+	aaguid, _ := uuid.FromBytes(authData.AAGUID)
+	if name == "" {
+		name = "Security key"
+	}
+
+	credential := models.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: credentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    authData.SignCount,
+		AAGUID:       aaguid.String(),
+		Name:         name,
+		Transports:   strings.Join(attestation.Response.Transports, ","),
+	}
+	err = h.DB.Create(&credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// BeginWebAuthnAssertion creates the options for a login. Without a user the browser offers its discoverable passkeys.
+// The client and MFA challenge are stored so that the assertion can only complete the flow it was started for.
+func (h *Handler) BeginWebAuthnAssertion(userID *uuid.UUID, clientID *uuid.UUID, mfaChallengeID *uuid.UUID, userVerification string) (*WebAuthnRequestOptions, error) {
+	config := helper.WebAuthnConfigFromEnv()
+
+	challenge, err := helper.GenerateWebAuthnChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.DB.Create(&models.WebAuthnChallenge{
+		Type:           models.WebAuthnChallengeAuthentication,
+		Challenge:      challenge,
+		UserID:         userID,
+		ClientID:       clientID,
+		MFAChallengeID: mfaChallengeID,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	allow := []WebAuthnCredentialDescriptor{}
+	if userID != nil {
+		allow, err = h.webAuthnDescriptors(*userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &WebAuthnRequestOptions{
+		Challenge:        challenge,
+		Timeout:          WebAuthnTimeout,
+		RPID:             config.RPID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}, nil
+}
+
+// FinishWebAuthnAssertion verifies the assertion of the browser (see helper.WebAuthnConfig.VerifyAssertion)
+// and returns the used credential and its challenge
+func (h *Handler) FinishWebAuthnAssertion(assertion *WebAuthnAssertion, requireUserVerification bool) (*models.WebAuthnCredential, *models.WebAuthnChallenge, error) {
+	config := helper.WebAuthnConfigFromEnv()
+
+	challenge, err := h.consumeWebAuthnChallenge(assertion.Response.ClientDataJSON, models.WebAuthnChallengeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var credential models.WebAuthnCredential
+	err = h.DB.Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(assertion.RawID)).First(&credential).Error
+	if err != nil {
+		return nil, nil, ErrWebAuthnCredential
+	}
+	if challenge.UserID != nil && *challenge.UserID != credential.UserID {
+		return nil, nil, ErrWebAuthnCredential
+	}
+	if len(assertion.Response.UserHandle) > 0 && string(assertion.Response.UserHandle) != string(credential.UserID[:]) {
+		return nil, nil, ErrWebAuthnCredential
+	}
+
+	authData, err := config.VerifyAssertion(credential.PublicKey, credential.SignCount, assertion.Response.ClientDataJSON,
+		assertion.Response.AuthenticatorData, assertion.Response.Signature, challenge.Challenge, requireUserVerification)
+	if errors.Is(err, ErrWebAuthnSignCount) {
+		return nil, nil, ErrWebAuthnSignCount
+	}
+	if err != nil {
+		return nil, nil, errors.Join(ErrWebAuthnVerification, err)
+	}
+
+	// Conditional update so that a replayed counter value of a concurrent request is rejected
+	result := h.DB.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", credential.ID, credential.SignCount).
+		Updates(map[string]interface{}{
+			"sign_count":   authData.SignCount,
+			"last_used_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil, ErrWebAuthnSignCount
+	}
+
+	return &credential, challenge, nil
+}
+
+// consumeWebAuthnChallenge looks up the open challenge referenced in the client data and marks it as used
+func (h *Handler) consumeWebAuthnChallenge(clientDataJSON []byte, challengeType string) (*models.WebAuthnChallenge, error) {
+	var clientData helper.WebAuthnClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, ErrWebAuthnChallenge
+	}
+	value, err := helper.DecodeBase64URL(clientData.Challenge)
+	if err != nil {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	var challenge models.WebAuthnChallenge
+	err = h.DB.Where("challenge = ? AND type = ? AND used_at IS NULL AND expires_at > ?", value, challengeType, time.Now()).First(&challenge).Error
+	if err != nil {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	result := h.DB.Model(&models.WebAuthnChallenge{}).Where("id = ? AND used_at IS NULL", challenge.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrWebAuthnChallenge
+	}
+	return &challenge, nil
+}
+
+// RemoveWebAuthnCredential deletes a credential of the user
+func (h *Handler) RemoveWebAuthnCredential(userID uuid.UUID, credentialID string) error {
+	result := h.DB.Unscoped().Where("id = ? AND user_id = ?", credentialID, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrWebAuthnCredential
+	}
+	return nil
+}
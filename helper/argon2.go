@@ -2,16 +2,22 @@ package helper
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 )
 
 type Argon2 struct {
 	Params *Argon2Params
+	// Minimum are the configured parameters. Hashes below them need a rehash. Tuning only raises Params
+	// above Minimum, so replicas tuned to different parameters do not rehash each other's hashes.
+	Minimum *Argon2Params
 }
 
 type Argon2Params struct {
@@ -22,16 +28,77 @@ type Argon2Params struct {
 	KeyLength   uint32
 }
 
+// MaxArgon2Time is the upper bound of the time cost used when tuning
+const MaxArgon2Time = 10
+
 func NewArgon2Default() *Argon2 {
+	return NewArgon2(DefaultArgon2Params())
+}
+
+func NewArgon2(params *Argon2Params) *Argon2 {
 	return &Argon2{
-		Params: &Argon2Params{
-			Memory:      64 * 1024, // 64 MB
-			Time:        2,
-			Parallelism: 4,
-			SaltLength:  16,
-			KeyLength:   32,
-		},
+		Params:  params,
+		Minimum: params,
+	}
+}
+
+// NewArgon2FromEnv uses the parameters of Argon2ParamsFromEnv. If ARGON2_TARGET_LATENCY (e.g. "250ms")
+// is set, the time cost is tuned to that latency while the configured parameters remain the Minimum.
+func NewArgon2FromEnv() *Argon2 {
+	configured := Argon2ParamsFromEnv()
+	a := NewArgon2(configured)
+	if target, err := time.ParseDuration(os.Getenv("ARGON2_TARGET_LATENCY")); err == nil && target > 0 {
+		a.Params = TuneArgon2(configured, target)
+	}
+	return a
+}
+
+func DefaultArgon2Params() *Argon2Params {
+	return &Argon2Params{
+		Memory:      64 * 1024, // 64 MB
+		Time:        2,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Argon2ParamsFromEnv reads ARGON2_MEMORY (KiB), ARGON2_TIME and ARGON2_PARALLELISM
+func Argon2ParamsFromEnv() *Argon2Params {
+	params := DefaultArgon2Params()
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY"), 10, 32); err == nil && value > 0 {
+		params.Memory = uint32(value)
 	}
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_TIME"), 10, 32); err == nil && value > 0 {
+		params.Time = uint32(value)
+	}
+	if value, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil && value > 0 {
+		params.Parallelism = uint8(value)
+	}
+	return params
+}
+
+// TuneArgon2 raises the time cost of base until one hash takes at least target or MaxArgon2Time is reached.
+// The result is never below base: if base is already slower than target, it is returned unchanged.
+func TuneArgon2(base *Argon2Params, target time.Duration) *Argon2Params {
+	params := *base
+	password := []byte("sethorize-tuning")
+	salt := make([]byte, params.SaltLength)
+
+	measure := func() time.Duration {
+		start := time.Now()
+		argon2.IDKey(password, salt, params.Time, params.Memory, params.Parallelism, params.KeyLength)
+		return time.Since(start)
+	}
+
+	elapsed := measure()
+	for elapsed < target && params.Time < MaxArgon2Time {
+		params.Time++
+		elapsed = measure()
+	}
+
+	fmt.Printf("Argon2 tuned to m=%d,t=%d,p=%d (%v per hash)\n", params.Memory, params.Time, params.Parallelism, elapsed)
+	return &params
 }
 
 func (a *Argon2) ExtractParams(hash string) (*Argon2Params, []byte, []byte, error) {
@@ -101,27 +168,41 @@ func (a *Argon2) ExtractParams(hash string) (*Argon2Params, []byte, []byte, erro
 	return params, salt, hashBytes, nil
 }
 
-func (a *Argon2) Compare(password string, hash string) (bool, error) {
+// Compare checks the password against the hash. needsRehash reports that the hash
+// was created with weaker parameters than the configured ones.
+func (a *Argon2) Compare(password string, hash string) (valid bool, needsRehash bool, err error) {
 	params, salt, expectedHash, err := a.ExtractParams(hash)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	// Hash das eingegebene Passwort mit den extrahierten Parametern
 	computedHash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, params.KeyLength)
 
-	// Vergleiche die Hashes
-	if len(computedHash) != len(expectedHash) {
-		return false, nil
+	// Vergleiche die Hashes in konstanter Zeit
+	if subtle.ConstantTimeCompare(computedHash, expectedHash) != 1 {
+		return false, false, nil
 	}
 
-	for i := range computedHash {
-		if computedHash[i] != expectedHash[i] {
-			return false, nil
-		}
+	return true, a.NeedsRehash(params), nil
+}
+
+// NeedsRehash reports whether params are below the configured Minimum or use another parallelism
+func (a *Argon2) NeedsRehash(params *Argon2Params) bool {
+	minimum := a.Minimum
+	if minimum == nil {
+		minimum = a.Params
 	}
+	return params.Memory < minimum.Memory ||
+		params.Time < minimum.Time ||
+		params.Parallelism != minimum.Parallelism ||
+		params.SaltLength < minimum.SaltLength ||
+		params.KeyLength < minimum.KeyLength
+}
 
-	return true, nil
+// IsArgon2Hash reports whether the hash is in the encoded Argon2id format
+func IsArgon2Hash(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
 }
 
 func (a *Argon2) Hash(password string) (string, error) {
@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func testArgon2Params() *Argon2Params {
+	return &Argon2Params{Memory: 1024, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestArgon2NeedsRehash(t *testing.T) {
+	configured := testArgon2Params()
+	a := NewArgon2(configured)
+
+	tests := []struct {
+		name   string
+		params Argon2Params
+		want   bool
+	}{
+		{"configured", *configured, false},
+		{"higher time", Argon2Params{Memory: 1024, Time: 4, Parallelism: 1, SaltLength: 16, KeyLength: 32}, false},
+		{"higher memory", Argon2Params{Memory: 2048, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, false},
+		{"lower memory", Argon2Params{Memory: 512, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"other parallelism", Argon2Params{Memory: 1024, Time: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"shorter key", Argon2Params{Memory: 1024, Time: 1, Parallelism: 1, SaltLength: 16, KeyLength: 16}, true},
+	}
+	for _, tt := range tests {
+		if got := a.NeedsRehash(&tt.params); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArgon2TunedHashesAreNotRehashed(t *testing.T) {
+	configured := testArgon2Params()
+
+	// Two replicas tuned to different time costs
+	fast := NewArgon2(configured)
+	fast.Params = TuneArgon2(configured, time.Nanosecond)
+	slow := NewArgon2(configured)
+	slow.Params = &Argon2Params{Memory: 1024, Time: 3, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	if fast.Params.Time < configured.Time {
+		t.Fatalf("tuned time %d below configured %d", fast.Params.Time, configured.Time)
+	}
+
+	for _, hasher := range []*Argon2{fast, slow} {
+		hash, err := hasher.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, verifier := range []*Argon2{fast, slow} {
+			valid, needsRehash, err := verifier.Compare("correct horse battery staple", hash)
+			if err != nil || !valid {
+				t.Fatalf("Compare = %v, %v", valid, err)
+			}
+			if needsRehash {
+				t.Errorf("hash with t=%d rehashed by replica with t=%d", hasher.Params.Time, verifier.Params.Time)
+			}
+		}
+	}
+}
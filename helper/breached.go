@@ -0,0 +1,165 @@
+package helper
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BreachedPasswordCorpus checks passwords against an offline corpus of SHA-1 hashes.
+//
+// Path may point to a single file with one upper case SHA-1 hash per line (optionally
+// followed by ":count"), or to a directory in the range layout of "Have I Been Pwned",
+// where each file is named after a five character hash prefix and lists "SUFFIX:count".
+type BreachedPasswordCorpus struct {
+	Path string
+
+	mu       sync.RWMutex
+	loaded   bool
+	isDir    bool
+	prefixes map[string]map[string]struct{}
+}
+
+// ErrBreachedPasswordsUnavailable is returned when the corpus cannot be read. Passwords cannot be
+// checked then and are rejected; handlers answer with 503.
+var ErrBreachedPasswordsUnavailable = errors.New("breached password corpus is unavailable")
+
+var (
+	breachedPasswords   *BreachedPasswordCorpus
+	breachedPasswordsMu sync.Mutex
+)
+
+func NewBreachedPasswordCorpus(path string) *BreachedPasswordCorpus {
+	return &BreachedPasswordCorpus{Path: path}
+}
+
+// BreachedPasswords returns the process wide corpus configured by BREACHED_PASSWORDS_PATH
+func BreachedPasswords() *BreachedPasswordCorpus {
+	breachedPasswordsMu.Lock()
+	defer breachedPasswordsMu.Unlock()
+	if breachedPasswords == nil {
+		breachedPasswords = NewBreachedPasswordCorpus(os.Getenv("BREACHED_PASSWORDS_PATH"))
+	}
+	return breachedPasswords
+}
+
+// SetBreachedPasswords replaces the process wide corpus
+func SetBreachedPasswords(corpus *BreachedPasswordCorpus) {
+	breachedPasswordsMu.Lock()
+	defer breachedPasswordsMu.Unlock()
+	breachedPasswords = corpus
+}
+
+// Contains reports whether the password is part of the corpus. Without a configured path nothing is breached.
+// If the corpus cannot be loaded the error wraps ErrBreachedPasswordsUnavailable and the next call retries.
+func (c *BreachedPasswordCorpus) Contains(password string) (bool, error) {
+	if c == nil || c.Path == "" {
+		return false, nil
+	}
+
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+	if !loaded {
+		if err := c.Reload(); err != nil {
+			return false, err
+		}
+	}
+
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	c.mu.RLock()
+	isDir := c.isDir
+	_, ok := c.prefixes[prefix][suffix]
+	c.mu.RUnlock()
+
+	if isDir {
+		ok, err := c.containsInRangeFile(prefix, suffix)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrBreachedPasswordsUnavailable, err)
+		}
+		return ok, nil
+	}
+	return ok, nil
+}
+
+// Reload reads the corpus again, e.g. after the file was replaced
+func (c *BreachedPasswordCorpus) Reload() error {
+	isDir, prefixes, err := c.load()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBreachedPasswordsUnavailable, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = true
+	c.isDir = isDir
+	c.prefixes = prefixes
+	return nil
+}
+
+func (c *BreachedPasswordCorpus) load() (bool, map[string]map[string]struct{}, error) {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return false, nil, err
+	}
+	if info.IsDir() {
+		return true, nil, nil
+	}
+
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return false, nil, err
+	}
+	defer file.Close()
+
+	prefixes := make(map[string]map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		digest, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(digest) != 40 {
+			continue
+		}
+		digest = strings.ToUpper(digest)
+		prefix := digest[:5]
+		if prefixes[prefix] == nil {
+			prefixes[prefix] = make(map[string]struct{})
+		}
+		prefixes[prefix][digest[5:]] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, nil, err
+	}
+	return false, prefixes, nil
+}
+
+func (c *BreachedPasswordCorpus) containsInRangeFile(prefix string, suffix string) (bool, error) {
+	file, err := os.Open(filepath.Join(c.Path, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(c.Path, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,40 @@
+package helper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBreachedPasswordsUnavailableRejectsAndRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	SetBreachedPasswords(NewBreachedPasswordCorpus(path))
+	t.Cleanup(func() { SetBreachedPasswords(nil) })
+
+	rules := DefaultPasswordRules()
+	context := PasswordContext{Email: "alice@example.com"}
+
+	err := rules.Check("a long enough passphrase", context)
+	if !errors.Is(err, ErrBreachedPasswordsUnavailable) {
+		t.Fatalf("Check with missing corpus = %v, want ErrBreachedPasswordsUnavailable", err)
+	}
+
+	sum := sha1.Sum([]byte("a long enough passphrase"))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(path, []byte(digest+":42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = rules.Check("a long enough passphrase", context)
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) || policyErr.Violations[0].Code != "breached" {
+		t.Fatalf("Check after the corpus appeared = %v, want breached violation", err)
+	}
+	if err := rules.Check("another long passphrase", context); err != nil {
+		t.Fatalf("Check of a password not in the corpus = %v", err)
+	}
+}
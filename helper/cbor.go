@@ -0,0 +1,201 @@
+package helper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// cborMaxDepth limits nesting to protect against malicious input
+const cborMaxDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// DecodeCBOR decodes the first CBOR item in data (RFC 8949) and returns it together with
+// the number of bytes consumed. Only the subset used by WebAuthn is supported: integers,
+// byte and text strings, arrays, maps, tags, booleans, null and floats. Indefinite lengths are rejected.
+//
+// Maps are returned as map[interface{}]interface{} with int64 or string keys.
+func DecodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.offset, nil
+}
+
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (d *cborDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.offset+n > len(d.data) {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+func (d *cborDecoder) header() (byte, uint64, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		v, err := d.read(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(v[0]), nil
+	case info == 25:
+		v, err := d.read(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(v)), nil
+	case info == 26:
+		v, err := d.read(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(v)), nil
+	case info == 27:
+		v, err := d.read(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(v), nil
+	}
+	return 0, 0, fmt.Errorf("cbor: unsupported additional information %d", info)
+}
+
+func (d *cborDecoder) length(value uint64) (int, error) {
+	if value > uint64(len(d.data)-d.offset) {
+		return 0, errCBORTruncated
+	}
+	return int(value), nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+
+	start := d.offset
+	major, value, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if value > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return int64(value), nil
+	case 1:
+		if value > math.MaxInt64 {
+			return nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(value), nil
+	case 2, 3:
+		n, err := d.length(value)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		n, err := d.length(value)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		n, err := d.length(value)
+		if err != nil {
+			return nil, err
+		}
+		items := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: unsupported map key type")
+			}
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items[key] = item
+		}
+		return items, nil
+	case 6:
+		// Tags are ignored, the tagged item is returned
+		return d.decode(depth + 1)
+	case 7:
+		info := d.data[start] & 0x1f
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float64(halfToFloat(uint16(value))), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(value))), nil
+		case 27:
+			return math.Float64frombits(value), nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+	return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exponent := uint32(h>>10) & 0x1f
+	fraction := uint32(h & 0x3ff)
+
+	switch exponent {
+	case 0:
+		value := float32(fraction) / 1024 / 16384
+		if sign != 0 {
+			return -value
+		}
+		return value
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | fraction<<13)
+	}
+	return math.Float32frombits(sign | (exponent+112)<<23 | fraction<<13)
+}
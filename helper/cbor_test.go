@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"small int", []byte{0x17}, int64(23)},
+		{"uint8", []byte{0x18, 0xff}, int64(255)},
+		{"uint16", []byte{0x19, 0x01, 0x00}, int64(256)},
+		{"negative", []byte{0x26}, int64(-7)},
+		{"negative uint16", []byte{0x39, 0x01, 0x00}, int64(-257)},
+		{"bytes", []byte{0x43, 0x01, 0x02, 0x03}, []byte{1, 2, 3}},
+		{"text", []byte{0x63, 'f', 'm', 't'}, "fmt"},
+		{"array", []byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x61, 'a', 0xf5}, map[interface{}]interface{}{int64(1): int64(2), "a": true}},
+		{"tag", []byte{0xc1, 0x01}, int64(1)},
+		{"false", []byte{0xf4}, false},
+		{"null", []byte{0xf6}, nil},
+		{"half float", []byte{0xf9, 0x3c, 0x00}, float64(1)},
+		{"double", []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+	}
+	for _, tt := range tests {
+		got, n, err := DecodeCBOR(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if n != len(tt.data) {
+			t.Errorf("%s: consumed %d of %d bytes", tt.name, n, len(tt.data))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated uint16", []byte{0x19, 0x01}},
+		{"truncated uint64", []byte{0x1b, 0, 0, 0}},
+		{"truncated bytes", []byte{0x45, 0x01, 0x02}},
+		{"truncated text", []byte{0x78, 0x05, 'a'}},
+		{"huge byte length", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array length", []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map length", []byte{0xba, 0xff, 0xff, 0xff, 0xff}},
+		{"array missing items", []byte{0x83, 0x01}},
+		{"map missing value", []byte{0xa1, 0x01}},
+		{"indefinite bytes", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"indefinite map", []byte{0xbf, 0x01, 0x02, 0xff}},
+		{"reserved additional information", []byte{0x1c}},
+		{"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"byte string map key", []byte{0xa1, 0x41, 0x01, 0x01}},
+		{"array map key", []byte{0xa1, 0x80, 0x01}},
+		{"unsupported simple value", []byte{0xf8, 0x20}},
+		{"tag without item", []byte{0xc1}},
+		{"nesting too deep", bytes.Repeat([]byte{0x81}, cborMaxDepth+2)},
+	}
+	for _, tt := range tests {
+		if _, _, err := DecodeCBOR(tt.data); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+// TestDecodeCBORNoPanic decodes every prefix of valid input and random mutations of it
+func TestDecodeCBORNoPanic(t *testing.T) {
+	authenticator := newSoftAuthenticator(t)
+	valid := authenticator.attestationObject(WebAuthnFlagUserPresent | WebAuthnFlagAttestedData)
+
+	for i := 0; i < len(valid); i++ {
+		if _, _, err := DecodeCBOR(valid[:i]); err == nil {
+			t.Fatalf("prefix of %d bytes decoded without error", i)
+		}
+		ParseAttestationObject(valid[:i])
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		mutated := append([]byte(nil), valid...)
+		for j := 0; j < 1+random.Intn(4); j++ {
+			mutated[random.Intn(len(mutated))] = byte(random.Intn(256))
+		}
+		DecodeCBOR(mutated)
+		ParseAttestationObject(mutated)
+
+		garbage := make([]byte, random.Intn(64))
+		random.Read(garbage)
+		DecodeCBOR(garbage)
+		ParseCOSEKey(garbage)
+		ParseAuthenticatorData(garbage)
+	}
+}
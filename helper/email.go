@@ -0,0 +1,9 @@
+package helper
+
+import "strings"
+
+// NormalizeEmail returns the form in which email addresses are stored and compared. Addresses are
+// unique within a tenant regardless of case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,216 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHasherBusy is returned when no hashing slot became available within the
+// configured queue timeout. Handlers should answer with 503 in that case.
+var ErrHasherBusy = errors.New("password hasher is busy")
+
+// HasherLatencyBuckets are the upper bounds (in seconds) of the hash latency histogram
+var HasherLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type HasherOptions struct {
+	// MaxConcurrent is the number of hash operations allowed to run at the same time
+	MaxConcurrent int
+	// QueueTimeout is how long a caller waits for a free slot before ErrHasherBusy is returned
+	QueueTimeout time.Duration
+}
+
+type HasherMetrics struct {
+	MaxConcurrent  int       `json:"max_concurrent"`
+	QueueDepth     int64     `json:"queue_depth"`
+	InFlight       int64     `json:"in_flight"`
+	Completed      uint64    `json:"completed"`
+	Rejected       uint64    `json:"rejected"`
+	LatencySum     float64   `json:"latency_seconds_sum"`
+	LatencyBuckets []uint64  `json:"latency_seconds_buckets"`
+	LatencyBounds  []float64 `json:"latency_seconds_bounds"`
+}
+
+// Hasher runs Argon2 operations with bounded concurrency. Every Argon2 computation
+// allocates Params.Memory KiB, so unbounded parallel logins can exhaust the memory of the process.
+type Hasher struct {
+	Argon2  *Argon2
+	Options HasherOptions
+
+	slots      chan struct{}
+	queueDepth atomic.Int64
+	inFlight   atomic.Int64
+	completed  atomic.Uint64
+	rejected   atomic.Uint64
+
+	mu             sync.Mutex
+	latencySum     float64
+	latencyBuckets []uint64
+}
+
+var (
+	defaultHasher   *Hasher
+	defaultHasherMu sync.RWMutex
+)
+
+func NewHasher(argon2 *Argon2, options HasherOptions) *Hasher {
+	if options.MaxConcurrent < 1 {
+		options.MaxConcurrent = 1
+	}
+	if options.QueueTimeout <= 0 {
+		options.QueueTimeout = 5 * time.Second
+	}
+	return &Hasher{
+		Argon2:         argon2,
+		Options:        options,
+		slots:          make(chan struct{}, options.MaxConcurrent),
+		latencyBuckets: make([]uint64, len(HasherLatencyBuckets)+1),
+	}
+}
+
+// HasherOptionsFromEnv reads HASH_MAX_CONCURRENT and HASH_QUEUE_TIMEOUT (e.g. "3s").
+// Missing values default to the number of CPUs and five seconds.
+func HasherOptionsFromEnv() HasherOptions {
+	options := HasherOptions{
+		MaxConcurrent: runtime.NumCPU(),
+		QueueTimeout:  5 * time.Second,
+	}
+	if value, err := strconv.Atoi(os.Getenv("HASH_MAX_CONCURRENT")); err == nil && value > 0 {
+		options.MaxConcurrent = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("HASH_QUEUE_TIMEOUT")); err == nil && value > 0 {
+		options.QueueTimeout = value
+	}
+	return options
+}
+
+// InitDefaultHasher creates the process wide hasher from the environment. It is called once at startup,
+// so that tuning to ARGON2_TARGET_LATENCY does not run during the first request.
+func InitDefaultHasher() *Hasher {
+	hasher := NewHasher(NewArgon2FromEnv(), HasherOptionsFromEnv())
+	SetDefaultHasher(hasher)
+	return hasher
+}
+
+// DefaultHasher returns the process wide hasher used by the models and handlers.
+// Without InitDefaultHasher it uses the configured parameters untuned.
+func DefaultHasher() *Hasher {
+	defaultHasherMu.RLock()
+	hasher := defaultHasher
+	defaultHasherMu.RUnlock()
+	if hasher != nil {
+		return hasher
+	}
+
+	defaultHasherMu.Lock()
+	defer defaultHasherMu.Unlock()
+	if defaultHasher == nil {
+		defaultHasher = NewHasher(NewArgon2(Argon2ParamsFromEnv()), HasherOptionsFromEnv())
+	}
+	return defaultHasher
+}
+
+// SetDefaultHasher replaces the process wide hasher, e.g. with different limits
+func SetDefaultHasher(hasher *Hasher) {
+	defaultHasherMu.Lock()
+	defer defaultHasherMu.Unlock()
+	defaultHasher = hasher
+}
+
+// Hash hashes the password once a slot is available
+func (h *Hasher) Hash(password string) (string, error) {
+	release, err := h.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return h.Argon2.Hash(password)
+}
+
+// Compare compares the password with the hash once a slot is available.
+// needsRehash is reported for Argon2 hashes with outdated parameters and for all legacy hashes.
+func (h *Hasher) Compare(password string, hash string) (valid bool, needsRehash bool, err error) {
+	release, err := h.acquire()
+	if err != nil {
+		return false, false, err
+	}
+	defer release()
+
+	if !IsArgon2Hash(hash) {
+		if verifier := legacyVerifierFor(hash); verifier != nil {
+			valid, err = verifier.Verify(password, hash)
+			return valid, valid, err
+		}
+	}
+
+	return h.Argon2.Compare(password, hash)
+}
+
+func (h *Hasher) acquire() (func(), error) {
+	h.queueDepth.Add(1)
+	timer := time.NewTimer(h.Options.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case h.slots <- struct{}{}:
+		h.queueDepth.Add(-1)
+	case <-timer.C:
+		h.queueDepth.Add(-1)
+		h.rejected.Add(1)
+		return nil, ErrHasherBusy
+	}
+
+	h.inFlight.Add(1)
+	start := time.Now()
+	return func() {
+		h.observe(time.Since(start))
+		h.inFlight.Add(-1)
+		h.completed.Add(1)
+		<-h.slots
+	}, nil
+}
+
+func (h *Hasher) observe(latency time.Duration) {
+	seconds := latency.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latencySum += seconds
+	for i, bound := range HasherLatencyBuckets {
+		if seconds <= bound {
+			h.latencyBuckets[i]++
+			return
+		}
+	}
+	h.latencyBuckets[len(HasherLatencyBuckets)]++
+}
+
+// Metrics returns a snapshot of the queue and latency metrics.
+// LatencyBuckets are cumulative; the last entry counts all observations (+Inf).
+func (h *Hasher) Metrics() HasherMetrics {
+	h.mu.Lock()
+	buckets := make([]uint64, len(h.latencyBuckets))
+	var cumulative uint64
+	for i, count := range h.latencyBuckets {
+		cumulative += count
+		buckets[i] = cumulative
+	}
+	latencySum := h.latencySum
+	h.mu.Unlock()
+
+	return HasherMetrics{
+		MaxConcurrent:  h.Options.MaxConcurrent,
+		QueueDepth:     h.queueDepth.Load(),
+		InFlight:       h.inFlight.Load(),
+		Completed:      h.completed.Load(),
+		Rejected:       h.rejected.Load(),
+		LatencySum:     latencySum,
+		LatencyBuckets: buckets,
+		LatencyBounds:  HasherLatencyBuckets,
+	}
+}
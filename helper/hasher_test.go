@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testHasher(maxConcurrent int, queueTimeout time.Duration) *Hasher {
+	return NewHasher(NewArgon2(testArgon2Params()), HasherOptions{MaxConcurrent: maxConcurrent, QueueTimeout: queueTimeout})
+}
+
+func TestHasherBusyWhenSlotsAreTaken(t *testing.T) {
+	h := testHasher(2, 50*time.Millisecond)
+	hash, err := h.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the semaphore
+	var releases []func()
+	for range 2 {
+		release, err := h.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	start := time.Now()
+	if _, err := h.Hash("password"); !errors.Is(err, ErrHasherBusy) {
+		t.Fatalf("Hash = %v, want ErrHasherBusy", err)
+	}
+	if waited := time.Since(start); waited < h.Options.QueueTimeout {
+		t.Errorf("rejected after %s, before the queue timeout", waited)
+	}
+	if _, _, err := h.Compare("password", hash); !errors.Is(err, ErrHasherBusy) {
+		t.Fatalf("Compare = %v, want ErrHasherBusy", err)
+	}
+
+	metrics := h.Metrics()
+	if metrics.Rejected != 2 || metrics.InFlight != 2 || metrics.QueueDepth != 0 || metrics.Completed != 1 || metrics.MaxConcurrent != 2 {
+		t.Errorf("metrics with full slots = %+v", metrics)
+	}
+
+	// A free slot is used again
+	releases[0]()
+	valid, _, err := h.Compare("password", hash)
+	if err != nil || !valid {
+		t.Fatalf("Compare = %v, %v", valid, err)
+	}
+	releases[1]()
+
+	metrics = h.Metrics()
+	if metrics.Rejected != 2 || metrics.InFlight != 0 || metrics.Completed != 4 {
+		t.Errorf("metrics after release = %+v", metrics)
+	}
+	if count := metrics.LatencyBuckets[len(metrics.LatencyBuckets)-1]; count != 4 {
+		t.Errorf("latency count = %d, want 4", count)
+	}
+}
+
+func TestHasherQueuesUntilASlotIsFree(t *testing.T) {
+	h := testHasher(1, 5*time.Second)
+	release, err := h.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := h.Hash("password")
+		done <- err
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for h.Metrics().QueueDepth != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the hash operation is not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Hash returned %v while the slot was taken", err)
+	default:
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if metrics := h.Metrics(); metrics.QueueDepth != 0 || metrics.Completed != 2 || metrics.Rejected != 0 {
+		t.Errorf("metrics = %+v", metrics)
+	}
+}
+
+func TestHasherLatencyBuckets(t *testing.T) {
+	h := testHasher(1, time.Second)
+	for _, latency := range []time.Duration{5 * time.Millisecond, 30 * time.Millisecond, 50 * time.Millisecond, 10 * time.Second} {
+		h.observe(latency)
+	}
+
+	metrics := h.Metrics()
+	want := []uint64{1, 1, 3, 3, 3, 3, 3, 3, 3, 4}
+	if len(metrics.LatencyBuckets) != len(want) {
+		t.Fatalf("buckets = %v", metrics.LatencyBuckets)
+	}
+	for i := range want {
+		if metrics.LatencyBuckets[i] != want[i] {
+			t.Fatalf("cumulative buckets = %v, want %v", metrics.LatencyBuckets, want)
+		}
+	}
+	if metrics.LatencySum < 10.084 || metrics.LatencySum > 10.086 {
+		t.Errorf("latency sum = %f", metrics.LatencySum)
+	}
+}
+
+func TestHasherOptionsFromEnv(t *testing.T) {
+	t.Setenv("HASH_MAX_CONCURRENT", "3")
+	t.Setenv("HASH_QUEUE_TIMEOUT", "150ms")
+	if options := HasherOptionsFromEnv(); options.MaxConcurrent != 3 || options.QueueTimeout != 150*time.Millisecond {
+		t.Errorf("options = %+v", options)
+	}
+
+	t.Setenv("HASH_MAX_CONCURRENT", "0")
+	t.Setenv("HASH_QUEUE_TIMEOUT", "soon")
+	if options := HasherOptionsFromEnv(); options.MaxConcurrent < 1 || options.QueueTimeout != 5*time.Second {
+		t.Errorf("default options = %+v", options)
+	}
+
+	if h := NewHasher(nil, HasherOptions{}); h.Options.MaxConcurrent != 1 || h.Options.QueueTimeout != 5*time.Second || cap(h.slots) != 1 {
+		t.Errorf("options without limits = %+v", h.Options)
+	}
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// JWK is a public RSA or, for keys of upstream providers, EC signing key as JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served as jwks_uri
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// RSAPublicJWK encodes the public key for RS256 signatures
+func RSAPublicJWK(key *rsa.PublicKey, kid string) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// JWKThumbprint returns the RFC 7638 thumbprint of the RSA public key, used as kid
+func JWKThumbprint(key *rsa.PublicKey) string {
+	jwk := RSAPublicJWK(key, "")
+	canonical := `{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PublicKey decodes an RSA or EC (P-256, P-384) public key
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		bytes, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(bytes) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(bytes), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type " + jwk.Kty)
+}
@@ -0,0 +1,172 @@
+package helper
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordVerifier verifies password hashes imported from legacy systems.
+// Matching hashes are always reported as outdated so they get upgraded to Argon2.
+type PasswordVerifier interface {
+	Supports(hash string) bool
+	Verify(password string, hash string) (bool, error)
+}
+
+// LegacyVerifiers are consulted for every hash that is not in the Argon2id format
+var LegacyVerifiers = []PasswordVerifier{
+	BcryptVerifier{},
+	ScryptVerifier{},
+	PBKDF2Verifier{},
+}
+
+// IsPasswordHash reports whether the value is a hash that can be verified
+func IsPasswordHash(hash string) bool {
+	if IsArgon2Hash(hash) {
+		return true
+	}
+	return legacyVerifierFor(hash) != nil
+}
+
+func legacyVerifierFor(hash string) PasswordVerifier {
+	for _, verifier := range LegacyVerifiers {
+		if verifier.Supports(hash) {
+			return verifier
+		}
+	}
+	return nil
+}
+
+// BcryptVerifier verifies $2a$, $2b$ and $2y$ hashes
+type BcryptVerifier struct{}
+
+func (BcryptVerifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (BcryptVerifier) Verify(password string, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ScryptVerifier verifies hashes in the passlib format: $scrypt$ln=15,r=8,p=1$salt$hash
+type ScryptVerifier struct{}
+
+func (ScryptVerifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$scrypt$")
+}
+
+func (ScryptVerifier) Verify(password string, hash string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return false, fmt.Errorf("invalid scrypt hash format")
+	}
+
+	var logN, r, p int
+	for _, param := range strings.Split(parts[2], ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return false, fmt.Errorf("invalid scrypt parameter format")
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return false, err
+		}
+		switch key {
+		case "ln":
+			logN = number
+		case "r":
+			r = number
+		case "p":
+			p = number
+		}
+	}
+	if logN < 1 || logN > 30 || r < 1 || p < 1 {
+		return false, fmt.Errorf("invalid scrypt parameters")
+	}
+
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return false, err
+	}
+	expected, err := decodeAdaptedBase64(parts[4])
+	if err != nil {
+		return false, err
+	}
+
+	computed, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(expected))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(computed, expected) == 1, nil
+}
+
+// PBKDF2Verifier verifies passlib ($pbkdf2-sha256$rounds$salt$hash) and
+// Django (pbkdf2_sha256$rounds$salt$hash) hashes with SHA-1, SHA-256 or SHA-512
+type PBKDF2Verifier struct{}
+
+func (PBKDF2Verifier) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$pbkdf2") || strings.HasPrefix(hash, "pbkdf2_")
+}
+
+func (PBKDF2Verifier) Verify(password string, encoded string) (bool, error) {
+	django := strings.HasPrefix(encoded, "pbkdf2_")
+	parts := strings.Split(strings.TrimPrefix(encoded, "$"), "$")
+	if len(parts) != 4 {
+		return false, fmt.Errorf("invalid pbkdf2 hash format")
+	}
+
+	var digest func() hash.Hash
+	switch strings.NewReplacer("_", "-").Replace(parts[0]) {
+	case "pbkdf2", "pbkdf2-sha1":
+		digest = sha1.New
+	case "pbkdf2-sha256":
+		digest = sha256.New
+	case "pbkdf2-sha512":
+		digest = sha512.New
+	default:
+		return false, fmt.Errorf("unsupported pbkdf2 digest: %s", parts[0])
+	}
+
+	rounds, err := strconv.Atoi(parts[1])
+	if err != nil || rounds < 1 {
+		return false, fmt.Errorf("invalid pbkdf2 rounds")
+	}
+
+	var salt, expected []byte
+	if django {
+		// Django stores the salt as plain text and the hash as standard base64
+		salt = []byte(parts[2])
+		expected, err = base64.StdEncoding.DecodeString(parts[3])
+	} else {
+		salt, err = decodeAdaptedBase64(parts[2])
+		if err == nil {
+			expected, err = decodeAdaptedBase64(parts[3])
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	computed := pbkdf2.Key([]byte(password), salt, rounds, len(expected), digest)
+	return subtle.ConstantTimeCompare(computed, expected) == 1, nil
+}
+
+// decodeAdaptedBase64 decodes standard base64 as well as the passlib variant ("." instead of "+", no padding)
+func decodeAdaptedBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(strings.ReplaceAll(value, ".", "+"), "=")
+	return base64.RawStdEncoding.DecodeString(value)
+}
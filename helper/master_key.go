@@ -0,0 +1,146 @@
+package helper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	MasterKeySize = 32
+	MasterKeyFile = "master.key"
+
+	encryptedPrefix = "$aes256gcm$"
+)
+
+// MasterKey encrypts key material at rest with AES-256-GCM
+type MasterKey struct {
+	Key []byte
+}
+
+var (
+	defaultMasterKey    *MasterKey
+	defaultMasterKeyErr error
+	defaultMasterKeyMu  sync.Mutex
+)
+
+func NewMasterKey(key []byte) *MasterKey {
+	return &MasterKey{Key: key}
+}
+
+// DefaultMasterKey returns the process wide master key.
+// It is read from MASTER_KEY (base64) or loaded/generated from master.key.
+func DefaultMasterKey() (*MasterKey, error) {
+	defaultMasterKeyMu.Lock()
+	defer defaultMasterKeyMu.Unlock()
+
+	if defaultMasterKey == nil && defaultMasterKeyErr == nil {
+		key, err := LoadOrGenerateMasterKey()
+		if err != nil {
+			defaultMasterKeyErr = err
+		} else {
+			defaultMasterKey = NewMasterKey(key)
+		}
+	}
+	return defaultMasterKey, defaultMasterKeyErr
+}
+
+// SetDefaultMasterKey replaces the process wide master key
+func SetDefaultMasterKey(masterKey *MasterKey) {
+	defaultMasterKeyMu.Lock()
+	defer defaultMasterKeyMu.Unlock()
+	defaultMasterKey = masterKey
+	defaultMasterKeyErr = nil
+}
+
+// LoadOrGenerateMasterKey loads the master key from MASTER_KEY or from the key file and generates it if missing
+func LoadOrGenerateMasterKey() ([]byte, error) {
+	if encoded := os.Getenv("MASTER_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding MASTER_KEY: %v", err)
+		}
+		if len(key) != MasterKeySize {
+			return nil, fmt.Errorf("MASTER_KEY must be %d bytes", MasterKeySize)
+		}
+		return key, nil
+	}
+
+	keyPath := filepath.Join(".", MasterKeyFile)
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading master key: %v", err)
+	}
+
+	fmt.Println("Master key not found. Generating new master key...")
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating master key: %v", err)
+	}
+	err = os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key)), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error saving master key: %v", err)
+	}
+	return key, nil
+}
+
+func (m *MasterKey) aead() (cipher.AEAD, error) {
+	if len(m.Key) != MasterKeySize {
+		return nil, errors.New("master key is not configured")
+	}
+	block, err := aes.NewCipher(m.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts plaintext. The associated data, e.g. the key ID, has to be given again for Decrypt.
+func (m *MasterKey) Encrypt(plaintext []byte, associatedData string) (string, error) {
+	aead, err := m.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(associatedData))
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Decrypt reverses Encrypt
+func (m *MasterKey) Decrypt(encrypted string, associatedData string) ([]byte, error) {
+	aead, err := m.aead()
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := strings.CutPrefix(encrypted, encryptedPrefix)
+	if !ok {
+		return nil, errors.New("unknown encryption format")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(associatedData))
+}
@@ -0,0 +1,146 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordRules struct {
+	MinLength          int
+	MaxLength          int
+	RequireUppercase   bool
+	RequireLowercase   bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectPersonalInfo bool
+	CheckBreached      bool
+}
+
+// PasswordContext carries the personal information a password must not contain
+type PasswordContext struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned when a password violates one or more rules
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password policy violated: " + strings.Join(messages, "; ")
+}
+
+func DefaultPasswordRules() PasswordRules {
+	return PasswordRules{
+		MinLength:          12,
+		MaxLength:          128,
+		RejectPersonalInfo: true,
+		CheckBreached:      true,
+	}
+}
+
+// Check validates the password against the rules. It returns a *PasswordPolicyError listing all violations,
+// or an error wrapping ErrBreachedPasswordsUnavailable if the breached passwords cannot be checked.
+func (r PasswordRules) Check(password string, context PasswordContext) error {
+	var violations []PasswordViolation
+	add := func(code string, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < r.MinLength || length == 0 {
+		add("too_short", "Password must be at least %d characters long", max(r.MinLength, 1))
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		add("too_long", "Password must be at most %d characters long", r.MaxLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+	if r.RequireUppercase && !hasUpper {
+		add("missing_uppercase", "Password must contain an uppercase letter")
+	}
+	if r.RequireLowercase && !hasLower {
+		add("missing_lowercase", "Password must contain a lowercase letter")
+	}
+	if r.RequireDigit && !hasDigit {
+		add("missing_digit", "Password must contain a digit")
+	}
+	if r.RequireSymbol && !hasSymbol {
+		add("missing_symbol", "Password must contain a symbol")
+	}
+
+	if r.RejectPersonalInfo {
+		lowered := strings.ToLower(password)
+		for _, fragment := range personalFragments(context) {
+			if strings.Contains(lowered, fragment) {
+				add("contains_personal_info", "Password must not contain parts of your name or email address")
+				break
+			}
+		}
+	}
+
+	if r.CheckBreached && length > 0 {
+		breached, err := BreachedPasswords().Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			add("breached", "Password appears in a list of breached passwords")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// personalFragments returns the lower cased parts of the context with at least three characters
+func personalFragments(context PasswordContext) []string {
+	candidates := []string{context.FirstName, context.LastName}
+	if local, domain, ok := strings.Cut(context.Email, "@"); ok {
+		candidates = append(candidates, local)
+		if name, _, ok := strings.Cut(domain, "."); ok {
+			candidates = append(candidates, name)
+		}
+	} else {
+		candidates = append(candidates, context.Email)
+	}
+
+	var fragments []string
+	for _, candidate := range candidates {
+		for _, part := range strings.FieldsFunc(strings.ToLower(candidate), func(c rune) bool {
+			return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+		}) {
+			if utf8.RuneCountInString(part) >= 3 {
+				fragments = append(fragments, part)
+			}
+		}
+	}
+	return fragments
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	PepperSize = 32
+	PepperFile = "secret.pepper"
+
+	secretHashPrefix = "$hmac-sha256$"
+)
+
+// SecretHasher hashes machine generated, high entropy secrets (client secrets,
+// auth codes, refresh tokens) with HMAC-SHA256 and a server side pepper.
+// Human passwords must keep using Argon2.
+type SecretHasher struct {
+	Pepper []byte
+}
+
+var (
+	defaultSecretHasher    *SecretHasher
+	defaultSecretHasherErr error
+	defaultSecretHasherMu  sync.Mutex
+)
+
+func NewSecretHasher(pepper []byte) *SecretHasher {
+	return &SecretHasher{Pepper: pepper}
+}
+
+// DefaultSecretHasher returns the process wide secret hasher.
+// The pepper is read from SECRET_PEPPER (base64) or loaded/generated from secret.pepper.
+func DefaultSecretHasher() (*SecretHasher, error) {
+	defaultSecretHasherMu.Lock()
+	defer defaultSecretHasherMu.Unlock()
+
+	if defaultSecretHasher == nil && defaultSecretHasherErr == nil {
+		pepper, err := LoadOrGeneratePepper()
+		if err != nil {
+			defaultSecretHasherErr = err
+		} else {
+			defaultSecretHasher = NewSecretHasher(pepper)
+		}
+	}
+	return defaultSecretHasher, defaultSecretHasherErr
+}
+
+// SetDefaultSecretHasher replaces the process wide secret hasher
+func SetDefaultSecretHasher(hasher *SecretHasher) {
+	defaultSecretHasherMu.Lock()
+	defer defaultSecretHasherMu.Unlock()
+	defaultSecretHasher = hasher
+	defaultSecretHasherErr = nil
+}
+
+// LoadOrGeneratePepper loads the pepper from SECRET_PEPPER or from the pepper file and generates it if missing
+func LoadOrGeneratePepper() ([]byte, error) {
+	if encoded := os.Getenv("SECRET_PEPPER"); encoded != "" {
+		pepper, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding SECRET_PEPPER: %v", err)
+		}
+		return pepper, nil
+	}
+
+	pepperPath := filepath.Join(".", PepperFile)
+	data, err := os.ReadFile(pepperPath)
+	if err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading pepper: %v", err)
+	}
+
+	fmt.Println("Secret pepper not found. Generating new pepper...")
+	pepper := make([]byte, PepperSize)
+	if _, err := rand.Read(pepper); err != nil {
+		return nil, fmt.Errorf("error generating pepper: %v", err)
+	}
+	err = os.WriteFile(pepperPath, []byte(base64.StdEncoding.EncodeToString(pepper)), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error saving pepper: %v", err)
+	}
+	return pepper, nil
+}
+
+// Hash returns the peppered HMAC of the secret
+func (s *SecretHasher) Hash(secret string) (string, error) {
+	if len(s.Pepper) == 0 {
+		return "", fmt.Errorf("secret pepper is not configured")
+	}
+	mac := hmac.New(sha256.New, s.Pepper)
+	mac.Write([]byte(secret))
+	return secretHashPrefix + base64.RawStdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Compare checks the secret against a stored hash. Legacy Argon2 hashes are still
+// accepted; needsRehash reports that the stored hash should be replaced by Hash(secret).
+func (s *SecretHasher) Compare(secret string, hash string) (valid bool, needsRehash bool, err error) {
+	if !IsSecretHash(hash) {
+		valid, _, err = DefaultHasher().Compare(secret, hash)
+		return valid, valid, err
+	}
+
+	expected, err := s.Hash(secret)
+	if err != nil {
+		return false, false, err
+	}
+	return hmac.Equal([]byte(expected), []byte(hash)), false, nil
+}
+
+// IsSecretHash reports whether the hash was created by a SecretHasher
+func IsSecretHash(hash string) bool {
+	return strings.HasPrefix(hash, secretHashPrefix)
+}
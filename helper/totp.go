@@ -0,0 +1,110 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30
+	// TOTPSkew is the number of periods before and after the current one that are accepted
+	TOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160 bit secret, base32 encoded without padding
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI used by authenticator apps (usually shown as QR code)
+func TOTPURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode computes the code for the given counter (RFC 4226 / RFC 6238)
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%1000000), nil
+}
+
+// TOTPCounter returns the time step of t
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// ValidateTOTP checks the code against the time steps around t. Codes of a counter
+// not greater than lastCounter are rejected to prevent replays. The matching counter is returned.
+func ValidateTOTP(secret string, code string, t time.Time, lastCounter int64) (bool, int64, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return false, 0, nil
+	}
+
+	current := TOTPCounter(t)
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		expected, err := TOTPCode(secret, counter)
+		if err != nil {
+			return false, 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true, counter, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// GenerateRecoveryCodes returns count random one-time codes in the form XXXXX-XXXXX
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := totpEncoding.EncodeToString(raw)[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode removes separators and whitespace and upper cases the code
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
@@ -0,0 +1,100 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 secret of the test vectors of RFC 6238, appendix B
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The 8 digit codes of the RFC truncated to 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPCounter(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("code at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := TOTPCounter(now)
+	code := func(counter int64) string {
+		code, err := TOTPCode(rfc6238Secret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		valid       bool
+		counter     int64
+	}{
+		{"current code", code(current), 0, true, current},
+		{"code with spaces", " " + code(current)[:3] + " " + code(current)[3:], 0, true, current},
+		{"previous period", code(current - 1), 0, true, current - 1},
+		{"next period", code(current + 1), 0, true, current + 1},
+		{"outside the skew", code(current - 2), 0, false, 0},
+		{"replayed code", code(current), current, false, 0},
+		{"code before the last used one", code(current - 1), current, false, 0},
+		{"newer code after a used one", code(current + 1), current, true, current + 1},
+		{"too short", code(current)[:5], 0, false, 0},
+	}
+	for _, test := range tests {
+		valid, counter, err := ValidateTOTP(rfc6238Secret, test.code, now, test.lastCounter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != test.valid || counter != test.counter {
+			t.Errorf("%s: valid = %t, counter = %d, want %t, %d", test.name, valid, counter, test.valid, test.counter)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || code != strings.ToUpper(code) {
+			t.Errorf("code %q has not the form XXXXX-XXXXX", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+
+	if normalized := NormalizeRecoveryCode(" abcde-12345 "); normalized != "ABCDE12345" {
+		t.Errorf("normalized = %q", normalized)
+	}
+	if NormalizeRecoveryCode("ABCDE 12345") != NormalizeRecoveryCode("abcde-12345") {
+		t.Error("separators are not ignored")
+	}
+}
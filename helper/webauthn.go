@@ -0,0 +1,367 @@
+package helper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Authenticator data flags (WebAuthn Level 2, 6.1)
+const (
+	WebAuthnFlagUserPresent    = 0x01
+	WebAuthnFlagUserVerified   = 0x04
+	WebAuthnFlagBackupEligible = 0x08
+	WebAuthnFlagBackupState    = 0x10
+	WebAuthnFlagAttestedData   = 0x40
+	WebAuthnFlagExtensionData  = 0x80
+)
+
+// COSE algorithm identifiers supported for credentials
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// ErrWebAuthnSignCount is returned when the sign counter of an assertion did not increase
+var ErrWebAuthnSignCount = errors.New("WebAuthn sign counter did not increase, the authenticator may be cloned")
+
+// Base64URL is a byte slice encoded as unpadded base64url in JSON, as used by the WebAuthn browser API
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := DecodeBase64URL(encoded)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// DecodeBase64URL decodes base64url with or without padding
+func DecodeBase64URL(encoded string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+}
+
+type WebAuthnConfig struct {
+	// RPID is the relying party ID, the domain the credentials are bound to
+	RPID   string
+	RPName string
+	// Origins are additional allowed origins, e.g. http://localhost:3000 during development.
+	// https origins on RPID and its subdomains are always allowed.
+	Origins []string
+}
+
+// WebAuthnConfigFromEnv derives the relying party from APPLICATION_DOMAIN and APPLICATION_NAME.
+// WEBAUTHN_ORIGINS can list additional comma separated origins.
+func WebAuthnConfigFromEnv() WebAuthnConfig {
+	config := WebAuthnConfig{
+		RPID:   os.Getenv("APPLICATION_DOMAIN"),
+		RPName: os.Getenv("APPLICATION_NAME"),
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			config.Origins = append(config.Origins, origin)
+		}
+	}
+	return config
+}
+
+// GenerateWebAuthnChallenge returns 32 random bytes
+func GenerateWebAuthnChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+type WebAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// VerifyClientData checks type, challenge and origin of the clientDataJSON
+func (c WebAuthnConfig) VerifyClientData(clientDataJSON []byte, expectedType string, challenge []byte) error {
+	var clientData WebAuthnClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("invalid client data: %v", err)
+	}
+
+	if clientData.Type != expectedType {
+		return fmt.Errorf("unexpected client data type %q", clientData.Type)
+	}
+
+	received, err := DecodeBase64URL(clientData.Challenge)
+	if err != nil || !bytes.Equal(received, challenge) {
+		return errors.New("challenge mismatch")
+	}
+
+	if !c.originAllowed(clientData.Origin) {
+		return fmt.Errorf("origin %q is not allowed", clientData.Origin)
+	}
+	return nil
+}
+
+func (c WebAuthnConfig) originAllowed(origin string) bool {
+	if slices.Contains(c.Origins, origin) {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme != "https" {
+		return false
+	}
+	host := parsed.Hostname()
+	return host == c.RPID || strings.HasSuffix(host, "."+c.RPID)
+}
+
+// VerifyRPIDHash checks that the authenticator data belongs to the relying party
+func (c WebAuthnConfig) VerifyRPIDHash(rpIDHash []byte) error {
+	expected := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(expected[:], rpIDHash) {
+		return errors.New("relying party ID mismatch")
+	}
+	return nil
+}
+
+type AuthenticatorData struct {
+	Raw       []byte
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Only present during registration (WebAuthnFlagAttestedData)
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func (a *AuthenticatorData) UserPresent() bool {
+	return a.Flags&WebAuthnFlagUserPresent != 0
+}
+
+func (a *AuthenticatorData) UserVerified() bool {
+	return a.Flags&WebAuthnFlagUserVerified != 0
+}
+
+// ParseAuthenticatorData parses the binary authenticator data
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	authData := &AuthenticatorData{
+		Raw:       data,
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if authData.Flags&WebAuthnFlagAttestedData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		authData.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return nil, errors.New("credential ID too short")
+		}
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, n, err := DecodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %v", err)
+		}
+		authData.PublicKey = rest[:n]
+	}
+
+	return authData, nil
+}
+
+// ParseAttestationObject returns the attestation format and the authenticator data.
+// The attestation statement itself is not verified: credentials are registered with attestation "none".
+func ParseAttestationObject(data []byte) (string, *AuthenticatorData, error) {
+	decoded, _, err := DecodeCBOR(data)
+	if err != nil {
+		return "", nil, err
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return "", nil, errors.New("invalid attestation object")
+	}
+
+	format, _ := object["fmt"].(string)
+	rawAuthData, ok := object["authData"].([]byte)
+	if !ok {
+		return "", nil, errors.New("attestation object without authenticator data")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return "", nil, err
+	}
+	if authData.CredentialID == nil {
+		return "", nil, errors.New("attestation object without credential")
+	}
+	return format, authData, nil
+}
+
+// ParseCOSEKey converts a COSE_Key (RFC 9053) into a public key and returns its algorithm
+func ParseCOSEKey(data []byte) (int64, crypto.PublicKey, error) {
+	decoded, _, err := DecodeCBOR(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, errors.New("invalid COSE key")
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch kty {
+	case 2: // EC2
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if alg != COSEAlgES256 || crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("unsupported EC2 key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return 0, nil, errors.New("EC2 key is not on the curve")
+		}
+		return alg, publicKey, nil
+	case 3: // RSA
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if alg != COSEAlgRS256 || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, errors.New("unsupported RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	case 1: // OKP
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if alg != COSEAlgEdDSA || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("unsupported OKP key")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	}
+	return 0, nil, fmt.Errorf("unsupported COSE key type %d", kty)
+}
+
+// VerifyWebAuthnSignature verifies an assertion signature over authenticatorData || SHA-256(clientDataJSON)
+func VerifyWebAuthnSignature(coseKey []byte, authData []byte, clientDataJSON []byte, signature []byte) error {
+	_, publicKey, err := ParseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, signature) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("unsupported public key")
+	}
+	return nil
+}
+
+// VerifyRegistration verifies the client data and the attestation object returned by navigator.credentials.create
+// for challenge. It returns the authenticator data with the new credential and its public key.
+func (c WebAuthnConfig) VerifyRegistration(clientDataJSON []byte, attestationObject []byte, challenge []byte) (*AuthenticatorData, error) {
+	if err := c.VerifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	_, authData, err := ParseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.VerifyRPIDHash(authData.RPIDHash); err != nil {
+		return nil, err
+	}
+	if !authData.UserPresent() {
+		return nil, errors.New("user not present")
+	}
+	if _, _, err := ParseCOSEKey(authData.PublicKey); err != nil {
+		return nil, err
+	}
+	return authData, nil
+}
+
+// VerifyAssertion verifies an assertion returned by navigator.credentials.get for challenge against the public key
+// and the sign counter of the stored credential. The sign counter must increase unless the authenticator does
+// not implement one; otherwise ErrWebAuthnSignCount is returned.
+func (c WebAuthnConfig) VerifyAssertion(coseKey []byte, signCount uint32, clientDataJSON []byte, authenticatorData []byte, signature []byte, challenge []byte, requireUserVerification bool) (*AuthenticatorData, error) {
+	if err := c.VerifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.VerifyRPIDHash(authData.RPIDHash); err != nil {
+		return nil, err
+	}
+	if !authData.UserPresent() {
+		return nil, errors.New("user not present")
+	}
+	if requireUserVerification && !authData.UserVerified() {
+		return nil, errors.New("user not verified")
+	}
+
+	if err := VerifyWebAuthnSignature(coseKey, authenticatorData, clientDataJSON, signature); err != nil {
+		return nil, err
+	}
+
+	if (authData.SignCount != 0 || signCount != 0) && authData.SignCount <= signCount {
+		return nil, ErrWebAuthnSignCount
+	}
+	return authData, nil
+}
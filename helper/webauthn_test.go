@@ -0,0 +1,284 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR encodes the subset of CBOR produced by authenticators: integers, byte and text strings and maps
+func encodeCBOR(value interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		encoded := make(map[string][]byte, len(v))
+		for key, item := range v {
+			k := string(encodeCBOR(key))
+			keys = append(keys, k)
+			encoded[k] = encodeCBOR(item)
+		}
+		sort.Strings(keys)
+		out := header(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), encoded[k]...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("unsupported CBOR value %T", value))
+}
+
+// softAuthenticator is an ES256 authenticator implemented in software
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	rpID         string
+	origin       string
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: []byte("credential-1"), rpID: "secnex.io", origin: "https://secnex.io"}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	return encodeCBOR(map[interface{}]interface{}{1: 2, 3: COSEAlgES256, -1: 1, -2: x, -3: y})
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(WebAuthnClientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if flags&WebAuthnFlagAttestedData != 0 {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) attestationObject(flags byte) []byte {
+	return encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authenticatorData(flags),
+	})
+}
+
+// assert returns clientDataJSON, authenticatorData and the signature of an assertion
+func (a *softAuthenticator) assert(t *testing.T, challenge []byte, flags byte) ([]byte, []byte, []byte) {
+	t.Helper()
+	a.counter++
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	authData := a.authenticatorData(flags)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData, authData, signature
+}
+
+func TestWebAuthnRegisterThenAssert(t *testing.T) {
+	config := WebAuthnConfig{RPID: "secnex.io", RPName: "SecNex"}
+	authenticator := newSoftAuthenticator(t)
+
+	challenge := []byte("registration-challenge")
+	registered, err := config.VerifyRegistration(
+		authenticator.clientData(t, "webauthn.create", challenge),
+		authenticator.attestationObject(WebAuthnFlagUserPresent|WebAuthnFlagUserVerified|WebAuthnFlagAttestedData),
+		challenge,
+	)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if !bytes.Equal(registered.CredentialID, authenticator.credentialID) {
+		t.Fatalf("credential ID = %q", registered.CredentialID)
+	}
+	publicKey := registered.PublicKey
+	signCount := registered.SignCount
+
+	for i := 0; i < 3; i++ {
+		challenge := []byte(fmt.Sprintf("login-challenge-%d", i))
+		clientData, authData, signature := authenticator.assert(t, challenge, WebAuthnFlagUserPresent|WebAuthnFlagUserVerified)
+		asserted, err := config.VerifyAssertion(publicKey, signCount, clientData, authData, signature, challenge, true)
+		if err != nil {
+			t.Fatalf("VerifyAssertion %d: %v", i, err)
+		}
+		if asserted.SignCount != signCount+1 {
+			t.Fatalf("sign count = %d, want %d", asserted.SignCount, signCount+1)
+		}
+		signCount = asserted.SignCount
+	}
+
+	// A replayed assertion does not increase the counter
+	challenge = []byte("replay")
+	authenticator.counter = signCount - 1
+	clientData, authData, signature := authenticator.assert(t, challenge, WebAuthnFlagUserPresent)
+	_, err = config.VerifyAssertion(publicKey, signCount, clientData, authData, signature, challenge, false)
+	if !errors.Is(err, ErrWebAuthnSignCount) {
+		t.Fatalf("replayed counter: %v, want ErrWebAuthnSignCount", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejected(t *testing.T) {
+	config := WebAuthnConfig{RPID: "secnex.io"}
+	challenge := []byte("registration-challenge")
+
+	tests := []struct {
+		name   string
+		modify func(a *softAuthenticator) (clientData []byte, attestation []byte)
+	}{
+		{"user not present", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.clientData(t, "webauthn.create", challenge), a.attestationObject(WebAuthnFlagAttestedData)
+		}},
+		{"other relying party", func(a *softAuthenticator) ([]byte, []byte) {
+			clientData := a.clientData(t, "webauthn.create", challenge)
+			a.rpID = "evil.example"
+			return clientData, a.attestationObject(WebAuthnFlagUserPresent | WebAuthnFlagAttestedData)
+		}},
+		{"other origin", func(a *softAuthenticator) ([]byte, []byte) {
+			a.origin = "https://secnex.io.evil.example"
+			return a.clientData(t, "webauthn.create", challenge), a.attestationObject(WebAuthnFlagUserPresent | WebAuthnFlagAttestedData)
+		}},
+		{"assertion client data", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.clientData(t, "webauthn.get", challenge), a.attestationObject(WebAuthnFlagUserPresent | WebAuthnFlagAttestedData)
+		}},
+		{"other challenge", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.clientData(t, "webauthn.create", []byte("other")), a.attestationObject(WebAuthnFlagUserPresent | WebAuthnFlagAttestedData)
+		}},
+		{"no credential", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.clientData(t, "webauthn.create", challenge), a.attestationObject(WebAuthnFlagUserPresent)
+		}},
+	}
+	for _, tt := range tests {
+		clientData, attestation := tt.modify(newSoftAuthenticator(t))
+		if _, err := config.VerifyRegistration(clientData, attestation, challenge); err == nil {
+			t.Errorf("%s: registration accepted", tt.name)
+		}
+	}
+}
+
+func TestWebAuthnAssertionRejected(t *testing.T) {
+	config := WebAuthnConfig{RPID: "secnex.io"}
+	challenge := []byte("login-challenge")
+
+	tests := []struct {
+		name                    string
+		requireUserVerification bool
+		assert                  func(a *softAuthenticator) ([]byte, []byte, []byte)
+	}{
+		{"user not present", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			return a.assert(t, challenge, 0)
+		}},
+		{"user not verified", true, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			return a.assert(t, challenge, WebAuthnFlagUserPresent)
+		}},
+		{"other relying party", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			a.rpID = "evil.example"
+			return a.assert(t, challenge, WebAuthnFlagUserPresent)
+		}},
+		{"other challenge", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			return a.assert(t, []byte("other"), WebAuthnFlagUserPresent)
+		}},
+		{"tampered signature", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			clientData, authData, signature := a.assert(t, challenge, WebAuthnFlagUserPresent)
+			signature[len(signature)-1] ^= 0xff
+			return clientData, authData, signature
+		}},
+		{"tampered flags", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			clientData, authData, signature := a.assert(t, challenge, WebAuthnFlagUserPresent)
+			authData[32] |= WebAuthnFlagUserVerified
+			return clientData, authData, signature
+		}},
+		{"signed by another key", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			a.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			return a.assert(t, challenge, WebAuthnFlagUserPresent)
+		}},
+		{"truncated authenticator data", false, func(a *softAuthenticator) ([]byte, []byte, []byte) {
+			clientData, authData, signature := a.assert(t, challenge, WebAuthnFlagUserPresent)
+			return clientData, authData[:36], signature
+		}},
+	}
+	for _, tt := range tests {
+		authenticator := newSoftAuthenticator(t)
+		publicKey := authenticator.coseKey()
+		clientData, authData, signature := tt.assert(authenticator)
+		if _, err := config.VerifyAssertion(publicKey, 0, clientData, authData, signature, challenge, tt.requireUserVerification); err == nil {
+			t.Errorf("%s: assertion accepted", tt.name)
+		}
+	}
+}
+
+func TestParseCOSEKeyRejectsInvalidKeys(t *testing.T) {
+	authenticator := newSoftAuthenticator(t)
+	x := authenticator.key.PublicKey.X.FillBytes(make([]byte, 32))
+
+	tests := []struct {
+		name string
+		key  map[interface{}]interface{}
+	}{
+		{"missing y", map[interface{}]interface{}{1: 2, 3: COSEAlgES256, -1: 1, -2: x}},
+		{"point not on curve", map[interface{}]interface{}{1: 2, 3: COSEAlgES256, -1: 1, -2: x, -3: x}},
+		{"wrong algorithm", map[interface{}]interface{}{1: 2, 3: COSEAlgRS256, -1: 1, -2: x, -3: x}},
+		{"short RSA modulus", map[interface{}]interface{}{1: 3, 3: COSEAlgRS256, -1: []byte{1, 2, 3}, -2: []byte{1, 0, 1}}},
+		{"short Ed25519 key", map[interface{}]interface{}{1: 1, 3: COSEAlgEdDSA, -1: 6, -2: []byte{1}}},
+		{"unknown key type", map[interface{}]interface{}{1: 4}},
+	}
+	for _, tt := range tests {
+		if _, _, err := ParseCOSEKey(encodeCBOR(tt.key)); err == nil {
+			t.Errorf("%s: key accepted", tt.name)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
 	"github.com/secnex/sethorize-kit/models"
 	"github.com/secnex/sethorize-kit/utils"
 	"gorm.io/gorm"
@@ -15,23 +16,30 @@ type Initializer struct {
 	DB              *gorm.DB
 	Domain          string
 	ApplicationName string
+	AdminPassword   string
 }
 
 func NewInitializer(db *gorm.DB) *Initializer {
 	domain := os.Getenv("APPLICATION_DOMAIN")
 	applicationName := os.Getenv("APPLICATION_NAME")
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
 	return &Initializer{
 		DB:              db,
 		Domain:          domain,
 		ApplicationName: applicationName,
+		AdminPassword:   adminPassword,
 	}
 }
 
 func (i *Initializer) Initialize() {
 	fmt.Println("Initializing basic data...")
 
-	// 1. Create default tenant
+	// 0. Tune the password hasher before the first password is hashed
+	helper.InitDefaultHasher()
+
+	// 1. Create default tenant, served on APPLICATION_DOMAIN
 	tenantID := i.createDefaultTenant()
+	i.createDefaultDomain(tenantID)
 
 	// 2. Create default clients
 	i.createDefaultClient(tenantID)
@@ -71,6 +79,28 @@ func (i *Initializer) createDefaultTenant() uuid.UUID {
 	return createdTenant.ID
 }
 
+func (i *Initializer) createDefaultDomain(tenantID uuid.UUID) {
+	if i.Domain == "" || tenantID == uuid.Nil {
+		return
+	}
+	hostname := strings.ToLower(i.Domain)
+
+	var domain models.TenantDomain
+	err := i.DB.Where("hostname = ?", hostname).First(&domain).Error
+	if err == nil {
+		fmt.Printf("Domain '%s' already exists\n", hostname)
+		return
+	}
+
+	err = i.DB.Create(&models.TenantDomain{TenantID: tenantID, Hostname: hostname, IsPrimary: true}).Error
+	if err != nil {
+		fmt.Printf("Error creating domain: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Domain '%s' created\n", hostname)
+}
+
 func (i *Initializer) createDefaultClient(tenantID uuid.UUID) {
 	clientName := fmt.Sprintf("%s Client", i.ApplicationName)
 	clientSlug := "default"
@@ -175,27 +205,44 @@ func (i *Initializer) createAccountClient(tenantID uuid.UUID) {
 }
 
 func (i *Initializer) createAdminUser(tenantID uuid.UUID) {
-	email := fmt.Sprintf("admin@%s", i.Domain)
+	email := helper.NormalizeEmail(fmt.Sprintf("admin@%s", i.Domain))
 	var user models.User
 
 	// Check if admin user already exists
-	err := i.DB.Where("email = ? AND tenant_id = ?", email, tenantID).First(&user).Error
+	err := i.DB.Where("LOWER(email) = ? AND tenant_id = ?", email, tenantID).First(&user).Error
 	if err == nil {
+		// The admin of the default tenant manages all tenants
+		if !user.IsSuperAdmin {
+			i.DB.Model(&user).Update("is_super_admin", true)
+		}
 		fmt.Printf("Admin User already exists (ID: %s)\n", user.ID)
 		return
 	}
 
+	// Use the configured password or generate a random one which is printed once
+	password := i.AdminPassword
+	if password == "" {
+		password = utils.GenerateToken(18)
+	}
+
+	err = helper.DefaultPasswordRules().Check(password, helper.PasswordContext{Email: email, FirstName: "Admin", LastName: "User"})
+	if err != nil {
+		fmt.Printf("Error creating admin user: %v\n", err)
+		return
+	}
+
 	// Create admin user
 	newUser := models.User{
-		FirstName:   "Admin",
-		LastName:    "User",
-		DisplayName: "Administrator",
-		Email:       email,
-		Password:    "Admin,2025!",
-		IsActive:    true,
-		IsVerified:  true,
-		IsAdmin:     true,
-		TenantID:    tenantID,
+		FirstName:    "Admin",
+		LastName:     "User",
+		DisplayName:  "Administrator",
+		Email:        email,
+		Password:     password,
+		IsActive:     true,
+		IsVerified:   true,
+		IsAdmin:      true,
+		IsSuperAdmin: true,
+		TenantID:     tenantID,
 	}
 
 	var createdUser models.User
@@ -205,5 +252,9 @@ func (i *Initializer) createAdminUser(tenantID uuid.UUID) {
 		return
 	}
 
+	if i.AdminPassword == "" {
+		fmt.Printf("Admin User created (ID: %s, Email: %s, Password: %s)\n", createdUser.ID, email, password)
+		return
+	}
 	fmt.Printf("Admin User created (ID: %s, Email: %s)\n", createdUser.ID, email)
 }
@@ -0,0 +1,130 @@
+package initializer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/secnex/sethorize-kit/helper"
+	"github.com/secnex/sethorize-kit/models"
+	"github.com/secnex/sethorize-kit/utils"
+	"gorm.io/gorm"
+)
+
+// TenantAdmin describes the first admin of a provisioned tenant. A password is generated if none is given.
+type TenantAdmin struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Password  string
+}
+
+type ProvisionedClient struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Slug   string `json:"slug"`
+	Secret string `json:"secret"`
+}
+
+// Provisioning is the result of ProvisionTenant. Client secrets and a generated password are only returned here.
+type Provisioning struct {
+	Tenant        models.Tenant       `json:"tenant"`
+	Clients       []ProvisionedClient `json:"clients"`
+	Admin         models.User         `json:"admin"`
+	AdminPassword string              `json:"admin_password,omitempty"`
+}
+
+// ProvisionTenant creates a tenant with the same default clients and admin user as Initialize
+// creates for the default tenant. Everything is created in one transaction.
+func ProvisionTenant(db *gorm.DB, tenant models.Tenant, admin TenantAdmin) (*Provisioning, error) {
+	provisioning := &Provisioning{}
+
+	if admin.FirstName == "" {
+		admin.FirstName = "Admin"
+	}
+	if admin.LastName == "" {
+		admin.LastName = "User"
+	}
+	generated := admin.Password == ""
+	if generated {
+		admin.Password = utils.GenerateToken(18)
+	}
+
+	err := helper.DefaultPasswordRules().Check(admin.Password, helper.PasswordContext{Email: admin.Email, FirstName: admin.FirstName, LastName: admin.LastName})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tenant).Error; err != nil {
+			return err
+		}
+		provisioning.Tenant = tenant
+
+		for _, client := range defaultClients(tenant.Name) {
+			secret := utils.GenerateToken(32)
+			client.Secret = secret
+			client.TenantID = tenant.ID
+			if err := tx.Create(&client).Error; err != nil {
+				return fmt.Errorf("creating client %s: %w", client.Slug, err)
+			}
+			provisioning.Clients = append(provisioning.Clients, ProvisionedClient{
+				ID:     client.ID.String(),
+				Name:   client.Name,
+				Slug:   client.Slug,
+				Secret: secret,
+			})
+		}
+
+		user := models.User{
+			FirstName:  admin.FirstName,
+			LastName:   admin.LastName,
+			Email:      admin.Email,
+			Password:   admin.Password,
+			IsActive:   true,
+			IsVerified: true,
+			IsAdmin:    true,
+			TenantID:   tenant.ID,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("creating admin user: %w", err)
+		}
+		user.Password = ""
+		provisioning.Admin = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if generated {
+		provisioning.AdminPassword = admin.Password
+	}
+	return provisioning, nil
+}
+
+// defaultClients are the clients every tenant starts with, see createDefaultClient, createCLIClient and createAccountClient
+func defaultClients(name string) []models.Client {
+	return []models.Client{
+		{
+			Name:         fmt.Sprintf("%s Client", name),
+			Slug:         "default",
+			Description:  "Default OAuth2 Client",
+			RedirectURIs: []string{},
+			Internal:     false,
+		},
+		{
+			Name:         fmt.Sprintf("%s CLI Client", name),
+			Slug:         fmt.Sprintf("%s-cli", strings.ToLower(name)),
+			Description:  "Command Line Interface Client",
+			RedirectURIs: []string{},
+			Internal:     true,
+		},
+		{
+			Name:         fmt.Sprintf("%s Account Client", name),
+			Slug:         "account",
+			Description:  "Account Management Client",
+			RedirectURIs: []string{},
+			Internal:     true,
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when the delivery queue of an AsyncMailer is full
+	ErrQueueFull = errors.New("mail queue is full")
+	// ErrMailerClosed is returned by Send after Close
+	ErrMailerClosed = errors.New("mailer is closed")
+)
+
+type AsyncOptions struct {
+	Workers   int
+	QueueSize int
+	// Retries is the number of additional attempts after a failed delivery
+	Retries int
+	// Backoff is the delay before the first retry; it doubles with every attempt
+	Backoff time.Duration
+}
+
+func DefaultAsyncOptions() AsyncOptions {
+	return AsyncOptions{
+		Workers:   2,
+		QueueSize: 1000,
+		Retries:   5,
+		Backoff:   2 * time.Second,
+	}
+}
+
+// AsyncMailer queues messages and delivers them in the background with retries
+type AsyncMailer struct {
+	Mailer  Mailer
+	Options AsyncOptions
+
+	queue  chan Message
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewAsyncMailer(mailer Mailer, options AsyncOptions) *AsyncMailer {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	a := &AsyncMailer{
+		Mailer:  mailer,
+		Options: options,
+		queue:   make(chan Message, options.QueueSize),
+	}
+	for i := 0; i < options.Workers; i++ {
+		a.wg.Add(1)
+		go a.work()
+	}
+	return a
+}
+
+// Send enqueues the message. It only fails if the queue is full or the mailer is closed.
+func (a *AsyncMailer) Send(message Message) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrMailerClosed
+	}
+
+	select {
+	case a.queue <- message:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits until the queue is drained
+func (a *AsyncMailer) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+}
+
+func (a *AsyncMailer) work() {
+	defer a.wg.Done()
+	for message := range a.queue {
+		a.deliver(message)
+	}
+}
+
+func (a *AsyncMailer) deliver(message Message) {
+	backoff := a.Options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := a.Mailer.Send(message)
+		if err == nil {
+			return
+		}
+		if attempt >= a.Options.Retries {
+			fmt.Printf("Error delivering mail to %s after %d attempts: %v\n", message.To, attempt+1, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
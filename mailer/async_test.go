@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyMailer fails the first failures deliveries of every recipient
+type flakyMailer struct {
+	failures int
+
+	mu       sync.Mutex
+	attempts map[string]int
+	sent     []string
+}
+
+func (m *flakyMailer) Send(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attempts == nil {
+		m.attempts = make(map[string]int)
+	}
+	m.attempts[message.To]++
+	if m.attempts[message.To] <= m.failures {
+		return errors.New("temporary failure")
+	}
+	m.sent = append(m.sent, message.To)
+	return nil
+}
+
+type mailerFunc func(Message) error
+
+func (f mailerFunc) Send(message Message) error {
+	return f(message)
+}
+
+func TestAsyncMailerRetries(t *testing.T) {
+	base := &flakyMailer{failures: 2}
+	a := NewAsyncMailer(base, AsyncOptions{Workers: 1, QueueSize: 10, Retries: 2, Backoff: time.Millisecond})
+
+	if err := a.Send(Message{To: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	if base.attempts["alice@example.com"] != 3 || len(base.sent) != 1 {
+		t.Fatalf("attempts = %d, sent = %v", base.attempts["alice@example.com"], base.sent)
+	}
+}
+
+func TestAsyncMailerGivesUpAfterRetries(t *testing.T) {
+	base := &flakyMailer{failures: 10}
+	a := NewAsyncMailer(base, AsyncOptions{Workers: 1, QueueSize: 10, Retries: 1, Backoff: time.Millisecond})
+
+	a.Send(Message{To: "bob@example.com"})
+	a.Close()
+
+	if base.attempts["bob@example.com"] != 2 || len(base.sent) != 0 {
+		t.Fatalf("attempts = %d, sent = %v", base.attempts["bob@example.com"], base.sent)
+	}
+}
+
+func TestAsyncMailerCloseDrainsQueue(t *testing.T) {
+	base := &flakyMailer{}
+	a := NewAsyncMailer(base, AsyncOptions{Workers: 2, QueueSize: 100})
+
+	for i := 0; i < 50; i++ {
+		if err := a.Send(Message{To: string(rune('a'+i%26)) + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close()
+	a.Close()
+
+	if len(base.sent) != 50 {
+		t.Fatalf("sent %d of 50 messages before Close returned", len(base.sent))
+	}
+	if err := a.Send(Message{To: "late@example.com"}); !errors.Is(err, ErrMailerClosed) {
+		t.Fatalf("Send after Close = %v, want ErrMailerClosed", err)
+	}
+}
+
+func TestAsyncMailerQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	base := mailerFunc(func(Message) error {
+		<-block
+		return nil
+	})
+	a := NewAsyncMailer(base, AsyncOptions{Workers: 1, QueueSize: 1})
+	defer a.Close()
+	defer close(block)
+
+	// The worker takes the first message, the second fills the queue
+	a.Send(Message{To: "a@example.com"})
+	deadline := time.Now().Add(time.Second)
+	for len(a.queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.Send(Message{To: "b@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Send(Message{To: "c@example.com"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Send to a full queue = %v, want ErrQueueFull", err)
+	}
+}
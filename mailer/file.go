@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message as .eml file into a directory. Useful for development.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+func (m *FileMailer) Send(message Message) error {
+	if message.From == "" {
+		message.From = m.From
+	}
+
+	err := os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), randomID()[:8])
+	return os.WriteFile(filepath.Join(m.Dir, name), message.Bytes(), 0600)
+}
@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers outbound email
+type Mailer interface {
+	Send(message Message) error
+}
+
+var (
+	defaultMailer   Mailer
+	defaultMailerMu sync.Mutex
+)
+
+// Default returns the process wide mailer configured from the environment:
+// SMTP_HOST selects the SMTP mailer, MAIL_DIR the file mailer and otherwise messages are logged.
+// Delivery is asynchronous with MAIL_RETRIES retries.
+func Default() Mailer {
+	defaultMailerMu.Lock()
+	defer defaultMailerMu.Unlock()
+
+	if defaultMailer == nil {
+		var base Mailer
+		switch {
+		case os.Getenv("SMTP_HOST") != "":
+			port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+			if err != nil {
+				port = 587
+			}
+			base = NewSMTPMailer(SMTPOptions{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     port,
+				Username: os.Getenv("SMTP_USER"),
+				Password: os.Getenv("SMTP_PASS"),
+				From:     os.Getenv("MAIL_FROM"),
+				TLS:      os.Getenv("SMTP_TLS") == "true",
+			})
+		case os.Getenv("MAIL_DIR") != "":
+			base = NewFileMailer(os.Getenv("MAIL_DIR"), os.Getenv("MAIL_FROM"))
+		default:
+			base = NewLogMailer()
+		}
+
+		options := DefaultAsyncOptions()
+		if retries, err := strconv.Atoi(os.Getenv("MAIL_RETRIES")); err == nil && retries >= 0 {
+			options.Retries = retries
+		}
+		defaultMailer = NewAsyncMailer(base, options)
+	}
+	return defaultMailer
+}
+
+// SetDefault replaces the process wide mailer
+func SetDefault(mailer Mailer) {
+	defaultMailerMu.Lock()
+	defer defaultMailerMu.Unlock()
+	defaultMailer = mailer
+}
+
+// LogMailer prints messages to stdout instead of sending them. Useful for development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(message Message) error {
+	fmt.Printf("Mail to %s: %s\n%s\n", message.To, message.Subject, message.Text)
+	return nil
+}
+
+// Bytes renders the message as RFC 5322 message. With both a text and a HTML body
+// a multipart/alternative message is created.
+func (m Message) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@sethorize>\r\n", randomID())
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.HTML == "" {
+		writePart(&b, "text/plain", m.Text)
+		return b.Bytes()
+	}
+
+	boundary := randomID()
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	writePart(&b, "text/plain", m.Text)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	writePart(&b, "text/html", m.HTML)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+func writePart(b *bytes.Buffer, contentType string, body string) {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(b)
+	writer.Write([]byte(body))
+	writer.Close()
+}
+
+func randomID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// TLS uses implicit TLS (port 465). Otherwise STARTTLS is used when the server offers it.
+	TLS bool
+	// TLSConfig overrides the TLS configuration, e.g. to trust a private CA. ServerName defaults to Host.
+	TLSConfig *tls.Config
+}
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	Options SMTPOptions
+}
+
+func NewSMTPMailer(options SMTPOptions) *SMTPMailer {
+	return &SMTPMailer{Options: options}
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	if message.From == "" {
+		message.From = m.Options.From
+	}
+
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+
+	address := net.JoinHostPort(m.Options.Host, strconv.Itoa(m.Options.Port))
+	var conn net.Conn
+	if m.Options.TLS {
+		conn, err = tls.Dial("tcp", address, m.tlsConfig())
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.Options.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !m.Options.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(m.tlsConfig())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Options.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", m.Options.Username, m.Options.Password, m.Options.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (m *SMTPMailer) tlsConfig() *tls.Config {
+	if m.Options.TLSConfig == nil {
+		return &tls.Config{ServerName: m.Options.Host}
+	}
+	config := m.Options.TLSConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = m.Options.Host
+	}
+	return config
+}
@@ -0,0 +1,209 @@
+package mailer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer is a minimal SMTP server that offers STARTTLS and, after the upgrade, AUTH PLAIN
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	done     chan struct{}
+	startTLS bool
+	authed   bool
+	from     string
+	to       []string
+	data     string
+}
+
+func newFakeSMTPServer(t *testing.T, certificate tls.Certificate, username string, password string) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		username:  username,
+		password:  password,
+		done:      make(chan struct{}),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake.test ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if s.startTLS {
+				text.PrintfLine("250-fake.test\r\n250 AUTH PLAIN")
+			} else {
+				text.PrintfLine("250-fake.test\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.startTLS = true
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			if s.startTLS && string(credentials) == "\x00"+s.username+"\x00"+s.password {
+				s.authed = true
+				text.PrintfLine("235 authenticated")
+			} else {
+				text.PrintfLine("535 authentication failed")
+			}
+		case "MAIL":
+			if !s.authed {
+				text.PrintfLine("530 authentication required")
+				continue
+			}
+			s.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = append(s.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestSMTPMailerStartTLSAndAuth(t *testing.T) {
+	certificate, pool := testCertificate(t)
+	server := newFakeSMTPServer(t, certificate, "mailer", "s3cret")
+	addr := server.listener.Addr().(*net.TCPAddr)
+
+	m := NewSMTPMailer(SMTPOptions{
+		Host:      "127.0.0.1",
+		Port:      addr.Port,
+		Username:  "mailer",
+		Password:  "s3cret",
+		From:      "SecNex <no-reply@secnex.io>",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	err := m.Send(Message{To: "alice@example.com", Subject: "Verify", Text: "Hello Alice"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+
+	if !server.startTLS || !server.authed {
+		t.Fatalf("startTLS = %v, authed = %v", server.startTLS, server.authed)
+	}
+	if server.from != "no-reply@secnex.io" || len(server.to) != 1 || server.to[0] != "alice@example.com" {
+		t.Errorf("envelope = %q -> %v", server.from, server.to)
+	}
+	if !strings.Contains(server.data, "Subject: Verify") || !strings.Contains(server.data, "Hello Alice") {
+		t.Errorf("data = %q", server.data)
+	}
+}
+
+func TestSMTPMailerRejectsUntrustedCertificate(t *testing.T) {
+	certificate, _ := testCertificate(t)
+	server := newFakeSMTPServer(t, certificate, "mailer", "s3cret")
+	addr := server.listener.Addr().(*net.TCPAddr)
+
+	m := NewSMTPMailer(SMTPOptions{Host: "127.0.0.1", Port: addr.Port, Username: "mailer", Password: "s3cret", From: "no-reply@secnex.io"})
+	if err := m.Send(Message{To: "alice@example.com", Subject: "Verify", Text: "Hello"}); err == nil {
+		t.Fatal("Send succeeded with an untrusted certificate")
+	}
+	if server.authed {
+		t.Error("credentials were sent")
+	}
+}
+
+func TestSMTPMailerWrongCredentials(t *testing.T) {
+	certificate, pool := testCertificate(t)
+	server := newFakeSMTPServer(t, certificate, "mailer", "s3cret")
+	addr := server.listener.Addr().(*net.TCPAddr)
+
+	m := NewSMTPMailer(SMTPOptions{
+		Host:      "127.0.0.1",
+		Port:      addr.Port,
+		Username:  "mailer",
+		Password:  "wrong",
+		From:      "no-reply@secnex.io",
+		TLSConfig: &tls.Config{RootCAs: pool},
+	})
+	if err := m.Send(Message{To: "alice@example.com", Subject: "Verify", Text: "Hello"}); err == nil {
+		t.Fatal("Send succeeded with wrong credentials")
+	}
+}
@@ -0,0 +1,170 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when no template exists for the locale of the user
+const DefaultLocale = "en"
+
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+	TemplateInvitation        = "invitation"
+	TemplateSecurityAlert     = "security_alert"
+	TemplateLoginLink         = "login_link"
+	TemplateLoginCode         = "login_code"
+)
+
+// Template is a localized email. Subject and Text use text/template, HTML uses html/template.
+type Template struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Templates contains the built-in templates by name and locale. Tenants can override them with models.EmailTemplate.
+var Templates = map[string]map[string]Template{
+	TemplatePasswordReset: {
+		"en": {
+			Subject: "Reset your {{.TenantName}} password",
+			Text:    "Hello {{.Name}},\n\nuse the following link to choose a new password. It is valid for 30 minutes.\n\n{{.Link}}\n\nIf you did not request a password reset, you can ignore this email.\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>use the following link to choose a new password. It is valid for 30 minutes.</p><p><a href="{{.Link}}">Reset password</a></p><p>If you did not request a password reset, you can ignore this email.</p>`,
+		},
+		"de": {
+			Subject: "Passwort für {{.TenantName}} zurücksetzen",
+			Text:    "Hallo {{.Name}},\n\nüber den folgenden Link kannst du ein neues Passwort wählen. Er ist 30 Minuten gültig.\n\n{{.Link}}\n\nFalls du kein neues Passwort angefordert hast, kannst du diese E-Mail ignorieren.\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>über den folgenden Link kannst du ein neues Passwort wählen. Er ist 30 Minuten gültig.</p><p><a href="{{.Link}}">Passwort zurücksetzen</a></p><p>Falls du kein neues Passwort angefordert hast, kannst du diese E-Mail ignorieren.</p>`,
+		},
+	},
+	TemplateEmailVerification: {
+		"en": {
+			Subject: "Verify your email address for {{.TenantName}}",
+			Text:    "Hello {{.Name}},\n\nplease confirm your email address with the following link. It is valid for 24 hours.\n\n{{.Link}}\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>please confirm your email address with the following link. It is valid for 24 hours.</p><p><a href="{{.Link}}">Verify email address</a></p>`,
+		},
+		"de": {
+			Subject: "Bestätige deine E-Mail-Adresse für {{.TenantName}}",
+			Text:    "Hallo {{.Name}},\n\nbitte bestätige deine E-Mail-Adresse über den folgenden Link. Er ist 24 Stunden gültig.\n\n{{.Link}}\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>bitte bestätige deine E-Mail-Adresse über den folgenden Link. Er ist 24 Stunden gültig.</p><p><a href="{{.Link}}">E-Mail-Adresse bestätigen</a></p>`,
+		},
+	},
+	TemplateInvitation: {
+		"en": {
+			Subject: "You have been invited to {{.TenantName}}",
+			Text:    "Hello {{.Name}},\n\nyou have been invited to {{.TenantName}}. Use the following link to set up your account.\n\n{{.Link}}\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>you have been invited to {{.TenantName}}. Use the following link to set up your account.</p><p><a href="{{.Link}}">Set up account</a></p>`,
+		},
+		"de": {
+			Subject: "Einladung zu {{.TenantName}}",
+			Text:    "Hallo {{.Name}},\n\ndu wurdest zu {{.TenantName}} eingeladen. Über den folgenden Link kannst du dein Konto einrichten.\n\n{{.Link}}\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>du wurdest zu {{.TenantName}} eingeladen. Über den folgenden Link kannst du dein Konto einrichten.</p><p><a href="{{.Link}}">Konto einrichten</a></p>`,
+		},
+	},
+	TemplateLoginLink: {
+		"en": {
+			Subject: "Sign in to {{.TenantName}}",
+			Text:    "Hello {{.Name}},\n\nuse the following link to sign in. It is valid for 10 minutes and only works in the browser where you requested it.\n\n{{.Link}}\n\nIf you did not try to sign in, you can ignore this email.\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>use the following link to sign in. It is valid for 10 minutes and only works in the browser where you requested it.</p><p><a href="{{.Link}}">Sign in</a></p><p>If you did not try to sign in, you can ignore this email.</p>`,
+		},
+		"de": {
+			Subject: "Bei {{.TenantName}} anmelden",
+			Text:    "Hallo {{.Name}},\n\nüber den folgenden Link kannst du dich anmelden. Er ist 10 Minuten gültig und funktioniert nur in dem Browser, in dem du ihn angefordert hast.\n\n{{.Link}}\n\nFalls du dich nicht anmelden wolltest, kannst du diese E-Mail ignorieren.\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>über den folgenden Link kannst du dich anmelden. Er ist 10 Minuten gültig und funktioniert nur in dem Browser, in dem du ihn angefordert hast.</p><p><a href="{{.Link}}">Anmelden</a></p><p>Falls du dich nicht anmelden wolltest, kannst du diese E-Mail ignorieren.</p>`,
+		},
+	},
+	TemplateLoginCode: {
+		"en": {
+			Subject: "{{.Code}} is your {{.TenantName}} sign-in code",
+			Text:    "Hello {{.Name}},\n\nyour sign-in code is {{.Code}}. It is valid for 10 minutes.\n\nIf you did not try to sign in, you can ignore this email.\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>your sign-in code is <strong>{{.Code}}</strong>. It is valid for 10 minutes.</p><p>If you did not try to sign in, you can ignore this email.</p>`,
+		},
+		"de": {
+			Subject: "{{.Code}} ist dein Anmeldecode für {{.TenantName}}",
+			Text:    "Hallo {{.Name}},\n\ndein Anmeldecode lautet {{.Code}}. Er ist 10 Minuten gültig.\n\nFalls du dich nicht anmelden wolltest, kannst du diese E-Mail ignorieren.\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>dein Anmeldecode lautet <strong>{{.Code}}</strong>. Er ist 10 Minuten gültig.</p><p>Falls du dich nicht anmelden wolltest, kannst du diese E-Mail ignorieren.</p>`,
+		},
+	},
+	TemplateSecurityAlert: {
+		"en": {
+			Subject: "Security alert for your {{.TenantName}} account",
+			Text:    "Hello {{.Name}},\n\n{{.Event}}\n\nIf this was not you, reset your password immediately.\n",
+			HTML:    `<p>Hello {{.Name}},</p><p>{{.Event}}</p><p>If this was not you, reset your password immediately.</p>`,
+		},
+		"de": {
+			Subject: "Sicherheitshinweis für dein {{.TenantName}}-Konto",
+			Text:    "Hallo {{.Name}},\n\n{{.Event}}\n\nFalls du das nicht warst, setze bitte sofort dein Passwort zurück.\n",
+			HTML:    `<p>Hallo {{.Name}},</p><p>{{.Event}}</p><p>Falls du das nicht warst, setze bitte sofort dein Passwort zurück.</p>`,
+		},
+	},
+}
+
+// LocaleCandidates returns the locales to try for locale, e.g. "de-AT" -> ["de-AT", "de", "en"]
+func LocaleCandidates(locale string) []string {
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if language, _, ok := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-"); ok {
+			candidates = append(candidates, language)
+		}
+	}
+	if locale != DefaultLocale {
+		candidates = append(candidates, DefaultLocale)
+	}
+	return candidates
+}
+
+// BuiltinTemplate returns the built-in template for the name in the best matching locale
+func BuiltinTemplate(name string, locale string) (Template, bool) {
+	locales, ok := Templates[name]
+	if !ok {
+		return Template{}, false
+	}
+	for _, candidate := range LocaleCandidates(locale) {
+		if template, ok := locales[candidate]; ok {
+			return template, true
+		}
+	}
+	return Template{}, false
+}
+
+// Render executes the template with data and returns a message for the recipient
+func (t Template) Render(to string, data interface{}) (Message, error) {
+	subject, err := renderText(t.Subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+	text, err := renderText(t.Text, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{To: to, Subject: subject, Text: text}
+	if t.HTML != "" {
+		tmpl, err := htmltemplate.New("html").Parse(t.HTML)
+		if err != nil {
+			return Message{}, err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return Message{}, err
+		}
+		message.HTML = b.String()
+	}
+	return message, nil
+}
+
+func renderText(source string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.New("text").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
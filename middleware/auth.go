@@ -3,11 +3,15 @@ package middleware
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/secnex/sethorize-kit/handler"
 	"github.com/secnex/sethorize-kit/helper"
 	"github.com/secnex/sethorize-kit/models"
@@ -47,9 +51,12 @@ func (h *AuthMiddleware) ClientMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		argon2 := helper.NewArgon2Default()
-		ok, err = argon2.Compare(clientSecret, client.Secret)
+		ok, err = h.Handler.VerifyClientSecret(&client, clientSecret)
 		if err != nil {
+			http.Error(w, "Invalid client authentication", handler.StatusFor(err, http.StatusUnauthorized))
+			return
+		}
+		if !ok {
 			http.Error(w, "Invalid client authentication", http.StatusUnauthorized)
 			return
 		}
@@ -63,11 +70,28 @@ func (h *AuthMiddleware) AuthMiddleware(next http.Handler) http.Handler {
 		accessToken := r.Header.Get("Authorization")
 		accessToken = strings.TrimPrefix(accessToken, "Bearer ")
 
+		// Tokens name the signing key of their tenant. Tokens without kid were signed with the legacy key
+		// and are only accepted until they have expired after the cutover to tenant keys.
+		var keyTenantID *uuid.UUID
 		token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
-			return h.KeyManager.GetPublicKey(), nil
+			kid, _ := token.Header["kid"].(string)
+			if kid == "" {
+				claims, _ := token.Claims.(jwt.MapClaims)
+				issuedAt, _ := claims["iat"].(float64)
+				if !h.Handler.AcceptsLegacyToken(time.Unix(int64(issuedAt), 0)) {
+					return nil, errors.New("tokens without kid are no longer accepted")
+				}
+				return h.KeyManager.GetPublicKey(), nil
+			}
+			key, publicKey, err := h.Handler.PublishedSigningKey(kid)
+			if err != nil {
+				return nil, err
+			}
+			keyTenantID = &key.TenantID
+			return publicKey, nil
 		})
 
 		if err != nil {
@@ -90,9 +114,286 @@ func (h *AuthMiddleware) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if !h.validIssuer(r, &session, claims, keyTenantID) {
+			http.Error(w, "Invalid issuer", http.StatusUnauthorized)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), "session", session)
+		ctx = context.WithValue(ctx, "claims", claims)
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// validIssuer checks that the token was signed with a key of the session's tenant, that iss is the current
+// issuer of the tenant and, if the token is presented on a host of this deployment, that the host belongs to the issuer
+func (h *AuthMiddleware) validIssuer(r *http.Request, session *models.Session, claims jwt.MapClaims, keyTenantID *uuid.UUID) bool {
+	var client models.Client
+	if h.Handler.DB.Where("id = ?", session.ClientID).First(&client).Error != nil {
+		return false
+	}
+
+	tenantID := client.TenantID
+	if session.UserID != uuid.Nil {
+		var user models.User
+		if h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error != nil {
+			return false
+		}
+		tenantID = user.TenantID
+	}
+	if keyTenantID != nil && *keyTenantID != tenantID {
+		return false
+	}
+
+	issuer, _ := claims["iss"].(string)
+	if issuer != h.Handler.Settings(tenantID, &client.ID).Issuer {
+		return false
+	}
+
+	// Internal hostnames and issuers that are no URL are not checked
+	issuerHost := handler.IssuerHost(issuer)
+	if issuerHost == "" || !h.Handler.KnownHost(r.Host) {
+		return true
+	}
+	return issuerHost == handler.NormalizeHost(r.Host)
+}
+
+// RequireVerified rejects tokens that were issued with the restricted "unverified" scope
+func (h *AuthMiddleware) RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if scope, _ := claims["scope"].(string); scope == handler.ScopeUnverified {
+			http.Error(w, "Email address not verified", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireACR rejects tokens whose acr is weaker than required or, with maxAge > 0, whose auth_time is older.
+// The response asks the client for a step-up (RFC 9470).
+func (h *AuthMiddleware) RequireACR(required string, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			acr, _ := claims["acr"].(string)
+			authTime, _ := claims["auth_time"].(float64)
+			recent := maxAge <= 0 || time.Since(time.Unix(int64(authTime), 0)) <= maxAge
+
+			if !handler.ACRSatisfies(acr, required) || !recent {
+				challenge := fmt.Sprintf("Bearer error=%q, acr_values=%q", handler.ErrorInsufficientAuthentication, required)
+				if maxAge > 0 {
+					challenge += fmt.Sprintf(", max_age=%d", int(maxAge.Seconds()))
+				}
+				w.Header().Set("WWW-Authenticate", challenge)
+				handler.WriteError(w, http.StatusUnauthorized, handler.ErrorInsufficientAuthentication, "A stronger or more recent authentication is required")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSuperAdmin only lets tokens with the admin scope of active super admins of an active tenant through.
+// Like RequireAdmin it rejects tokens of other clients, which never carry the admin scope.
+func (h *AuthMiddleware) RequireSuperAdmin(next http.Handler) http.Handler {
+	return h.requireAdmin("users.is_super_admin", next)
+}
+
+// RequireAdmin only lets tokens with the admin scope of active admins of an active tenant through
+func (h *AuthMiddleware) RequireAdmin(next http.Handler) http.Handler {
+	return h.requireAdmin("users.is_admin", next)
+}
+
+// requireAdmin checks the admin scope of the token and the flag column of its user
+func (h *AuthMiddleware) requireAdmin(column string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		session, ok := r.Context().Value("session").(models.Session)
+		if !ok {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		}
+
+		scope, _ := claims["scope"].(string)
+		if !slices.Contains(strings.Fields(scope), handler.ScopeAdmin) {
+			handler.WriteError(w, http.StatusForbidden, "insufficient_scope", "The admin scope is required")
+			return
+		}
+
+		var user models.User
+		err := h.Handler.DB.Joins("Tenant").Where("users.id = ? AND users.is_active = ? AND "+column+" = ?", session.UserID, true, true).First(&user).Error
+		if err != nil || !user.Tenant.IsActive {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets users with the role through
+func (h *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handler {
+	return h.requireAuthorization(handler.ClaimRoles, role)
+}
+
+// RequireGroup only lets members of the group through
+func (h *AuthMiddleware) RequireGroup(group string) func(http.Handler) http.Handler {
+	return h.requireAuthorization(handler.ClaimGroups, group)
+}
+
+// RequirePermission only lets users through whose roles grant the permission of the token's client
+func (h *AuthMiddleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return h.requireAuthorization(handler.ClaimPermissions, permission)
+}
+
+// requireAuthorization checks the claim of the token. A claim that was left out for its size is resolved
+// from the database.
+func (h *AuthMiddleware) requireAuthorization(claim string, value string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			session, ok := r.Context().Value("session").(models.Session)
+			if !ok {
+				http.Error(w, "Invalid session", http.StatusUnauthorized)
+				return
+			}
+
+			if claimContains(claims[claim], value) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if session.UserID != uuid.Nil && claimContains(claims[handler.ClaimOverage], claim) {
+				authorization, err := h.Handler.UserAuthorization(session.UserID, &session.ClientID)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if authorization.Has(claim, value) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			handler.WriteError(w, http.StatusForbidden, "insufficient_permissions", "The "+claim+" claim does not contain "+value)
+		})
+	}
+}
+
+// claimContains reports whether a list claim, as decoded from JSON, contains the value
+func claimContains(claim interface{}, value string) bool {
+	values, _ := claim.([]interface{})
+	for _, entry := range values {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope only lets tokens with the scope through. The scope claim is a space separated string in
+// user tokens and a list in client credentials tokens.
+func (h *AuthMiddleware) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			granted := claimContains(claims["scope"], scope)
+			if scopes, ok := claims["scope"].(string); ok {
+				granted = slices.Contains(strings.Fields(scopes), scope)
+			}
+			if !granted {
+				handler.WriteError(w, http.StatusForbidden, "insufficient_scope", "The "+scope+" scope is required")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePolicies evaluates the api policies of the tenant for the request and rejects denied requests
+func (h *AuthMiddleware) RequirePolicies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("claims").(jwt.MapClaims)
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		session, ok := r.Context().Value("session").(models.Session)
+		if !ok {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		}
+
+		var client models.Client
+		if err := h.Handler.DB.Where("id = ?", session.ClientID).First(&client).Error; err != nil {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		}
+		input := handler.PolicyInput{
+			Point:       handler.PolicyPointAPI,
+			TenantID:    client.TenantID,
+			Client:      &client,
+			Request:     r,
+			AuthMethods: session.AuthMethods,
+			ACR:         session.ACR,
+		}
+		if scopes, ok := claims["scope"].(string); ok {
+			input.Scopes = strings.Fields(scopes)
+		}
+		if scopes, ok := claims["scope"].([]interface{}); ok {
+			for _, scope := range scopes {
+				input.Scopes = append(input.Scopes, fmt.Sprint(scope))
+			}
+		}
+		if session.UserID != uuid.Nil {
+			var user models.User
+			if err := h.Handler.DB.Where("id = ?", session.UserID).First(&user).Error; err != nil {
+				http.Error(w, "Invalid session", http.StatusUnauthorized)
+				return
+			}
+			input.User = &user
+			input.TenantID = user.TenantID
+		}
+
+		decision, err := h.Handler.EvaluatePolicies(input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !decision.Allowed {
+			handler.WriteError(w, http.StatusForbidden, "access_denied", decision.Reason)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
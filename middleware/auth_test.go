@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/handler"
+	"github.com/secnex/sethorize-kit/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRequireACR(t *testing.T) {
+	recent := float64(time.Now().Add(-time.Minute).Unix())
+	old := float64(time.Now().Add(-time.Hour).Unix())
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		maxAge time.Duration
+		status int
+	}{
+		{"stronger acr", jwt.MapClaims{"acr": handler.ACRPhishingResistant, "auth_time": old}, 0, http.StatusOK},
+		{"required acr", jwt.MapClaims{"acr": handler.ACRMultiFactor, "auth_time": recent}, 10 * time.Minute, http.StatusOK},
+		{"weaker acr", jwt.MapClaims{"acr": handler.ACRSingleFactor, "auth_time": recent}, 0, http.StatusUnauthorized},
+		{"without acr", jwt.MapClaims{"auth_time": recent}, 0, http.StatusUnauthorized},
+		{"old authentication", jwt.MapClaims{"acr": handler.ACRMultiFactor, "auth_time": old}, 10 * time.Minute, http.StatusUnauthorized},
+		{"without auth_time", jwt.MapClaims{"acr": handler.ACRMultiFactor}, 10 * time.Minute, http.StatusUnauthorized},
+		{"without token", nil, 0, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &AuthMiddleware{}
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.claims != nil {
+				request = request.WithContext(context.WithValue(request.Context(), "claims", test.claims))
+			}
+			recorder := httptest.NewRecorder()
+			m.RequireACR(handler.ACRMultiFactor, test.maxAge)(next).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.status)
+			}
+			challenge := recorder.Header().Get("WWW-Authenticate")
+			if test.status == http.StatusOK || test.claims == nil {
+				if challenge != "" {
+					t.Errorf("WWW-Authenticate = %s", challenge)
+				}
+				return
+			}
+			if !strings.Contains(challenge, `error="`+handler.ErrorInsufficientAuthentication+`"`) || !strings.Contains(challenge, `acr_values="`+handler.ACRMultiFactor+`"`) {
+				t.Errorf("WWW-Authenticate = %s", challenge)
+			}
+			if test.maxAge > 0 && !strings.Contains(challenge, "max_age=600") {
+				t.Errorf("WWW-Authenticate without max_age: %s", challenge)
+			}
+		})
+	}
+}
+
+// TestValidIssuer checks that tokens carry the issuer of their tenant and are only accepted on its hosts
+func TestValidIssuer(t *testing.T) {
+	t.Setenv("APPLICATION_DOMAIN", "secnex.io")
+	tenantID, otherID := uuid.New(), uuid.New()
+	tests := []struct {
+		name      string
+		issuer    string
+		keyTenant uuid.UUID
+		host      string
+		// hostTenant is the tenant of a custom domain host, uuid.Nil if the host is unknown
+		hostTenant uuid.UUID
+		valid      bool
+	}{
+		{"own custom domain", "https://login.acme.example", tenantID, "login.acme.example", tenantID, true},
+		{"custom domain of another tenant", "https://login.acme.example", tenantID, "login.other.example", otherID, false},
+		{"shared host", "https://login.acme.example", tenantID, "secnex.io", uuid.Nil, false},
+		{"internal host", "https://login.acme.example", tenantID, "idp.internal:8080", uuid.Nil, true},
+		{"issuer of another tenant", "https://login.other.example", tenantID, "login.other.example", otherID, false},
+		{"key of another tenant", "https://login.acme.example", otherID, "login.acme.example", tenantID, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &AuthMiddleware{Handler: handler.NewHandler(gormDB)}
+			session := &models.Session{ClientID: uuid.New(), UserID: uuid.New()}
+
+			mock.ExpectQuery(`FROM "clients"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(session.ClientID, tenantID))
+			mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id"}).AddRow(session.UserID, tenantID))
+			if test.keyTenant == tenantID {
+				// The issuer of the tenant is the one of its custom domain
+				mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(tenantID))
+				mock.ExpectQuery(`FROM "tenant_domains" WHERE tenant_id = \$1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "tenant_id", "hostname"}).AddRow(uuid.New(), tenantID, "login.acme.example"))
+				for range 3 {
+					mock.ExpectQuery(`FROM "settings"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				}
+			}
+			if test.keyTenant == tenantID && test.issuer == "https://login.acme.example" && test.host != "secnex.io" {
+				domains := sqlmock.NewRows([]string{"id", "tenant_id", "hostname"})
+				if test.hostTenant != uuid.Nil {
+					domains.AddRow(uuid.New(), test.hostTenant, handler.NormalizeHost(test.host))
+				}
+				mock.ExpectQuery(`FROM "tenant_domains" WHERE hostname = \$1`).WithArgs(handler.NormalizeHost(test.host), 1).WillReturnRows(domains)
+				if test.hostTenant != uuid.Nil {
+					mock.ExpectQuery(`FROM "tenants"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(test.hostTenant))
+				}
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Host = test.host
+			keyTenant := test.keyTenant
+			if valid := m.validIssuer(request, session, jwt.MapClaims{"iss": test.issuer}, &keyTenant); valid != test.valid {
+				t.Errorf("valid = %t, want %t", valid, test.valid)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRequireRoleResolvesOverage(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		// roles are resolved from the database, nil if they are not looked up
+		roles  []string
+		status int
+	}{
+		{"role in the claim", jwt.MapClaims{"roles": []interface{}{"viewer", "admin"}}, nil, http.StatusOK},
+		{"role missing from the claim", jwt.MapClaims{"roles": []interface{}{"viewer"}}, nil, http.StatusForbidden},
+		{"role in the overage", jwt.MapClaims{"overage": []interface{}{"roles"}}, []string{"admin"}, http.StatusOK},
+		{"role missing from the overage", jwt.MapClaims{"overage": []interface{}{"roles"}}, []string{"viewer"}, http.StatusForbidden},
+		{"overage of another claim", jwt.MapClaims{"overage": []interface{}{"groups"}}, nil, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &AuthMiddleware{Handler: handler.NewHandler(gormDB)}
+			session := models.Session{ClientID: uuid.New(), UserID: uuid.New()}
+
+			if test.roles != nil {
+				mock.ExpectQuery(`FROM "groups"`).WithArgs(session.UserID).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				roles := sqlmock.NewRows([]string{"name"})
+				for _, role := range test.roles {
+					roles.AddRow(role)
+				}
+				mock.ExpectQuery(`FROM "roles"`).WithArgs(session.UserID, session.UserID).WillReturnRows(roles)
+				// The permissions are those of the session's client
+				mock.ExpectQuery(`FROM "permissions"`).WithArgs(session.ClientID, session.UserID, session.UserID).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+			}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx := context.WithValue(request.Context(), "claims", test.claims)
+			request = request.WithContext(context.WithValue(ctx, "session", session))
+			recorder := httptest.NewRecorder()
+			m.RequireRole("admin")(next).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("status = %d (%s), want %d", recorder.Code, recorder.Body.String(), test.status)
+			}
+			if test.status == http.StatusForbidden && !strings.Contains(recorder.Body.String(), "insufficient_permissions") {
+				t.Errorf("body = %s", recorder.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security relevant event of a tenant, e.g. a policy decision
+type AuditEvent struct {
+	ID        uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID       `gorm:"type:uuid;not null;index:idx_audit_event_tenant_time" json:"tenant_id"`
+	Type      string          `gorm:"type:varchar(64);not null;index" json:"type"`
+	UserID    *uuid.UUID      `gorm:"type:uuid;index" json:"user_id"`
+	ClientID  *uuid.UUID      `gorm:"type:uuid" json:"client_id"`
+	Details   json.RawMessage `gorm:"type:jsonb" json:"details"`
+	CreatedAt time.Time       `gorm:"autoCreateTime;index:idx_audit_event_tenant_time" json:"created_at"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
@@ -13,6 +13,7 @@ type AuthCode struct {
 	ID          uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	ClientID    uuid.UUID      `gorm:"type:uuid;not null"`
 	UserID      uuid.UUID      `gorm:"type:uuid;not null"`
+	SessionID   *uuid.UUID     `gorm:"type:uuid"`
 	Code        string         `gorm:"type:varchar(255);not null"`
 	Scopes      pq.StringArray `gorm:"type:text[]" json:"scopes"`
 	RedirectURI string         `gorm:"type:varchar(255);not null"`
@@ -28,8 +29,11 @@ func (AuthCode) TableName() string {
 func (a *AuthCode) BeforeCreate(tx *gorm.DB) (err error) {
 	a.ExpiresAt = time.Now().Add(time.Minute * 5)
 
-	argon2 := helper.NewArgon2Default()
-	hash, err := argon2.Hash(a.Code)
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(a.Code)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthzNamespace holds the relation config (authz.NamespaceConfig) of a namespace of the tenant
+type AuthzNamespace struct {
+	ID        uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_authz_namespace_name_tenant" json:"tenant_id"`
+	Name      string          `gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_namespace_name_tenant" json:"name"`
+	Config    json.RawMessage `gorm:"type:jsonb;not null" json:"config"`
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (AuthzNamespace) TableName() string {
+	return "authz_namespaces"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ClaimMapper adds one claim to the access tokens of a client. Claim is the claim name, nested with dots
+// (e.g. "user.email"). With a Scope the claim is only added if the token carries the scope. Type selects
+// the value: a static JSON Value, the user attribute named in Source, the roles, groups or permissions
+// of the user, or the expression in Source.
+type ClaimMapper struct {
+	ID        uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID       `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	ClientID  uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_claim_mapper" json:"client_id"`
+	Claim     string          `gorm:"type:varchar(255);not null;uniqueIndex:idx_claim_mapper" json:"claim"`
+	Scope     string          `gorm:"type:varchar(255);not null;default:'';uniqueIndex:idx_claim_mapper" json:"scope"`
+	Type      string          `gorm:"type:varchar(20);not null" json:"type"`
+	Value     json.RawMessage `gorm:"type:jsonb" json:"value,omitempty"`
+	Source    string          `gorm:"type:text;not null;default:''" json:"source,omitempty"`
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (ClaimMapper) TableName() string {
+	return "claim_mappers"
+}
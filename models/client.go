@@ -14,7 +14,7 @@ type Client struct {
 	Name         string         `gorm:"not null;uniqueIndex:idx_name_tenant" json:"name"`
 	Slug         string         `gorm:"not null;uniqueIndex:idx_slug_tenant" json:"slug"`
 	Description  string         `gorm:"not null" json:"description"`
-	Secret       string         `gorm:"not null" json:"secret"`
+	Secret       string         `gorm:"not null" json:"-"`
 	RedirectURIs pq.StringArray `gorm:"type:text[]" json:"redirect_uris"`
 	Scopes       pq.StringArray `gorm:"type:text[]" json:"scopes"`
 	IsActive     bool           `gorm:"not null;default:true" json:"is_active"`
@@ -24,6 +24,14 @@ type Client struct {
 	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
 
+	// CrossTenant clients can authenticate users of other tenants, e.g. a shared admin console
+	CrossTenant bool `gorm:"not null;default:false" json:"cross_tenant"`
+
+	// Passwordless email login with a link to PasswordlessURL and/or a 6-digit code
+	PasswordlessLink bool   `gorm:"not null;default:false" json:"passwordless_link"`
+	PasswordlessCode bool   `gorm:"not null;default:false" json:"passwordless_code"`
+	PasswordlessURL  string `gorm:"type:varchar(255)" json:"passwordless_url"`
+
 	Tenant *Tenant `gorm:"foreignKey:TenantID"`
 }
 
@@ -31,9 +39,16 @@ func (Client) TableName() string {
 	return "clients"
 }
 
+// BeforeCreate hashes Secret. Clients created by the admin API keep their secrets in ClientSecret and have none.
 func (c *Client) BeforeCreate(tx *gorm.DB) (err error) {
-	argon2 := helper.NewArgon2Default()
-	hash, err := argon2.Hash(c.Secret)
+	if c.Secret == "" {
+		return nil
+	}
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(c.Secret)
 	if err != nil {
 		return err
 	}
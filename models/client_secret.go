@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// ClientSecret is one of the secrets of a client. During a rotation the old and the new secret are both
+// valid until the old one expires. Secrets without ExpiresAt do not expire.
+type ClientSecret struct {
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ClientID uuid.UUID `gorm:"type:uuid;not null;index" json:"client_id"`
+	Secret   string    `gorm:"not null" json:"-"`
+	// Hint are the last characters of the secret to recognize it
+	Hint       string    `gorm:"type:varchar(8);not null" json:"hint"`
+	ExpiresAt  time.Time `gorm:"type:timestamp;default:null" json:"expires_at"`
+	LastUsedAt time.Time `gorm:"type:timestamp;default:null" json:"last_used_at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Client *Client `gorm:"foreignKey:ClientID" json:"-"`
+}
+
+func (ClientSecret) TableName() string {
+	return "client_secrets"
+}
+
+func (s *ClientSecret) BeforeCreate(tx *gorm.DB) (err error) {
+	if len(s.Secret) > 4 {
+		s.Hint = s.Secret[len(s.Secret)-4:]
+	}
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(s.Secret)
+	if err != nil {
+		return err
+	}
+	s.Secret = hash
+	return nil
+}
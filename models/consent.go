@@ -29,6 +29,8 @@ func (Consent) TableName() string {
 }
 
 func (c *Consent) BeforeCreate(tx *gorm.DB) (err error) {
-	c.ExpiresAt = time.Now().Add(time.Hour * 24 * 30)
+	if c.ExpiresAt.IsZero() {
+		c.ExpiresAt = time.Now().Add(time.Hour * 24 * 30)
+	}
 	return nil
 }
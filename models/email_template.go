@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailTemplate overrides a built-in mail template (see mailer.Templates) for a tenant and locale
+type EmailTemplate struct {
+	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_template_tenant_name_locale" json:"tenant_id"`
+	Name      string         `gorm:"not null;uniqueIndex:idx_template_tenant_name_locale" json:"name"`
+	Locale    string         `gorm:"not null;uniqueIndex:idx_template_tenant_name_locale" json:"locale"`
+	Subject   string         `gorm:"not null" json:"subject"`
+	Text      string         `gorm:"type:text;not null" json:"text"`
+	HTML      string         `gorm:"type:text" json:"html"`
+	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
+
+	Tenant *Tenant `gorm:"foreignKey:TenantID" json:"-"`
+}
+
+func (EmailTemplate) TableName() string {
+	return "email_templates"
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// EmailVerification confirms that a user owns Email. For an email change Email is the new address.
+type EmailVerification struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Email     string    `gorm:"type:varchar(255);not null"`
+	Token     string    `gorm:"type:varchar(255);not null"`
+	UsedAt    time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (EmailVerification) TableName() string {
+	return "email_verifications"
+}
+
+func (e *EmailVerification) BeforeCreate(tx *gorm.DB) (err error) {
+	e.ExpiresAt = time.Now().Add(time.Hour * 24)
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(e.Token)
+	if err != nil {
+		return err
+	}
+	e.Token = hash
+	return
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Group is a set of users within a tenant. The members hold the roles of the group.
+type Group struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_group_name_tenant" json:"tenant_id"`
+	Name        string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_group_name_tenant" json:"name"`
+	Description string    `gorm:"not null;default:''" json:"description"`
+	// ExternalID is the ID of the group in the provisioning system (SCIM externalId)
+	ExternalID string    `gorm:"type:varchar(255);not null;default:''" json:"external_id"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Group) TableName() string {
+	return "groups"
+}
+
+// UserGroup is the membership of a user in a group
+type UserGroup struct {
+	GroupID   uuid.UUID `gorm:"primaryKey;type:uuid" json:"group_id"`
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid;index" json:"user_id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (UserGroup) TableName() string {
+	return "user_groups"
+}
+
+// GroupRole assigns a role to all members of a group
+type GroupRole struct {
+	GroupID   uuid.UUID `gorm:"primaryKey;type:uuid" json:"group_id"`
+	RoleID    uuid.UUID `gorm:"primaryKey;type:uuid;index" json:"role_id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (GroupRole) TableName() string {
+	return "group_roles"
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// MFAChallenge is issued after a successful first factor. It is redeemed with a second factor for a session.
+type MFAChallenge struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID   uuid.UUID `gorm:"type:uuid;not null;index"`
+	ClientID uuid.UUID `gorm:"type:uuid;not null"`
+	// SessionID is set for a step-up of an existing session
+	SessionID *uuid.UUID `gorm:"type:uuid"`
+	// AuthMethods are the methods of the first factor, the password if empty
+	AuthMethods pq.StringArray `gorm:"type:text[]"`
+	Token       string         `gorm:"type:varchar(255);not null"`
+	Attempts    int            `gorm:"not null;default:0"`
+	UsedAt      time.Time      `gorm:"type:timestamp;default:null"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime"`
+	ExpiresAt   time.Time      `gorm:"type:timestamp;not null"`
+
+	User   User   `gorm:"foreignKey:UserID"`
+	Client Client `gorm:"foreignKey:ClientID"`
+}
+
+func (MFAChallenge) TableName() string {
+	return "mfa_challenges"
+}
+
+func (c *MFAChallenge) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ExpiresAt = time.Now().Add(time.Minute * 10)
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(c.Token)
+	if err != nil {
+		return err
+	}
+	c.Token = hash
+	return
+}
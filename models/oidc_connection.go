@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// OIDCConnection is an upstream OpenID Connect provider, e.g. the corporate IdP of a customer, with which
+// users of the tenant log in
+type OIDCConnection struct {
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_oidc_connection_name_tenant" json:"tenant_id"`
+	Name     string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_oidc_connection_name_tenant" json:"name"`
+	// DisplayName is shown on the login button
+	DisplayName string `gorm:"type:varchar(255);not null;default:''" json:"display_name"`
+	Issuer      string `gorm:"type:varchar(255);not null" json:"issuer"`
+	ClientID    string `gorm:"type:varchar(255);not null" json:"client_id"`
+	// ClientSecret is encrypted with the master key, connections without secret are public clients
+	ClientSecret string         `gorm:"not null;default:''" json:"-"`
+	Scopes       pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	// Domains are the email domains whose users are routed to the connection
+	Domains pq.StringArray `gorm:"type:text[]" json:"domains"`
+	// ClaimMapping maps user fields and custom attributes ("attributes.<name>") to claims of the ID token
+	ClaimMapping json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"claim_mapping"`
+	// JITProvisioning creates unknown users on their first login
+	JITProvisioning bool `gorm:"not null;default:false" json:"jit_provisioning"`
+	// LinkByEmail links the first login to the verified user with the same, upstream verified email address
+	LinkByEmail bool      `gorm:"not null;default:true" json:"link_by_email"`
+	IsActive    bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (OIDCConnection) TableName() string {
+	return "oidc_connections"
+}
+
+// UserIdentity links a user to its subject at an upstream provider
+type UserIdentity struct {
+	ID           uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID     uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	ConnectionID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_identity_subject" json:"connection_id"`
+	Subject      string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_identity_subject" json:"subject"`
+	// Email is the email address at the provider at the last login
+	Email       string    `gorm:"type:varchar(255);not null;default:''" json:"email"`
+	LastLoginAt time.Time `gorm:"type:timestamp;default:null" json:"last_login_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// OIDCLogin is a pending login at an upstream provider. Its ID is the state of the authorization request,
+// and it can only be completed by the browser that started it, which proves this with BrowserToken.
+type OIDCLogin struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ConnectionID uuid.UUID `gorm:"type:uuid;not null;index"`
+	ClientID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	BrowserToken string    `gorm:"type:varchar(255);not null"`
+	Nonce        string    `gorm:"type:varchar(255);not null"`
+	// CodeVerifier is the PKCE verifier sent with the code to the token endpoint
+	CodeVerifier string    `gorm:"type:varchar(255);not null"`
+	RedirectURI  string    `gorm:"type:text;not null"`
+	UsedAt       time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	ExpiresAt    time.Time `gorm:"type:timestamp;not null"`
+}
+
+func (OIDCLogin) TableName() string {
+	return "oidc_logins"
+}
+
+func (l *OIDCLogin) BeforeCreate(tx *gorm.DB) (err error) {
+	l.ExpiresAt = time.Now().Add(time.Minute * 10)
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	l.BrowserToken, err = secretHasher.Hash(l.BrowserToken)
+	return
+}
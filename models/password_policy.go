@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// PasswordPolicy holds the password rules of a tenant. Tenants without a policy use helper.DefaultPasswordRules.
+type PasswordPolicy struct {
+	ID                 uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID           uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex" json:"tenant_id"`
+	MinLength          int            `gorm:"not null;default:12" json:"min_length"`
+	MaxLength          int            `gorm:"not null;default:128" json:"max_length"`
+	RequireUppercase   bool           `gorm:"not null;default:false" json:"require_uppercase"`
+	RequireLowercase   bool           `gorm:"not null;default:false" json:"require_lowercase"`
+	RequireDigit       bool           `gorm:"not null;default:false" json:"require_digit"`
+	RequireSymbol      bool           `gorm:"not null;default:false" json:"require_symbol"`
+	RejectPersonalInfo bool           `gorm:"not null;default:true" json:"reject_personal_info"`
+	CheckBreached      bool           `gorm:"not null;default:true" json:"check_breached"`
+	HistorySize        int            `gorm:"not null;default:5" json:"history_size"`
+	CreatedAt          time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
+
+	Tenant *Tenant `gorm:"foreignKey:TenantID" json:"-"`
+}
+
+func (PasswordPolicy) TableName() string {
+	return "password_policies"
+}
+
+// DefaultPasswordPolicy returns the policy used for tenants without an own policy
+func DefaultPasswordPolicy(tenantID uuid.UUID) PasswordPolicy {
+	rules := helper.DefaultPasswordRules()
+	return PasswordPolicy{
+		TenantID:           tenantID,
+		MinLength:          rules.MinLength,
+		MaxLength:          rules.MaxLength,
+		RejectPersonalInfo: rules.RejectPersonalInfo,
+		CheckBreached:      rules.CheckBreached,
+		HistorySize:        5,
+	}
+}
+
+func (p PasswordPolicy) Rules() helper.PasswordRules {
+	return helper.PasswordRules{
+		MinLength:          p.MinLength,
+		MaxLength:          p.MaxLength,
+		RequireUppercase:   p.RequireUppercase,
+		RequireLowercase:   p.RequireLowercase,
+		RequireDigit:       p.RequireDigit,
+		RequireSymbol:      p.RequireSymbol,
+		RejectPersonalInfo: p.RejectPersonalInfo,
+		CheckBreached:      p.CheckBreached,
+	}
+}
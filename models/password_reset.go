@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+type PasswordReset struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Token     string    `gorm:"type:varchar(255);not null"`
+	UsedAt    time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+func (p *PasswordReset) BeforeCreate(tx *gorm.DB) (err error) {
+	p.ExpiresAt = time.Now().Add(time.Minute * 30)
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(p.Token)
+	if err != nil {
+		return err
+	}
+	p.Token = hash
+	return
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+const (
+	PasswordlessMethodLink = "link"
+	PasswordlessMethodCode = "code"
+)
+
+// PasswordlessLogin is a pending email login. It can only be redeemed by the browser that requested it,
+// which proves this with BrowserToken, together with the link token or the code from the email.
+type PasswordlessLogin struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index"`
+	ClientID     uuid.UUID `gorm:"type:uuid;not null"`
+	Method       string    `gorm:"type:varchar(10);not null"`
+	Secret       string    `gorm:"type:varchar(255);not null"`
+	BrowserToken string    `gorm:"type:varchar(255);not null"`
+	Attempts     int       `gorm:"not null;default:0"`
+	UsedAt       time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	ExpiresAt    time.Time `gorm:"type:timestamp;not null"`
+
+	User   User   `gorm:"foreignKey:UserID"`
+	Client Client `gorm:"foreignKey:ClientID"`
+}
+
+func (PasswordlessLogin) TableName() string {
+	return "passwordless_logins"
+}
+
+func (p *PasswordlessLogin) BeforeCreate(tx *gorm.DB) (err error) {
+	p.ExpiresAt = time.Now().Add(time.Minute * 10)
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	p.Secret, err = secretHasher.Hash(p.Secret)
+	if err != nil {
+		return err
+	}
+	p.BrowserToken, err = secretHasher.Hash(p.BrowserToken)
+	return
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Permission is defined by a client for its resources, e.g. "invoices:read". Tokens for the client carry
+// the permissions that the roles of the user grant.
+type Permission struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	ClientID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_permission_name_client" json:"client_id"`
+	Name        string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_permission_name_client" json:"name"`
+	Description string    `gorm:"not null;default:''" json:"description"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Policy is a rule of a tenant evaluated at one point (login, authorize, token or api). Its content is
+// versioned, Version is the active PolicyVersion.
+type Policy struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_policy_name_tenant" json:"tenant_id"`
+	Name        string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_policy_name_tenant" json:"name"`
+	Description string    `gorm:"not null;default:''" json:"description"`
+	Point       string    `gorm:"type:varchar(20);not null;index" json:"point"`
+	Version     int       `gorm:"not null;default:1" json:"version"`
+	IsActive    bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Policy) TableName() string {
+	return "policies"
+}
+
+// PolicyVersion is an immutable version of a policy. A deny policy rejects the request with Message if
+// Condition is true, a claims policy adds Claims (claim name to expression) to the access token.
+type PolicyVersion struct {
+	ID        uuid.UUID       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	PolicyID  uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_policy_version" json:"policy_id"`
+	TenantID  uuid.UUID       `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	Version   int             `gorm:"not null;uniqueIndex:idx_policy_version" json:"version"`
+	Effect    string          `gorm:"type:varchar(20);not null" json:"effect"`
+	Condition string          `gorm:"type:text;not null" json:"condition"`
+	Message   string          `gorm:"not null;default:''" json:"message"`
+	Claims    json.RawMessage `gorm:"type:jsonb" json:"claims"`
+	CreatedBy *uuid.UUID      `gorm:"type:uuid" json:"created_by"`
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (PolicyVersion) TableName() string {
+	return "policy_versions"
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Code      string    `gorm:"not null"`
+	UsedAt    time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+func (c *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(helper.NormalizeRecoveryCode(c.Code))
+	if err != nil {
+		return err
+	}
+	c.Code = hash
+	return nil
+}
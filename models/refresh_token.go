@@ -9,13 +9,14 @@ import (
 )
 
 type RefreshToken struct {
-	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	UserID    uuid.UUID `gorm:"not null" json:"user_id"`
-	ClientID  uuid.UUID `gorm:"not null" json:"client_id"`
-	Token     string    `gorm:"not null" json:"token"`
-	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
-	RevokedAt time.Time `gorm:"type:timestamp;default:null" json:"revoked_at"`
-	UsedAt    time.Time `gorm:"type:timestamp;default:null" json:"used_at"`
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null" json:"user_id"`
+	ClientID  uuid.UUID  `gorm:"not null" json:"client_id"`
+	SessionID *uuid.UUID `gorm:"type:uuid" json:"session_id"`
+	Token     string     `gorm:"not null" json:"token"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt time.Time  `gorm:"type:timestamp;default:null" json:"revoked_at"`
+	UsedAt    time.Time  `gorm:"type:timestamp;default:null" json:"used_at"`
 
 	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
@@ -30,10 +31,16 @@ func (RefreshToken) TableName() string {
 }
 
 func (u *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
-	u.ExpiresAt = time.Now().Add(time.Hour * 24)
+	// The flows set the lifetime from the settings, see handler.Settings
+	if u.ExpiresAt.IsZero() {
+		u.ExpiresAt = time.Now().Add(time.Hour * 24)
+	}
 
-	argon2 := helper.NewArgon2Default()
-	hash, err := argon2.Hash(u.Token)
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(u.Token)
 	if err != nil {
 		return err
 	}
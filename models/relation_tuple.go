@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RelationTuple relates a subject to an object, e.g. document:readme#editor@group:eng#member. Tuples are
+// never updated: a write sets CreatedRevision and a delete sets DeletedRevision, so that reads can be
+// evaluated at any snapshot.
+type RelationTuple struct {
+	ID               uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID         uuid.UUID `gorm:"type:uuid;not null;index:idx_relation_tuple_object" json:"tenant_id"`
+	Namespace        string    `gorm:"type:varchar(64);not null;index:idx_relation_tuple_object" json:"namespace"`
+	ObjectID         string    `gorm:"type:varchar(255);not null;index:idx_relation_tuple_object" json:"object_id"`
+	Relation         string    `gorm:"type:varchar(64);not null;index:idx_relation_tuple_object" json:"relation"`
+	SubjectNamespace string    `gorm:"type:varchar(64);not null;index:idx_relation_tuple_subject" json:"subject_namespace"`
+	SubjectObjectID  string    `gorm:"type:varchar(255);not null;index:idx_relation_tuple_subject" json:"subject_object_id"`
+	SubjectRelation  string    `gorm:"type:varchar(64);not null;default:''" json:"subject_relation"`
+	CreatedRevision  int64     `gorm:"not null" json:"created_revision"`
+	DeletedRevision  *int64    `gorm:"default:null" json:"deleted_revision"`
+	CreatedAt        time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (RelationTuple) TableName() string {
+	return "relation_tuples"
+}
+
+// AuthzRevision counts the writes of a tenant's tuples. The revision is the snapshot a zookie names.
+type AuthzRevision struct {
+	TenantID  uuid.UUID `gorm:"primaryKey;type:uuid" json:"tenant_id"`
+	Revision  int64     `gorm:"not null;default:0" json:"revision"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (AuthzRevision) TableName() string {
+	return "authz_revisions"
+}
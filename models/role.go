@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role is a named set of permissions within a tenant. Users hold roles directly or through groups.
+type Role struct {
+	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_role_name_tenant" json:"tenant_id"`
+	Name        string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_role_name_tenant" json:"name"`
+	Description string    `gorm:"not null;default:''" json:"description"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	RoleID       uuid.UUID `gorm:"primaryKey;type:uuid" json:"role_id"`
+	PermissionID uuid.UUID `gorm:"primaryKey;type:uuid;index" json:"permission_id"`
+	TenantID     uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (RolePermission) TableName() string {
+	return "role_permissions"
+}
+
+// UserRole assigns a role directly to a user
+type UserRole struct {
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid" json:"user_id"`
+	RoleID    uuid.UUID `gorm:"primaryKey;type:uuid;index" json:"role_id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (UserRole) TableName() string {
+	return "user_roles"
+}
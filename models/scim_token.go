@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/secnex/sethorize-kit/helper"
+	"gorm.io/gorm"
+)
+
+// ScimToken is a bearer token with which a provisioning client manages the users and groups of a tenant
+// through the SCIM API. Tokens without ExpiresAt do not expire.
+type ScimToken struct {
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	Name     string    `gorm:"type:varchar(100);not null" json:"name"`
+	Secret   string    `gorm:"not null" json:"-"`
+	// Hint are the last characters of the secret to recognize it
+	Hint       string    `gorm:"type:varchar(8);not null" json:"hint"`
+	ExpiresAt  time.Time `gorm:"type:timestamp;default:null" json:"expires_at"`
+	LastUsedAt time.Time `gorm:"type:timestamp;default:null" json:"last_used_at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (ScimToken) TableName() string {
+	return "scim_tokens"
+}
+
+func (t *ScimToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if len(t.Secret) > 4 {
+		t.Hint = t.Secret[len(t.Secret)-4:]
+	}
+
+	secretHasher, err := helper.DefaultSecretHasher()
+	if err != nil {
+		return err
+	}
+	hash, err := secretHasher.Hash(t.Secret)
+	if err != nil {
+		return err
+	}
+	t.Secret = hash
+	return nil
+}
@@ -4,9 +4,17 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
+// Authentication describes how and when the user of a session authenticated
+type Authentication struct {
+	AuthMethods pq.StringArray `gorm:"type:text[]" json:"amr"`
+	ACR         string         `gorm:"type:varchar(255)" json:"acr"`
+	AuthTime    time.Time      `gorm:"type:timestamp;default:null" json:"auth_time"`
+}
+
 type Session struct {
 	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
 	UserID    uuid.UUID      `gorm:"default:null" json:"user_id"`
@@ -17,6 +25,8 @@ type Session struct {
 	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
 
+	Authentication `gorm:"embedded"`
+
 	User   User   `gorm:"foreignKey:UserID"`
 	Client Client `gorm:"foreignKey:ClientID"`
 }
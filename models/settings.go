@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Settings configures token lifetimes, allowed grants, MFA and branding. A row without tenant and client
+// holds the global settings, a row with a tenant the tenant settings and a row with a client the client
+// settings. Unset fields (nil) are inherited from the level above, see handler.Settings.
+type Settings struct {
+	ID       uuid.UUID  `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_settings_scope" json:"tenant_id"`
+	ClientID *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_settings_scope" json:"client_id"`
+
+	// Lifetimes in seconds
+	AccessTokenLifetime  *int `json:"access_token_lifetime"`
+	RefreshTokenLifetime *int `json:"refresh_token_lifetime"`
+	ConsentLifetime      *int `json:"consent_lifetime"`
+
+	Issuer        *string        `gorm:"type:varchar(255)" json:"issuer"`
+	AllowedGrants pq.StringArray `gorm:"type:text[];default:null" json:"allowed_grants"`
+	MFAPolicy     *string        `gorm:"type:varchar(20)" json:"mfa_policy"`
+
+	BrandName         *string `gorm:"type:varchar(255)" json:"brand_name"`
+	BrandLogoURL      *string `gorm:"type:varchar(1024)" json:"brand_logo_url"`
+	BrandPrimaryColor *string `gorm:"type:varchar(20)" json:"brand_primary_color"`
+
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (Settings) TableName() string {
+	return "settings"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SigningKey is an RSA key of a tenant. The active key signs new tokens, retired keys stay published
+// in the JWKS until ExpiresAt so that tokens signed with them can still be validated.
+type SigningKey struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	Kid       string    `gorm:"type:varchar(64);not null;uniqueIndex" json:"kid"`
+	Algorithm string    `gorm:"type:varchar(10);not null;default:'RS256'" json:"alg"`
+	PublicKey string    `gorm:"type:text;not null" json:"public_key"`
+	// PrivateKey is the PKCS#1 key encrypted with the master key (helper.MasterKey) and the kid as associated data
+	PrivateKey string    `gorm:"type:text;not null" json:"-"`
+	RetiredAt  time.Time `gorm:"type:timestamp;default:null" json:"retired_at"`
+	ExpiresAt  time.Time `gorm:"type:timestamp;default:null" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Tenant *Tenant `gorm:"foreignKey:TenantID" json:"-"`
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
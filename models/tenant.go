@@ -7,10 +7,33 @@ import (
 	"gorm.io/gorm"
 )
 
+// DefaultRetentionDays is the retention of deleted tenants if none is configured
+const DefaultRetentionDays = 30
+
+const (
+	// MFAPolicyOptional requires a second factor only from users who enrolled one
+	MFAPolicyOptional = "optional"
+	// MFAPolicyAdmins requires a second factor from all admin users
+	MFAPolicyAdmins = "admins"
+	// MFAPolicyAll requires a second factor from all users
+	MFAPolicyAll = "all"
+)
+
 type Tenant struct {
-	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Name      string         `gorm:"not null;unique" json:"name"`
-	IsActive  bool           `gorm:"not null;default:true" json:"is_active"`
+	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name     string    `gorm:"not null;unique" json:"name"`
+	IsActive bool      `gorm:"not null;default:true" json:"is_active"`
+	// Slug identifies the tenant in requests and as subdomain of APPLICATION_DOMAIN. Custom hostnames are TenantDomains.
+	Slug string `gorm:"type:varchar(63);uniqueIndex;default:null" json:"slug"`
+
+	// AllowUnverifiedLogin lets users with an unverified email address log in with the restricted "unverified" scope
+	AllowUnverifiedLogin bool `gorm:"not null;default:false" json:"allow_unverified_login"`
+	// MFAPolicy is one of MFAPolicyOptional, MFAPolicyAdmins or MFAPolicyAll. Empty inherits the global settings.
+	MFAPolicy string `gorm:"not null;default:''" json:"mfa_policy"`
+	// RetentionDays is how long the data of a deleted tenant is kept before it is purged
+	RetentionDays int       `gorm:"not null;default:30" json:"retention_days"`
+	SuspendedAt   time.Time `gorm:"type:timestamp;default:null" json:"suspended_at"`
+
 	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TenantDomain is a hostname on which the tenant is served. Requests are routed to the tenant by their
+// Host header and the primary domain is the issuer of the tenant's tokens.
+type TenantDomain struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID  uuid.UUID `gorm:"type:uuid;not null;index" json:"tenant_id"`
+	Hostname  string    `gorm:"type:varchar(253);not null;uniqueIndex" json:"hostname"`
+	IsPrimary bool      `gorm:"not null;default:false" json:"is_primary"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Tenant *Tenant `gorm:"foreignKey:TenantID" json:"-"`
+}
+
+func (TenantDomain) TableName() string {
+	return "tenant_domains"
+}
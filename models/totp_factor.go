@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TOTPFactor is the authenticator app of a user. It is only used for login once ConfirmedAt is set.
+type TOTPFactor struct {
+	ID     uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"user_id"`
+	// Secret is the base32 secret encrypted with the master key (helper.MasterKey) and the ID as associated data
+	Secret      string         `gorm:"not null" json:"-"`
+	LastCounter int64          `gorm:"not null;default:0" json:"-"`
+	ConfirmedAt time.Time      `gorm:"type:timestamp;default:null" json:"confirmed_at"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (TOTPFactor) TableName() string {
+	return "totp_factors"
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -16,12 +17,21 @@ type User struct {
 	FirstName   string `gorm:"not null" json:"first_name"`
 	LastName    string `gorm:"not null" json:"last_name"`
 	DisplayName string `gorm:"not null" json:"display_name"`
+	Locale      string `gorm:"not null;default:'en'" json:"locale"`
 	Password    string `gorm:"not null" json:"password"`
 	IsActive    bool   `gorm:"not null;default:true" json:"is_active"`
 	IsVerified  bool   `gorm:"not null;default:false" json:"is_verified"`
 	IsAdmin     bool   `gorm:"not null;default:false" json:"is_admin"`
+	// IsSuperAdmin users manage all tenants
+	IsSuperAdmin bool `gorm:"not null;default:false" json:"is_super_admin"`
+	// PasswordIsHash marks Password as an already hashed value, e.g. imported from a legacy system
+	PasswordIsHash bool `gorm:"-" json:"-"`
 
 	TenantID uuid.UUID `gorm:"not null;uniqueIndex:idx_email_tenant" json:"tenant_id"`
+	// ExternalID is the ID of the user in the provisioning system (SCIM externalId)
+	ExternalID string `gorm:"type:varchar(255);not null;default:''" json:"external_id"`
+	// Attributes are the values of the tenant's custom attributes (UserAttribute) by name
+	Attributes json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"attributes"`
 
 	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
@@ -35,9 +45,15 @@ func (User) TableName() string {
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	u.Email = helper.NormalizeEmail(u.Email)
 	u.DisplayName = fmt.Sprintf("%s %s", u.FirstName, u.LastName)
-	argon2 := helper.NewArgon2Default()
-	hash, err := argon2.Hash(u.Password)
+	if u.PasswordIsHash {
+		if !helper.IsPasswordHash(u.Password) {
+			return fmt.Errorf("unsupported password hash format")
+		}
+		return nil
+	}
+	hash, err := helper.DefaultHasher().Hash(u.Password)
 	if err != nil {
 		return err
 	}
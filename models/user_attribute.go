@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// UserAttribute defines a custom attribute of the users of a tenant. The values are stored in
+// User.Attributes. UserAccess and AdminAccess are "none", "read" or "write".
+type UserAttribute struct {
+	ID          uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TenantID    uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_user_attribute_name" json:"tenant_id"`
+	Name        string         `gorm:"type:varchar(64);not null;uniqueIndex:idx_user_attribute_name" json:"name"`
+	DisplayName string         `gorm:"not null;default:''" json:"display_name"`
+	Type        string         `gorm:"type:varchar(20);not null" json:"type"`
+	Required    bool           `gorm:"not null;default:false" json:"required"`
+	Unique      bool           `gorm:"not null;default:false" json:"unique"`
+	Pattern     string         `gorm:"not null;default:''" json:"pattern,omitempty"`
+	MaxLength   int            `gorm:"not null;default:0" json:"max_length,omitempty"`
+	Min         *float64       `json:"min,omitempty"`
+	Max         *float64       `json:"max,omitempty"`
+	Options     pq.StringArray `gorm:"type:text[]" json:"options,omitempty"`
+	UserAccess  string         `gorm:"type:varchar(10);not null;default:'read'" json:"user_access"`
+	AdminAccess string         `gorm:"type:varchar(10);not null;default:'write'" json:"admin_access"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (UserAttribute) TableName() string {
+	return "user_attributes"
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	WebAuthnChallengeRegistration   = "registration"
+	WebAuthnChallengeAuthentication = "authentication"
+)
+
+// WebAuthnChallenge is the server side state of a registration or authentication ceremony.
+// UserID is empty for passwordless logins where the user is only known from the returned credential.
+type WebAuthnChallenge struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Type           string     `gorm:"type:varchar(20);not null"`
+	Challenge      []byte     `gorm:"type:bytea;not null"`
+	UserID         *uuid.UUID `gorm:"type:uuid;index"`
+	ClientID       *uuid.UUID `gorm:"type:uuid"`
+	MFAChallengeID *uuid.UUID `gorm:"type:uuid"`
+	UsedAt         time.Time  `gorm:"type:timestamp;default:null"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime"`
+	ExpiresAt      time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (WebAuthnChallenge) TableName() string {
+	return "webauthn_challenges"
+}
+
+func (c *WebAuthnChallenge) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ExpiresAt = time.Now().Add(time.Minute * 5)
+	return
+}
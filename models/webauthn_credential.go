@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredential is a security key or passkey of a user. PublicKey holds the COSE encoded key.
+type WebAuthnCredential struct {
+	ID           uuid.UUID      `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID       uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	CredentialID string         `gorm:"type:varchar(1024);not null;uniqueIndex" json:"credential_id"`
+	PublicKey    []byte         `gorm:"type:bytea;not null" json:"-"`
+	SignCount    uint32         `gorm:"not null;default:0" json:"-"`
+	AAGUID       string         `gorm:"type:varchar(36)" json:"aaguid"`
+	Name         string         `gorm:"type:varchar(255)" json:"name"`
+	Transports   string         `gorm:"type:varchar(255)" json:"transports"`
+	LastUsedAt   time.Time      `gorm:"type:timestamp;default:null" json:"last_used_at"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"autoDeleteTime" json:"deleted_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
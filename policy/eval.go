@@ -0,0 +1,580 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Values are nil, bool, int64, float64, string, time.Time, []interface{} and map[string]interface{}
+
+type activation struct {
+	name   string
+	value  interface{}
+	parent *activation
+	vars   map[string]interface{}
+}
+
+func (a *activation) lookup(name string) (interface{}, bool) {
+	for current := a; current != nil; current = current.parent {
+		if current.vars != nil {
+			value, ok := current.vars[name]
+			return value, ok
+		}
+		if current.name == name {
+			return current.value, true
+		}
+	}
+	return nil, false
+}
+
+type node interface {
+	eval(env *activation) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(*activation) (interface{}, error) {
+	return n.value, nil
+}
+
+type identifier struct {
+	name string
+}
+
+func (n *identifier) eval(env *activation) (interface{}, error) {
+	value, ok := env.lookup(n.name)
+	if !ok {
+		return nil, fmt.Errorf("no value for %s", n.name)
+	}
+	return value, nil
+}
+
+type selection struct {
+	operand node
+	field   string
+}
+
+func (n *selection) eval(env *activation) (interface{}, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot select %s from %s", n.field, typeName(operand))
+	}
+	value, ok := fields[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key %s", n.field)
+	}
+	return value, nil
+}
+
+type has struct {
+	operand node
+	field   string
+}
+
+func (n *has) eval(env *activation) (interface{}, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	value, ok := fields[n.field]
+	return ok && value != nil, nil
+}
+
+type indexing struct {
+	operand node
+	index   node
+}
+
+func (n *indexing) eval(env *activation) (interface{}, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch container := operand.(type) {
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys are strings, not %s", typeName(index))
+		}
+		value, ok := container[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key %s", key)
+		}
+		return value, nil
+	case []interface{}:
+		position, ok := index.(int64)
+		if !ok {
+			return nil, fmt.Errorf("list indexes are ints, not %s", typeName(index))
+		}
+		if position < 0 || position >= int64(len(container)) {
+			return nil, fmt.Errorf("index %d out of range", position)
+		}
+		return container[position], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(operand))
+}
+
+type list struct {
+	elements []node
+}
+
+func (n *list) eval(env *activation) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.elements))
+	for _, element := range n.elements {
+		value, err := element.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type mapping struct {
+	keys   []node
+	values []node
+}
+
+func (n *mapping) eval(env *activation) (interface{}, error) {
+	result := map[string]interface{}{}
+	for i := range n.keys {
+		key, err := n.keys[i].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys are strings, not %s", typeName(key))
+		}
+		value, err := n.values[i].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(env *activation) (interface{}, error) {
+	value, err := evalBool(n.operand, env)
+	return !value, err
+}
+
+type negate struct {
+	operand node
+}
+
+func (n *negate) eval(env *activation) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch number := value.(type) {
+	case int64:
+		return -number, nil
+	case float64:
+		return -number, nil
+	}
+	return nil, fmt.Errorf("cannot negate %s", typeName(value))
+}
+
+type conditional struct {
+	condition node
+	then      node
+	otherwise node
+}
+
+func (n *conditional) eval(env *activation) (interface{}, error) {
+	condition, err := evalBool(n.condition, env)
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+// logical implements && and || like CEL: an error on one side is ignored if the other side decides the result
+type logical struct {
+	and   bool
+	left  node
+	right node
+}
+
+func (n *logical) eval(env *activation) (interface{}, error) {
+	left, leftErr := evalBool(n.left, env)
+	if leftErr == nil && left != n.and {
+		return left, nil
+	}
+	right, rightErr := evalBool(n.right, env)
+	if rightErr == nil && right != n.and {
+		return right, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	return right, rightErr
+}
+
+func evalBool(n node, env *activation) (bool, error) {
+	value, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", typeName(value))
+	}
+	return result, nil
+}
+
+type binary struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (n *binary) eval(env *activation) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	case "<", "<=", ">", ">=":
+		order, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.operator {
+		case "<":
+			return order < 0, nil
+		case "<=":
+			return order <= 0, nil
+		case ">":
+			return order > 0, nil
+		}
+		return order >= 0, nil
+	}
+	return arithmetic(n.operator, left, right)
+}
+
+func equal(left interface{}, right interface{}) bool {
+	if l, r, ok := numbers(left, right); ok {
+		return l == r
+	}
+	if l, ok := left.(time.Time); ok {
+		r, ok := right.(time.Time)
+		return ok && l.Equal(r)
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func contains(container interface{}, value interface{}) (bool, error) {
+	switch values := container.(type) {
+	case []interface{}:
+		for _, element := range values {
+			if equal(element, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := values[key]
+		return found, nil
+	}
+	return false, fmt.Errorf("cannot use in with %s", typeName(container))
+}
+
+// numbers converts two numbers to float64 for comparisons of int and double
+func numbers(left interface{}, right interface{}) (float64, float64, bool) {
+	l, leftOK := toFloat(left)
+	r, rightOK := toFloat(right)
+	return l, r, leftOK && rightOK
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int64:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+func compare(left interface{}, right interface{}) (int, error) {
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			return sign(l - r), nil
+		}
+	}
+	if l, r, ok := numbers(left, right); ok {
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return l.Compare(r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", typeName(left), typeName(right))
+}
+
+func sign(value int64) int {
+	switch {
+	case value < 0:
+		return -1
+	case value > 0:
+		return 1
+	}
+	return 0
+}
+
+func arithmetic(operator string, left interface{}, right interface{}) (interface{}, error) {
+	if operator == "+" {
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []interface{}:
+			if r, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+	}
+
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch operator {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "*":
+				return l * r, nil
+			}
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if operator == "/" {
+				return l / r, nil
+			}
+			return l % r, nil
+		}
+	}
+	if l, r, ok := numbers(left, right); ok && operator != "%" {
+		switch operator {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		}
+		return l / r, nil
+	}
+	return nil, fmt.Errorf("cannot apply %s to %s and %s", operator, typeName(left), typeName(right))
+}
+
+type call struct {
+	name      string
+	fn        func([]interface{}) (interface{}, error)
+	arguments []node
+}
+
+func (n *call) eval(env *activation) (interface{}, error) {
+	arguments, err := evalAll(n.arguments, env)
+	if err != nil {
+		return nil, err
+	}
+	return n.fn(arguments)
+}
+
+type methodCall struct {
+	name      string
+	fn        func(interface{}, []interface{}, *regexp.Regexp) (interface{}, error)
+	target    node
+	arguments []node
+	pattern   *regexp.Regexp
+}
+
+func (n *methodCall) eval(env *activation) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	arguments, err := evalAll(n.arguments, env)
+	if err != nil {
+		return nil, err
+	}
+	value, err := n.fn(target, arguments, n.pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return value, nil
+}
+
+func evalAll(nodes []node, env *activation) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		value, err := n.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type comprehensionKind int
+
+const (
+	macroExists comprehensionKind = iota
+	macroAll
+	macroExistsOne
+	macroFilter
+	macroMap
+)
+
+var macros = map[string]comprehensionKind{
+	"exists":     macroExists,
+	"all":        macroAll,
+	"exists_one": macroExistsOne,
+	"filter":     macroFilter,
+	"map":        macroMap,
+}
+
+// comprehension evaluates the body for each element of a list or each key of a map
+type comprehension struct {
+	kind     comprehensionKind
+	target   node
+	variable string
+	body     node
+}
+
+func (n *comprehension) eval(env *activation) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []interface{}
+	switch values := target.(type) {
+	case []interface{}:
+		elements = values
+	case map[string]interface{}:
+		for key := range values {
+			elements = append(elements, key)
+		}
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", typeName(target))
+	}
+
+	matches := 0
+	results := []interface{}{}
+	for _, element := range elements {
+		scope := &activation{name: n.variable, value: element, parent: env}
+		if n.kind == macroMap {
+			value, err := n.body.eval(scope)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, value)
+			continue
+		}
+
+		matched, err := evalBool(n.body, scope)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case n.kind == macroExists && matched:
+			return true, nil
+		case n.kind == macroAll && !matched:
+			return false, nil
+		case matched:
+			matches++
+			results = append(results, element)
+		}
+	}
+
+	switch n.kind {
+	case macroExists:
+		return false, nil
+	case macroAll:
+		return true, nil
+	case macroExistsOne:
+		return matches == 1, nil
+	}
+	return results, nil
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case time.Time:
+		return "timestamp"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
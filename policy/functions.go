@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type function struct {
+	// arity is the number of arguments, -1 for any number
+	arity int
+	call  func([]interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"size": {1, func(arguments []interface{}) (interface{}, error) {
+		return size(arguments[0])
+	}},
+	"int": {1, func(arguments []interface{}) (interface{}, error) {
+		switch value := arguments[0].(type) {
+		case int64:
+			return value, nil
+		case float64:
+			return int64(value), nil
+		case string:
+			return strconv.ParseInt(value, 10, 64)
+		case time.Time:
+			return value.Unix(), nil
+		}
+		return nil, fmt.Errorf("cannot convert %s to int", typeName(arguments[0]))
+	}},
+	"double": {1, func(arguments []interface{}) (interface{}, error) {
+		if value, ok := toFloat(arguments[0]); ok {
+			return value, nil
+		}
+		if value, ok := arguments[0].(string); ok {
+			return strconv.ParseFloat(value, 64)
+		}
+		return nil, fmt.Errorf("cannot convert %s to double", typeName(arguments[0]))
+	}},
+	"string": {1, func(arguments []interface{}) (interface{}, error) {
+		switch value := arguments[0].(type) {
+		case string:
+			return value, nil
+		case int64:
+			return strconv.FormatInt(value, 10), nil
+		case float64:
+			return strconv.FormatFloat(value, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(value), nil
+		case time.Time:
+			return value.Format(time.RFC3339), nil
+		}
+		return nil, fmt.Errorf("cannot convert %s to string", typeName(arguments[0]))
+	}},
+	"timestamp": {1, func(arguments []interface{}) (interface{}, error) {
+		value, ok := arguments[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("timestamp() needs a string")
+		}
+		return time.Parse(time.RFC3339, value)
+	}},
+}
+
+func size(value interface{}) (interface{}, error) {
+	switch container := value.(type) {
+	case string:
+		return int64(utf8.RuneCountInString(container)), nil
+	case []interface{}:
+		return int64(len(container)), nil
+	case map[string]interface{}:
+		return int64(len(container)), nil
+	}
+	return nil, fmt.Errorf("no size of %s", typeName(value))
+}
+
+type method struct {
+	minArguments int
+	maxArguments int
+	call         func(target interface{}, arguments []interface{}, pattern *regexp.Regexp) (interface{}, error)
+}
+
+// stringMethod is a method of strings with string arguments
+func stringMethod(arguments int, fn func(target string, arguments []string) interface{}) method {
+	return method{arguments, arguments, func(target interface{}, values []interface{}, _ *regexp.Regexp) (interface{}, error) {
+		text, ok := target.(string)
+		if !ok {
+			return nil, fmt.Errorf("not defined on %s", typeName(target))
+		}
+		var parameters []string
+		for _, value := range values {
+			parameter, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("needs string arguments")
+			}
+			parameters = append(parameters, parameter)
+		}
+		return fn(text, parameters), nil
+	}}
+}
+
+// timeMethod returns a part of a timestamp in UTC or in the time zone given as argument
+func timeMethod(part func(time.Time) int) method {
+	return method{0, 1, func(target interface{}, arguments []interface{}, _ *regexp.Regexp) (interface{}, error) {
+		timestamp, ok := target.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("not defined on %s", typeName(target))
+		}
+		timestamp = timestamp.UTC()
+		if len(arguments) == 1 {
+			name, ok := arguments[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("needs a time zone name")
+			}
+			location, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, err
+			}
+			timestamp = timestamp.In(location)
+		}
+		return int64(part(timestamp)), nil
+	}}
+}
+
+var methods = map[string]method{
+	"size": {0, 0, func(target interface{}, _ []interface{}, _ *regexp.Regexp) (interface{}, error) {
+		return size(target)
+	}},
+	"startsWith": stringMethod(1, func(target string, arguments []string) interface{} {
+		return strings.HasPrefix(target, arguments[0])
+	}),
+	"endsWith": stringMethod(1, func(target string, arguments []string) interface{} {
+		return strings.HasSuffix(target, arguments[0])
+	}),
+	"contains": stringMethod(1, func(target string, arguments []string) interface{} {
+		return strings.Contains(target, arguments[0])
+	}),
+	"lowerAscii": stringMethod(0, func(target string, _ []string) interface{} {
+		return strings.ToLower(target)
+	}),
+	"upperAscii": stringMethod(0, func(target string, _ []string) interface{} {
+		return strings.ToUpper(target)
+	}),
+	"trim": stringMethod(0, func(target string, _ []string) interface{} {
+		return strings.TrimSpace(target)
+	}),
+	"split": stringMethod(1, func(target string, arguments []string) interface{} {
+		parts := []interface{}{}
+		for _, part := range strings.Split(target, arguments[0]) {
+			parts = append(parts, part)
+		}
+		return parts
+	}),
+	"matches": {1, 1, func(target interface{}, arguments []interface{}, pattern *regexp.Regexp) (interface{}, error) {
+		text, ok := target.(string)
+		if !ok {
+			return nil, fmt.Errorf("not defined on %s", typeName(target))
+		}
+		if pattern == nil {
+			expression, ok := arguments[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("needs a string pattern")
+			}
+			var err error
+			pattern, err = regexp.Compile(expression)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pattern.MatchString(text), nil
+	}},
+	"getFullYear":   timeMethod(func(t time.Time) int { return t.Year() }),
+	"getMonth":      timeMethod(func(t time.Time) int { return int(t.Month()) - 1 }),
+	"getDate":       timeMethod(func(t time.Time) int { return t.Day() }),
+	"getDayOfMonth": timeMethod(func(t time.Time) int { return t.Day() - 1 }),
+	"getDayOfWeek":  timeMethod(func(t time.Time) int { return int(t.Weekday()) }),
+	"getHours":      timeMethod(func(t time.Time) int { return t.Hour() }),
+	"getMinutes":    timeMethod(func(t time.Time) int { return t.Minute() }),
+}
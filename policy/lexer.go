@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenFloat
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "(", ")", "[", "]", "{", "}", ".", ",", ":", "?", "!", "-", "+", "*", "/", "%", "<", ">"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+
+		case c == '_' || unicode.IsLetter(c):
+			start := pos
+			for pos < len(source) && (source[pos] == '_' || unicode.IsLetter(rune(source[pos])) || unicode.IsDigit(rune(source[pos]))) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:pos], pos: start})
+
+		case unicode.IsDigit(c):
+			start := pos
+			kind := tokenInt
+			for pos < len(source) && unicode.IsDigit(rune(source[pos])) {
+				pos++
+			}
+			if pos+1 < len(source) && source[pos] == '.' && unicode.IsDigit(rune(source[pos+1])) {
+				kind = tokenFloat
+				pos++
+				for pos < len(source) && unicode.IsDigit(rune(source[pos])) {
+					pos++
+				}
+			}
+			tokens = append(tokens, token{kind: kind, text: source[start:pos], pos: start})
+
+		case c == '"' || c == '\'':
+			value, end, err := readString(source, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[pos:end], value: value, pos: pos})
+			pos = end
+
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(source[pos:], operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+					pos += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, pos)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// readString reads the quoted string starting at pos and returns its value and the position after it
+func readString(source string, pos int) (string, int, error) {
+	quote := source[pos]
+	var value strings.Builder
+	for i := pos + 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(source) {
+				break
+			}
+			switch source[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case '\\', '"', '\'':
+				value.WriteByte(source[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c at %d", source[i], i)
+			}
+		default:
+			value.WriteByte(source[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %d", pos)
+}
@@ -0,0 +1,382 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// maxNesting limits the nesting of expressions
+const maxNesting = 50
+
+type parser struct {
+	tokens    []token
+	pos       int
+	nesting   int
+	variables map[string]bool
+	// scopes are the variables of the enclosing macros
+	scopes []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(operator string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(operator string) error {
+	if !p.accept(operator) {
+		return p.errorf("expected %q", operator)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokenEOF {
+		found = "end of expression"
+	}
+	return fmt.Errorf("%s at %d, found %s", fmt.Sprintf(format, args...), t.pos, found)
+}
+
+// expression := or ("?" expression ":" expression)?
+func (p *parser) expression() (node, error) {
+	p.nesting++
+	defer func() { p.nesting-- }()
+	if p.nesting > maxNesting {
+		return nil, p.errorf("expression is nested too deeply")
+	}
+
+	condition, err := p.or()
+	if err != nil || !p.accept("?") {
+		return condition, err
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &conditional{condition: condition, then: then, otherwise: otherwise}, nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right node
+		right, err = p.and()
+		left = &logical{and: false, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.relation()
+	for err == nil && p.accept("&&") {
+		var right node
+		right, err = p.relation()
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) relation() (node, error) {
+	left, err := p.addition()
+	for err == nil {
+		t := p.peek()
+		isRelation := t.kind == tokenOperator && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">=")
+		if !isRelation && !(t.kind == tokenIdent && t.text == "in") {
+			return left, nil
+		}
+		p.next()
+		var right node
+		right, err = p.addition()
+		left = &binary{operator: t.text, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) addition() (node, error) {
+	left, err := p.multiplication()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+		p.next()
+		var right node
+		right, err = p.multiplication()
+		left = &binary{operator: t.text, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) multiplication() (node, error) {
+	left, err := p.unary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return left, nil
+		}
+		p.next()
+		var right node
+		right, err = p.unary()
+		left = &binary{operator: t.text, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		return &not{operand: operand}, err
+	}
+	if p.accept("-") {
+		operand, err := p.unary()
+		return &negate{operand: operand}, err
+	}
+	return p.member()
+}
+
+// member := primary ("." ident ("(" args ")")? | "[" expression "]")*
+func (p *parser) member() (node, error) {
+	operand, err := p.primary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, p.errorf("expected a field name")
+			}
+			if p.peek().kind == tokenOperator && p.peek().text == "(" {
+				operand, err = p.method(operand, name.text)
+			} else {
+				operand = &selection{operand: operand, field: name.text}
+			}
+		case p.accept("["):
+			var index node
+			index, err = p.expression()
+			if err == nil {
+				err = p.expect("]")
+			}
+			operand = &indexing{operand: operand, index: index}
+		default:
+			return operand, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenInt:
+		value, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at %d", t.text, t.pos)
+		}
+		return &literal{value: value}, nil
+	case tokenFloat:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at %d", t.text, t.pos)
+		}
+		return &literal{value: value}, nil
+	case tokenString:
+		return &literal{value: t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+		if p.peek().kind == tokenOperator && p.peek().text == "(" {
+			return p.function(t)
+		}
+		if !p.declared(t.text) {
+			return nil, fmt.Errorf("undeclared variable %s at %d", t.text, t.pos)
+		}
+		return &identifier{name: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			inner, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			elements, err := p.arguments("]")
+			return &list{elements: elements}, err
+		case "{":
+			return p.mapLiteral()
+		}
+	}
+	if t.kind != tokenEOF {
+		p.pos--
+	}
+	return nil, p.errorf("unexpected token")
+}
+
+func (p *parser) declared(name string) bool {
+	for _, scoped := range p.scopes {
+		if scoped == name {
+			return true
+		}
+	}
+	return p.variables[name]
+}
+
+// arguments parses a comma separated list of expressions up to the closing operator
+func (p *parser) arguments(closing string) ([]node, error) {
+	var arguments []node
+	if p.accept(closing) {
+		return arguments, nil
+	}
+	for {
+		argument, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+		if p.accept(closing) {
+			return arguments, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) mapLiteral() (node, error) {
+	result := &mapping{}
+	if p.accept("}") {
+		return result, nil
+	}
+	for {
+		key, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		result.keys = append(result.keys, key)
+		result.values = append(result.values, value)
+		if p.accept("}") {
+			return result, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// function parses a global function call or the has macro
+func (p *parser) function(name token) (node, error) {
+	p.next()
+	if name.text == "has" {
+		argument, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		selected, ok := argument.(*selection)
+		if !ok {
+			return nil, fmt.Errorf("has() needs a field selection at %d", name.pos)
+		}
+		return &has{operand: selected.operand, field: selected.field}, nil
+	}
+
+	arguments, err := p.arguments(")")
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at %d", name.text, name.pos)
+	}
+	if fn.arity >= 0 && len(arguments) != fn.arity {
+		return nil, fmt.Errorf("%s() takes %d arguments at %d", name.text, fn.arity, name.pos)
+	}
+	return &call{name: name.text, fn: fn.call, arguments: arguments}, nil
+}
+
+// method parses a method call or one of the macros exists, all, exists_one, filter and map
+func (p *parser) method(target node, name string) (node, error) {
+	p.next()
+	if kind, ok := macros[name]; ok {
+		variable := p.next()
+		if variable.kind != tokenIdent {
+			return nil, p.errorf("expected a variable name")
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		p.scopes = append(p.scopes, variable.text)
+		body, err := p.expression()
+		p.scopes = p.scopes[:len(p.scopes)-1]
+		if err != nil {
+			return nil, err
+		}
+		return &comprehension{kind: kind, target: target, variable: variable.text, body: body}, p.expect(")")
+	}
+
+	arguments, err := p.arguments(")")
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+	if len(arguments) < fn.minArguments || len(arguments) > fn.maxArguments {
+		return nil, fmt.Errorf("wrong number of arguments for %s()", name)
+	}
+
+	result := &methodCall{name: name, fn: fn.call, target: target, arguments: arguments}
+	// Patterns given as literal are compiled once
+	if name == "matches" {
+		if pattern, ok := arguments[0].(*literal); ok {
+			text, ok := pattern.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches() needs a string pattern")
+			}
+			compiled, err := regexp.Compile(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+			result.pattern = compiled
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,139 @@
+// Package policy implements a small expression language modelled on CEL (Common Expression Language).
+//
+// Expressions support literals (int, double, string, bool, null, lists and maps), field selection and
+// indexing, the operators ! - * / % + < <= > >= == != in && || and ?:, the functions size, int, double,
+// string, timestamp and has, the string methods startsWith, endsWith, contains, matches, lowerAscii,
+// upperAscii, trim and split, the timestamp methods getFullYear, getMonth, getDate, getDayOfMonth,
+// getDayOfWeek, getHours and getMinutes (with an optional time zone) and the macros exists, all,
+// exists_one, filter and map:
+//
+//	client.slug == "billing" && !("finance" in user.groups)
+//	now.getHours("Europe/Berlin") < 7 || now.getDayOfWeek("Europe/Berlin") == 0
+//	user.roles.exists(r, r.startsWith("support-"))
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// MaxLength is the maximum length of an expression
+const MaxLength = 4096
+
+// Program is a compiled expression
+type Program struct {
+	Source string
+	root   node
+}
+
+// Compile parses the expression. Only the declared variables may be used.
+func Compile(source string, variables ...string) (*Program, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, variables: map[string]bool{}}
+	for _, variable := range variables {
+		p.variables[variable] = true
+	}
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return &Program{Source: source, root: root}, nil
+}
+
+// Eval evaluates the program with the variables, which are converted with Value
+func (p *Program) Eval(variables map[string]interface{}) (interface{}, error) {
+	converted := map[string]interface{}{}
+	for name, value := range variables {
+		converted[name] = Value(value)
+	}
+	return p.root.eval(&activation{vars: converted})
+}
+
+// EvalBool evaluates a program that has to result in a bool
+func (p *Program) EvalBool(variables map[string]interface{}) (bool, error) {
+	value, err := p.Eval(variables)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, errors.New("expression does not result in a bool but " + typeName(value))
+	}
+	return result, nil
+}
+
+// Value converts a Go value to the values of the language: integers to int64, floats to float64,
+// slices to lists, maps with string keys to maps and fmt.Stringers such as uuid.UUID to strings.
+// Other values are formatted with fmt.Sprint.
+func Value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int64, float64, string, time.Time:
+		return v
+	case int:
+		return int64(v)
+	case fmt.Stringer:
+		return v.String()
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			values = append(values, Value(element))
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			values[key] = Value(element)
+		}
+		return values
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflected.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(reflected.Uint())
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float()
+	case reflect.String:
+		return reflected.String()
+	case reflect.Bool:
+		return reflected.Bool()
+	case reflect.Pointer:
+		if reflected.IsNil() {
+			return nil
+		}
+		return Value(reflected.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if reflected.Kind() == reflect.Slice && reflected.IsNil() {
+			return []interface{}{}
+		}
+		values := make([]interface{}, 0, reflected.Len())
+		for i := 0; i < reflected.Len(); i++ {
+			values = append(values, Value(reflected.Index(i).Interface()))
+		}
+		return values
+	case reflect.Map:
+		if reflected.Type().Key().Kind() == reflect.String {
+			values := make(map[string]interface{}, reflected.Len())
+			iterator := reflected.MapRange()
+			for iterator.Next() {
+				values[iterator.Key().String()] = Value(iterator.Value().Interface())
+			}
+			return values
+		}
+	}
+	return fmt.Sprint(value)
+}
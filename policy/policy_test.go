@@ -0,0 +1,412 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// testVariables are the variables of the expressions in the tests
+func testVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"user": map[string]interface{}{
+			"name":    "Alice",
+			"email":   "alice@example.com",
+			"roles":   []string{"support-tier1", "billing"},
+			"manager": nil,
+			"age":     42,
+		},
+		"client":  nil,
+		"now":     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		"pattern": "^a.*e$",
+	}
+}
+
+func compileTest(t *testing.T, source string) *Program {
+	t.Helper()
+	program, err := Compile(source, "user", "client", "now", "pattern")
+	if err != nil {
+		t.Fatalf("Compile(%q) = %v", source, err)
+	}
+	return program
+}
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize(`a_1 >= 12 || 1.5 != "x\"\n" && !'y\\'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind tokenKind
+		text string
+	}{
+		{tokenIdent, "a_1"}, {tokenOperator, ">="}, {tokenInt, "12"}, {tokenOperator, "||"}, {tokenFloat, "1.5"},
+		{tokenOperator, "!="}, {tokenString, `"x\"\n"`}, {tokenOperator, "&&"}, {tokenOperator, "!"},
+		{tokenString, `'y\\'`}, {tokenEOF, ""},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %+v", len(tokens), len(want), tokens)
+	}
+	for i, token := range tokens {
+		if token.kind != want[i].kind || token.text != want[i].text {
+			t.Errorf("token %d = %v %q, want %v %q", i, token.kind, token.text, want[i].kind, want[i].text)
+		}
+	}
+	if tokens[6].value != "x\"\n" || tokens[9].value != `y\` {
+		t.Errorf("string values = %q, %q", tokens[6].value, tokens[9].value)
+	}
+
+	// A dot without digits after it is a selection, not a double
+	tokens, err = tokenize("1.size()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[0].kind != tokenInt || tokens[1].text != "." {
+		t.Errorf("1.size() tokenized as %+v", tokens)
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{`user.name == "alice`, "unterminated string at 13"},
+		{`"trailing\`, "unterminated string"},
+		{`'a\qb'`, `invalid escape \q at 3`},
+		{`a # b`, `unexpected character '#' at 2`},
+		{`a = b`, `unexpected character '=' at 2`},
+		{`a & b`, `unexpected character '&' at 2`},
+		{"a ; b", `unexpected character ';' at 2`},
+	}
+	for _, test := range tests {
+		_, err := tokenize(test.source)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("tokenize(%q) = %v, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{``, "unexpected token at 0, found end of expression"},
+		{`1 +`, "unexpected token at 3"},
+		{`(1 + 2`, `expected ")" at 6`},
+		{`[1, 2`, `expected "," at 5`},
+		{`{"a" 1}`, `expected ":" at 5`},
+		{`true ? 1`, `expected ":"`},
+		{`1 2`, "unexpected token at 2, found 2"},
+		{`user.`, "expected a field name"},
+		{`user.1`, "expected a field name"},
+		{`user[1`, `expected "]"`},
+		{`secret == 1`, "undeclared variable secret at 0"},
+		{`r.startsWith("a")`, "undeclared variable r"},
+		{`user.roles.exists(r, true) && r == ""`, "undeclared variable r"},
+		{`user.roles.exists("r", true)`, "expected a variable name"},
+		{`user.roles.exists(r true)`, `expected ","`},
+		{`unknown(1)`, "unknown function unknown at 0"},
+		{`size(1, 2)`, "size() takes 1 arguments"},
+		{`size()`, "size() takes 1 arguments"},
+		{`user.name.unknown()`, "unknown method unknown"},
+		{`user.name.startsWith()`, "wrong number of arguments for startsWith()"},
+		{`user.name.trim(1)`, "wrong number of arguments for trim()"},
+		{`now.getHours("UTC", 1)`, "wrong number of arguments for getHours()"},
+		{`has(user)`, "has() needs a field selection"},
+		{`has(user["name"])`, "has() needs a field selection"},
+		{`user.name.matches(1)`, "matches() needs a string pattern"},
+		{`user.name.matches("[")`, "invalid pattern"},
+		{strings.Repeat("(", maxNesting+1) + "1" + strings.Repeat(")", maxNesting+1), "nested too deeply"},
+		{strings.Repeat("!", MaxLength+1), "longer than 4096 characters"},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source, "user", "now")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Compile(%q) = %v, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{`1 + 2 * 3`, int64(7)},
+		{`(1 + 2) * 3`, int64(9)},
+		{`10 - 4 - 3`, int64(3)},
+		{`100 / 10 / 5`, int64(2)},
+		{`2 * 7 % 4`, int64(2)},
+		{`7 % 4 * 2`, int64(6)},
+		{`-2 * 3`, int64(-6)},
+		{`- -3`, int64(3)},
+		{`-user.age + 2`, int64(-40)},
+		{`1 + 2 < 4`, true},
+		{`1 < 2 == true`, true},
+		{`2 in [1, 2] == true`, true},
+		{`!true || true`, true},
+		{`!(true || true)`, false},
+		{`!!true`, true},
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`false && true || true`, true},
+		{`1 == 1 && 2 != 2 || 3 > 2`, true},
+		{`true ? 1 : 2 + 3`, int64(1)},
+		{`false ? 1 : 2 + 3`, int64(5)},
+		{`false ? 1 : true ? 2 : 3`, int64(2)},
+		{`true ? false ? 1 : 2 : 3`, int64(2)},
+		{`1 < 2 ? "a" : "b"`, "a"},
+		{`user.roles[0].size() + 1`, int64(14)},
+		{`[1, 2][1] * 2`, int64(4)},
+		{`{"a": 1 + 1}["a"]`, int64(2)},
+		{`"a" + "b" == "ab"`, true},
+		{`1 + 2.5`, 3.5},
+		{`7 / 2`, int64(3)},
+		{`7.0 / 2`, 3.5},
+	}
+	for _, test := range tests {
+		got, err := compileTest(t, test.source).Eval(testVariables())
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvalTypeErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{`1 + "a"`, "cannot apply + to int and string"},
+		{`"a" - "b"`, "cannot apply - to string and string"},
+		{`[1] + "a"`, "cannot apply + to list and string"},
+		{`1.5 % 2`, "cannot apply % to double and int"},
+		{`1 / 0`, "division by zero"},
+		{`1 % 0`, "division by zero"},
+		{`"a" < 1`, "cannot compare string and int"},
+		{`now > 1`, "cannot compare timestamp and int"},
+		{`true < false`, "cannot compare bool and bool"},
+		{`-"a"`, "cannot negate string"},
+		{`!1`, "expected bool, got int"},
+		{`1 ? 2 : 3`, "expected bool, got int"},
+		{`1 && true`, "expected bool, got int"},
+		{`"a" || false`, "expected bool, got string"},
+		{`user.name.first`, "cannot select first from string"},
+		{`user.roles[0.5]`, "list indexes are ints, not double"},
+		{`user.roles[5]`, "index 5 out of range"},
+		{`user.roles[-1]`, "index -1 out of range"},
+		{`user[1]`, "map keys are strings, not int"},
+		{`user.age[0]`, "cannot index int"},
+		{`{1: 2}`, "map keys are strings, not int"},
+		{`1 in "abc"`, "cannot use in with string"},
+		{`user.age.startsWith("4")`, "startsWith(): not defined on int"},
+		{`user.name.startsWith(1)`, "startsWith(): needs string arguments"},
+		{`user.age.matches("4")`, "matches(): not defined on int"},
+		{`user.name.matches(user.age)`, "matches(): needs a string pattern"},
+		{`user.name.matches(pattern + "(")`, "matches(): error parsing regexp"},
+		{`user.name.getHours()`, "getHours(): not defined on string"},
+		{`now.getHours(1)`, "getHours(): needs a time zone name"},
+		{`now.getHours("Mars/Olympus_Mons")`, "unknown time zone"},
+		{`user.age.exists(a, true)`, "cannot iterate over int"},
+		{`user.roles.exists(r, r)`, "expected bool, got string"},
+		{`user.roles.map(r, r + 1)`, "cannot apply + to string and int"},
+		{`size(1)`, "no size of int"},
+		{`user.age.size()`, "size(): no size of int"},
+		{`int("4x")`, "invalid syntax"},
+		{`int(true)`, "cannot convert bool to int"},
+		{`double("x")`, "invalid syntax"},
+		{`double(now)`, "cannot convert timestamp to double"},
+		{`string([])`, "cannot convert list to string"},
+		{`timestamp(1)`, "timestamp() needs a string"},
+		{`timestamp("yesterday")`, "cannot parse"},
+	}
+	for _, test := range tests {
+		_, err := compileTest(t, test.source).Eval(testVariables())
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s = %v, want %q", test.source, err, test.err)
+		}
+	}
+
+	if _, err := compileTest(t, `user.age`).EvalBool(testVariables()); err == nil || !strings.Contains(err.Error(), "does not result in a bool but int") {
+		t.Errorf("EvalBool of an int = %v", err)
+	}
+}
+
+func TestNullAndMissingAttributes(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+		err    string
+	}{
+		{source: `null == null`, want: true},
+		{source: `null == 0`, want: false},
+		{source: `null == ""`, want: false},
+		{source: `null in [1, null]`, want: true},
+		{source: `user.manager == null`, want: true},
+		{source: `client == null`, want: true},
+		{source: `has(user.name)`, want: true},
+		{source: `has(user.manager)`, want: false},
+		{source: `has(user.missing)`, want: false},
+		{source: `has(user.name.first)`, want: false},
+		{source: `has(client.name)`, want: false},
+		{source: `"missing" in user`, want: false},
+		{source: `"manager" in user`, want: true},
+		{source: `1 in user`, want: false},
+		{source: `user.missing == null`, err: "no such key missing"},
+		{source: `user["missing"]`, err: "no such key missing"},
+		{source: `client.name`, err: "cannot select name from null"},
+		{source: `client["name"]`, err: "cannot index null"},
+		{source: `user.manager.name`, err: "cannot select name from null"},
+		{source: `size(user.manager)`, err: "no size of null"},
+		{source: `-user.manager`, err: "cannot negate null"},
+		{source: `user.manager < 1`, err: "cannot compare null and int"},
+		// Errors are absorbed by the side that decides a logical operator, as in CEL
+		{source: `has(user.missing) && user.missing == 1`, want: false},
+		{source: `user.missing == 1 && false`, want: false},
+		{source: `user.missing == 1 || true`, want: true},
+		{source: `true || user.missing`, want: true},
+		{source: `user.missing == 1 || false`, err: "no such key missing"},
+		{source: `user.missing == 1 && true`, err: "no such key missing"},
+		{source: `has(user.manager) ? user.manager.name : "none"`, want: "none"},
+	}
+	for _, test := range tests {
+		got, err := compileTest(t, test.source).Eval(testVariables())
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s = %v, %v, want error %q", test.source, got, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.source, err)
+		case !reflect.DeepEqual(got, test.want):
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+
+	// Variables without a value fail at evaluation
+	if _, err := compileTest(t, `client == null`).Eval(map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "no value for client") {
+		t.Errorf("unset variable = %v", err)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{`size("äbc")`, int64(3)},
+		{`size(user.roles)`, int64(2)},
+		{`size({"a": 1})`, int64(1)},
+		{`"äbc".size()`, int64(3)},
+		{`[].size()`, int64(0)},
+		{`int(1.9)`, int64(1)},
+		{`int(-1.9)`, int64(-1)},
+		{`int("42")`, int64(42)},
+		{`int(now)`, int64(1704164645)},
+		{`int(7)`, int64(7)},
+		{`double(2)`, 2.0},
+		{`double(1.5)`, 1.5},
+		{`double("1.25")`, 1.25},
+		{`string(42)`, "42"},
+		{`string(1.5)`, "1.5"},
+		{`string(true)`, "true"},
+		{`string("a")`, "a"},
+		{`string(now)`, "2024-01-02T03:04:05Z"},
+		{`timestamp("2024-01-02T04:04:05+01:00") == now`, true},
+		{`timestamp("2024-01-01T00:00:00Z") < now`, true},
+		{`user.name.startsWith("Al")`, true},
+		{`user.name.startsWith("al")`, false},
+		{`user.email.endsWith("@example.com")`, true},
+		{`user.email.contains("@")`, true},
+		{`user.email.contains("#")`, false},
+		{`user.name.lowerAscii()`, "alice"},
+		{`user.name.upperAscii()`, "ALICE"},
+		{`"  a b  ".trim()`, "a b"},
+		{`user.email.split("@")`, []interface{}{"alice", "example.com"}},
+		{`"a,,b".split(",")`, []interface{}{"a", "", "b"}},
+		{`user.email.matches("^[a-z]+@example\\.com$")`, true},
+		{`user.name.matches("^a")`, false},
+		{`"alice".matches(pattern)`, true},
+		{`now.getFullYear()`, int64(2024)},
+		{`now.getMonth()`, int64(0)},
+		{`now.getDate()`, int64(2)},
+		{`now.getDayOfMonth()`, int64(1)},
+		{`now.getDayOfWeek()`, int64(2)},
+		{`now.getHours()`, int64(3)},
+		{`now.getHours("Europe/Berlin")`, int64(4)},
+		{`now.getHours("America/New_York")`, int64(22)},
+		{`now.getDayOfWeek("America/New_York")`, int64(1)},
+		{`now.getMinutes()`, int64(4)},
+		{`now.getMinutes("Asia/Kolkata")`, int64(34)},
+		{`user.roles.exists(r, r.startsWith("support-"))`, true},
+		{`user.roles.exists(r, r == "admin")`, false},
+		{`[].exists(r, true)`, false},
+		{`user.roles.all(r, size(r) > 3)`, true},
+		{`user.roles.all(r, r == "billing")`, false},
+		{`[].all(r, false)`, true},
+		{`[1, 2, 3].exists_one(n, n > 2)`, true},
+		{`[1, 2, 3].exists_one(n, n > 1)`, false},
+		{`[1, 2, 3, 4].filter(n, n % 2 == 0)`, []interface{}{int64(2), int64(4)}},
+		{`[1, 2].map(n, n * 10)`, []interface{}{int64(10), int64(20)}},
+		{`{"a": 1, "b": 2}.all(k, k in ["a", "b"])`, true},
+		{`{"a": 1}.map(k, k + "!")`, []interface{}{"a!"}},
+		{`[[1, 2], [3]].map(l, l.map(n, n + size(l)))`, []interface{}{[]interface{}{int64(3), int64(4)}, []interface{}{int64(4)}}},
+		{`[1, 2].exists(user, user == 2) && user.name == "Alice"`, true},
+		{`[1] + [2]`, []interface{}{int64(1), int64(2)}},
+		{`1 in [1.0]`, true},
+		{`"a" in {"a": 1}`, true},
+		{`[1, [2]] == [1, [2]]`, true},
+		{`{"a": [1]} == {"a": [1]}`, true},
+	}
+	for _, test := range tests {
+		got, err := compileTest(t, test.source).Eval(testVariables())
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestValue(t *testing.T) {
+	var nilPointer *int
+	seven := 7
+	var nilSlice []string
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{nil, nil},
+		{int32(5), int64(5)},
+		{uint8(5), int64(5)},
+		{float32(1.5), 1.5},
+		{stringer{}, "stringer"},
+		{&seven, int64(7)},
+		{nilPointer, nil},
+		{nilSlice, []interface{}{}},
+		{[]string{"a"}, []interface{}{"a"}},
+		{[2]int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{map[string]int{"a": 1}, map[string]interface{}{"a": int64(1)}},
+		{map[int]string{1: "a"}, "map[1:a]"},
+		{struct{ A int }{1}, "{1}"},
+	}
+	for _, test := range tests {
+		if got := Value(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Value(%#v) = %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"math/big"
+	"strings"
 )
 
 func GenerateToken(length int) string {
@@ -10,3 +12,31 @@ func GenerateToken(length int) string {
 	rand.Read(token)
 	return base64.StdEncoding.EncodeToString(token)
 }
+
+// EncodeBearerToken combines the id of a stored token with its plain value ("id:token", base64 encoded)
+func EncodeBearerToken(id string, token string) string {
+	return base64.StdEncoding.EncodeToString([]byte(id + ":" + token))
+}
+
+// DecodeBearerToken splits a token created by EncodeBearerToken into id and plain value
+func DecodeBearerToken(bearer string) (string, string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(bearer)
+	if err != nil {
+		return "", "", false
+	}
+	id, token, ok := strings.Cut(string(decoded), ":")
+	if !ok || id == "" || token == "" {
+		return "", "", false
+	}
+	return id, token, true
+}
+
+// GenerateCode returns a random numeric code with the given number of digits
+func GenerateCode(digits int) string {
+	code := make([]byte, digits)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(10))
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code)
+}